@@ -0,0 +1,274 @@
+/*
+	This file supports a background scan of all stores that accounts for storage by
+	data instance, version, and type-specific key class.
+*/
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// KeyClassNamer is a DataService that can name its type-specific key classes for
+// storage accounting, e.g., "sparsevol" for a labelvol's RLE keys.
+type KeyClassNamer interface {
+	KeyClassName(storage.TKeyClass) string
+}
+
+// ByteCount is the number of key-value pairs and their total key and value bytes.
+type ByteCount struct {
+	Keys  uint64
+	Bytes uint64
+}
+
+func (c *ByteCount) add(kv *storage.KeyValue) {
+	c.Keys++
+	c.Bytes += uint64(len(kv.K) + len(kv.V))
+}
+
+// KeyClassCount is the storage used by a type-specific key class.
+type KeyClassCount struct {
+	Name string `json:",omitempty"`
+	ByteCount
+}
+
+// InstanceAccounting is the storage used by a data instance within a store, broken down
+// by the version UUID of each key and by type-specific key class.
+type InstanceAccounting struct {
+	Name       string
+	DataType   string
+	DataUUID   string
+	Total      ByteCount
+	Tombstones ByteCount
+	Versions   map[string]*ByteCount     // keyed by version UUID
+	KeyClasses map[string]*KeyClassCount // keyed by key class number
+}
+
+// AccountingProgress gives the progress of a storage accounting scan.
+type AccountingProgress struct {
+	Store          string // alias of store being scanned
+	StoresDone     int
+	StoresTotal    int
+	KeysScanned    uint64
+	BytesScanned   uint64
+	BytesEstimated uint64 // approximate total bytes to scan, if stores can report sizes
+}
+
+// StorageAccounting holds the results of the last storage accounting scan and the
+// progress of any current scan.
+type StorageAccounting struct {
+	Running   bool
+	Started   time.Time
+	Completed time.Time
+	Error     string `json:",omitempty"`
+	Progress  AccountingProgress
+
+	// Results of the last completed scan keyed by store alias then instance ID.
+	Stores map[string]map[string]*InstanceAccounting
+}
+
+var accounting struct {
+	sync.RWMutex
+	cur StorageAccounting
+}
+
+// Number of key-value pairs between updates of scan progress.
+const accountingProgressInterval = 10000
+
+// StartStorageAccounting begins a background scan of all stores.  The results replace
+// any cached results when the scan completes.
+func StartStorageAccounting() error {
+	stores, err := storage.AllStores()
+	if err != nil {
+		return err
+	}
+	accounting.Lock()
+	defer accounting.Unlock()
+	if accounting.cur.Running {
+		return fmt.Errorf("storage accounting scan already running since %s", accounting.cur.Started)
+	}
+	accounting.cur.Running = true
+	accounting.cur.Started = time.Now()
+	accounting.cur.Error = ""
+	accounting.cur.Progress = AccountingProgress{StoresTotal: len(stores)}
+	for _, store := range stores {
+		if sizes, err := storage.GetDataSizes(store, nil); err == nil {
+			for _, size := range sizes {
+				accounting.cur.Progress.BytesEstimated += size
+			}
+		}
+	}
+	go scanStorage(stores)
+	return nil
+}
+
+// GetStorageAccounting returns JSON for the cached results of the last storage accounting
+// scan and the progress of any current scan.
+func GetStorageAccounting() (string, error) {
+	accounting.RLock()
+	defer accounting.RUnlock()
+	m, err := json.Marshal(accounting.cur)
+	if err != nil {
+		return "", err
+	}
+	return string(m), nil
+}
+
+func scanStorage(stores map[storage.Alias]dvid.Store) {
+	results := make(map[string]map[string]*InstanceAccounting, len(stores))
+	var err error
+	for alias, store := range stores {
+		accounting.Lock()
+		accounting.cur.Progress.Store = string(alias)
+		accounting.Unlock()
+
+		db, ok := store.(storage.OrderedKeyValueGetter)
+		if ok {
+			results[string(alias)], err = scanStore(db)
+			if err != nil {
+				err = fmt.Errorf("storage accounting of store %q: %v", alias, err)
+				break
+			}
+		}
+		accounting.Lock()
+		accounting.cur.Progress.StoresDone++
+		accounting.Unlock()
+	}
+
+	accounting.Lock()
+	defer accounting.Unlock()
+	accounting.cur.Running = false
+	accounting.cur.Progress.Store = ""
+	if err != nil {
+		dvid.Errorf("%v\n", err)
+		accounting.cur.Error = err.Error()
+		return
+	}
+	accounting.cur.Completed = time.Now()
+	accounting.cur.Stores = results
+	dvid.Infof("Completed storage accounting of %d stores in %s\n", len(stores), accounting.cur.Completed.Sub(accounting.cur.Started))
+}
+
+// scanStore reads all data key-value pairs in a store and accounts for them.
+func scanStore(db storage.OrderedKeyValueGetter) (map[string]*InstanceAccounting, error) {
+	instances := make(map[dvid.InstanceID]*InstanceAccounting)
+	namers := make(map[dvid.InstanceID]KeyClassNamer)
+	versions := make(map[dvid.VersionID]string)
+
+	ch := make(chan *storage.KeyValue, 1000)
+	errCh := make(chan error, 1)
+	minKey, maxKey := storage.DataKeyRange()
+	go func() {
+		errCh <- db.RawRangeQuery(minKey, maxKey, false, ch, nil)
+		close(ch)
+	}()
+
+	var keys, bytes uint64
+	var scanErr error
+	for kv := range ch {
+		if kv == nil || scanErr != nil {
+			continue // drain so the query can finish
+		}
+		instanceID, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+		if err != nil {
+			scanErr = err
+			continue
+		}
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			scanErr = err
+			continue
+		}
+		class, err := tk.Class()
+		if err != nil {
+			scanErr = err
+			continue
+		}
+
+		ia, found := instances[instanceID]
+		if !found {
+			ia = &InstanceAccounting{
+				Versions:   make(map[string]*ByteCount),
+				KeyClasses: make(map[string]*KeyClassCount),
+			}
+			d, err := getDataByInstanceID(instanceID)
+			if err != nil {
+				ia.Name = fmt.Sprintf("unknown-%d", instanceID)
+			} else {
+				ia.Name = string(d.DataName())
+				ia.DataType = string(d.TypeName())
+				ia.DataUUID = string(d.DataUUID())
+				if namer, ok := d.(KeyClassNamer); ok {
+					namers[instanceID] = namer
+				}
+			}
+			instances[instanceID] = ia
+		}
+		ia.Total.add(kv)
+		if kv.K.IsTombstone() {
+			ia.Tombstones.add(kv)
+		}
+
+		uuid, found := versions[v]
+		if !found {
+			u, err := UUIDFromVersion(v)
+			if err != nil {
+				uuid = fmt.Sprintf("unknown-%d", v)
+			} else {
+				uuid = string(u)
+			}
+			versions[v] = uuid
+		}
+		vc, found := ia.Versions[uuid]
+		if !found {
+			vc = new(ByteCount)
+			ia.Versions[uuid] = vc
+		}
+		vc.add(kv)
+
+		classStr := fmt.Sprintf("%d", class)
+		cc, found := ia.KeyClasses[classStr]
+		if !found {
+			cc = new(KeyClassCount)
+			if class == journalKeyClass {
+				cc.Name = "mutation journal"
+			} else if namer, ok := namers[instanceID]; ok {
+				cc.Name = namer.KeyClassName(class)
+			}
+			ia.KeyClasses[classStr] = cc
+		}
+		cc.add(kv)
+
+		keys++
+		bytes += uint64(len(kv.K) + len(kv.V))
+		if keys%accountingProgressInterval == 0 {
+			accounting.Lock()
+			accounting.cur.Progress.KeysScanned += keys
+			accounting.cur.Progress.BytesScanned += bytes
+			accounting.Unlock()
+			keys, bytes = 0, 0
+		}
+	}
+	accounting.Lock()
+	accounting.cur.Progress.KeysScanned += keys
+	accounting.cur.Progress.BytesScanned += bytes
+	accounting.Unlock()
+
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	results := make(map[string]*InstanceAccounting, len(instances))
+	for instanceID, ia := range instances {
+		results[fmt.Sprintf("%d", instanceID)] = ia
+	}
+	return results, nil
+}
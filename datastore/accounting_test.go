@@ -0,0 +1,95 @@
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// instanceAccounting runs storage accounting and returns the results for a data instance.
+func instanceAccounting(t *testing.T, name dvid.InstanceName) *datastore.InstanceAccounting {
+	acctreq := fmt.Sprintf("%sstorage/accounting", server.WebAPIPath)
+	server.TestHTTP(t, "POST", acctreq, nil)
+	var acct datastore.StorageAccounting
+	for {
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", acctreq, nil), &acct); err != nil {
+			t.Fatalf("Unable to parse storage accounting: %v\n", err)
+		}
+		if !acct.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if acct.Error != "" {
+		t.Fatalf("Error in storage accounting: %s\n", acct.Error)
+	}
+	for _, instances := range acct.Stores {
+		for _, ia := range instances {
+			if ia.Name == string(name) {
+				return ia
+			}
+		}
+	}
+	t.Fatalf("Storage accounting did not include data instance %q: %+v\n", name, acct)
+	return nil
+}
+
+func TestStorageAccounting(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, versionID := initTestRepo()
+	dataservice, err := datastore.NewData(uuid, kvtype, "acctdata", dvid.NewConfig())
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	kvdata := dataservice.(*keyvalue.Data)
+
+	// Key-values are written directly so the instance has no journal entries.
+	rootctx := datastore.NewVersionedCtx(dataservice, versionID)
+	for _, key := range []string{"a", "b"} {
+		if err := kvdata.PutData(rootctx, key, []byte("root "+key)); err != nil {
+			t.Fatalf("Could not put keyvalue data: %v\n", err)
+		}
+	}
+	if err := datastore.Commit(uuid, "root commit", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", nil)
+	if err != nil {
+		t.Fatalf("Unable to branch: %v\n", err)
+	}
+	childV, err := datastore.VersionFromUUID(child)
+	if err != nil {
+		t.Fatalf("Unable to get version of child: %v\n", err)
+	}
+	childctx := datastore.NewVersionedCtx(dataservice, childV)
+	if err := kvdata.PutData(childctx, "a", []byte("child a")); err != nil {
+		t.Fatalf("Could not put keyvalue data: %v\n", err)
+	}
+	if err := kvdata.DeleteData(childctx, "b"); err != nil {
+		t.Fatalf("Could not delete keyvalue data: %v\n", err)
+	}
+
+	found := instanceAccounting(t, "acctdata")
+	if found.Total.Keys != 4 || found.Tombstones.Keys != 1 {
+		t.Errorf("Expected 4 keys with 1 tombstone, got %+v\n", found)
+	}
+	if root := found.Versions[string(uuid)]; root == nil || root.Keys != 2 {
+		t.Errorf("Expected 2 keys in root version, got %v\n", root)
+	}
+	if c := found.Versions[string(child)]; c == nil || c.Keys != 2 {
+		t.Errorf("Expected 2 keys in child version, got %v\n", c)
+	}
+	tk, _ := keyvalue.NewTKey("a")
+	class, _ := tk.Class()
+	if kc := found.KeyClasses[fmt.Sprintf("%d", class)]; kc == nil || kc.Name != "key" || kc.Keys != 4 {
+		t.Errorf("Bad key class accounting: %v\n", kc)
+	}
+}
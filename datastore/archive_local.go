@@ -0,0 +1,439 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports export of a repo to a portable archive file and import of that
+	archive into another DVID server, e.g., when there is no network between the servers.
+
+	An archive is a magic string followed by a stream of records.  Each record is a kind
+	byte, a 4-byte big-endian payload length, the payload, and a CRC32 (Castagnoli) of the
+	preceding bytes of the record.  Records in order:
+
+		header     gob-encoded archiveHeader with the serialized repo metadata
+		data       gob-encoded DataTxInit that starts the key-values of a data instance
+		kv         uvarint key length, key, and value of a key-value pair
+		data end   empty payload that ends the key-values of a data instance
+		trailer    gob-encoded archiveTrailer with counts and a SHA-256 of all prior records
+
+	Data, kv and data end records repeat for each data instance.  Key-values use the
+	exporting server's instance and version IDs, which are remapped on import just as
+	they are for a push.
+*/
+
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/rpc"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/go-humanize"
+)
+
+const (
+	archiveMagic   = "DVID repo archive\n"
+	archiveVersion = 1
+
+	// maxArchiveRecord guards against allocating huge buffers for a corrupt record length.
+	maxArchiveRecord = 1 << 30
+)
+
+type archiveRecord byte
+
+const (
+	archiveHeaderRec  archiveRecord = 'H'
+	archiveDataRec    archiveRecord = 'D'
+	archiveKVRec      archiveRecord = 'K'
+	archiveDataEndRec archiveRecord = 'E'
+	archiveTrailerRec archiveRecord = 'T'
+)
+
+var archiveCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// archiveHeader describes the exported repo.
+type archiveHeader struct {
+	Version  int
+	Created  time.Time
+	Host     string
+	UUID     dvid.UUID // the version given for the export
+	Transmit rpc.Transmit
+	Repo     []byte // serialized repo limited to the exported versions and data instances
+}
+
+// archiveTrailer ends an archive and allows detection of truncated or altered archives.
+type archiveTrailer struct {
+	Records   uint64 // number of records before the trailer
+	KeyValues uint64
+	Bytes     uint64 // total bytes of keys and values
+	SHA256    []byte // hash of all records before the trailer
+}
+
+type archiveWriter struct {
+	w       *bufio.Writer
+	hash    hash.Hash
+	trailer archiveTrailer
+
+	err error // first write error, after which all writes fail
+}
+
+func newArchiveWriter(w io.Writer) (*archiveWriter, error) {
+	aw := &archiveWriter{w: bufio.NewWriterSize(w, 1<<20), hash: sha256.New()}
+	if _, err := aw.w.WriteString(archiveMagic); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+func (aw *archiveWriter) writeRecord(kind archiveRecord, payload []byte) error {
+	if aw.err != nil {
+		return aw.err
+	}
+	if len(payload) > maxArchiveRecord {
+		aw.err = fmt.Errorf("archive record of %d bytes exceeds maximum size", len(payload))
+		return aw.err
+	}
+	hdr := make([]byte, 5)
+	hdr[0] = byte(kind)
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Update(crc32.Checksum(hdr, archiveCRC), archiveCRC, payload))
+	for _, b := range [][]byte{hdr, payload, crc} {
+		if _, err := aw.w.Write(b); err != nil {
+			aw.err = err
+			return err
+		}
+	}
+	if kind != archiveTrailerRec {
+		aw.hash.Write(hdr)
+		aw.hash.Write(payload)
+		aw.trailer.Records++
+	}
+	return nil
+}
+
+func (aw *archiveWriter) writeGob(kind archiveRecord, v interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return aw.writeRecord(kind, buf.Bytes())
+}
+
+func (aw *archiveWriter) startData(d dvid.Data) error {
+	dmsg := DataTxInit{
+		DataName:   d.DataName(),
+		TypeName:   d.TypeName(),
+		InstanceID: d.InstanceID(),
+	}
+	return aw.writeGob(archiveDataRec, dmsg)
+}
+
+func (aw *archiveWriter) putKV(kv *storage.KeyValue) error {
+	payload := make([]byte, binary.MaxVarintLen64+len(kv.K)+len(kv.V))
+	n := binary.PutUvarint(payload, uint64(len(kv.K)))
+	n += copy(payload[n:], kv.K)
+	n += copy(payload[n:], kv.V)
+	if err := aw.writeRecord(archiveKVRec, payload[:n]); err != nil {
+		return err
+	}
+	aw.trailer.KeyValues++
+	aw.trailer.Bytes += uint64(len(kv.K) + len(kv.V))
+	return nil
+}
+
+func (aw *archiveWriter) endData() error {
+	return aw.writeRecord(archiveDataEndRec, nil)
+}
+
+// close writes the trailer and flushes the archive.
+func (aw *archiveWriter) close() error {
+	aw.trailer.SHA256 = aw.hash.Sum(nil)
+	if err := aw.writeGob(archiveTrailerRec, aw.trailer); err != nil {
+		return err
+	}
+	return aw.w.Flush()
+}
+
+type archiveReader struct {
+	r       *bufio.Reader
+	hash    hash.Hash
+	records uint64
+}
+
+func newArchiveReader(r io.Reader) (*archiveReader, error) {
+	ar := &archiveReader{r: bufio.NewReaderSize(r, 1<<20), hash: sha256.New()}
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(ar.r, magic); err != nil || string(magic) != archiveMagic {
+		return nil, fmt.Errorf("not a DVID repo archive")
+	}
+	return ar, nil
+}
+
+// next returns the next record after verifying its checksum.
+func (ar *archiveReader) next() (archiveRecord, []byte, error) {
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(ar.r, hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("archive truncated after %d records", ar.records)
+		}
+		return 0, nil, err
+	}
+	kind := archiveRecord(hdr[0])
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > maxArchiveRecord {
+		return 0, nil, fmt.Errorf("archive record %d has bad size %d", ar.records, size)
+	}
+	payload := make([]byte, size)
+	crc := make([]byte, 4)
+	if _, err := io.ReadFull(ar.r, payload); err != nil {
+		return 0, nil, fmt.Errorf("archive truncated in record %d: %v", ar.records, err)
+	}
+	if _, err := io.ReadFull(ar.r, crc); err != nil {
+		return 0, nil, fmt.Errorf("archive truncated in record %d: %v", ar.records, err)
+	}
+	if binary.BigEndian.Uint32(crc) != crc32.Update(crc32.Checksum(hdr, archiveCRC), archiveCRC, payload) {
+		return 0, nil, fmt.Errorf("archive record %d is corrupt: bad checksum", ar.records)
+	}
+	if kind != archiveTrailerRec {
+		ar.hash.Write(hdr)
+		ar.hash.Write(payload)
+		ar.records++
+	}
+	return kind, payload, nil
+}
+
+// checkTrailer verifies the trailer against the records, key-values and bytes read.
+func (ar *archiveReader) checkTrailer(payload []byte, kvs, kvBytes uint64) error {
+	var trailer archiveTrailer
+	if err := gob.NewDecoder(bytes.NewBuffer(payload)).Decode(&trailer); err != nil {
+		return fmt.Errorf("bad archive trailer: %v", err)
+	}
+	if trailer.Records != ar.records || trailer.KeyValues != kvs || trailer.Bytes != kvBytes ||
+		!bytes.Equal(trailer.SHA256, ar.hash.Sum(nil)) {
+		return fmt.Errorf("contents do not match trailer")
+	}
+	return nil
+}
+
+// kvSizes returns the length of the key size prefix and the key size of a kv record.
+func (ar *archiveReader) kvSizes(payload []byte) (n int, keySize uint64, err error) {
+	keySize, n = binary.Uvarint(payload)
+	if n <= 0 || uint64(n)+keySize > uint64(len(payload)) {
+		return 0, 0, fmt.Errorf("bad key-value record %d in archive", ar.records)
+	}
+	return n, keySize, nil
+}
+
+// verifyArchive reads an entire archive file, checking every record and the trailer,
+// so an import never writes key-values from a corrupt or truncated archive.
+func verifyArchive(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ar, err := newArchiveReader(f)
+	if err != nil {
+		return err
+	}
+	var kvs, kvBytes uint64
+	for {
+		kind, payload, err := ar.next()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case archiveHeaderRec, archiveDataRec, archiveDataEndRec:
+		case archiveKVRec:
+			n, _, err := ar.kvSizes(payload)
+			if err != nil {
+				return err
+			}
+			kvs++
+			kvBytes += uint64(len(payload) - n)
+		case archiveTrailerRec:
+			if err := ar.checkTrailer(payload, kvs, kvBytes); err != nil {
+				return fmt.Errorf("archive %s failed verification: %v", filename, err)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown record type %q in archive", kind)
+		}
+	}
+}
+
+// ExportRepo writes a repo to an archive file.  The config accepts the "data", "filter" and
+// "transmit" settings of a push.
+func ExportRepo(uuid dvid.UUID, filename string, config dvid.Config) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	txRepo, transmit, filter, err := customizeTransfer(uuid, config)
+	if err != nil {
+		return err
+	}
+	repoSerialization, err := txRepo.GobEncode()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	aw, err := newArchiveWriter(f)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	hdr := archiveHeader{
+		Version:  archiveVersion,
+		Created:  time.Now(),
+		Host:     host,
+		UUID:     uuid,
+		Transmit: transmit,
+		Repo:     repoSerialization,
+	}
+	if err := aw.writeGob(archiveHeaderRec, hdr); err != nil {
+		return err
+	}
+
+	// The customized repo only holds the versions to be exported.
+	timedLog := dvid.NewTimeLog()
+	ps := &PushSession{Filter: filter, Versions: txRepo.versionSet(), t: transmit, a: aw}
+	for _, d := range txRepo.data {
+		dvid.Infof("Exporting instance %q data to %s\n", d.DataName(), filename)
+		if err := d.PushData(ps); err != nil {
+			return err
+		}
+		if aw.err != nil {
+			return fmt.Errorf("error writing archive %s: %v", filename, aw.err)
+		}
+	}
+	if err := aw.close(); err != nil {
+		return err
+	}
+	timedLog.Infof("Exported repo %s to %s: %d key-value pairs, %s", uuid, filename,
+		aw.trailer.KeyValues, humanize.Bytes(aw.trailer.Bytes))
+	return f.Sync()
+}
+
+// ImportRepo adds the repo in an archive file to this server, returning the UUID given
+// for its export.  None of the archive's versions can already be present on this server.
+// The whole archive is verified before any key-values are written.  If the import still
+// fails, e.g., on a storage error, the key-values already written are deleted.
+func ImportRepo(filename string) (uuid dvid.UUID, err error) {
+	if manager == nil {
+		return dvid.NilUUID, ErrManagerNotInitialized
+	}
+	if err := verifyArchive(filename); err != nil {
+		return dvid.NilUUID, err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	defer f.Close()
+	ar, err := newArchiveReader(f)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	kind, payload, err := ar.next()
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	if kind != archiveHeaderRec {
+		return dvid.NilUUID, fmt.Errorf("archive %s does not start with header", filename)
+	}
+	var hdr archiveHeader
+	if err := gob.NewDecoder(bytes.NewBuffer(payload)).Decode(&hdr); err != nil {
+		return dvid.NilUUID, fmt.Errorf("bad archive header: %v", err)
+	}
+	if hdr.Version != archiveVersion {
+		return dvid.NilUUID, fmt.Errorf("archive %s has unsupported version %d", filename, hdr.Version)
+	}
+	r := new(repoT)
+	if err := r.GobDecode(hdr.Repo); err != nil {
+		return dvid.NilUUID, err
+	}
+	for _, node := range r.dag.nodes {
+		if _, err := manager.versionFromUUID(node.uuid); err == nil {
+			return dvid.NilUUID, fmt.Errorf("version %s in archive is already present on this server", node.uuid)
+		}
+	}
+	dvid.Infof("Importing repo %s exported from %q at %s\n", hdr.UUID, hdr.Host, hdr.Created)
+
+	// A branch archive only holds the ancestor path, so all its versions are imported.
+	transmit := hdr.Transmit
+	if transmit == rpc.TransmitBranch {
+		transmit = rpc.TransmitAll
+	}
+	timedLog := dvid.NewTimeLog()
+	p := &pusher{startTime: time.Now()}
+	if _, err := p.readRepo(&repoTxMsg{Transmit: transmit, UUID: hdr.UUID, Repo: hdr.Repo}); err != nil {
+		return dvid.NilUUID, err
+	}
+	defer func() {
+		if err != nil {
+			dvid.Errorf("Deleting key-values of failed import of %s: %v\n", filename, err)
+			p.abort()
+		}
+	}()
+	var kvs, kvBytes uint64
+	for {
+		kind, payload, err := ar.next()
+		if err != nil {
+			return dvid.NilUUID, err
+		}
+		switch kind {
+		case archiveDataRec:
+			var dmsg DataTxInit
+			if err := gob.NewDecoder(bytes.NewBuffer(payload)).Decode(&dmsg); err != nil {
+				return dvid.NilUUID, fmt.Errorf("bad data record in archive: %v", err)
+			}
+			if err := p.startData(&dmsg); err != nil {
+				return dvid.NilUUID, err
+			}
+		case archiveKVRec:
+			n, keySize, err := ar.kvSizes(payload)
+			if err != nil {
+				return dvid.NilUUID, err
+			}
+			end := n + int(keySize)
+			kvmsg := KVMessage{KV: storage.KeyValue{K: storage.Key(payload[n:end]), V: payload[end:]}}
+			if err := p.putData(&kvmsg); err != nil {
+				return dvid.NilUUID, err
+			}
+			kvs++
+			kvBytes += uint64(len(payload) - n)
+		case archiveDataEndRec:
+			if err := p.putData(&KVMessage{Terminate: true}); err != nil {
+				return dvid.NilUUID, err
+			}
+		case archiveTrailerRec:
+			// The archive could have changed since it was verified.
+			if err := ar.checkTrailer(payload, kvs, kvBytes); err != nil {
+				return dvid.NilUUID, fmt.Errorf("archive %s failed verification: %v", filename, err)
+			}
+			if err := p.Close(); err != nil {
+				return dvid.NilUUID, err
+			}
+			timedLog.Infof("Imported repo %s from %s: %d key-value pairs, %s", hdr.UUID, filename,
+				kvs, humanize.Bytes(kvBytes))
+			return hdr.UUID, nil
+		default:
+			return dvid.NilUUID, fmt.Errorf("unknown record type %q in archive", kind)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// testArchiveRecord is a record of an archive file as described in archive_local.go.
+type testArchiveRecord struct {
+	kind    byte
+	payload []byte
+}
+
+// testArchiveTrailer matches the gob encoding of the trailer of an archive.
+type testArchiveTrailer struct {
+	Records   uint64
+	KeyValues uint64
+	Bytes     uint64
+	SHA256    []byte
+}
+
+const testArchiveMagic = "DVID repo archive\n"
+
+var testArchiveCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// readTestArchive returns the records of an archive other than the trailer.
+func readTestArchive(t *testing.T, filename string) []testArchiveRecord {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unable to read archive: %v\n", err)
+	}
+	data = data[len(testArchiveMagic):]
+	var records []testArchiveRecord
+	for len(data) != 0 {
+		size := binary.BigEndian.Uint32(data[1:5])
+		if data[0] != 'T' {
+			records = append(records, testArchiveRecord{data[0], data[5 : 5+size]})
+		}
+		data = data[9+size:]
+	}
+	return records
+}
+
+// writeTestArchive writes records to an archive file with a valid trailer.
+func writeTestArchive(t *testing.T, filename string, records []testArchiveRecord) {
+	var buf bytes.Buffer
+	buf.WriteString(testArchiveMagic)
+	hash := sha256.New()
+	var trailer testArchiveTrailer
+	write := func(kind byte, payload []byte) {
+		hdr := make([]byte, 5)
+		hdr[0] = kind
+		binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.Update(crc32.Checksum(hdr, testArchiveCRC), testArchiveCRC, payload))
+		buf.Write(hdr)
+		buf.Write(payload)
+		buf.Write(crc)
+		if kind != 'T' {
+			hash.Write(hdr)
+			hash.Write(payload)
+		}
+	}
+	for _, rec := range records {
+		write(rec.kind, rec.payload)
+		trailer.Records++
+		if rec.kind == 'K' {
+			_, n := binary.Uvarint(rec.payload)
+			trailer.KeyValues++
+			trailer.Bytes += uint64(len(rec.payload) - n)
+		}
+	}
+	trailer.SHA256 = hash.Sum(nil)
+	var tbuf bytes.Buffer
+	if err := gob.NewEncoder(&tbuf).Encode(trailer); err != nil {
+		t.Fatalf("Unable to encode archive trailer: %v\n", err)
+	}
+	write('T', tbuf.Bytes())
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Unable to write archive: %v\n", err)
+	}
+}
+
+// hasDataKeys returns true if the default store holds any key-values of data instances.
+func hasDataKeys(t *testing.T) bool {
+	store, err := storage.DefaultOrderedKVStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	begKey, endKey := storage.DataKeyRange()
+	ch := make(chan *storage.KeyValue)
+	done := make(chan error, 1)
+	go func() {
+		done <- store.RawRangeQuery(begKey, endKey, true, ch, nil)
+	}()
+	var found bool
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		found = true
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Unable to read store: %v\n", err)
+	}
+	return found
+}
+
+func TestExportImport(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "keyvalue", "exported", dvid.NewConfig())
+	rootreq := fmt.Sprintf("%snode/%s/exported/key/", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", rootreq+"a", strings.NewReader("root a"))
+	server.TestHTTP(t, "POST", rootreq+"b", strings.NewReader("root b"))
+	if err := datastore.Commit(uuid, "root commit", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", nil)
+	if err != nil {
+		t.Fatalf("Unable to branch: %v\n", err)
+	}
+	childreq := fmt.Sprintf("%snode/%s/exported/key/", server.WebAPIPath, child)
+	server.TestHTTP(t, "POST", childreq+"a", strings.NewReader("child a"))
+	server.TestHTTP(t, "DELETE", childreq+"b", nil)
+
+	dir, err := ioutil.TempDir("", "dvid-archive")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "repo.dvid")
+	if err := datastore.ExportRepo(child, filename, dvid.NewConfig()); err != nil {
+		t.Fatalf("Unable to export repo: %v\n", err)
+	}
+
+	// Versions already on this server can't be imported.
+	if _, err := datastore.ImportRepo(filename); err == nil {
+		t.Fatalf("Expected error importing repo with versions already present\n")
+	}
+	if err := datastore.DeleteRepo(uuid, "foobar"); err != nil {
+		t.Fatalf("Unable to delete repo: %v\n", err)
+	}
+	for i := 0; hasDataKeys(t); i++ {
+		if i == 100 {
+			t.Fatalf("Key-values of deleted repo were not deleted\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A corrupted archive is rejected.
+	archive, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unable to read archive: %v\n", err)
+	}
+	corrupt := make([]byte, len(archive))
+	copy(corrupt, archive)
+	corrupt[len(corrupt)-20] ^= 0xff
+	corruptname := filepath.Join(dir, "corrupt.dvid")
+	if err := ioutil.WriteFile(corruptname, corrupt, 0644); err != nil {
+		t.Fatalf("Unable to write corrupt archive: %v\n", err)
+	}
+	if _, err := datastore.ImportRepo(corruptname); err == nil {
+		t.Errorf("Expected error importing corrupt archive\n")
+	}
+	if err := ioutil.WriteFile(corruptname, archive[:len(archive)/2], 0644); err != nil {
+		t.Fatalf("Unable to write truncated archive: %v\n", err)
+	}
+	if _, err := datastore.ImportRepo(corruptname); err == nil {
+		t.Errorf("Expected error importing truncated archive\n")
+	}
+
+	// An archive that passes verification but has a key-value of an unknown version fails
+	// after writing key-values, which are deleted.
+	records := readTestArchive(t, filename)
+	var last int
+	for i, rec := range records {
+		if rec.kind == 'K' {
+			last = i
+		}
+	}
+	keySize, n := binary.Uvarint(records[last].payload)
+	bad := make([]byte, len(records[last].payload))
+	copy(bad, records[last].payload)
+	k := storage.Key(bad[n : n+int(keySize)])
+	instance, _, _, err := storage.DataKeyToLocalIDs(k)
+	if err != nil {
+		t.Fatalf("Bad key in archive: %v\n", err)
+	}
+	if err := storage.UpdateDataKey(k, instance, dvid.MaxVersionID, 0); err != nil {
+		t.Fatalf("Unable to modify key: %v\n", err)
+	}
+	records = append(records[:last+1], append([]testArchiveRecord{{'K', bad}}, records[last+1:]...)...)
+	writeTestArchive(t, corruptname, records)
+	if _, err := datastore.ImportRepo(corruptname); err == nil || !strings.Contains(err.Error(), "version id") {
+		t.Errorf("Expected error importing key-value of unknown version, got %v\n", err)
+	}
+	if hasDataKeys(t) {
+		t.Errorf("Expected no key-values left after failed import\n")
+	}
+
+	imported, err := datastore.ImportRepo(filename)
+	if err != nil {
+		t.Fatalf("Unable to import repo: %v\n", err)
+	}
+	if imported != child {
+		t.Errorf("Expected import of %s, got %s\n", child, imported)
+	}
+	if value := server.TestHTTP(t, "GET", rootreq+"a", nil); string(value) != "root a" {
+		t.Errorf("Expected root value after import, got %q\n", string(value))
+	}
+	if value := server.TestHTTP(t, "GET", rootreq+"b", nil); string(value) != "root b" {
+		t.Errorf("Expected root value after import, got %q\n", string(value))
+	}
+	if value := server.TestHTTP(t, "GET", childreq+"a", nil); string(value) != "child a" {
+		t.Errorf("Expected child value after import, got %q\n", string(value))
+	}
+	server.TestBadHTTP(t, "GET", childreq+"b", nil)
+}
+
@@ -0,0 +1,5 @@
+// +build bolt
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/bolt"
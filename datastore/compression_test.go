@@ -0,0 +1,61 @@
+package datastore_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestZstdCompression(t *testing.T) {
+	if !dvid.ZstdAvailable {
+		t.Skip("zstd compression requires the zstd build tag")
+	}
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	dict := []byte("some stuff that is often stored")
+	config := dvid.NewConfig()
+	config.Set("Compression", "zstd:19")
+	config.Set("ZstdDictionary", base64.StdEncoding.EncodeToString(dict))
+	dataservice, err := datastore.NewData(uuid, kvtype, "zstdkv", config)
+	if err != nil {
+		t.Fatalf("Unable to create keyvalue instance: %v\n", err)
+	}
+	kvdata := dataservice.(*keyvalue.Data)
+	compression := kvdata.Compression()
+	if compression.Format() != dvid.Zstd || compression.Level() != 19 || compression.Dictionary() != dvid.ZstdDictionaryID(dict) {
+		t.Fatalf("Bad zstd compression for instance: %s\n", compression)
+	}
+
+	keyreq := fmt.Sprintf("%snode/%s/zstdkv/key/mykey", server.WebAPIPath, uuid)
+	value := "some stuff that is often stored, again and again"
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader(value))
+	if returnValue := server.TestHTTP(t, "GET", keyreq, nil); string(returnValue) != value {
+		t.Errorf("Expected %q from zstd compressed value, got %q\n", value, string(returnValue))
+	}
+
+	// Dictionaries persist with the data instance.
+	oldData := *kvdata
+	if err = datastore.SaveDataByUUID(uuid, kvdata); err != nil {
+		t.Fatalf("Unable to save repo: %v\n", err)
+	}
+	datastore.CloseReopenTest()
+	dataservice2, err := datastore.GetDataByUUIDName(uuid, "zstdkv")
+	if err != nil {
+		t.Fatalf("Can't get keyvalue instance from reloaded test db: %v\n", err)
+	}
+	if !oldData.Equals(dataservice2.(*keyvalue.Data)) {
+		t.Errorf("Expected %v, got %v\n", oldData, dataservice2)
+	}
+	if returnValue := server.TestHTTP(t, "GET", keyreq, nil); string(returnValue) != value {
+		t.Errorf("Expected %q from zstd compressed value after reload, got %q\n", value, string(returnValue))
+	}
+}
@@ -6,6 +6,7 @@ package datastore
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
@@ -267,6 +268,11 @@ type Data struct {
 	// Checksum approach for serialized data.
 	checksum dvid.Checksum
 
+	// zstd dictionaries ever used by this instance, with the last one being the one
+	// used by any zstd compression.  Earlier dictionaries are kept so older values
+	// can still be deserialized.  An empty dictionary denotes no dictionary.
+	zstdDicts [][]byte
+
 	// a list of the instances to which this data should be synced
 	syncNames []dvid.InstanceName // deprecated but used for legacy serialization
 	syncData  dvid.UUIDSet        // data UUIDs of syncs.
@@ -278,8 +284,9 @@ type Data struct {
 	unversioned bool
 
 	// the assigned backend store for a data instance.  If nil, we
-	// will use the default store.
-	store dvid.Store
+	// will use the default store.  It can be changed by online migration.
+	storeMu sync.RWMutex
+	store   dvid.Store
 
 	// these sync management vars aren't serialized
 	syncmu     sync.RWMutex
@@ -410,6 +417,8 @@ func (d *Data) TypeVersion() string { return d.typeversion }
 func (d *Data) Versioned() bool { return !d.unversioned }
 
 func (d *Data) BackendStore() (dvid.Store, error) {
+	d.storeMu.RLock()
+	defer d.storeMu.RUnlock()
 	if d.store == nil {
 		return storage.DefaultStore()
 	}
@@ -442,7 +451,9 @@ func (d *Data) SetSync(syncs dvid.UUIDSet) {
 }
 
 func (d *Data) SetBackendStore(store dvid.Store) {
+	d.storeMu.Lock()
 	d.store = store
+	d.storeMu.Unlock()
 }
 
 // ---------------
@@ -491,6 +502,21 @@ func (d *Data) GobDecode(b []byte) error {
 			dvid.Infof("Data %q has legacy sync names, will convert to data UUIDs...\n", d.name)
 		}
 	}
+	// Data encoded before zstd dictionaries were added ends before them.
+	if err := dec.Decode(&(d.zstdDicts)); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("data %q has bad zstd dictionaries: %v", d.name, err)
+	}
+	for _, dict := range d.zstdDicts {
+		if len(dict) == 0 {
+			continue
+		}
+		if _, err := dvid.RegisterZstdDictionary(dict); err != nil {
+			return fmt.Errorf("data %q: %v", d.name, err)
+		}
+	}
 	return nil
 }
 
@@ -534,6 +560,9 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.syncData); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.zstdDicts); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -549,9 +578,15 @@ func (d *Data) Equals(d2 *Data) bool {
 		d.rootUUID != d2.rootUUID ||
 		d.compression != d2.compression ||
 		d.checksum != d2.checksum ||
-		!d.syncData.Equals(d2.syncData) {
+		!d.syncData.Equals(d2.syncData) ||
+		len(d.zstdDicts) != len(d2.zstdDicts) {
 		return false
 	}
+	for i, dict := range d.zstdDicts {
+		if !bytes.Equal(dict, d2.zstdDicts[i]) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -565,6 +600,12 @@ func (d *Data) Checksum() dvid.Checksum {
 	return d.checksum
 }
 
+// DeserializeData deserializes a value stored by this data instance, returning a
+// dvid.ChecksumError if the value lacks the instance's checksum or fails it.
+func (d *Data) DeserializeData(s []byte, uncompress bool) ([]byte, dvid.CompressionFormat, error) {
+	return dvid.DeserializeCheckedData(s, uncompress, d.checksum)
+}
+
 // --- DataService implementation -----
 
 func (d *Data) GetType() TypeService {
@@ -575,9 +616,57 @@ func (d *Data) GetType() TypeService {
 	return typeservice
 }
 
+// zstdDictionary returns the ID of the zstd dictionary used for new values or 0 if none.
+func (d *Data) zstdDictionary() uint32 {
+	if len(d.zstdDicts) == 0 || len(d.zstdDicts[len(d.zstdDicts)-1]) == 0 {
+		return 0
+	}
+	return dvid.ZstdDictionaryID(d.zstdDicts[len(d.zstdDicts)-1])
+}
+
+// setZstdDictionary sets the zstd dictionary for new values from a base64 encoding, or
+// stops use of a dictionary if "none".
+func (d *Data) setZstdDictionary(s string) error {
+	if strings.ToLower(s) == "none" {
+		if d.zstdDictionary() != 0 {
+			d.zstdDicts = append(d.zstdDicts, nil)
+		}
+		if d.compression.Format() == dvid.Zstd {
+			d.compression, _ = dvid.NewZstdCompression(d.compression.Level(), 0)
+		}
+		return nil
+	}
+	dict, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("Unable to decode base64 zstd dictionary: %v", err)
+	}
+	id, err := dvid.RegisterZstdDictionary(dict)
+	if err != nil {
+		return err
+	}
+	if id != d.zstdDictionary() {
+		d.zstdDicts = append(d.zstdDicts, dict)
+	}
+	if d.compression.Format() == dvid.Zstd {
+		d.compression, err = dvid.NewZstdCompression(d.compression.Level(), id)
+	}
+	return err
+}
+
 func (d *Data) ModifyConfig(config dvid.Config) error {
+	// Set any zstd dictionary, which must precede compression so zstd can use it.
+	s, found, err := config.GetString("ZstdDictionary")
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := d.setZstdDictionary(s); err != nil {
+			return err
+		}
+	}
+
 	// Set compression for this instance
-	s, found, err := config.GetString("Compression")
+	s, found, err = config.GetString("Compression")
 	if err != nil {
 		return err
 	}
@@ -592,8 +681,12 @@ func (d *Data) ModifyConfig(config dvid.Config) error {
 			d.compression, _ = dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
 		case "gzip":
 			d.compression, _ = dvid.NewCompression(dvid.Gzip, dvid.DefaultCompression)
+		case "zstd":
+			if d.compression, err = dvid.NewZstdCompression(dvid.DefaultCompression, d.zstdDictionary()); err != nil {
+				return err
+			}
 		default:
-			// Check for gzip + compression level
+			// Check for gzip or zstd + compression level
 			parts := strings.Split(format, ":")
 			if len(parts) == 2 && parts[0] == "gzip" {
 				level, err := strconv.Atoi(parts[1])
@@ -601,6 +694,14 @@ func (d *Data) ModifyConfig(config dvid.Config) error {
 					return fmt.Errorf("Unable to parse gzip compression level (%q).  Should be 'gzip:<level>'.", parts[1])
 				}
 				d.compression, _ = dvid.NewCompression(dvid.Gzip, dvid.CompressionLevel(level))
+			} else if len(parts) == 2 && parts[0] == "zstd" {
+				level, err := strconv.Atoi(parts[1])
+				if err != nil {
+					return fmt.Errorf("Unable to parse zstd compression level (%q).  Should be 'zstd:<level>'.", parts[1])
+				}
+				if d.compression, err = dvid.NewZstdCompression(dvid.CompressionLevel(level), d.zstdDictionary()); err != nil {
+					return err
+				}
 			} else {
 				return fmt.Errorf("Illegal compression specified: %s", s)
 			}
@@ -1,6 +1,7 @@
 package datastore
 
 import (
+	"bytes"
 	"encoding/gob"
 	"net/http"
 	"reflect"
@@ -45,6 +46,20 @@ func (d *TestData) Help() string {
 	return "no help here!"
 }
 
+func (d *TestData) GobDecode(b []byte) error {
+	dec := gob.NewDecoder(bytes.NewBuffer(b))
+	return dec.Decode(&(d.Data))
+}
+
+func (d *TestData) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(d.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func TestDataGobEncoding(t *testing.T) {
 	compression, _ := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
 	data := &TestData{&Data{
@@ -246,6 +246,9 @@ func Merge(parents []dvid.UUID, note string, mt MergeType) (dvid.UUID, error) {
 	if manager == nil {
 		return dvid.NilUUID, ErrManagerNotInitialized
 	}
+	if mt == MergeThreeWay {
+		return dvid.NilUUID, fmt.Errorf("three-way merges must use ThreeWayMerge()")
+	}
 	return manager.merge(parents, note, mt)
 }
 
@@ -592,3 +595,23 @@ func GetStorageBreakdown() (string, error) {
 	}
 	return string(m), nil
 }
+
+// GetStorageStats returns JSON for the runtime statistics of stores that keep them, e.g.,
+// the hit and miss counts of tiered cache stores.
+func GetStorageStats() (string, error) {
+	stores, err := storage.AllStores()
+	if err != nil {
+		return "", err
+	}
+	stats := make(map[string]interface{})
+	for alias, store := range stores {
+		if reporter, ok := store.(storage.StatsReporter); ok {
+			stats[string(alias)] = reporter.Stats()
+		}
+	}
+	m, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+	return string(m), nil
+}
@@ -0,0 +1,194 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports listing the changes to a data instance between two versions by
+	scanning the versioned keys of the instance.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Differ is a DataService that can describe the changes to its data between two versions.
+type Differ interface {
+	DataService
+
+	// DiffVersions returns a JSON-encodable, type-specific description of what was added,
+	// removed or modified going from version v1 to version v2.
+	DiffVersions(v1, v2 dvid.VersionID) (interface{}, error)
+}
+
+// Diff returns a type-specific description of the changes to a data instance going from
+// the version with the given UUID to the version with the other UUID.  Both versions
+// must be in the same repo.
+func Diff(uuid dvid.UUID, name dvid.InstanceName, otheruuid dvid.UUID) (interface{}, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	data, err := GetDataByUUIDName(uuid, name)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := data.(Differ)
+	if !ok {
+		return nil, fmt.Errorf("data %q of type %q does not support diffs", name, data.TypeName())
+	}
+	if !d.Versioned() {
+		return nil, fmt.Errorf("data %q is unversioned so has no changes between versions", name)
+	}
+	v1, err := manager.versionFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	v2, err := manager.versionFromUUID(otheruuid)
+	if err != nil {
+		return nil, err
+	}
+	r1, err := manager.repoFromVersion(v1)
+	if err != nil {
+		return nil, err
+	}
+	r2, err := manager.repoFromVersion(v2)
+	if err != nil {
+		return nil, err
+	}
+	if r1 != r2 {
+		return nil, fmt.Errorf("versions %s and %s are not in the same repo", uuid, otheruuid)
+	}
+	return d.DiffVersions(v1, v2)
+}
+
+// ChangedKeys calls f for each type-specific key between begTKey and endTKey, inclusive,
+// whose visible value differs between versions v1 and v2.  A nil value means the key is
+// absent or deleted at that version.  Only stored keys are read so the cost is proportional
+// to the number of key-value pairs across all versions in the range.
+func ChangedKeys(d dvid.Data, v1, v2 dvid.VersionID, begTKey, endTKey storage.TKey, f func(tk storage.TKey, value1, value2 []byte) error) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	ctx := NewVersionedCtx(d, 0)
+	minKey, err := ctx.MinVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+	maxKey, err := ctx.MaxVersionKey(endTKey)
+	if err != nil {
+		return err
+	}
+	return scanVersionedKeys(db, ctx, minKey, maxKey, func(tk storage.TKey, kvv kvVersions) error {
+		kv1, match1, err := manager.findMatchCopy(kvv, v1)
+		if err != nil {
+			return err
+		}
+		kv2, match2, err := manager.findMatchCopy(kvv, v2)
+		if err != nil {
+			return err
+		}
+		if match1 == match2 {
+			return nil
+		}
+		var value1, value2 []byte
+		if kv1 != nil {
+			value1 = kv1.V
+		}
+		if kv2 != nil {
+			value2 = kv2.V
+		}
+		if (value1 == nil) == (value2 == nil) && bytes.Equal(value1, value2) {
+			return nil
+		}
+		return f(tk, value1, value2)
+	})
+}
+
+// findMatchCopy returns the value visible at a version without modifying the given versions.
+func (m *repoManager) findMatchCopy(kvv kvVersions, v dvid.VersionID) (*storage.KeyValue, dvid.VersionID, error) {
+	dup := make(kvVersions, len(kvv))
+	for version, node := range kvv {
+		dup[version] = node
+	}
+	return m.findMatch(dup, v)
+}
+
+// scanVersionedKeys reads all versions of keys in a raw key range and calls f with the
+// versions of each type-specific key.
+func scanVersionedKeys(db storage.OrderedKeyValueDB, ctx *VersionedCtx, minKey, maxKey storage.Key, f func(storage.TKey, kvVersions) error) error {
+	return scanTKeys(db, minKey, maxKey, false, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		kvv := make(kvVersions, len(kvs))
+		for _, kv := range kvs {
+			v, err := ctx.VersionFromKey(kv.K)
+			if err != nil {
+				return err
+			}
+			kvv[v] = kvvNode{kv: kv}
+		}
+		return f(tk, kvv)
+	})
+}
+
+// scanTKeys reads the key-value pairs in a raw key range and calls f with all the pairs,
+// i.e., all versions, of each type-specific key.  Mutation journal entries aren't data, so
+// they are skipped.  The scan reads to the end of the range even if f returns an error,
+// which is then returned.
+func scanTKeys(db storage.OrderedKeyValueDB, minKey, maxKey storage.Key, keysOnly bool, f func(storage.TKey, []*storage.KeyValue) error) error {
+	return scanAllTKeys(db, minKey, maxKey, keysOnly, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		if isJournalKey(tk) {
+			return nil
+		}
+		return f(tk, kvs)
+	})
+}
+
+// scanAllTKeys is scanTKeys including mutation journal entries.
+func scanAllTKeys(db storage.OrderedKeyValueDB, minKey, maxKey storage.Key, keysOnly bool, f func(storage.TKey, []*storage.KeyValue) error) error {
+	// The channel is closed after the query so queries ending in error terminate the loop.
+	ch := make(chan *storage.KeyValue, 1000)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- db.RawRangeQuery(minKey, maxKey, keysOnly, ch, nil)
+		close(ch)
+	}()
+
+	// Keys are ordered by type-specific key then version.
+	var scanErr error
+	var curTK storage.TKey
+	var kvs []*storage.KeyValue
+	for kv := range ch {
+		if kv == nil || scanErr != nil {
+			continue // drain so the query can finish
+		}
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			scanErr = err
+			continue
+		}
+		if len(kvs) != 0 && !bytes.Equal(tk, curTK) {
+			if scanErr = f(curTK, kvs); scanErr != nil {
+				continue
+			}
+			kvs = nil
+		}
+		curTK = tk
+		kvs = append(kvs, kv)
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	if len(kvs) != 0 {
+		return f(curTK, kvs)
+	}
+	return nil
+}
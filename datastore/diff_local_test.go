@@ -0,0 +1,69 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestDiff(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	_, err := datastore.NewData(uuid, kvtype, "difftest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/difftest/key/%s", server.WebAPIPath, uuid, key)
+	}
+	for _, key := range []string{"kept", "modified", "rewritten", "removed"} {
+		server.TestHTTP(t, "POST", keyreq(uuid, key), strings.NewReader("root "+key))
+	}
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root %s: %v\n", uuid, err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyreq(child, "modified"), strings.NewReader("child modified"))
+	server.TestHTTP(t, "POST", keyreq(child, "rewritten"), strings.NewReader("root rewritten"))
+	server.TestHTTP(t, "DELETE", keyreq(child, "removed"), nil)
+	server.TestHTTP(t, "POST", keyreq(child, "added"), strings.NewReader("child added"))
+
+	diffreq := fmt.Sprintf("%snode/%s/difftest/diff/%s", server.WebAPIPath, uuid, child)
+	var diff keyvalue.VersionDiff
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", diffreq, nil), &diff); err != nil {
+		t.Fatalf("Bad diff JSON: %v\n", err)
+	}
+	expected := keyvalue.VersionDiff{Added: []string{"added"}, Removed: []string{"removed"}, Modified: []string{"modified"}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected diff %v, got %v\n", expected, diff)
+	}
+
+	// The reverse diff swaps added and removed keys.
+	diffreq = fmt.Sprintf("%snode/%s/difftest/diff/%s", server.WebAPIPath, child, uuid)
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", diffreq, nil), &diff); err != nil {
+		t.Fatalf("Bad diff JSON: %v\n", err)
+	}
+	expected = keyvalue.VersionDiff{Added: []string{"removed"}, Removed: []string{"added"}, Modified: []string{"modified"}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected reverse diff %v, got %v\n", expected, diff)
+	}
+
+	badreq := fmt.Sprintf("%snode/%s/difftest/diff/%s", server.WebAPIPath, uuid, dvid.NewUUID())
+	server.TestBadHTTP(t, "GET", badreq, nil)
+}
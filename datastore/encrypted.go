@@ -0,0 +1,4 @@
+package datastore
+
+// Encrypted stores wrap other configured stores so are always available.
+import _ "github.com/janelia-flyem/dvid/storage/encrypted"
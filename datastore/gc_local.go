@@ -0,0 +1,307 @@
+// +build !clustered,!gcloud
+
+/*
+	This file contains local server code supporting garbage collection of key-value pairs
+	that can no longer be returned from any version in a repo.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/go-humanize"
+)
+
+// DefaultGCBatchSize is the default number of key-value pairs deleted per batch during
+// garbage collection.
+const DefaultGCBatchSize = 1000
+
+// GCStats reports the progress or results of a garbage collection.
+type GCStats struct {
+	DryRun         bool   // if true, nothing was deleted.
+	Running        bool
+	Started        time.Time
+	Finished       time.Time         `json:",omitempty"`
+	Data           dvid.InstanceName `json:",omitempty"` // data instance being collected while running.
+	Instances      int               // number of data instances collected.
+	Scanned        uint64            // number of key-value pairs examined.
+	Deleted        uint64            // number of key-value pairs deleted or, if a dry run, deletable.
+	Orphans        uint64            // deleted key-value pairs for versions no longer in the DAG.
+	Tombstones     uint64            // deleted tombstones that no longer mask any ancestor value.
+	BytesReclaimed uint64            // key and value bytes of deleted key-value pairs.
+	Elapsed        string
+	Error          string `json:",omitempty"`
+}
+
+var collections = struct {
+	sync.Mutex
+	stats map[dvid.RepoID]*GCStats
+}{
+	stats: make(map[dvid.RepoID]*GCStats),
+}
+
+// GetGCStats returns the last or current garbage collection of a repo.
+func GetGCStats(uuid dvid.UUID) (*GCStats, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	collections.Lock()
+	defer collections.Unlock()
+	stats, found := collections.stats[r.id]
+	if !found {
+		return nil, fmt.Errorf("no garbage collection of repo %s since server start", uuid)
+	}
+	cp := *stats
+	return &cp, nil
+}
+
+// gcDAG is a snapshot of the versions in a repo at the start of garbage collection.
+type gcDAG struct {
+	// versions created after the snapshot have IDs at or above nextV and are never collected.
+	nextV dvid.VersionID
+
+	// ancestors holds the transitive ancestors for each version in the DAG.
+	ancestors map[dvid.VersionID]map[dvid.VersionID]struct{}
+}
+
+func (m *repoManager) snapshotDAG(r *repoT) *gcDAG {
+	m.idMutex.RLock()
+	nextV := m.versionID
+	m.idMutex.RUnlock()
+
+	r.RLock()
+	defer r.RUnlock()
+	g := &gcDAG{
+		nextV:     nextV,
+		ancestors: make(map[dvid.VersionID]map[dvid.VersionID]struct{}, len(r.dag.nodes)),
+	}
+	for v := range r.dag.nodes {
+		ancestors := make(map[dvid.VersionID]struct{})
+		toVisit := append([]dvid.VersionID{}, r.dag.nodes[v].parents...)
+		for len(toVisit) != 0 {
+			parent := toVisit[len(toVisit)-1]
+			toVisit = toVisit[:len(toVisit)-1]
+			if _, visited := ancestors[parent]; visited {
+				continue
+			}
+			ancestors[parent] = struct{}{}
+			if node, found := r.dag.nodes[parent]; found {
+				toVisit = append(toVisit, node.parents...)
+			}
+		}
+		g.ancestors[v] = ancestors
+	}
+	return g
+}
+
+type gcEntry struct {
+	k storage.Key
+	v dvid.VersionID
+	n uint64 // bytes of key and value
+}
+
+// collectible returns the entries for a single type-specific key that can never be
+// returned from a version in the DAG: those from versions no longer in the DAG and
+// tombstones with no ancestor value to mask.  Entries at version 0 hold unversioned data,
+// e.g., labelvol's repo-wide maximum label, and are never collected.
+func (g *gcDAG) collectible(entries []gcEntry, stats *GCStats) []gcEntry {
+	var toDelete []gcEntry
+	for _, e := range entries {
+		if e.v == 0 || e.v >= g.nextV {
+			continue
+		}
+		ancestors, found := g.ancestors[e.v]
+		if !found {
+			stats.Orphans++
+			toDelete = append(toDelete, e)
+			continue
+		}
+		if !e.k.IsTombstone() {
+			continue
+		}
+		var masking bool
+		for _, other := range entries {
+			if _, isAncestor := ancestors[other.v]; isAncestor && !other.k.IsTombstone() {
+				masking = true
+				break
+			}
+		}
+		if !masking {
+			stats.Tombstones++
+			toDelete = append(toDelete, e)
+		}
+	}
+	return toDelete
+}
+
+// StartGarbageCollection deletes, in the background, key-value pairs of all data instances
+// in a repo that can never be returned from any version in the repo's DAG.  Deletions are
+// done in batches and progress can be retrieved with GetGCStats.  Allowed settings in the
+// configuration:
+//
+//	dryrun=true      only computes what would be deleted
+//	batchsize=<n>    number of key-value pairs deleted per batch
+func StartGarbageCollection(uuid dvid.UUID, c dvid.Config) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	dryrun, _, err := c.GetString("dryrun")
+	if err != nil {
+		return err
+	}
+	batchSize := DefaultGCBatchSize
+	batchStr, found, err := c.GetString("batchsize")
+	if err != nil {
+		return err
+	}
+	if found {
+		if _, err := fmt.Sscanf(batchStr, "%d", &batchSize); err != nil || batchSize <= 0 {
+			return fmt.Errorf("bad batchsize %q for garbage collection", batchStr)
+		}
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+
+	collections.Lock()
+	if stats, found := collections.stats[r.id]; found && stats.Running {
+		collections.Unlock()
+		return fmt.Errorf("garbage collection of repo %s already running since %s", uuid, stats.Started)
+	}
+	stats := &GCStats{
+		DryRun:  dryrun == "true" || dryrun == "1",
+		Running: true,
+		Started: time.Now(),
+	}
+	collections.stats[r.id] = stats
+	collections.Unlock()
+
+	go func() {
+		err := manager.garbageCollect(r, batchSize, stats)
+		collections.Lock()
+		stats.Running = false
+		stats.Data = ""
+		stats.Finished = time.Now()
+		stats.Elapsed = stats.Finished.Sub(stats.Started).String()
+		if err != nil {
+			stats.Error = err.Error()
+		}
+		collections.Unlock()
+		if err != nil {
+			dvid.Errorf("error in garbage collection of repo %s: %v\n", uuid, err)
+			return
+		}
+		dvid.Infof("Garbage collection of repo %s (dry run %t): deleted %d of %d key-value pairs, reclaiming %s in %s\n",
+			uuid, stats.DryRun, stats.Deleted, stats.Scanned, humanize.Bytes(stats.BytesReclaimed), stats.Elapsed)
+	}()
+	return nil
+}
+
+// garbageCollect collects each data instance of a repo, publishing progress to the given
+// stats, which must only be accessed under the collections lock.
+func (m *repoManager) garbageCollect(r *repoT, batchSize int, stats *GCStats) error {
+	g := m.snapshotDAG(r)
+	r.RLock()
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	r.RUnlock()
+
+	collections.Lock()
+	progress := *stats
+	collections.Unlock()
+	for _, d := range instances {
+		store, err := getOrderedKeyValueDB(d)
+		if err != nil {
+			dvid.Infof("Skipping garbage collection of data %q: %v\n", d.DataName(), err)
+			continue
+		}
+		progress.Data = d.DataName()
+		publishGC(stats, &progress)
+		if err := g.collectData(d, store, batchSize, stats, &progress); err != nil {
+			return err
+		}
+		progress.Instances++
+		publishGC(stats, &progress)
+	}
+	return nil
+}
+
+// publishGC copies the counts of a collection in progress to its shared stats.
+func publishGC(stats, progress *GCStats) {
+	collections.Lock()
+	stats.Data = progress.Data
+	stats.Instances = progress.Instances
+	stats.Scanned = progress.Scanned
+	stats.Deleted = progress.Deleted
+	stats.Orphans = progress.Orphans
+	stats.Tombstones = progress.Tombstones
+	stats.BytesReclaimed = progress.BytesReclaimed
+	collections.Unlock()
+}
+
+// collectData scans all key-value pairs of a data instance, grouping them by type-specific
+// key to find collectible versions.  Progress is published after each batch.
+func (g *gcDAG) collectData(d dvid.Data, store storage.OrderedKeyValueDB, batchSize int, stats, progress *GCStats) error {
+	var batch []storage.Key
+	deleteBatch := func() error {
+		defer publishGC(stats, progress)
+		if progress.DryRun || len(batch) == 0 {
+			batch = batch[:0]
+			return nil
+		}
+		for _, k := range batch {
+			if err := store.RawDelete(k); err != nil {
+				return err
+			}
+		}
+		dvid.Debugf("Garbage collection deleted batch of %d key-value pairs from data %q\n", len(batch), d.DataName())
+		batch = batch[:0]
+		return nil
+	}
+
+	var entries []gcEntry
+	var unpublished int
+	minKey, maxKey := storage.NewDataContext(d, 0).KeyRange()
+	err := scanTKeys(store, minKey, maxKey, false, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		entries = entries[:0]
+		for _, kv := range kvs {
+			_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, gcEntry{k: kv.K, v: v, n: uint64(len(kv.K) + len(kv.V))})
+		}
+		progress.Scanned += uint64(len(kvs))
+		for _, e := range g.collectible(entries, progress) {
+			progress.Deleted++
+			progress.BytesReclaimed += e.n
+			batch = append(batch, e.k)
+			if len(batch) >= batchSize {
+				if err := deleteBatch(); err != nil {
+					return err
+				}
+			}
+		}
+		if unpublished += len(kvs); unpublished >= batchSize {
+			publishGC(stats, progress)
+			unpublished = 0
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteBatch()
+}
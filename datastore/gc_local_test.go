@@ -0,0 +1,93 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, versionID := initTestRepo()
+	dataservice, err := datastore.NewData(uuid, kvtype, "gcdata", dvid.NewConfig())
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	kvdata := dataservice.(*keyvalue.Data)
+
+	// Key-values are written directly so the instance has no journal entries.
+	// Deleting a key that was never written leaves a tombstone masking nothing.
+	rootctx := datastore.NewVersionedCtx(dataservice, versionID)
+	if err := kvdata.PutData(rootctx, "kept", []byte("root value")); err != nil {
+		t.Fatalf("Could not put keyvalue data: %v\n", err)
+	}
+	if err := kvdata.DeleteData(rootctx, "neverwritten"); err != nil {
+		t.Fatalf("Could not delete keyvalue data: %v\n", err)
+	}
+	if err := datastore.Commit(uuid, "root commit", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+
+	// A tombstone masking an ancestor value is kept.
+	child, err := datastore.NewVersion(uuid, "child", nil)
+	if err != nil {
+		t.Fatalf("Unable to branch: %v\n", err)
+	}
+	childV, err := datastore.VersionFromUUID(child)
+	if err != nil {
+		t.Fatalf("Unable to get version of child: %v\n", err)
+	}
+	if err := kvdata.DeleteData(datastore.NewVersionedCtx(dataservice, childV), "kept"); err != nil {
+		t.Fatalf("Could not delete keyvalue data: %v\n", err)
+	}
+
+	gcreq := fmt.Sprintf("%srepo/%s/gc", server.WebAPIPath, uuid)
+	stats := runGC(t, gcreq, "?dryrun=true")
+	if !stats.DryRun || stats.Scanned != 3 || stats.Deleted != 1 || stats.Tombstones != 1 || stats.BytesReclaimed == 0 {
+		t.Errorf("Bad dry run gc results: %+v\n", stats)
+	}
+	stats = runGC(t, gcreq, "?batchsize=1")
+	if stats.DryRun || stats.Deleted != 1 {
+		t.Errorf("Bad gc results: %+v\n", stats)
+	}
+	stats = runGC(t, gcreq, "")
+	if stats.Scanned != 2 || stats.Deleted != 0 {
+		t.Errorf("Expected nothing to collect after gc: %+v\n", stats)
+	}
+
+	rootreq := fmt.Sprintf("%snode/%s/gcdata/key/", server.WebAPIPath, uuid)
+	if value := server.TestHTTP(t, "GET", rootreq+"kept", nil); string(value) != "root value" {
+		t.Errorf("Expected root value after gc, got %q\n", string(value))
+	}
+	childreq := fmt.Sprintf("%snode/%s/gcdata/key/", server.WebAPIPath, child)
+	server.TestBadHTTP(t, "GET", childreq+"kept", nil)
+}
+
+// runGC starts a garbage collection and waits for it to finish.
+func runGC(t *testing.T, gcreq, query string) datastore.GCStats {
+	server.TestHTTP(t, "POST", gcreq+query, nil)
+	var stats datastore.GCStats
+	for {
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", gcreq, nil), &stats); err != nil {
+			t.Fatalf("Unable to parse gc stats: %v\n", err)
+		}
+		if !stats.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.Error != "" {
+		t.Fatalf("Error in gc: %s\n", stats.Error)
+	}
+	return stats
+}
@@ -0,0 +1,32 @@
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// groupcacheResolver lets the storage package's groupcache load keys requested by peers
+// and determine which versions are immutable.
+type groupcacheResolver struct{}
+
+func (groupcacheResolver) GroupcacheContext(id dvid.InstanceID, v dvid.VersionID) (storage.Context, storage.KeyValueDB, error) {
+	d, err := getDataByInstanceID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := d.BackendStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, ok := store.(storage.KeyValueDB)
+	if !ok {
+		return nil, nil, fmt.Errorf("store for data %q is not a key-value store", d.DataName())
+	}
+	return NewVersionedCtx(d, v), db, nil
+}
+
+func (groupcacheResolver) LockedVersion(v dvid.VersionID) (bool, error) {
+	return LockedVersion(v)
+}
@@ -0,0 +1,234 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports an append-only journal of mutations for each data instance and
+	version.  Journal entries are stored with the data instance's key-value pairs under a
+	reserved key class so they are transferred and deleted along with the instance.
+*/
+
+package datastore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// journalKeyClass is the reserved key class of journal entries within a data instance.
+const journalKeyClass storage.TKeyClass = 242
+
+// MutationEntry records a mutation of a data instance.  The method, endpoint, query and
+// payload are sufficient to replay the mutation through the HTTP API of another instance.
+// Mutations with large payloads, e.g., label volumes, may be recorded with only the size
+// and hash of the payload, in which case they can't be replayed.
+type MutationEntry struct {
+	ID          uint64    `json:"id"`
+	Time        time.Time `json:"time"`
+	User        string    `json:"user,omitempty"`
+	Method      string    `json:"method"`
+	Endpoint    string    `json:"endpoint"` // path following the data instance name, e.g., "key/foo"
+	Query       string    `json:"query,omitempty"`
+	Payload     []byte    `json:"payload,omitempty"`
+	PayloadSize int       `json:"payload_size,omitempty"`   // size of a payload recorded by hash
+	PayloadHash string    `json:"payload_sha256,omitempty"` // hex SHA-256 of a payload recorded by hash
+	Result      string    `json:"result,omitempty"`         // type-specific result, e.g., a new label
+}
+
+// Replayable returns true if the entry holds the payload needed to replay the mutation.
+func (entry MutationEntry) Replayable() bool {
+	return entry.PayloadHash == ""
+}
+
+// mutationIDs holds the last mutation ID issued for each data instance.
+var mutationIDs = struct {
+	sync.Mutex
+	last map[dvid.UUID]uint64
+}{last: make(map[dvid.UUID]uint64)}
+
+func journalTKey(id uint64) storage.TKey {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, id)
+	return storage.NewTKey(journalKeyClass, buf)
+}
+
+func journalIDFromTKey(tk storage.TKey) (uint64, error) {
+	buf, err := tk.ClassBytes(journalKeyClass)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 8 {
+		return 0, fmt.Errorf("bad journal key of %d bytes", len(buf))
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+// journalRange returns the raw keys bounding all versions of journal entries with IDs
+// starting at the given ID.
+func journalRange(d dvid.Data, begID uint64) (minKey, maxKey storage.Key, err error) {
+	ctx := storage.NewDataContext(d, 0)
+	if minKey, err = ctx.MinVersionKey(journalTKey(begID)); err != nil {
+		return
+	}
+	maxKey, err = ctx.MaxVersionKey(storage.MaxTKey(journalKeyClass))
+	return
+}
+
+// NewMutationID returns a new mutation ID for a data instance.  Types that need the ID
+// while performing a mutation, e.g., to store data for undoing it, can get an ID with this
+// function and later journal the mutation with RecordMutationID.
+func NewMutationID(d dvid.Data) (uint64, error) {
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		return 0, err
+	}
+	mutationIDs.Lock()
+	defer mutationIDs.Unlock()
+
+	last, found := mutationIDs.last[d.DataUUID()]
+	if !found {
+		if last, err = lastMutationID(db, d); err != nil {
+			return 0, err
+		}
+	}
+	mutationIDs.last[d.DataUUID()] = last + 1
+	return last + 1, nil
+}
+
+// RecordMutation appends an entry for a mutation made through the given HTTP request to
+// the journal of the data instance at the given version.  The payload is the request
+// body and the result is an optional type-specific description of the outcome.  The
+// user is given by the "u" query string.  The mutation ID of the new entry is returned.
+func RecordMutation(d dvid.Data, v dvid.VersionID, r *http.Request, payload []byte, result string) (uint64, error) {
+	id, err := NewMutationID(d)
+	if err != nil {
+		return 0, err
+	}
+	return id, RecordMutationID(d, v, id, r, payload, result)
+}
+
+// RecordMutationRef is like RecordMutation but only records the size and SHA-256 hash of
+// the payload, e.g., for label volumes whose extents are already given by the endpoint.
+func RecordMutationRef(d dvid.Data, v dvid.VersionID, r *http.Request, payload []byte, result string) (uint64, error) {
+	id, err := NewMutationID(d)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(payload)
+	entry := MutationEntry{
+		ID:          id,
+		PayloadSize: len(payload),
+		PayloadHash: hex.EncodeToString(sum[:]),
+		Result:      result,
+	}
+	return id, putMutation(d, v, r, entry)
+}
+
+// RecordMutationID is like RecordMutation but uses a mutation ID from NewMutationID.
+func RecordMutationID(d dvid.Data, v dvid.VersionID, id uint64, r *http.Request, payload []byte, result string) error {
+	return putMutation(d, v, r, MutationEntry{ID: id, Payload: payload, Result: result})
+}
+
+// putMutation completes the entry with the details of the request and stores it in the
+// journal of the data instance at the given version.
+func putMutation(d dvid.Data, v dvid.VersionID, r *http.Request, entry MutationEntry) error {
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+
+	// Drop the lease token so it isn't kept in the journal.
+	query := r.URL.Query()
+	user := query.Get("u")
+	query.Del("lease")
+
+	// Endpoint follows /api/node/<uuid>/<data name>/
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 5)
+	var endpoint string
+	if len(parts) == 5 {
+		endpoint = parts[4]
+	}
+	entry.Time = time.Now()
+	entry.User = user
+	entry.Method = r.Method
+	entry.Endpoint = endpoint
+	entry.Query = query.Encode()
+	compression, err := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	serialization, err := dvid.Serialize(entry, compression, dvid.CRC32)
+	if err != nil {
+		return err
+	}
+	key := storage.NewDataContext(d, v).ConstructKey(journalTKey(entry.ID))
+	return db.RawPut(key, serialization)
+}
+
+// lastMutationID returns the largest mutation ID in the journal of a data instance.
+func lastMutationID(db storage.OrderedKeyValueDB, d dvid.Data) (uint64, error) {
+	minKey, maxKey, err := journalRange(d, 0)
+	if err != nil {
+		return 0, err
+	}
+	var last uint64
+	err = scanAllTKeys(db, minKey, maxKey, true, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		id, err := journalIDFromTKey(tk)
+		if err != nil {
+			return err
+		}
+		if id > last {
+			last = id
+		}
+		return nil
+	})
+	return last, err
+}
+
+// GetMutations returns the journal entries of a data instance at a version with mutation
+// IDs greater than the given ID, in order of mutation.
+func GetMutations(d dvid.Data, v dvid.VersionID, since uint64) ([]MutationEntry, error) {
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	minKey, maxKey, err := journalRange(d, since+1)
+	if err != nil {
+		return nil, err
+	}
+	entries := []MutationEntry{}
+	err = scanAllTKeys(db, minKey, maxKey, false, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		for _, kv := range kvs {
+			_, kvV, _, err := storage.DataKeyToLocalIDs(kv.K)
+			if err != nil {
+				return err
+			}
+			if kvV != v || kv.K.IsTombstone() {
+				continue
+			}
+			var entry MutationEntry
+			if err := dvid.Deserialize(kv.V, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// isJournalKey returns true if the type-specific key is a journal entry.
+func isJournalKey(tk storage.TKey) bool {
+	class, err := tk.Class()
+	return err == nil && class == journalKeyClass
+}
@@ -0,0 +1,97 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestMutationJournal(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	for _, name := range []dvid.InstanceName{"journaled", "replayed"} {
+		if _, err := datastore.NewData(uuid, kvtype, name, config); err != nil {
+			t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+		}
+	}
+	keyreq := func(name, key string) string {
+		return fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, name, key)
+	}
+	mutreq := fmt.Sprintf("%snode/%s/journaled/mutations", server.WebAPIPath, uuid)
+
+	server.TestHTTP(t, "POST", keyreq("journaled", "a")+"?u=jane", strings.NewReader("first a"))
+	server.TestHTTP(t, "POST", keyreq("journaled", "b")+"?u=joe", strings.NewReader("b value"))
+	server.TestHTTP(t, "POST", keyreq("journaled", "a")+"?u=jane", strings.NewReader("second a"))
+	server.TestHTTP(t, "DELETE", keyreq("journaled", "b")+"?u=joe", nil)
+
+	var entries []datastore.MutationEntry
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", mutreq, nil), &entries); err != nil {
+		t.Fatalf("Bad mutations response: %v\n", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 journal entries, got %d\n", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.ID != uint64(i+1) {
+			t.Errorf("Expected mutation id %d, got %d\n", i+1, entry.ID)
+		}
+	}
+	if entries[1].User != "joe" || entries[1].Method != "POST" || entries[1].Endpoint != "key/b" || string(entries[1].Payload) != "b value" {
+		t.Errorf("Bad journal entry: %v\n", entries[1])
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", mutreq+"?since=2", nil), &entries); err != nil {
+		t.Fatalf("Bad mutations response: %v\n", err)
+	}
+	if len(entries) != 2 || entries[0].ID != 3 || entries[1].Method != "DELETE" {
+		t.Errorf("Bad journal entries since mutation 2: %v\n", entries)
+	}
+
+	// Journal entries are accounted under their own key class.
+	if kc := instanceAccounting(t, "journaled").KeyClasses["242"]; kc == nil || kc.Name != "mutation journal" || kc.Keys != 4 {
+		t.Errorf("Bad journal key class accounting: %v\n", kc)
+	}
+
+	// Replay the journal into another instance.
+	replayreq := fmt.Sprintf("%snode/%s/replayed/mutations", server.WebAPIPath, uuid)
+	payload := fmt.Sprintf(`{"uuid":%q,"data":"journaled"}`, uuid)
+	server.TestHTTP(t, "POST", replayreq, strings.NewReader(payload))
+	if got := server.TestHTTP(t, "GET", keyreq("replayed", "a"), nil); string(got) != "second a" {
+		t.Errorf("Expected replayed value %q, got %q\n", "second a", string(got))
+	}
+	server.TestBadHTTP(t, "GET", keyreq("replayed", "b"), nil)
+
+	// Mutation ids continue after a restart and journals are kept per version.
+	if err := datastore.Commit(uuid, "journaled", nil); err != nil {
+		t.Fatalf("Unable to commit: %v\n", err)
+	}
+	datastore.CloseReopenTest()
+	child, err := datastore.NewVersion(uuid, "child", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/journaled/key/c", server.WebAPIPath, child), strings.NewReader("c"))
+	childreq := fmt.Sprintf("%snode/%s/journaled/mutations", server.WebAPIPath, child)
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", childreq, nil), &entries); err != nil {
+		t.Fatalf("Bad mutations response: %v\n", err)
+	}
+	if len(entries) != 1 || entries[0].ID != 5 || entries[0].Endpoint != "key/c" {
+		t.Errorf("Bad journal entries for child: %v\n", entries)
+	}
+
+	// Journal entries aren't data, so garbage collection doesn't scan them.
+	stats := runGC(t, fmt.Sprintf("%srepo/%s/gc", server.WebAPIPath, uuid), "?dryrun=true")
+	if stats.Scanned != 5 {
+		t.Errorf("Expected gc to scan only the 5 data keys: %+v\n", stats)
+	}
+}
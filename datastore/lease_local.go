@@ -0,0 +1,196 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports checkouts of uncommitted nodes: time-limited leases that reserve
+	mutations of a node for the owner holding the lease token.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// MaxLeaseTTL is the longest time a lease can be held without renewal.
+const MaxLeaseTTL = 24 * time.Hour
+
+type leaseT struct {
+	owner    string
+	token    string
+	acquired time.Time
+	expires  time.Time
+}
+
+func (l *leaseT) active() bool {
+	return l != nil && time.Now().Before(l.expires)
+}
+
+// Lease describes the checkout of a node.  The token is only given to the lease holder.
+type Lease struct {
+	Owner    string    `json:"owner"`
+	Token    string    `json:"token,omitempty"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (l *leaseT) describe(withToken bool) *Lease {
+	lease := &Lease{Owner: l.owner, Acquired: l.acquired, Expires: l.expires}
+	if withToken {
+		lease.Token = l.token
+	}
+	return lease
+}
+
+// Checkout leases an uncommitted node to an owner for the given duration.  While the lease
+// is active, mutations of the node require the returned lease's token.  A node can only be
+// checked out by one owner at a time.
+func Checkout(uuid dvid.UUID, owner string, ttl time.Duration) (*Lease, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if owner == "" {
+		return nil, fmt.Errorf("checkout of node %s requires an owner", uuid)
+	}
+	if err := checkLeaseTTL(ttl); err != nil {
+		return nil, err
+	}
+	node, err := manager.nodeFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	node.Lock()
+	defer node.Unlock()
+
+	if node.locked {
+		return nil, ErrModifyLockedNode
+	}
+	if node.lease.active() {
+		return nil, fmt.Errorf("node %s is checked out by %q until %s", uuid, node.lease.owner, node.lease.expires.Format(time.RFC3339))
+	}
+	t := time.Now()
+	node.lease = &leaseT{
+		owner:    owner,
+		token:    string(dvid.NewUUID()),
+		acquired: t,
+		expires:  t.Add(ttl),
+	}
+	dvid.Infof("Node %s checked out by %q until %s\n", uuid, owner, node.lease.expires)
+	return node.lease.describe(true), nil
+}
+
+// RenewLease extends the lease of a node to the given duration from now.
+func RenewLease(uuid dvid.UUID, token string, ttl time.Duration) (*Lease, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if err := checkLeaseTTL(ttl); err != nil {
+		return nil, err
+	}
+	node, err := manager.nodeFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	node.Lock()
+	defer node.Unlock()
+
+	if !node.lease.active() {
+		return nil, ErrNoLease
+	}
+	if node.lease.token != token {
+		return nil, ErrLeaseMismatch
+	}
+	node.lease.expires = time.Now().Add(ttl)
+	return node.lease.describe(true), nil
+}
+
+// ReleaseLease ends the lease of a node so it can be modified by anyone.
+func ReleaseLease(uuid dvid.UUID, token string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	node, err := manager.nodeFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	node.Lock()
+	defer node.Unlock()
+
+	if !node.lease.active() {
+		return ErrNoLease
+	}
+	if node.lease.token != token {
+		return ErrLeaseMismatch
+	}
+	dvid.Infof("Node %s released by %q\n", uuid, node.lease.owner)
+	node.lease = nil
+	return nil
+}
+
+// GetLease returns the active lease of a node without its token, or nil if the node isn't
+// checked out.
+func GetLease(uuid dvid.UUID) (*Lease, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	node, err := manager.nodeFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	node.RLock()
+	defer node.RUnlock()
+
+	if !node.lease.active() {
+		return nil, nil
+	}
+	return node.lease.describe(false), nil
+}
+
+// CheckLease returns an error if a node is checked out and the given token is not the
+// lease's token.
+func CheckLease(uuid dvid.UUID, token string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	node, err := manager.nodeFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	node.RLock()
+	defer node.RUnlock()
+
+	if !node.lease.active() || node.lease.token == token {
+		return nil
+	}
+	return fmt.Errorf("node %s is checked out by %q until %s", uuid, node.lease.owner, node.lease.expires.Format(time.RFC3339))
+}
+
+func checkLeaseTTL(ttl time.Duration) error {
+	if ttl <= 0 || ttl > MaxLeaseTTL {
+		return fmt.Errorf("lease duration must be positive and at most %s, not %s", MaxLeaseTTL, ttl)
+	}
+	return nil
+}
+
+// nodeFromUUID returns the node of a UUID.
+func (m *repoManager) nodeFromUUID(uuid dvid.UUID) (*nodeT, error) {
+	v, err := m.versionFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	r, err := m.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+
+	node, found := r.dag.nodes[v]
+	if !found {
+		return nil, ErrInvalidVersion
+	}
+	return node, nil
+}
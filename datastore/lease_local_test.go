@@ -0,0 +1,68 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestCheckout(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	_, err := datastore.NewData(uuid, kvtype, "leasetest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/leasetest/key/a", server.WebAPIPath, uuid)
+	nodereq := func(action string) string {
+		return fmt.Sprintf("%snode/%s/%s", server.WebAPIPath, uuid, action)
+	}
+
+	var lease datastore.Lease
+	resp := server.TestHTTP(t, "POST", nodereq("checkout"), strings.NewReader(`{"owner":"jane","ttl":60}`))
+	if err := json.Unmarshal(resp, &lease); err != nil {
+		t.Fatalf("Bad checkout response: %v\n", err)
+	}
+	if lease.Owner != "jane" || lease.Token == "" {
+		t.Fatalf("Bad lease: %v\n", lease)
+	}
+
+	// Only the lease holder can modify the node, and only one owner can check it out.
+	server.TestBadHTTP(t, "POST", nodereq("checkout"), strings.NewReader(`{"owner":"joe","ttl":60}`))
+	server.TestBadHTTP(t, "POST", keyreq, strings.NewReader("joe's value"))
+	server.TestBadHTTP(t, "POST", keyreq+"?lease=bogus", strings.NewReader("joe's value"))
+	server.TestHTTP(t, "POST", keyreq+"?lease="+lease.Token, strings.NewReader("jane's value"))
+	if got := server.TestHTTP(t, "GET", keyreq, nil); string(got) != "jane's value" {
+		t.Errorf("Expected lease holder's value, got %q\n", string(got))
+	}
+
+	var state struct {
+		Locked bool
+		Lease  *datastore.Lease
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", nodereq("commit"), nil), &state); err != nil {
+		t.Fatalf("Bad commit state response: %v\n", err)
+	}
+	if state.Locked || state.Lease == nil || state.Lease.Owner != "jane" || state.Lease.Token != "" {
+		t.Errorf("Bad commit state for checked out node: %v\n", state)
+	}
+
+	server.TestBadHTTP(t, "POST", nodereq("renew"), strings.NewReader(`{"token":"bogus","ttl":60}`))
+	server.TestHTTP(t, "POST", nodereq("renew"), strings.NewReader(fmt.Sprintf(`{"token":%q,"ttl":120}`, lease.Token)))
+	server.TestHTTP(t, "POST", nodereq("release"), strings.NewReader(fmt.Sprintf(`{"token":%q}`, lease.Token)))
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("joe's value"))
+	if got, err := datastore.GetLease(uuid); err != nil || got != nil {
+		t.Errorf("Expected no lease after release, got %v (%v)\n", got, err)
+	}
+}
@@ -0,0 +1,4 @@
+package datastore
+
+// The in-memory store is pure Go and always compiled in so there is a testable engine.
+import _ "github.com/janelia-flyem/dvid/storage/memstore"
@@ -0,0 +1,274 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports three-way merges of two versions, where key-value pairs changed in
+	both branches since their common ancestor are merged by data type-specific code.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// ThreeWayMerger is a DataService that can merge the values of a key changed in both
+// branches of a three-way merge.
+type ThreeWayMerger interface {
+	DataService
+
+	// MergeKey returns a description of a type-specific key for merge reports, e.g., a
+	// key name or block coordinate.
+	MergeKey(storage.TKey) string
+
+	// MergeValues merges the stored values of a key changed in both branches since their
+	// common ancestor.  A nil value means the key was absent or deleted.  Elements changed
+	// differently in both branches are taken from the first branch and counted as conflicts.
+	// A nil merged value deletes the key.  Merged values are stored without sync events, so
+	// three-way merges are refused if other data instances are synced to the instance.
+	MergeValues(tk storage.TKey, base, a, b []byte) (merged []byte, conflicts int, err error)
+}
+
+// MergeConflict describes a key changed differently in both branches of a three-way merge.
+type MergeConflict struct {
+	Key       string `json:"key"`       // type-specific description of key, e.g., block coordinate
+	Conflicts int    `json:"conflicts"` // number of conflicting elements, e.g., voxels
+}
+
+// InstanceMergeReport gives the results of a three-way merge for one data instance.
+type InstanceMergeReport struct {
+	Taken     int             `json:"taken"`  // keys changed in only one branch
+	Merged    int             `json:"merged"` // keys changed in both branches merged without conflicts
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+// MergeReport gives the results of a three-way merge.  Data instances that do not support
+// three-way merges are listed in "skipped" and are merged as if conflict-free.
+type MergeReport struct {
+	Child     dvid.UUID                                  `json:"child"`
+	Ancestor  dvid.UUID                                  `json:"ancestor"`
+	Instances map[dvid.InstanceName]*InstanceMergeReport `json:"instances"`
+	Skipped   []dvid.InstanceName                        `json:"skipped,omitempty"`
+}
+
+// ThreeWayMerge creates a child of two committed parents, merging key-value pairs changed in
+// both parents since their nearest common ancestor for all data instances that implement
+// ThreeWayMerger.  A report of the merge, including conflicting keys, is returned.  If the
+// merge of any data instance fails, the child is deleted.
+func ThreeWayMerge(parents []dvid.UUID, note string) (*MergeReport, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.threeWayMerge(parents, note)
+}
+
+// commonAncestor returns the nearest common ancestor of two versions.
+func (m *repoManager) commonAncestor(v1, v2 dvid.VersionID) (dvid.VersionID, error) {
+	r, err := m.repoFromVersion(v1)
+	if err != nil {
+		return 0, err
+	}
+	ancestors, err := r.ancestorSet(v1)
+	if err != nil {
+		return 0, err
+	}
+
+	// Breadth-first search from v2 so the nearest common ancestor is found first.
+	r.RLock()
+	defer r.RUnlock()
+	visited := map[dvid.VersionID]struct{}{v2: struct{}{}}
+	todo := []dvid.VersionID{v2}
+	for len(todo) > 0 {
+		cur := todo[0]
+		todo = todo[1:]
+		if _, found := ancestors[cur]; found {
+			return cur, nil
+		}
+		parents, err := r.dag.getParents(cur)
+		if err != nil {
+			return 0, err
+		}
+		for _, parent := range parents {
+			if _, found := visited[parent]; !found {
+				visited[parent] = struct{}{}
+				todo = append(todo, parent)
+			}
+		}
+	}
+	return 0, fmt.Errorf("versions %d and %d have no common ancestor", v1, v2)
+}
+
+// threeWayMerge merges two committed parents into a new child.  Data instances that
+// implement ThreeWayMerger have keys changed in both branches since the common ancestor
+// merged into the child, with the first parent's changes used for conflicting elements.
+// Keys changed in only one branch are taken by the child through the version DAG.
+func (m *repoManager) threeWayMerge(parents []dvid.UUID, note string) (*MergeReport, error) {
+	if len(parents) != 2 {
+		return nil, fmt.Errorf("three-way merge requires exactly two parents, got %d", len(parents))
+	}
+	var parentsV [2]dvid.VersionID
+	for i, parent := range parents {
+		v, err := m.versionFromUUID(parent)
+		if err != nil {
+			return nil, err
+		}
+		parentsV[i] = v
+	}
+	ancestorV, err := m.commonAncestor(parentsV[0], parentsV[1])
+	if err != nil {
+		return nil, err
+	}
+	ancestor, err := m.uuidFromVersion(ancestorV)
+	if err != nil {
+		return nil, err
+	}
+	parentRepo, err := m.repoFromUUID(parents[0])
+	if err != nil {
+		return nil, err
+	}
+	if synced := parentRepo.syncedMergers(); len(synced) != 0 {
+		return nil, fmt.Errorf("three-way merge would not update instances synced to data %v", synced)
+	}
+
+	child, err := m.merge(parents, note, MergeThreeWay)
+	if err != nil {
+		return nil, err
+	}
+	childV, err := m.versionFromUUID(child)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := m.repoFromUUID(child)
+	if err != nil {
+		return nil, err
+	}
+	r.RLock()
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	r.RUnlock()
+
+	report := &MergeReport{
+		Child:     child,
+		Ancestor:  ancestor,
+		Instances: make(map[dvid.InstanceName]*InstanceMergeReport),
+	}
+	for _, d := range instances {
+		merger, ok := d.(ThreeWayMerger)
+		if !ok || !d.Versioned() {
+			report.Skipped = append(report.Skipped, d.DataName())
+			continue
+		}
+		ireport, err := mergeInstance(merger, ancestorV, parentsV, childV)
+		if err != nil {
+			if delErr := m.deleteLeaf(child); delErr != nil {
+				dvid.Errorf("Unable to delete child %s of failed three-way merge: %v\n", child, delErr)
+			}
+			return nil, fmt.Errorf("three-way merge of data %q: %v", d.DataName(), err)
+		}
+		report.Instances[d.DataName()] = ireport
+	}
+	sort.Sort(instanceNames(report.Skipped))
+	return report, nil
+}
+
+// syncedMergers returns the names of versioned data instances implementing ThreeWayMerger
+// that have other data instances subscribed to their changes.
+func (r *repoT) syncedMergers() []dvid.InstanceName {
+	r.RLock()
+	defer r.RUnlock()
+	var names []dvid.InstanceName
+	for _, d := range r.data {
+		if _, ok := d.(ThreeWayMerger); !ok || !d.Versioned() {
+			continue
+		}
+		for e, subs := range r.subs {
+			if e.Data == d.DataUUID() && len(subs) != 0 {
+				names = append(names, d.DataName())
+				break
+			}
+		}
+	}
+	sort.Sort(instanceNames(names))
+	return names
+}
+
+type instanceNames []dvid.InstanceName
+
+func (n instanceNames) Len() int           { return len(n) }
+func (n instanceNames) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n instanceNames) Less(i, j int) bool { return n[i] < n[j] }
+
+// mergeInstance merges the keys of a data instance changed in both parents into the child.
+func mergeInstance(d ThreeWayMerger, ancestorV dvid.VersionID, parentsV [2]dvid.VersionID, childV dvid.VersionID) (*InstanceMergeReport, error) {
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	childCtx := NewVersionedCtx(d, childV)
+	baseCtx := NewVersionedCtx(d, 0)
+	report := &InstanceMergeReport{Conflicts: []MergeConflict{}}
+
+	mergeKey := func(tk storage.TKey, kvv kvVersions) error {
+		baseKV, baseV, err := manager.findMatchCopy(kvv, ancestorV)
+		if err != nil {
+			return err
+		}
+		var kvs [2]*storage.KeyValue
+		var changed [2]bool
+		for i, v := range parentsV {
+			kv, matchV, err := manager.findMatchCopy(kvv, v)
+			if err != nil {
+				return err
+			}
+			kvs[i] = kv
+			changed[i] = matchV != baseV
+		}
+		if !changed[0] || !changed[1] {
+			if changed[0] || changed[1] {
+				report.Taken++
+			}
+			return nil
+		}
+
+		var base, a, b []byte
+		if baseKV != nil {
+			base = baseKV.V
+		}
+		if kvs[0] != nil {
+			a = kvs[0].V
+		}
+		if kvs[1] != nil {
+			b = kvs[1].V
+		}
+		var merged []byte
+		var conflicts int
+		if (a == nil) == (b == nil) && bytes.Equal(a, b) {
+			merged = a
+		} else if merged, conflicts, err = d.MergeValues(tk, base, a, b); err != nil {
+			return err
+		}
+		if conflicts != 0 {
+			report.Conflicts = append(report.Conflicts, MergeConflict{Key: d.MergeKey(tk), Conflicts: conflicts})
+		} else {
+			report.Merged++
+		}
+		if merged == nil {
+			return db.Delete(childCtx, tk)
+		}
+		return db.Put(childCtx, tk, merged)
+	}
+
+	// Each branch keeps its own journal entries, which the scan skips.
+	minKey, maxKey := baseCtx.KeyRange()
+	if err := scanVersionedKeys(db, baseCtx, minKey, maxKey, mergeKey); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,99 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestThreeWayMerge(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	_, err := datastore.NewData(uuid, kvtype, "threeway", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/threeway/key/%s", server.WebAPIPath, uuid, key)
+	}
+	for _, key := range []string{"a", "b", "same", "conflict"} {
+		server.TestHTTP(t, "POST", keyreq(uuid, key), strings.NewReader("root "+key))
+	}
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root %s: %v\n", uuid, err)
+	}
+
+	// Make two branches with changes to different keys, the same change to one key,
+	// and conflicting changes to another.
+	branch1, err := datastore.NewVersion(uuid, "branch 1", nil)
+	if err != nil {
+		t.Fatalf("Unable to create branch: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyreq(branch1, "a"), strings.NewReader("branch1 a"))
+	server.TestHTTP(t, "POST", keyreq(branch1, "same"), strings.NewReader("both"))
+	server.TestHTTP(t, "POST", keyreq(branch1, "conflict"), strings.NewReader("branch1 conflict"))
+	if err = datastore.Commit(branch1, "branch 1", nil); err != nil {
+		t.Fatalf("Unable to commit %s: %v\n", branch1, err)
+	}
+
+	branch2, err := datastore.NewVersion(uuid, "branch 2", nil)
+	if err != nil {
+		t.Fatalf("Unable to create branch: %v\n", err)
+	}
+	server.TestHTTP(t, "DELETE", keyreq(branch2, "b"), nil)
+	server.TestHTTP(t, "POST", keyreq(branch2, "same"), strings.NewReader("both"))
+	server.TestHTTP(t, "POST", keyreq(branch2, "conflict"), strings.NewReader("branch2 conflict"))
+	server.TestHTTP(t, "POST", keyreq(branch2, "new"), strings.NewReader("branch2 new"))
+	if err = datastore.Commit(branch2, "branch 2", nil); err != nil {
+		t.Fatalf("Unable to commit %s: %v\n", branch2, err)
+	}
+
+	// Only two parents are allowed.
+	mergereq := fmt.Sprintf("%srepo/%s/merge", server.WebAPIPath, uuid)
+	payload := fmt.Sprintf(`{"mergeType":"three-way","parents":[%q],"note":"bad merge"}`, branch1)
+	server.TestBadHTTP(t, "POST", mergereq, bytes.NewBufferString(payload))
+
+	payload = fmt.Sprintf(`{"mergeType":"three-way","parents":[%q,%q],"note":"three-way merge"}`, branch1, branch2)
+	respData := server.TestHTTP(t, "POST", mergereq, bytes.NewBufferString(payload))
+	var report datastore.MergeReport
+	if err := json.Unmarshal(respData, &report); err != nil {
+		t.Fatalf("Bad merge report %q: %v\n", string(respData), err)
+	}
+	if report.Ancestor != uuid {
+		t.Errorf("Expected common ancestor %s, got %s\n", uuid, report.Ancestor)
+	}
+	ireport, found := report.Instances["threeway"]
+	if !found {
+		t.Fatalf("Expected merge report for instance, got %s\n", string(respData))
+	}
+	if ireport.Taken != 3 || ireport.Merged != 1 || len(ireport.Conflicts) != 1 {
+		t.Errorf("Bad merge report: %s\n", string(respData))
+	} else if ireport.Conflicts[0].Key != "conflict" || ireport.Conflicts[0].Conflicts != 1 {
+		t.Errorf("Bad conflict in merge report: %v\n", ireport.Conflicts[0])
+	}
+
+	expected := map[string]string{
+		"a":        "branch1 a",
+		"same":     "both",
+		"conflict": "branch1 conflict",
+		"new":      "branch2 new",
+	}
+	for key, value := range expected {
+		if returnValue := server.TestHTTP(t, "GET", keyreq(report.Child, key), nil); string(returnValue) != value {
+			t.Errorf("Expected %q for key %q in merged child, got %q\n", value, key, string(returnValue))
+		}
+	}
+	server.TestBadHTTP(t, "GET", keyreq(report.Child, "b"), nil)
+}
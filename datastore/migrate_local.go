@@ -0,0 +1,391 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports online migration of a data instance to another store while the
+	server continues to serve requests for the instance.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// MigrationBatchSize is the maximum number of key-value pairs copied while writes to a
+// migrating data instance are blocked.
+const MigrationBatchSize = 1000
+
+// MigrationStats describes the last or current online migration of a data instance.
+type MigrationStats struct {
+	Data     dvid.InstanceName
+	From     string
+	To       storage.Alias
+	Running  bool
+	Started  time.Time
+	Switched time.Time `json:",omitempty"` // when the instance began using the new store
+	Finished time.Time `json:",omitempty"`
+	Copied   uint64    // key-value pairs copied
+	Bytes    uint64    // bytes of keys and values copied
+	Error    string    `json:",omitempty"`
+}
+
+var migrations = struct {
+	sync.Mutex
+	stats map[dvid.UUID]*MigrationStats // keyed by data UUID
+}{
+	stats: make(map[dvid.UUID]*MigrationStats),
+}
+
+// GetMigrationStats returns the last or current online migration of a data instance.
+func GetMigrationStats(uuid dvid.UUID, name dvid.InstanceName) (*MigrationStats, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	d, err := manager.getDataByUUIDName(uuid, name)
+	if err != nil {
+		return nil, err
+	}
+	migrations.Lock()
+	defer migrations.Unlock()
+	stats, found := migrations.stats[d.DataUUID()]
+	if !found {
+		return nil, fmt.Errorf("no migration of data %q since server start", name)
+	}
+	cp := *stats
+	return &cp, nil
+}
+
+// MigrateInstanceOnline moves all key-value pairs of a data instance to the store with the
+// given alias without restarting the server.  The copy is done in the background while
+// mutations are written to both stores.  When the copy completes, the instance is switched
+// to the new store, the assignment is saved so it persists across restarts, and the
+// instance's key-value pairs are deleted from the old store.
+func MigrateInstanceOnline(uuid dvid.UUID, name dvid.InstanceName, alias storage.Alias) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	d, err := manager.getDataByUUIDName(uuid, name)
+	if err != nil {
+		return err
+	}
+	oldStore, err := d.BackendStore()
+	if err != nil {
+		return err
+	}
+	oldKV, ok := oldStore.(storage.OrderedKeyValueDB)
+	if !ok {
+		return fmt.Errorf("unable to migrate data %q from store %s which isn't ordered kv store", name, oldStore)
+	}
+	newStore, err := storage.GetStoreByAlias(alias)
+	if err != nil {
+		return err
+	}
+	newKV, ok := newStore.(storage.OrderedKeyValueDB)
+	if !ok {
+		return fmt.Errorf("unable to migrate data %q to store %q which isn't ordered kv store", name, alias)
+	}
+	if _, ok := oldStore.(storage.KeyValueBatcher); !ok {
+		return fmt.Errorf("unable to migrate data %q from store %s which can't batch writes", name, oldStore)
+	}
+	if _, ok := newStore.(storage.KeyValueBatcher); !ok {
+		return fmt.Errorf("unable to migrate data %q to store %q which can't batch writes", name, alias)
+	}
+	if oldStore == newStore {
+		return fmt.Errorf("data %q already uses store %q", name, alias)
+	}
+
+	// Keys left in the new store, e.g., by an earlier migration, could resurrect deleted data.
+	begKey, endKey := storage.NewDataContext(d, 0).KeyRange()
+	existing, err := storage.ReadRawBatch(newKV, begKey, endKey, 1)
+	if err != nil {
+		return err
+	}
+	if len(existing) != 0 {
+		return fmt.Errorf("unable to migrate data %q to store %q, which already holds its key-value pairs", name, alias)
+	}
+
+	migrations.Lock()
+	if stats, found := migrations.stats[d.DataUUID()]; found && stats.Running {
+		migrations.Unlock()
+		return fmt.Errorf("data %q is already being migrated to store %q", name, stats.To)
+	}
+	stats := &MigrationStats{
+		Data:    name,
+		From:    oldStore.String(),
+		To:      alias,
+		Running: true,
+		Started: time.Now(),
+	}
+	migrations.stats[d.DataUUID()] = stats
+	migrations.Unlock()
+
+	ms := &migratingStore{old: oldKV, new: newKV}
+	d.SetBackendStore(ms)
+
+	go func() {
+		err := ms.migrate(d, alias, stats)
+		migrations.Lock()
+		stats.Running = false
+		stats.Finished = time.Now()
+		if err != nil {
+			stats.Error = err.Error()
+		}
+		migrations.Unlock()
+		if err != nil {
+			dvid.Errorf("error in online migration of data %q to store %q: %v\n", name, alias, err)
+			return
+		}
+		dvid.Infof("Finished online migration of data %q to store %q: %d key-value pairs in %s\n",
+			name, alias, stats.Copied, time.Since(stats.Started))
+	}()
+
+	dvid.Infof("Migrating data %q online from store %s to store %q ...\n", name, oldStore, alias)
+	return nil
+}
+
+// migratingStore writes to both the old and new stores of a data instance while it is
+// copied, reading from the old store until switched to the new store.  Data services
+// holding the migrating store continue to work after the switch.
+type migratingStore struct {
+	old, new storage.OrderedKeyValueDB
+
+	// writeMu is held for reading during writes and for writing by copy batches and the switch.
+	writeMu   sync.RWMutex
+	switched  bool
+	abandoned bool // if true, the migration failed and only the old store is written
+}
+
+func (ms *migratingStore) String() string {
+	return fmt.Sprintf("migration of %s to %s", ms.old, ms.new)
+}
+
+func (ms *migratingStore) Equal(config dvid.StoreConfig) bool {
+	return false
+}
+
+// Close does nothing since the stores are closed by the storage manager.
+func (ms *migratingStore) Close() {}
+
+// reader returns the store to read from.
+func (ms *migratingStore) reader() storage.OrderedKeyValueDB {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	if ms.switched {
+		return ms.new
+	}
+	return ms.old
+}
+
+// write applies a write to the new store, and the old store if not yet switched.  The
+// caller should hold writeMu for reading.
+func (ms *migratingStore) write(f func(db storage.OrderedKeyValueDB) error) error {
+	if ms.abandoned {
+		return f(ms.old)
+	}
+	if !ms.switched {
+		if err := f(ms.old); err != nil {
+			return err
+		}
+	}
+	return f(ms.new)
+}
+
+// migrate copies all key-value pairs of the data instance in batches, switches the data
+// instance to the new store, and deletes its key-value pairs from the old store.
+func (ms *migratingStore) migrate(d DataService, alias storage.Alias, stats *MigrationStats) error {
+	ctx := storage.NewDataContext(d, 0)
+	begKey, endKey := ctx.KeyRange()
+	for {
+		done, next, err := ms.copyBatch(begKey, endKey, stats)
+		if err != nil {
+			return ms.abandon(d, ctx, err)
+		}
+		if done {
+			break
+		}
+		begKey = next
+	}
+
+	ms.writeMu.Lock()
+	if err := storage.AssignStore(d.DataName(), d.RootUUID(), alias); err != nil {
+		ms.writeMu.Unlock()
+		return ms.abandon(d, ctx, err)
+	}
+	ms.switched = true
+	ms.writeMu.Unlock()
+
+	store, err := storage.GetAssignedStore(d.DataName(), d.RootUUID(), d.TypeName())
+	if err != nil {
+		return err
+	}
+	d.SetBackendStore(store)
+	migrations.Lock()
+	stats.Switched = time.Now()
+	migrations.Unlock()
+
+	dvid.Infof("Starting delete of instance %q from old storage %s\n", d.DataName(), ms.old)
+	if err := ms.old.DeleteAll(ctx, true); err != nil {
+		return fmt.Errorf("deleting instance %q from %s after copy: %v", d.DataName(), ms.old, err)
+	}
+	return nil
+}
+
+// abandon switches the data instance back to the old store and deletes the partial copy
+// from the new store, so a later migration can't resurrect keys deleted in the meantime.
+func (ms *migratingStore) abandon(d DataService, ctx storage.Context, err error) error {
+	ms.writeMu.Lock()
+	ms.abandoned = true
+	ms.writeMu.Unlock()
+	d.SetBackendStore(ms.old)
+
+	dvid.Infof("Deleting partial copy of instance %q from store %s after failed migration\n", d.DataName(), ms.new)
+	if delErr := ms.new.DeleteAll(ctx, true); delErr != nil {
+		return fmt.Errorf("%v (partial copy in %s could not be deleted: %v)", err, ms.new, delErr)
+	}
+	return err
+}
+
+// copyBatch copies one batch of key-value pairs starting at begKey from the old store to the
+// new store and returns the next key to copy or done if all keys were copied.
+func (ms *migratingStore) copyBatch(begKey, endKey storage.Key, stats *MigrationStats) (done bool, next storage.Key, err error) {
+	ms.writeMu.Lock()
+	defer ms.writeMu.Unlock()
+
+	var kvs []*storage.KeyValue
+	if kvs, err = storage.ReadRawBatch(ms.old, begKey, endKey, MigrationBatchSize); err != nil {
+		return
+	}
+	if len(kvs) < MigrationBatchSize {
+		done = true
+	}
+
+	var bytes uint64
+	for _, kv := range kvs {
+		if err = ms.new.RawPut(kv.K, kv.V); err != nil {
+			return
+		}
+		bytes += uint64(len(kv.K) + len(kv.V))
+	}
+	if len(kvs) != 0 {
+		next = append(append(storage.Key{}, kvs[len(kvs)-1].K...), 0)
+	}
+
+	migrations.Lock()
+	stats.Copied += uint64(len(kvs))
+	stats.Bytes += bytes
+	migrations.Unlock()
+	return
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (ms *migratingStore) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	return ms.reader().Get(ctx, tk)
+}
+
+func (ms *migratingStore) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	return ms.reader().KeysInRange(ctx, kStart, kEnd)
+}
+
+func (ms *migratingStore) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	return ms.reader().SendKeysInRange(ctx, kStart, kEnd, ch)
+}
+
+func (ms *migratingStore) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	return ms.reader().GetRange(ctx, kStart, kEnd)
+}
+
+func (ms *migratingStore) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	return ms.reader().ProcessRange(ctx, kStart, kEnd, op, f)
+}
+
+func (ms *migratingStore) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	return ms.reader().RawRangeQuery(kStart, kEnd, keysOnly, out, cancel)
+}
+
+// ---- KeyValueSetter interface ------
+
+func (ms *migratingStore) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	return ms.write(func(db storage.OrderedKeyValueDB) error { return db.Put(ctx, tk, v) })
+}
+
+func (ms *migratingStore) Delete(ctx storage.Context, tk storage.TKey) error {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	return ms.write(func(db storage.OrderedKeyValueDB) error { return db.Delete(ctx, tk) })
+}
+
+func (ms *migratingStore) RawPut(k storage.Key, v []byte) error {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	return ms.write(func(db storage.OrderedKeyValueDB) error { return db.RawPut(k, v) })
+}
+
+func (ms *migratingStore) RawDelete(k storage.Key) error {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	return ms.write(func(db storage.OrderedKeyValueDB) error { return db.RawDelete(k) })
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (ms *migratingStore) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	return ms.write(func(db storage.OrderedKeyValueDB) error { return db.PutRange(ctx, kvs) })
+}
+
+func (ms *migratingStore) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	return ms.write(func(db storage.OrderedKeyValueDB) error { return db.DeleteRange(ctx, kStart, kEnd) })
+}
+
+func (ms *migratingStore) DeleteAll(ctx storage.Context, allVersions bool) error {
+	ms.writeMu.RLock()
+	defer ms.writeMu.RUnlock()
+	return ms.write(func(db storage.OrderedKeyValueDB) error { return db.DeleteAll(ctx, allVersions) })
+}
+
+// --- Batcher interface ----
+
+type migratingBatch struct {
+	ms       *migratingStore
+	old, new storage.Batch
+}
+
+func (ms *migratingStore) NewBatch(ctx storage.Context) storage.Batch {
+	return &migratingBatch{
+		ms:  ms,
+		old: ms.old.(storage.KeyValueBatcher).NewBatch(ctx),
+		new: ms.new.(storage.KeyValueBatcher).NewBatch(ctx),
+	}
+}
+
+func (b *migratingBatch) Delete(tk storage.TKey) {
+	b.old.Delete(tk)
+	b.new.Delete(tk)
+}
+
+func (b *migratingBatch) Put(tk storage.TKey, v []byte) {
+	b.old.Put(tk, v)
+	b.new.Put(tk, v)
+}
+
+func (b *migratingBatch) Commit() error {
+	b.ms.writeMu.RLock()
+	defer b.ms.writeMu.RUnlock()
+	if !b.ms.switched {
+		if err := b.old.Commit(); err != nil {
+			return err
+		}
+	}
+	return b.new.Commit()
+}
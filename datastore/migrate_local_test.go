@@ -0,0 +1,100 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func TestOnlineMigration(t *testing.T) {
+	datastore.OpenTestWithStores("other")
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "keyvalue", "moving", dvid.NewConfig())
+	keyreq := fmt.Sprintf("%snode/%s/moving/key/", server.WebAPIPath, uuid)
+	for i := 0; i < 2500; i++ {
+		server.TestHTTP(t, "POST", keyreq+fmt.Sprintf("key%04d", i), strings.NewReader(fmt.Sprintf("value %d", i)))
+	}
+
+	migratereq := fmt.Sprintf("%srepo/%s/instance/moving/migrate", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", migratereq, strings.NewReader(`{"store": "nonexistent"}`))
+	server.TestHTTP(t, "POST", migratereq, strings.NewReader(`{"store": "other"}`))
+
+	// Mutations are accepted while the copy proceeds.
+	server.TestHTTP(t, "POST", keyreq+"during", strings.NewReader("written during migration"))
+	server.TestHTTP(t, "DELETE", keyreq+"key0000", nil)
+
+	var stats datastore.MigrationStats
+	for i := 0; i < 100; i++ {
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", migratereq, nil), &stats); err != nil {
+			t.Fatalf("Unable to parse migration stats: %v\n", err)
+		}
+		if !stats.Running {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if stats.Running || stats.Error != "" || stats.To != "other" || stats.Copied < 2500 {
+		t.Fatalf("Bad migration stats: %+v\n", stats)
+	}
+
+	d, err := datastore.GetDataByUUIDName(uuid, "moving")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := d.BackendStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := storage.GetStoreByAlias("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store != other {
+		t.Errorf("Expected data instance to use store %s after migration, got %s\n", other, store)
+	}
+	if value := server.TestHTTP(t, "GET", keyreq+"key2499", nil); string(value) != "value 2499" {
+		t.Errorf("Expected copied value after migration, got %q\n", string(value))
+	}
+	if value := server.TestHTTP(t, "GET", keyreq+"during", nil); string(value) != "written during migration" {
+		t.Errorf("Expected value written during migration, got %q\n", string(value))
+	}
+	server.TestBadHTTP(t, "GET", keyreq+"key0000", nil)
+
+	// The instance's data is gone from the old store and the assignment survives restarts.
+	defaultStore, err := storage.DefaultOrderedKVStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := storage.NewDataContext(d, 0)
+	begKey, endKey := ctx.KeyRange()
+	ch := make(chan *storage.KeyValue)
+	go defaultStore.RawRangeQuery(begKey, endKey, true, ch, nil)
+	if kv := <-ch; kv != nil {
+		t.Errorf("Expected no data left in old store, got key %v\n", kv.K)
+	}
+
+	datastore.CloseReopenTest()
+	if d, err = datastore.GetDataByUUIDName(uuid, "moving"); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = d.BackendStore(); err != nil {
+		t.Fatal(err)
+	}
+	if other, err = storage.GetStoreByAlias("other"); err != nil {
+		t.Fatal(err)
+	}
+	if store != other {
+		t.Errorf("Expected store assignment to persist after restart, got %s\n", store)
+	}
+}
@@ -0,0 +1,4 @@
+package datastore
+
+// Mirrored stores are composed of other configured stores so are always available.
+import _ "github.com/janelia-flyem/dvid/storage/mirror"
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 // SyncEvent identifies an event in which a data instance has modified its data
@@ -26,8 +27,23 @@ type SyncMessage struct {
 	Delta   interface{}
 }
 
+// DeltaSummarizer is implemented by deltas of sync messages that can be summarized for
+// notifications outside the server, e.g., webhooks.  The summary is encoded as JSON.
+type DeltaSummarizer interface {
+	Summary() interface{}
+}
+
+// DeltaLocator is implemented by deltas of sync messages that change known regions of space.
+// Extents returns the voxel extents of the change, using the block size of the notifying
+// data instance for changes given by block.  The block size is zero if it is unknown, in
+// which case block changes can't be located and false is returned.
+type DeltaLocator interface {
+	Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool)
+}
+
 // SyncSub is a subscription request from an instance to be notified via a channel when
-// a given data instance has a given event.
+// a given data instance has a given event.  If the channel is nil, the notified instance
+// must be a TxnSyncer and is only sent the event through PrepareSyncTxn.
 type SyncSub struct {
 	Event  SyncEvent
 	Notify dvid.UUID // the data UUID of data instance to notify
@@ -66,6 +82,14 @@ type Syncer interface {
 	SyncedData() dvid.UUIDSet
 }
 
+// TxnSyncer types apply changes of synced data within the transaction of the notifying
+// data instance so both commit atomically.  SyncTxn adds the updates for a message to
+// the transaction and returns a function that must be called once the transaction is
+// committed or abandoned, with true only if it was committed.
+type TxnSyncer interface {
+	SyncTxn(txn *storage.Transaction, m SyncMessage) (done func(committed bool), err error)
+}
+
 // SetSyncByJSON takes a JSON object of sync names and UUID, and creates the sync graph
 // and sets the data instance's sync.
 func SetSyncByJSON(d dvid.Data, uuid dvid.UUID, in io.ReadCloser) error {
@@ -132,3 +156,18 @@ func NotifySubscribers(e SyncEvent, m SyncMessage) error {
 	// Use the repo notification system to notify internal subscribers.
 	return repo.notifySubscribers(e, m)
 }
+
+// PrepareSyncTxn adds to a transaction the updates for an event by data instances that
+// subscribed to it without a channel.  The returned function must be called once the
+// transaction is committed or abandoned.  The event should still be sent through
+// NotifySubscribers after a successful commit.
+func PrepareSyncTxn(txn *storage.Transaction, e SyncEvent, m SyncMessage) (done func(committed bool), err error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	repo, err := manager.repoFromVersion(m.Version)
+	if err != nil {
+		return nil, err
+	}
+	return repo.prepareSyncTxn(txn, e, m)
+}
@@ -0,0 +1,409 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports pulling a repo from a remote DVID server.  A pull runs the push
+	protocol in reverse: the pulling server opens a pull session on the remote, which
+	customizes and sends its repo metadata just as it would for a push.  The pulling
+	server then requests the versions it lacks, and the remote runs the usual PushData
+	for each data instance, queuing key-values that the pulling server fetches in batches.
+	Since the pulling server makes all the calls, the remote never has to connect back.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/rpc"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/go-humanize"
+
+	"github.com/valyala/gorpc"
+)
+
+const (
+	// maximum number of queued items and total bytes of key-values returned per batch.
+	pullBatchSize  = 1000
+	pullBatchBytes = 8 << 20
+
+	// time a batch request waits for the first queued item.  It must be shorter than the
+	// gorpc request timeout.
+	pullBatchWait = 10 * time.Second
+
+	// interval between progress reports of a pull.
+	pullProgressInterval = 30 * time.Second
+)
+
+// PullRepo adds a repo from a remote DVID server at the target address to this server.
+// The config accepts the "data", "filter" and "transmit" settings of a push, which are
+// applied by the remote.  As with a push, only versions this server lacks are requested,
+// so newer versions of a repo already pulled are added to the local repo.  If the pull
+// fails, the key-values already received are deleted.
+func PullRepo(uuid dvid.UUID, target string, config dvid.Config) (err error) {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+
+	if target == "" {
+		target = rpc.DefaultAddress
+		dvid.Infof("No target specified for pull, defaulting to %q\n", rpc.DefaultAddress)
+	}
+
+	s, err := rpc.NewSession(target, pullMessageID)
+	if err != nil {
+		return fmt.Errorf("Unable to connect (%s) for pull: %s", target, err.Error())
+	}
+	defer s.Close()
+
+	// Have the remote send its repo metadata customized by our settings.
+	settings, err := config.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	resp, err := s.Call()(pullRepoMsg, &pullReqMsg{Session: s.ID(), UUID: uuid, Config: settings})
+	if err != nil {
+		return err
+	}
+	repoMsg, ok := resp.(*repoTxMsg)
+	if !ok {
+		return fmt.Errorf("received response during repo pull that wasn't expected repo metadata")
+	}
+
+	// Determine the versions we need and have the remote start sending them.
+	p := &pusher{startTime: time.Now()}
+	versions, err := p.readRepo(repoMsg)
+	if err != nil {
+		return err
+	}
+	newData := p.newData()
+	if len(versions) == 0 && len(newData) == 0 {
+		return fmt.Errorf("pull unnecessary -- versions and data of repo %s at %q are already present", uuid, target)
+	}
+	defer func() {
+		if err != nil {
+			p.abort()
+		}
+	}()
+	dvid.Infof("Pulling %d versions and %d new data instances of repo %s from %q\n", len(versions), len(newData), uuid, target)
+	req := &pullVersionsReq{Session: s.ID(), Versions: versions, NewData: newData}
+	if _, err := s.Call()(pullVersionsMsg, req); err != nil {
+		return err
+	}
+
+	timedLog := dvid.NewTimeLog()
+	kvs, err := p.receivePull(target, func() (*pullBatch, error) {
+		resp, err := s.Call()(pullBatchMsg, s.ID())
+		if err != nil {
+			return nil, fmt.Errorf("error pulling repo %s from %q: %v", uuid, target, err)
+		}
+		batch, ok := resp.(*pullBatch)
+		if !ok {
+			return nil, fmt.Errorf("received response during repo pull that wasn't expected key-value batch")
+		}
+		return batch, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.Close(); err != nil {
+		return err
+	}
+	timedLog.Infof("Pulled repo %s from %q: %d key-value pairs, %s", uuid, target, kvs, humanize.Bytes(p.received))
+	return nil
+}
+
+// receivePull stores the batches returned by next until the remote has sent all data,
+// returning the number of key-value pairs received.
+func (p *pusher) receivePull(target string, next func() (*pullBatch, error)) (uint64, error) {
+	var kvs uint64
+	lastReport := time.Now()
+	for {
+		batch, err := next()
+		if err != nil {
+			return kvs, err
+		}
+		for i := range batch.Items {
+			item := &batch.Items[i]
+			if item.Start != nil {
+				dvid.Infof("Pulling instance %q data from %q\n", item.Start.DataName, target)
+				if err := p.startData(item.Start); err != nil {
+					return kvs, err
+				}
+				continue
+			}
+			if err := p.putData(&item.KV); err != nil {
+				return kvs, err
+			}
+			if !item.KV.Terminate {
+				kvs++
+			}
+		}
+		if elapsed := time.Since(lastReport); elapsed > pullProgressInterval {
+			dvid.Infof("Pull of repo %s from %q: received %d key-value pairs (%s) in %s, currently instance %q\n",
+				p.uuid, target, kvs, humanize.Bytes(p.received), time.Since(p.startTime), p.dname)
+			lastReport = time.Now()
+		}
+		if batch.Done {
+			return kvs, nil
+		}
+	}
+}
+
+var (
+	pullMessageID rpc.MessageID = "datastore.Pull"
+)
+
+const (
+	pullRepoMsg     = "datastore.pullRepo"
+	pullVersionsMsg = "datastore.pullVersions"
+	pullBatchMsg    = "datastore.pullBatch"
+)
+
+func init() {
+	rpc.RegisterSessionMaker(pullMessageID, rpc.NewSessionHandlerFunc(makePullSession))
+
+	d := rpc.Dispatcher()
+	d.AddFunc(pullRepoMsg, handlePullRepo)
+	d.AddFunc(pullVersionsMsg, handlePullVersions)
+	d.AddFunc(pullBatchMsg, handlePullBatch)
+
+	gorpc.RegisterType(&pullReqMsg{})
+	gorpc.RegisterType(&pullVersionsReq{})
+	gorpc.RegisterType(&pullBatch{})
+}
+
+type pullReqMsg struct {
+	Session rpc.SessionID
+	UUID    dvid.UUID
+	Config  []byte // JSON push settings
+}
+
+type pullVersionsReq struct {
+	Session  rpc.SessionID
+	Versions map[dvid.VersionID]struct{}
+	NewData  []dvid.InstanceName // instances the pulling server lacks, which need all versions
+}
+
+// pullItem is either the start of a data instance or a key-value message.
+type pullItem struct {
+	Start *DataTxInit
+	KV    KVMessage
+}
+
+type pullBatch struct {
+	Items []pullItem
+	Done  bool // true if all data has been sent
+}
+
+func getPullSession(s rpc.SessionID) (*pullSource, error) {
+	handler, err := rpc.GetSessionHandler(s)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := handler.(*pullSource)
+	if !ok {
+		return nil, fmt.Errorf("handler for session %d is not expected pull type: %v", s, handler)
+	}
+	return p, nil
+}
+
+func handlePullRepo(m *pullReqMsg) (*repoTxMsg, error) {
+	p, err := getPullSession(m.Session)
+	if err != nil {
+		return nil, err
+	}
+	return p.sendRepo(m)
+}
+
+func handlePullVersions(m *pullVersionsReq) error {
+	p, err := getPullSession(m.Session)
+	if err != nil {
+		return err
+	}
+	return p.startSend(m.Versions, m.NewData)
+}
+
+func handlePullBatch(sid rpc.SessionID) (*pullBatch, error) {
+	p, err := getPullSession(sid)
+	if err != nil {
+		return nil, err
+	}
+	return p.nextBatch()
+}
+
+// --- The following is the remote side of a pull command ----
+
+// pullSource queues the key-values of a repo for a pulling server.
+type pullSource struct {
+	sessionID rpc.SessionID
+	uuid      dvid.UUID
+	repo      *repoT // customized repo being sent
+	filter    storage.FilterSpec
+	transmit  rpc.Transmit
+
+	ch   chan pullItem // closed after all data is queued
+	done chan struct{} // closed when the session closes
+
+	mu  sync.Mutex
+	err error // error that stopped the send
+}
+
+func makePullSession(rpc.MessageID) (rpc.SessionHandler, error) {
+	dvid.Debugf("Creating pull session...\n")
+	return &pullSource{done: make(chan struct{})}, nil
+}
+
+// --- rpc.SessionHandler interface implementation ---
+
+func (p *pullSource) ID() rpc.SessionID {
+	return p.sessionID
+}
+
+func (p *pullSource) Open(sid rpc.SessionID) error {
+	dvid.Debugf("Pull start, session %d\n", sid)
+	p.sessionID = sid
+	return nil
+}
+
+func (p *pullSource) Close() error {
+	dvid.Debugf("Closing pull session %d of uuid %s\n", p.sessionID, p.uuid)
+	close(p.done)
+	return nil
+}
+
+func (p *pullSource) sendRepo(m *pullReqMsg) (*repoTxMsg, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	config := dvid.NewConfig()
+	if err := config.SetByJSON(bytes.NewBuffer(m.Config)); err != nil {
+		return nil, err
+	}
+	txRepo, transmit, filter, err := customizeTransfer(m.UUID, config)
+	if err != nil {
+		return nil, err
+	}
+	repoSerialization, err := txRepo.GobEncode()
+	if err != nil {
+		return nil, err
+	}
+	p.uuid = m.UUID
+	p.repo = txRepo
+	p.filter = filter
+	p.transmit = transmit
+	dvid.Infof("Sending repo %s data for pull (session %d)\n", m.UUID, p.sessionID)
+	return &repoTxMsg{Session: m.Session, Transmit: transmit, UUID: m.UUID, Repo: repoSerialization}, nil
+}
+
+// startSend starts queuing the key-values of each data instance for the given versions or,
+// for new data instances, all versions.
+func (p *pullSource) startSend(versions map[dvid.VersionID]struct{}, newData []dvid.InstanceName) error {
+	if p.repo == nil {
+		return fmt.Errorf("pull session %d requested versions before repo", p.sessionID)
+	}
+	if p.ch != nil {
+		return fmt.Errorf("pull session %d already sending", p.sessionID)
+	}
+	delta := &pushDelta{Versions: versions, NewData: newData}
+	dataVersions := lackingVersions(p.repo, delta, nil)
+	p.ch = make(chan pullItem, pullBatchSize)
+	go func() {
+		defer close(p.ch)
+		ps := &PushSession{Filter: p.filter, t: p.transmit, q: p}
+		for name, d := range p.repo.data {
+			if ps.Versions = dataVersions[name]; len(ps.Versions) == 0 {
+				continue
+			}
+			dvid.Infof("Sending instance %q data for pull (session %d)\n", d.DataName(), p.sessionID)
+			if err := d.PushData(ps); err != nil {
+				dvid.Errorf("Aborting send of instance %q data for pull: %v\n", d.DataName(), err)
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+				return
+			}
+			select {
+			case <-p.done:
+				return
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+// queue adds an item for the pulling server, returning an error once the session has
+// closed so the push of the current data instance stops.
+func (p *pullSource) queue(item pullItem) error {
+	select {
+	case <-p.done:
+		return fmt.Errorf("pull session %d closed", p.sessionID)
+	default:
+	}
+	select {
+	case p.ch <- item:
+		return nil
+	case <-p.done:
+		return fmt.Errorf("pull session %d closed", p.sessionID)
+	}
+}
+
+func (p *pullSource) startData(d dvid.Data) error {
+	return p.queue(pullItem{Start: &DataTxInit{
+		Session:    p.sessionID,
+		DataName:   d.DataName(),
+		TypeName:   d.TypeName(),
+		InstanceID: d.InstanceID(),
+	}})
+}
+
+func (p *pullSource) putKV(kv *storage.KeyValue) error {
+	return p.queue(pullItem{KV: KVMessage{Session: p.sessionID, KV: *kv}})
+}
+
+func (p *pullSource) endData() error {
+	return p.queue(pullItem{KV: KVMessage{Session: p.sessionID, Terminate: true}})
+}
+
+// nextBatch returns the queued items, waiting a limited time for the first one.
+func (p *pullSource) nextBatch() (*pullBatch, error) {
+	if p.ch == nil {
+		return nil, fmt.Errorf("pull session %d has not been sent versions", p.sessionID)
+	}
+	batch := new(pullBatch)
+	var size int
+	timeout := time.After(pullBatchWait)
+	for len(batch.Items) < pullBatchSize && size < pullBatchBytes {
+		var item pullItem
+		var ok bool
+		if len(batch.Items) == 0 {
+			select {
+			case item, ok = <-p.ch:
+			case <-timeout:
+				return batch, nil
+			}
+		} else {
+			select {
+			case item, ok = <-p.ch:
+			default:
+				return batch, nil
+			}
+		}
+		if !ok {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.err != nil {
+				return nil, p.err
+			}
+			batch.Done = true
+			return batch, nil
+		}
+		batch.Items = append(batch.Items, item)
+		size += len(item.KV.KV.K) + len(item.KV.KV.V)
+	}
+	return batch, nil
+}
@@ -13,6 +13,8 @@
 package datastore
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -26,7 +28,11 @@ import (
 	"github.com/valyala/gorpc"
 )
 
-// PushRepo pushes a Repo to a remote DVID server at the target address.
+// PushRepo pushes a Repo to a remote DVID server at the target address.  Only versions
+// the remote lacks are sent, along with all versions of data instances the remote lacks.
+// If a checkpoint of an interrupted push of the repo to the target with the same settings
+// exists, the interrupted push is resumed first.  Otherwise the data instances it left
+// unfinished are sent again in full.
 func PushRepo(uuid dvid.UUID, target string, config dvid.Config) error {
 	if manager == nil {
 		return ErrManagerNotInitialized
@@ -37,25 +43,7 @@ func PushRepo(uuid dvid.UUID, target string, config dvid.Config) error {
 		dvid.Infof("No target specified for push, defaulting to %q\n", rpc.DefaultAddress)
 	}
 
-	// Get the full local repo
-	thisRepo, err := manager.repoFromUUID(uuid)
-	if err != nil {
-		return err
-	}
-
-	// Get any filter
-	filter, found, err := config.GetString("filter")
-	if err != nil {
-		return err
-	}
-
-	// Create a repo that is tailored by the push configuration, e.g.,
-	// keeping just given data instances, etc.
-	v, found := manager.uuidToVersion[uuid]
-	if !found {
-		return ErrInvalidUUID
-	}
-	txRepo, transmit, err := thisRepo.customize(v, config)
+	txRepo, transmit, filter, err := customizeTransfer(uuid, config)
 	if err != nil {
 		return err
 	}
@@ -73,7 +61,7 @@ func PushRepo(uuid dvid.UUID, target string, config dvid.Config) error {
 	if err != nil {
 		return err
 	}
-	repoMsg := repoTxMsg{
+	repoMsg := &repoTxMsg{
 		Session:  s.ID(),
 		Transmit: transmit,
 		UUID:     uuid,
@@ -83,36 +71,145 @@ func PushRepo(uuid dvid.UUID, target string, config dvid.Config) error {
 	if err != nil {
 		return err
 	}
-	if resp == nil {
-		return fmt.Errorf("push unnecessary -- versions at remote are already present")
-	}
 
-	// We should get back a version set to send, or nil = send all versions.
-	versions, ok := resp.(map[dvid.VersionID]struct{})
+	// We should get back the versions and data instances the remote lacks.
+	delta, ok := resp.(*pushDelta)
 	if !ok {
-		return fmt.Errorf("received response during repo push that wasn't expected set of delta versions")
+		return fmt.Errorf("received response during repo push that wasn't expected delta of versions and data")
 	}
-	dvid.Debugf("Remote sent list of %d versions to send\n", len(versions))
+	dvid.Debugf("Remote lacks %d versions and %d data instances\n", len(delta.Versions), len(delta.NewData))
 
 	// For each data instance, send the data with optional datatype-specific filtering.
-	ps := &PushSession{storage.FilterSpec(filter), versions, s, transmit}
-	for _, d := range txRepo.data {
-		dvid.Infof("Sending instance %q data to %q\n", d.DataName(), target)
-		if err := d.PushData(ps); err != nil {
-			dvid.Errorf("Aborting send of instance %q data\n", d.DataName())
-			return err
+	ps := &PushSession{Filter: filter, s: s, t: transmit}
+	settings := pushSettings(transmit, filter, txRepo)
+	cp, err := getPushCheckpoint(target, uuid)
+	if err != nil {
+		return err
+	}
+	var unfinished map[dvid.InstanceName]map[dvid.VersionID]struct{}
+	if cp != nil {
+		if cp.resumable(settings, delta.Versions) {
+			dvid.Infof("Resuming interrupted push of repo %s to %q from %s\n", uuid, target, cp.Updated)
+			if err := ps.sendCheckpointed(txRepo, cp); err != nil {
+				return err
+			}
+		} else {
+			dvid.Infof("Discarding checkpoint of interrupted push of repo %s to %q, which can't be resumed\n", uuid, target)
+			unfinished = cp.unfinished()
+			if err := deletePushCheckpoint(target, uuid); err != nil {
+				return err
+			}
+			cp = nil
+		}
+	}
+	dataVersions := lackingVersions(txRepo, delta, unfinished)
+	if len(dataVersions) == 0 {
+		if cp != nil {
+			return nil
 		}
+		return fmt.Errorf("push unnecessary -- versions and data at remote are already present")
 	}
+	return ps.sendCheckpointed(txRepo, newPushCheckpoint(target, uuid, settings, dataVersions))
+}
 
-	return nil
+// lackingVersions returns the versions of each data instance to send: the versions the
+// remote lacks, all versions of instances the remote lacks, and the versions of instances
+// left unfinished by an interrupted push that won't be resumed.  Instances with nothing to
+// send are omitted.
+func lackingVersions(txRepo *repoT, delta *pushDelta, unfinished map[dvid.InstanceName]map[dvid.VersionID]struct{}) map[dvid.InstanceName]map[dvid.VersionID]struct{} {
+	newData := make(map[dvid.InstanceName]struct{}, len(delta.NewData))
+	for _, name := range delta.NewData {
+		newData[name] = struct{}{}
+	}
+	allVersions := txRepo.versionSet()
+	dataVersions := make(map[dvid.InstanceName]map[dvid.VersionID]struct{})
+	for name := range txRepo.data {
+		versions := make(map[dvid.VersionID]struct{})
+		if _, found := newData[name]; found {
+			for v := range allVersions {
+				versions[v] = struct{}{}
+			}
+		}
+		for v := range delta.Versions {
+			versions[v] = struct{}{}
+		}
+		for v := range unfinished[name] {
+			if _, found := allVersions[v]; found {
+				versions[v] = struct{}{}
+			}
+		}
+		if len(versions) != 0 {
+			dataVersions[name] = versions
+		}
+	}
+	return dataVersions
 }
 
-/*
-func Pull(repo Repo, target string, config dvid.Config) error {
-	// To Pull() we initiate a push from target.
-	return nil
+// sendCheckpointed sends the key-values of the checkpoint's versions for each data instance,
+// skipping instances already sent and keys already acknowledged by the remote.  The
+// checkpoint is deleted once all data is sent.
+func (p *PushSession) sendCheckpointed(txRepo *repoT, cp *pushCheckpoint) error {
+	if err := cp.save(); err != nil {
+		return err
+	}
+	p.cp = cp
+	p.prog = newPushProgress(cp, txRepo)
+	for name, d := range txRepo.data {
+		versions, found := cp.versionsOf(name)
+		if !found {
+			continue
+		}
+		p.Versions = versions
+		ic := cp.instance(name)
+		if ic.Done {
+			dvid.Infof("Skipping instance %q data already sent to %q\n", name, cp.Target)
+			continue
+		}
+		if ic.LastKey != nil {
+			dvid.Infof("Resuming send of instance %q data to %q after %d key-value pairs\n", name, cp.Target, ic.KeyValues)
+		} else {
+			dvid.Infof("Sending instance %q data to %q\n", name, cp.Target)
+		}
+		p.after = ic.LastKey
+		if err := d.PushData(p); err != nil {
+			dvid.Errorf("Aborting send of instance %q data\n", name)
+			if err := cp.save(); err != nil {
+				dvid.Errorf("Unable to save checkpoint of push of repo %s to %q: %v\n", cp.UUID, cp.Target, err)
+			}
+			return err
+		}
+		ic.Done = true
+		ic.LastKey = nil
+		if err := cp.save(); err != nil {
+			return err
+		}
+	}
+	p.prog.finish()
+	return deletePushCheckpoint(cp.Target, cp.UUID)
+}
+
+// customizeTransfer returns a copy of the repo with the given UUID tailored by the push
+// or pull configuration, e.g., keeping just given data instances, as well as the transmit
+// type and any filter.
+func customizeTransfer(uuid dvid.UUID, config dvid.Config) (*repoT, rpc.Transmit, storage.FilterSpec, error) {
+	thisRepo, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return nil, rpc.TransmitUnknown, "", err
+	}
+	filter, _, err := config.GetString("filter")
+	if err != nil {
+		return nil, rpc.TransmitUnknown, "", err
+	}
+	v, err := manager.versionFromUUID(uuid)
+	if err != nil {
+		return nil, rpc.TransmitUnknown, "", err
+	}
+	txRepo, transmit, err := thisRepo.customize(v, config)
+	if err != nil {
+		return nil, rpc.TransmitUnknown, "", err
+	}
+	return txRepo, transmit, storage.FilterSpec(filter), nil
 }
-*/
 
 // PushSession encapsulates parameters necessary for DVID-to-DVID push/pull processing.
 type PushSession struct {
@@ -121,19 +218,38 @@ type PushSession struct {
 
 	s rpc.Session
 	t rpc.Transmit
+	a *archiveWriter // if non-nil, data is written to an archive instead of a remote session
+	q *pullSource    // if non-nil, data is queued for a remote pulling server
+
+	cp    *pushCheckpoint // if non-nil, key-values acknowledged by the remote are checkpointed
+	prog  *pushProgress
+	dname dvid.InstanceName // data instance being sent
+	after storage.Key       // if non-nil, only keys after this one are sent
+	err   error             // first error sending to the remote, after which nothing is sent
 }
 
 // StartInstancePush initiates a data instance push.  After some number of Send
 // calls, the EndInstancePush must be called.
 func (p *PushSession) StartInstancePush(d dvid.Data) error {
-	dmsg := DataTxInit{
+	p.dname = d.DataName()
+	if p.a != nil {
+		return p.a.startData(d)
+	}
+	if p.q != nil {
+		return p.q.startData(d)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	dmsg := &DataTxInit{
 		Session:    p.s.ID(),
 		DataName:   d.DataName(),
 		TypeName:   d.TypeName(),
 		InstanceID: d.InstanceID(),
 	}
 	if _, err := p.s.Call()(StartDataMsg, dmsg); err != nil {
-		return fmt.Errorf("couldn't send data instance %q start: %v\n", d.DataName(), err)
+		p.err = fmt.Errorf("couldn't send data instance %q start: %v\n", d.DataName(), err)
+		return p.err
 	}
 	return nil
 }
@@ -141,18 +257,44 @@ func (p *PushSession) StartInstancePush(d dvid.Data) error {
 // SendKV sends a key-value pair.  The key-values may be buffered before sending
 // for efficiency of transmission.
 func (p *PushSession) SendKV(kv *storage.KeyValue) error {
-	kvmsg := KVMessage{Session: p.s.ID(), KV: *kv, Terminate: false}
+	if p.a != nil {
+		return p.a.putKV(kv)
+	}
+	if p.q != nil {
+		return p.q.putKV(kv)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	kvmsg := &KVMessage{Session: p.s.ID(), KV: *kv, Terminate: false}
 	if _, err := p.s.Call()(PutKVMsg, kvmsg); err != nil {
-		return fmt.Errorf("error sending key-value to remote: %v", err)
+		p.err = fmt.Errorf("error sending key-value to remote: %v", err)
+		return p.err
+	}
+	if p.cp != nil {
+		p.cp.ack(p.dname, kv)
+	}
+	if p.prog != nil {
+		p.prog.add(kv)
 	}
 	return nil
 }
 
 // EndInstancePush terminates a data instance push.
 func (p *PushSession) EndInstancePush() error {
-	endmsg := KVMessage{Session: p.s.ID(), Terminate: true}
+	if p.a != nil {
+		return p.a.endData()
+	}
+	if p.q != nil {
+		return p.q.endData()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	endmsg := &KVMessage{Session: p.s.ID(), Terminate: true}
 	if _, err := p.s.Call()(PutKVMsg, endmsg); err != nil {
-		return fmt.Errorf("error sending terminate data to remote: %v", err)
+		p.err = fmt.Errorf("error sending terminate data to remote: %v", err)
+		return p.err
 	}
 	return nil
 }
@@ -198,6 +340,16 @@ func PushData(d dvid.Data, p *PushSession) error {
 	}
 	ctx := NewVersionedCtx(d, v)
 
+	// If resuming a flattened push, start after the type-specific key last sent.
+	var resumeTKey storage.TKey
+	if p.after != nil && p.t == rpc.TransmitFlatten {
+		tk, err := storage.TKeyFromKey(p.after)
+		if err != nil {
+			return err
+		}
+		resumeTKey = append(append(storage.TKey{}, tk...), 0)
+	}
+
 	// Send the initial data instance start message
 	if err := p.StartInstancePush(d); err != nil {
 		return err
@@ -209,6 +361,8 @@ func PushData(d dvid.Data, p *PushSession) error {
 
 	var kvTotal, kvSent int
 	var bytesTotal, bytesSent uint64
+	var sendErr error             // after a failed send, nothing more is sent so the push can be resumed
+	cancel := make(chan struct{}) // closed after a failed send to stop the scan
 	keysOnly := false
 	if p.t == rpc.TransmitFlatten {
 		// Start goroutine to receive flattened key-value pairs and transmit to remote.
@@ -238,28 +392,39 @@ func PushData(d dvid.Data, p *PushSession) error {
 						continue
 					}
 				}
+				if sendErr != nil {
+					continue
+				}
 				kvSent++
 				bytesSent += curBytes
 				kv := storage.KeyValue{
 					K: ctx.ConstructKey(tkv.K),
 					V: tkv.V,
 				}
-				if err := p.SendKV(&kv); err != nil {
-					dvid.Errorf("Bad data %q send KV: %v", d.DataName(), err)
+				if sendErr = p.SendKV(&kv); sendErr != nil {
+					dvid.Errorf("Bad data %q send KV: %v", d.DataName(), sendErr)
+					close(cancel)
 				}
 			}
 		}()
 
 		begKey, endKey := ctx.TKeyRange()
+		if resumeTKey != nil {
+			begKey = resumeTKey
+		}
 		err := store.ProcessRange(ctx, begKey, endKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
 			if c == nil {
 				return fmt.Errorf("received nil chunk in flatten push for data %s", d.DataName())
 			}
-			ch <- c.TKeyValue
-			return nil
+			select {
+			case <-cancel:
+				return errPushStopped
+			case ch <- c.TKeyValue:
+				return nil
+			}
 		})
 		ch <- nil
-		if err != nil {
+		if err != nil && err != errPushStopped {
 			return fmt.Errorf("error in flatten push for data %q: %v", d.DataName(), err)
 		}
 	} else {
@@ -298,25 +463,40 @@ func PushData(d dvid.Data, p *PushSession) error {
 						continue
 					}
 				}
+				if sendErr != nil {
+					continue
+				}
 				kvSent++
 				bytesSent += curBytes
-				if err := p.SendKV(kv); err != nil {
-					dvid.Errorf("Bad data %q send KV: %v", d.DataName(), err)
+				if sendErr = p.SendKV(kv); sendErr != nil {
+					dvid.Errorf("Bad data %q send KV: %v", d.DataName(), sendErr)
+					close(cancel)
 				}
 			}
 		}()
 
 		begKey, endKey := ctx.KeyRange()
-		if err = store.RawRangeQuery(begKey, endKey, keysOnly, ch, nil); err != nil {
+		if p.after != nil && bytes.Compare(p.after, begKey) >= 0 {
+			begKey = append(append(storage.Key{}, p.after...), 0)
+		}
+		err = store.RawRangeQuery(begKey, endKey, keysOnly, ch, cancel)
+		close(ch) // a canceled query ends without sending a nil
+		if err != nil {
 			return fmt.Errorf("push voxels %q range query: %v", d.DataName(), err)
 		}
 	}
 	wg.Wait()
+	if sendErr != nil {
+		return fmt.Errorf("push of data %q stopped after %d key-value pairs: %v", d.DataName(), kvSent, sendErr)
+	}
 	return nil
 }
 
 var (
 	pushMessageID rpc.MessageID = "datastore.Push"
+
+	// errPushStopped ends the scan of a flattened push after a failed send.
+	errPushStopped = errors.New("push stopped after failed send")
 )
 
 const (
@@ -334,6 +514,7 @@ func init() {
 	d.AddFunc(PutKVMsg, handlePutKV)
 
 	gorpc.RegisterType(&repoTxMsg{})
+	gorpc.RegisterType(&pushDelta{})
 	gorpc.RegisterType(&DataTxInit{})
 	gorpc.RegisterType(&KVMessage{})
 }
@@ -372,12 +553,23 @@ func getPusherSession(s rpc.SessionID) (*pusher, error) {
 	return p, nil
 }
 
-func handleSendRepo(m *repoTxMsg) (map[dvid.VersionID]struct{}, error) {
+// pushDelta is the part of a pushed repo the receiver lacks: versions it lacks for all
+// data instances and data instances it lacks entirely, which need all versions.
+type pushDelta struct {
+	Versions map[dvid.VersionID]struct{}
+	NewData  []dvid.InstanceName
+}
+
+func handleSendRepo(m *repoTxMsg) (*pushDelta, error) {
 	p, err := getPusherSession(m.Session)
 	if err != nil {
 		return nil, err
 	}
-	return p.readRepo(m)
+	versions, err := p.readRepo(m)
+	if err != nil {
+		return nil, err
+	}
+	return &pushDelta{Versions: versions, NewData: p.newData()}, nil
 }
 
 func handleStartData(m *DataTxInit) error {
@@ -406,9 +598,11 @@ type pusher struct {
 	sessionID rpc.SessionID
 	uuid      dvid.UUID
 	repo      *repoT
+	local     *repoT // local repo already holding some pushed versions, if any
 
-	instanceMap dvid.InstanceMap // map from pushed to local instance ids
-	versionMap  dvid.VersionMap  // map from pushed to local version ids
+	instanceMap dvid.InstanceMap            // map from pushed to local instance ids
+	versionMap  dvid.VersionMap             // map from pushed to local version ids
+	versions    map[dvid.VersionID]struct{} // requested versions using pushed version ids
 
 	// current stats for data instance transfer
 	dname dvid.InstanceName
@@ -446,13 +640,73 @@ func (p *pusher) Close() error {
 	gb := float64(p.received) / 1000000000
 	dvid.Debugf("Closing push of uuid %s: received %.1f GBytes in %s\n", p.repo.uuid, gb, time.Since(p.startTime))
 
-	// Add this repo to current DVID server
+	// Add this repo to current DVID server or add to the local repo pushed into.
+	if p.local != nil {
+		return manager.addToRepo(p.local, p.repo)
+	}
 	if err := manager.addRepo(p.repo); err != nil {
 		return err
 	}
 	return nil
 }
 
+// abort deletes the key-values received, which are orphaned if the transfer doesn't
+// complete.  These are all key-values of data instances new to this server and, for
+// instances already here, the key-values of the requested versions, which are new.
+func (p *pusher) abort() {
+	if p.repo == nil {
+		return
+	}
+	for name, d := range p.repo.data {
+		local, found := p.localData(name)
+		if found {
+			d = local
+		}
+		db, err := getOrderedKeyValueDB(d)
+		if err != nil {
+			dvid.Errorf("Unable to delete received key-values of data %q: %v\n", name, err)
+			continue
+		}
+		if !found {
+			if err := db.DeleteAll(storage.NewDataContext(d, 0), true); err != nil {
+				dvid.Errorf("Unable to delete received key-values of data %q: %v\n", name, err)
+			}
+			continue
+		}
+		for v := range p.versions {
+			localV, found := p.versionMap[v]
+			if !found {
+				continue
+			}
+			if err := db.DeleteAll(NewVersionedCtx(d, localV), false); err != nil {
+				dvid.Errorf("Unable to delete received key-values of data %q, version %d: %v\n", name, localV, err)
+			}
+		}
+	}
+}
+
+// newData returns the names of pushed data instances not already on this server.
+func (p *pusher) newData() []dvid.InstanceName {
+	var names []dvid.InstanceName
+	for name := range p.repo.data {
+		if _, found := p.localData(name); !found {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// localData returns a data instance of the local repo being pushed into.
+func (p *pusher) localData(name dvid.InstanceName) (DataService, bool) {
+	if p.local == nil {
+		return nil, false
+	}
+	p.local.RLock()
+	defer p.local.RUnlock()
+	d, found := p.local.data[name]
+	return d, found
+}
+
 func (p *pusher) readRepo(m *repoTxMsg) (map[dvid.VersionID]struct{}, error) {
 	dvid.Debugf("Reading repo for push of %s...\n", m.UUID)
 
@@ -467,54 +721,32 @@ func (p *pusher) readRepo(m *repoTxMsg) (map[dvid.VersionID]struct{}, error) {
 		return nil, err
 	}
 
-	// TODO: Check to see if the transmitted repo is in current metadata.
+	// If any transmitted version is already here, the push adds to that local repo.
+	for _, node := range p.repo.dag.nodes {
+		if local, err := manager.repoFromUUID(node.uuid); err == nil {
+			p.local = local
+			break
+		}
+	}
 
 	p.uuid = m.UUID
 	remoteV, err := p.repo.versionFromUUID(m.UUID) // do this before we remap the repo's IDs
 	if err != nil {
 		return nil, err
 	}
-	repoID, err := manager.newRepoID()
-	if err != nil {
-		return nil, err
-	}
-	p.repo.id = repoID
-
-	p.instanceMap, p.versionMap, err = p.repo.remapLocalIDs()
-	if err != nil {
-		return nil, err
-	}
-
-	// After getting remote repo, adjust data instances for local settings.
-	for _, d := range p.repo.data {
-		// see if it needs to adjust versions.
-		dv, needsUpdate := d.(VersionRemapper)
-		if needsUpdate {
-			if err := dv.RemapVersions(p.versionMap); err != nil {
-				return nil, err
-			}
-		}
-
-		// check if we have an assigned store for this data instance.
-		store, err := storage.GetAssignedStore(d.DataName(), d.RootUUID(), d.TypeName())
-		if err != nil {
-			return nil, err
-		}
-		d.SetBackendStore(store)
-		dvid.Debugf("Assigning as default store of data instance %q @ %s: %s\n", d.DataName(), d.RootUUID(), store)
-	}
 
+	// Determine the versions to request using the sender's version ids.
 	var versions map[dvid.VersionID]struct{}
 	switch m.Transmit {
 	case rpc.TransmitFlatten:
-		versions = map[dvid.VersionID]struct{}{
-			remoteV: struct{}{},
+		versions = make(map[dvid.VersionID]struct{})
+		if _, err := manager.versionFromUUID(m.UUID); err != nil {
+			versions[remoteV] = struct{}{}
 		}
 		// Also have to make sure any data instances are rerooted if the root
 		// no longer exists.
 		for name, d := range p.repo.data {
-			_, found := manager.uuidToVersion[d.RootUUID()]
-			if !found {
+			if _, err := manager.versionFromUUID(d.RootUUID()); err != nil {
 				p.repo.data[name].SetRootUUID(m.UUID)
 			}
 		}
@@ -528,11 +760,51 @@ func (p *pusher) readRepo(m *repoTxMsg) (map[dvid.VersionID]struct{}, error) {
 		if err != nil {
 			return nil, err
 		}
+	default:
+		return nil, fmt.Errorf("unknown transmit %d", m.Transmit)
+	}
+
+	if p.local != nil {
+		p.instanceMap, p.versionMap, err = p.repo.remapToRepo(p.local)
+	} else {
+		var repoID dvid.RepoID
+		if repoID, err = manager.newRepoID(); err != nil {
+			return nil, err
+		}
+		p.repo.id = repoID
+		p.instanceMap, p.versionMap, err = p.repo.remapLocalIDs()
+	}
+	if err != nil {
+		return nil, err
 	}
-	if versions == nil {
-		return nil, fmt.Errorf("no push required -- remote has necessary versions")
+
+	// After getting remote repo, adjust new data instances for local settings.
+	for name, d := range p.repo.data {
+		if p.local != nil {
+			if _, found := p.local.data[name]; found {
+				continue
+			}
+		}
+
+		// see if it needs to adjust versions.
+		dv, needsUpdate := d.(VersionRemapper)
+		if needsUpdate {
+			if err := dv.RemapVersions(p.versionMap); err != nil {
+				return nil, err
+			}
+		}
+
+		// check if we have an assigned store for this data instance.
+		store, err := storage.GetAssignedStore(d.DataName(), d.RootUUID(), d.TypeName())
+		if err != nil {
+			return nil, err
+		}
+		d.SetBackendStore(store)
+		dvid.Debugf("Assigning as default store of data instance %q @ %s: %s\n", d.DataName(), d.RootUUID(), store)
 	}
+
 	dvid.Debugf("Finished comparing repos -- requesting %d versions from source.\n", len(versions))
+	p.versions = versions
 	return versions, nil
 }
 
@@ -544,12 +816,11 @@ func getDeltaAll(remote *repoT, uuid dvid.UUID) (map[dvid.VersionID]struct{}, er
 	// then convert to VersionID.
 	delta := make(map[dvid.VersionID]struct{})
 	for _, rnode := range remote.dag.nodes {
-		lv, found := manager.uuidToVersion[rnode.uuid]
-		if found {
+		if _, err := manager.versionFromUUID(rnode.uuid); err == nil {
 			dvid.Debugf("Both remote and local have uuid %s... skipping\n", rnode.uuid)
 		} else {
-			dvid.Debugf("Found version %s in remote not in local: sending local version id %d\n", rnode.uuid, lv)
-			delta[lv] = struct{}{}
+			dvid.Debugf("Found version %s in remote not in local: requesting remote version id %d\n", rnode.uuid, rnode.version)
+			delta[rnode.version] = struct{}{}
 		}
 	}
 	return delta, nil
@@ -558,8 +829,29 @@ func getDeltaAll(remote *repoT, uuid dvid.UUID) (map[dvid.VersionID]struct{}, er
 // compares remote Repo with local one, determining a list of versions that
 // need to be sent from remote to bring the local DVID up-to-date.
 func getDeltaBranch(remote *repoT, branch dvid.UUID) (map[dvid.VersionID]struct{}, error) {
-	// TODO branch
-	return nil, fmt.Errorf("Branch transmission not currently supported in DVID")
+	// Only consider the ancestor path of the branch version, which is all a customized
+	// branch transmission should hold.
+	v, err := remote.versionFromUUID(branch)
+	if err != nil {
+		return nil, err
+	}
+	ancestors, err := remote.ancestorSet(v)
+	if err != nil {
+		return nil, err
+	}
+	delta := make(map[dvid.VersionID]struct{})
+	for av := range ancestors {
+		rnode, found := remote.dag.nodes[av]
+		if !found {
+			return nil, fmt.Errorf("branch %s has ancestor version %d missing from remote DAG", branch, av)
+		}
+		if _, err := manager.versionFromUUID(rnode.uuid); err == nil {
+			dvid.Debugf("Both remote and local have uuid %s... skipping\n", rnode.uuid)
+		} else {
+			delta[av] = struct{}{}
+		}
+	}
+	return delta, nil
 }
 
 func (p *pusher) startData(d *DataTxInit) error {
@@ -569,8 +861,14 @@ func (p *pusher) startData(d *DataTxInit) error {
 
 	p.dname = d.DataName
 
-	// Get the store associated with this data instance.
-	store, err := storage.GetAssignedStore(d.DataName, p.uuid, d.TypeName)
+	// Get the store associated with this data instance, which may already be here.
+	var store dvid.Store
+	var err error
+	if localData, found := p.localData(d.DataName); found {
+		store, err = localData.BackendStore()
+	} else {
+		store, err = storage.GetAssignedStore(d.DataName, p.uuid, d.TypeName)
+	}
 	if err != nil {
 		return err
 	}
@@ -655,7 +953,10 @@ func (r *repoT) customize(v dvid.VersionID, config dvid.Config) (*repoT, rpc.Tra
 		versions = r.versionSet()
 	case "branch":
 		transmit = rpc.TransmitBranch
-		versions = r.versionSet()
+		versions, err = r.ancestorSet(v)
+		if err != nil {
+			return nil, rpc.TransmitUnknown, err
+		}
 	default:
 		return nil, rpc.TransmitUnknown, fmt.Errorf("unknown transmit %s", transmitStr)
 	}
@@ -667,3 +968,25 @@ func (r *repoT) customize(v dvid.VersionID, config dvid.Config) (*repoT, rpc.Tra
 	dup, err := r.duplicate(versions, datanames)
 	return dup, transmit, err
 }
+
+// ancestorSet returns the given version and all its ancestors.
+func (r *repoT) ancestorSet(v dvid.VersionID) (map[dvid.VersionID]struct{}, error) {
+	r.RLock()
+	defer r.RUnlock()
+	vset := make(map[dvid.VersionID]struct{})
+	todo := []dvid.VersionID{v}
+	for len(todo) > 0 {
+		cur := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if _, found := vset[cur]; found {
+			continue
+		}
+		vset[cur] = struct{}{}
+		parents, err := r.dag.getParents(cur)
+		if err != nil {
+			return nil, err
+		}
+		todo = append(todo, parents...)
+	}
+	return vset, nil
+}
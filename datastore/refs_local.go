@@ -0,0 +1,208 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports git-style refs for a repo: mutable branch names that follow the
+	newest descendant of a node and immutable tags.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Kinds of refs.
+const (
+	BranchRef = "branch" // follows new child versions of the node it names
+	TagRef    = "tag"    // names a committed node and can't be moved or deleted
+)
+
+// refT is the persisted form of a ref.  Refs are stored by UUID so they survive transfer
+// of the repo to other servers.
+type refT struct {
+	Kind    string
+	UUID    dvid.UUID
+	Updated time.Time
+}
+
+// Ref describes a named branch or tag of a repo.
+type Ref struct {
+	Name    string    `json:"name"`
+	Kind    string    `json:"type"`
+	UUID    dvid.UUID `json:"uuid"`
+	Updated time.Time `json:"updated"`
+}
+
+type refsByName []Ref
+
+func (r refsByName) Len() int           { return len(r) }
+func (r refsByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r refsByName) Less(i, j int) bool { return r[i].Name < r[j].Name }
+
+// GetRefs returns the branches and tags of the repo containing the given UUID, sorted by name.
+func GetRefs(uuid dvid.UUID) ([]Ref, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	r.RLock()
+	defer r.RUnlock()
+	refs := make([]Ref, 0, len(r.refs))
+	for name, ref := range r.refs {
+		refs = append(refs, Ref{Name: name, Kind: ref.Kind, UUID: ref.UUID, Updated: ref.Updated})
+	}
+	sort.Sort(refsByName(refs))
+	return refs, nil
+}
+
+// SetRef creates or moves a named ref of the given kind in the repo containing the given
+// UUID so it points to the target node.  Branches can be moved to any node of the repo.
+// Tags can only name committed nodes and can't be moved once created.
+func SetRef(uuid dvid.UUID, name, kind string, target dvid.UUID) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	if err := checkRefName(name); err != nil {
+		return err
+	}
+	if kind != BranchRef && kind != TagRef {
+		return fmt.Errorf("ref type must be %q or %q, not %q", BranchRef, TagRef, kind)
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	v, err := r.versionFromUUID(target)
+	if err != nil {
+		return fmt.Errorf("node %s is not in repo %s", target, r.uuid)
+	}
+	if old, found := r.refs[name]; found {
+		if old.Kind == TagRef {
+			return fmt.Errorf("ref %q is a tag and can't be changed", name)
+		}
+		if kind == TagRef {
+			return fmt.Errorf("ref %q is already a branch", name)
+		}
+	}
+	if kind == TagRef {
+		node := r.dag.nodes[v]
+		node.RLock()
+		locked := node.locked
+		node.RUnlock()
+		if !locked {
+			return fmt.Errorf("tag %q must name a committed node and %s is uncommitted", name, target)
+		}
+	}
+	r.refs[name] = refT{Kind: kind, UUID: target, Updated: time.Now()}
+	r.updated = time.Now()
+	return r.save()
+}
+
+// DeleteRef deletes a branch of the repo containing the given UUID.  Tags can't be deleted.
+func DeleteRef(uuid dvid.UUID, name string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	ref, found := r.refs[name]
+	if !found {
+		return fmt.Errorf("repo %s has no ref %q", r.uuid, name)
+	}
+	if ref.Kind == TagRef {
+		return fmt.Errorf("ref %q is a tag and can't be deleted", name)
+	}
+	delete(r.refs, name)
+	r.updated = time.Now()
+	return r.save()
+}
+
+// checkRefName makes sure a ref name can't be confused with a UUID string or URL path.
+func checkRefName(name string) error {
+	if name == "" {
+		return fmt.Errorf("ref name can't be empty")
+	}
+	if strings.ContainsAny(name, ":/") || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("ref name %q can't contain ':', '/' or whitespace", name)
+	}
+	return nil
+}
+
+// moveRefs points refs of the given kind at node "from" to node "to".  If kind is empty,
+// refs of all kinds are moved.  The repo must be locked by the caller.
+func (r *repoT) moveRefs(from, to dvid.UUID, kind string) {
+	t := time.Now()
+	for name, ref := range r.refs {
+		if ref.UUID == from && (kind == "" || ref.Kind == kind) {
+			r.refs[name] = refT{Kind: ref.Kind, UUID: to, Updated: t}
+		}
+	}
+}
+
+// matchingRef returns the node named by a ref string of the form "<uuid prefix>:<name>" or
+// ":<name>".  If no UUID prefix is given, the name must be unique across all repos.
+func (m *repoManager) matchingRef(str string) (dvid.UUID, dvid.VersionID, error) {
+	parts := strings.SplitN(str, ":", 2)
+	prefix, name := parts[0], parts[1]
+
+	var repos []*repoT
+	if prefix != "" {
+		uuid, _, err := m.matchingUUID(prefix)
+		if err != nil {
+			return dvid.NilUUID, 0, err
+		}
+		r, err := m.repoFromUUID(uuid)
+		if err != nil {
+			return dvid.NilUUID, 0, err
+		}
+		repos = append(repos, r)
+	} else {
+		m.idMutex.RLock()
+		for _, uuid := range m.repoToUUID {
+			if r, found := m.repos[uuid]; found {
+				repos = append(repos, r)
+			}
+		}
+		m.idMutex.RUnlock()
+	}
+
+	var target dvid.UUID
+	numMatches := 0
+	for _, r := range repos {
+		r.RLock()
+		ref, found := r.refs[name]
+		r.RUnlock()
+		if found {
+			numMatches++
+			target = ref.UUID
+		}
+	}
+	switch {
+	case numMatches > 1:
+		return dvid.NilUUID, 0, fmt.Errorf("More than one repo has ref %q!", name)
+	case numMatches == 0:
+		return dvid.NilUUID, 0, fmt.Errorf("Could not find ref %q!", str)
+	}
+	v, err := m.versionFromUUID(target)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	return target, v, nil
+}
@@ -0,0 +1,72 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestRefs(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	_, err := datastore.NewData(uuid, kvtype, "refstest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(node, key string) string {
+		return fmt.Sprintf("%snode/%s/refstest/key/%s", server.WebAPIPath, node, key)
+	}
+	refsreq := fmt.Sprintf("%srepo/%s/refs", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", keyreq(string(uuid), "a"), strings.NewReader("root a"))
+
+	server.TestHTTP(t, "POST", refsreq, strings.NewReader(fmt.Sprintf(`{"name":"master","uuid":%q}`, uuid)))
+	if got := server.TestHTTP(t, "GET", keyreq(":master", "a"), nil); string(got) != "root a" {
+		t.Errorf("Expected %q via branch, got %q\n", "root a", string(got))
+	}
+
+	// Tags require committed nodes and bad names are refused.
+	server.TestBadHTTP(t, "POST", refsreq, strings.NewReader(fmt.Sprintf(`{"name":"v1","type":"tag","uuid":%q}`, uuid)))
+	server.TestBadHTTP(t, "POST", refsreq, strings.NewReader(fmt.Sprintf(`{"name":"a:b","uuid":%q}`, uuid)))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", refsreq, strings.NewReader(fmt.Sprintf(`{"name":"v1","type":"tag","uuid":%q}`, uuid)))
+
+	// The branch follows a new child while the tag stays put.
+	child, err := datastore.NewVersion(uuid, "child", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyreq(":master", "a"), strings.NewReader("child a"))
+	if got := server.TestHTTP(t, "GET", keyreq(string(child), "a"), nil); string(got) != "child a" {
+		t.Errorf("Expected write via branch to go to child, got %q\n", string(got))
+	}
+	if got := server.TestHTTP(t, "GET", keyreq(string(uuid)[:8]+":v1", "a"), nil); string(got) != "root a" {
+		t.Errorf("Expected %q via tag, got %q\n", "root a", string(got))
+	}
+	server.TestBadHTTP(t, "POST", refsreq, strings.NewReader(fmt.Sprintf(`{"name":"v1","type":"tag","uuid":%q}`, child)))
+	server.TestBadHTTP(t, "DELETE", refsreq+"/v1", nil)
+
+	// Refs persist across restarts.
+	datastore.CloseReopenTest()
+	var refs []datastore.Ref
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", refsreq, nil), &refs); err != nil {
+		t.Fatalf("Bad refs response: %v\n", err)
+	}
+	if len(refs) != 2 || refs[0].Name != "master" || refs[0].UUID != child || refs[1].Name != "v1" || refs[1].UUID != uuid {
+		t.Errorf("Bad refs after restart: %v\n", refs)
+	}
+	server.TestHTTP(t, "DELETE", refsreq+"/master", nil)
+	server.TestBadHTTP(t, "GET", keyreq(":master", "a"), nil)
+}
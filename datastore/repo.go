@@ -25,6 +25,10 @@ const (
 
 	// MergeExternalData requires external data to reconcile merging of nodes.
 	MergeExternalData
+
+	// MergeThreeWay merges key-value pairs changed in both nodes since their common
+	// ancestor using datatype-specific code.  See ThreeWayMerge().
+	MergeThreeWay
 )
 
 var (
@@ -44,4 +48,7 @@ var (
 
 	ErrModifyLockedNode   = errors.New("can't modify locked node")
 	ErrBranchUnlockedNode = errors.New("can't branch an unlocked node")
+
+	ErrNoLease       = errors.New("node is not checked out")
+	ErrLeaseMismatch = errors.New("lease token does not match node's checkout")
 )
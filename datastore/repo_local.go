@@ -39,6 +39,8 @@ const (
 	newIDsKey
 	repoKey
 	formatKey
+	uuidAliasKey
+	pushCheckpointKey
 )
 
 func Close() error {
@@ -59,6 +61,7 @@ func Initialize(initMetadata bool, iconfig *InstanceConfig) error {
 		repoToUUID:      make(map[dvid.RepoID]dvid.UUID),
 		versionToUUID:   make(map[dvid.VersionID]dvid.UUID),
 		uuidToVersion:   make(map[dvid.UUID]dvid.VersionID),
+		uuidAliases:     make(map[dvid.UUID]dvid.VersionID),
 		repos:           make(map[dvid.UUID]*repoT),
 		repoID:          1,
 		versionID:       1,
@@ -84,6 +87,7 @@ func Initialize(initMetadata bool, iconfig *InstanceConfig) error {
 
 	// Set the package variable.  We are good to go...
 	manager = m
+	storage.SetGroupcacheResolver(groupcacheResolver{})
 	m.Lock()
 	defer m.Unlock()
 	m.idMutex.Lock()
@@ -119,6 +123,7 @@ func ReloadMetadata() error {
 		repoToUUID:      make(map[dvid.RepoID]dvid.UUID),
 		versionToUUID:   make(map[dvid.VersionID]dvid.UUID),
 		uuidToVersion:   make(map[dvid.UUID]dvid.VersionID),
+		uuidAliases:     make(map[dvid.UUID]dvid.VersionID),
 		repos:           make(map[dvid.UUID]*repoT),
 		repoID:          manager.repoID,
 		versionID:       manager.versionID,
@@ -174,6 +179,9 @@ type repoManager struct {
 	// Map UUID to local VersionID -- this is not stored but generated on load
 	uuidToVersion map[dvid.UUID]dvid.VersionID
 
+	// Map UUIDs of squashed nodes to the local VersionID now holding their data.
+	uuidAliases map[dvid.UUID]dvid.VersionID
+
 	// Counters that provide the local IDs of the next new repo, version, or data instance.
 	// Valid counters should be >= 1, so we can distinguish between valid ids and the
 	// default zero value.
@@ -345,6 +353,9 @@ func (m *repoManager) putCaches() error {
 	if err := m.putData(versionToUUIDKey, m.versionToUUID); err != nil {
 		return err
 	}
+	if err := m.putData(uuidAliasKey, m.uuidAliases); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -356,6 +367,9 @@ func (m *repoManager) loadVersion0() error {
 	if _, err := m.loadData(versionToUUIDKey, &(m.versionToUUID)); err != nil {
 		return fmt.Errorf("Error loading version to UUID map: %s", err)
 	}
+	if _, err := m.loadData(uuidAliasKey, &(m.uuidAliases)); err != nil {
+		return fmt.Errorf("Error loading UUID aliases: %s", err)
+	}
 	if err := m.loadNewIDs(); err != nil {
 		return fmt.Errorf("Error loading new local ids: %s", err)
 	}
@@ -543,6 +557,22 @@ func (m *repoManager) loadVersion0() error {
 		return err
 	}
 
+	// Resolve UUIDs of squashed nodes to the versions holding their data.
+	for uuid, v := range m.uuidAliases {
+		var r *repoT
+		if canonical, found := m.versionToUUID[v]; found {
+			r = m.repos[canonical]
+		}
+		if r == nil {
+			dvid.Errorf("Dropping alias of UUID %s to missing version id %d\n", uuid, v)
+			delete(m.uuidAliases, uuid)
+			saveCache = true
+			continue
+		}
+		m.uuidToVersion[uuid] = v
+		m.repos[uuid] = r
+	}
+
 	// If we noticed missing cache entries, save current metadata.
 	if saveCache {
 		if err := m.putCaches(); err != nil {
@@ -728,8 +758,13 @@ func (m *repoManager) versionFromUUID(uuid dvid.UUID) (dvid.VersionID, error) {
 // string. Partial matches are accepted as long as they are unique for a datastore.  So if
 // a datastore has nodes with UUID strings 3FA22..., 7CD11..., and 836EE...,
 // we can still find a match even if given the minimum 3 letters.  (We don't
-// allow UUID strings of less than 3 letters just to prevent mistakes.)
+// allow UUID strings of less than 3 letters just to prevent mistakes.)  Strings with
+// a colon name a branch or tag, e.g., ":master" or "3FA2:master" to limit the search to one repo.
 func (m *repoManager) matchingUUID(str string) (dvid.UUID, dvid.VersionID, error) {
+	if strings.Contains(str, ":") {
+		return m.matchingRef(str)
+	}
+
 	m.idMutex.RLock()
 	defer m.idMutex.RUnlock()
 
@@ -768,6 +803,7 @@ func (m *repoManager) addRepo(r *repoT) error {
 	for v, node := range r.dag.nodes {
 		m.versionToUUID[v] = node.uuid
 		m.uuidToVersion[node.uuid] = v
+		m.repos[node.uuid] = r
 	}
 
 	// Persist the changes
@@ -777,6 +813,59 @@ func (m *repoManager) addRepo(r *repoT) error {
 	return r.save()
 }
 
+// addToRepo adds the versions and data instances of a transmitted repo, already remapped
+// to local IDs, that aren't in the local repo.
+func (m *repoManager) addToRepo(local, r *repoT) error {
+	m.Lock()
+	defer m.Unlock()
+	local.Lock()
+	defer local.Unlock()
+
+	for v := range r.dag.nodes {
+		if err := local.checkSquashing(v); err != nil {
+			return err
+		}
+	}
+	for name, dataservice := range r.data {
+		if _, found := local.data[name]; found {
+			continue
+		}
+		local.data[name] = dataservice
+		m.iids[dataservice.InstanceID()] = dataservice
+		m.dataByUUID[dataservice.DataUUID()] = dataservice
+	}
+	for v, node := range r.dag.nodes {
+		localNode, found := local.dag.nodes[v]
+		if !found {
+			local.dag.nodes[v] = node
+			m.versionToUUID[v] = node.uuid
+			m.uuidToVersion[node.uuid] = v
+			m.repos[node.uuid] = local
+			continue
+		}
+		// Link any new children to the existing node.
+		for _, child := range node.children {
+			linked := false
+			for _, localChild := range localNode.children {
+				if localChild == child {
+					linked = true
+					break
+				}
+			}
+			if !linked {
+				localNode.children = append(localNode.children, child)
+			}
+		}
+	}
+	local.updated = time.Now()
+
+	// Persist the changes
+	if err := m.putCaches(); err != nil {
+		return err
+	}
+	return local.save()
+}
+
 func (m *repoManager) deleteRepo(uuid dvid.UUID, passcode string) error {
 	m.Lock()
 	defer m.Unlock()
@@ -822,7 +911,14 @@ func (m *repoManager) deleteRepo(uuid dvid.UUID, passcode string) error {
 		delete(m.uuidToVersion, u)
 		delete(m.versionToUUID, v)
 	}
-	return nil
+	for u, v := range m.uuidAliases {
+		if _, found := r.dag.nodes[v]; found {
+			delete(m.repos, u)
+			delete(m.uuidToVersion, u)
+			delete(m.uuidAliases, u)
+		}
+	}
+	return m.putCaches()
 }
 
 // ---- Repo-level properties functions -------
@@ -1233,6 +1329,7 @@ func (m *repoManager) commit(uuid dvid.UUID, note string, log []string) error {
 	defer node.Unlock()
 
 	node.locked = true
+	node.lease = nil
 	t := time.Now()
 
 	if len(note) != 0 {
@@ -1284,6 +1381,9 @@ func (m *repoManager) newVersion(parent dvid.UUID, note string, assign *dvid.UUI
 	if !node.locked {
 		return dvid.NilUUID, ErrBranchUnlockedNode
 	}
+	if err := r.checkSquashing(v); err != nil {
+		return dvid.NilUUID, err
+	}
 
 	// Add the child node.  Since it's new and unavailable, no need to lock it.
 	childUUID, childV, err := m.newUUID(assign)
@@ -1303,6 +1403,9 @@ func (m *repoManager) newVersion(parent dvid.UUID, note string, assign *dvid.UUI
 
 	r.updated = time.Now()
 
+	// Branches follow the newest descendant.
+	r.moveRefs(parent, childUUID, BranchRef)
+
 	// Notify data instances that we have a new child in case they have to do some kind of initialization.
 	for _, dataservice := range r.data {
 		initializer, ok := dataservice.(VersionInitializer)
@@ -1361,6 +1464,9 @@ func (m *repoManager) merge(parents []dvid.UUID, note string, mt MergeType) (dvi
 		if !node.locked {
 			return dvid.NilUUID, ErrBranchUnlockedNode
 		}
+		if err := r.checkSquashing(v); err != nil {
+			return dvid.NilUUID, err
+		}
 
 		// Add this parent node
 		child.parents = append(child.parents, v)
@@ -1395,10 +1501,20 @@ func (m *repoManager) merge(parents []dvid.UUID, note string, mt MergeType) (dvi
 	case MergeExternalData:
 		return dvid.NilUUID, fmt.Errorf("merging with external data has not been implemented yet")
 
+	case MergeThreeWay:
+		// Only metadata changes here.  Keys changed in both parents are merged into the
+		// child by threeWayMerge().
+		if len(parents) != 2 {
+			return dvid.NilUUID, fmt.Errorf("three-way merge requires exactly two parents, got %d", len(parents))
+		}
+
 	default:
 		return dvid.NilUUID, ErrBadMergeType
 	}
 
+	// Branches of the first parent follow the merge.
+	r.moveRefs(parents[0], childUUID, BranchRef)
+
 	r.updated = time.Now()
 	return child.uuid, r.save()
 }
@@ -1777,10 +1893,19 @@ type repoT struct {
 
 	dag *dagT
 
+	// squashing holds the versions of chains being squashed, whose DAG links can't change.
+	squashing map[dvid.VersionID]struct{}
+
 	data map[dvid.InstanceName]DataService
 
 	// subs holds subscriptions to change events for each data instance
 	subs map[SyncEvent]SyncSubs
+
+	// refs holds named branches and tags for nodes in this repo.
+	refs map[string]refT
+
+	// hooks holds webhooks notified of sync events, keyed by webhook ID.
+	hooks map[string]webhookT
 }
 
 // newRepo creates a new repository given a UUID, version, and RepoID,
@@ -1796,6 +1921,8 @@ func newRepo(uuid dvid.UUID, v dvid.VersionID, id dvid.RepoID, passcode string)
 		log:        []string{},
 		properties: make(map[string]interface{}),
 		data:       make(map[dvid.InstanceName]DataService),
+		refs:       make(map[string]refT),
+		hooks:      make(map[string]webhookT),
 		created:    t,
 		updated:    t,
 	}
@@ -1866,6 +1993,18 @@ func (r *repoT) duplicate(versions map[dvid.VersionID]struct{}, names dvid.Insta
 		dup.subs[k] = v
 	}
 
+	dup.refs = make(map[string]refT, len(r.refs))
+	for name, ref := range r.refs {
+		if v, err := r.versionFromUUID(ref.UUID); err == nil {
+			if _, allowed := versions[v]; allowed || len(versions) == 0 {
+				dup.refs[name] = ref
+			}
+		}
+	}
+
+	// Webhooks are specific to this server and aren't duplicated.
+	dup.hooks = make(map[string]webhookT)
+
 	return dup, nil
 }
 
@@ -1906,6 +2045,14 @@ func (r *repoT) GobDecode(b []byte) error {
 	if err := dec.Decode(&(r.passcode)); err != nil {
 		r.passcode = ""
 	}
+	// refs may not exist.
+	if err := dec.Decode(&(r.refs)); err != nil || r.refs == nil {
+		r.refs = make(map[string]refT)
+	}
+	// hooks may not exist.
+	if err := dec.Decode(&(r.hooks)); err != nil || r.hooks == nil {
+		r.hooks = make(map[string]webhookT)
+	}
 	r.version = r.dag.rootV
 	return nil
 }
@@ -1946,6 +2093,12 @@ func (r *repoT) GobEncode() ([]byte, error) {
 	if err := enc.Encode(r.passcode); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(r.refs); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(r.hooks); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -1990,18 +2143,56 @@ func (r *repoT) types() (map[dvid.URLString]TypeService, error) {
 	return datatypes, nil
 }
 
-// notifySubscribers sends a message to any data instances subscribed to the event.
+// notifySubscribers sends a message to any data instances subscribed to the event,
+// queues it for any matching webhooks, and publishes it to any event streams.
 func (r *repoT) notifySubscribers(e SyncEvent, m SyncMessage) error {
+	r.notifyWebhooks(e, m)
+	publishStreamEvent(e, m)
 	subs, found := r.subs[e]
 	if !found {
 		return nil
 	}
 	for _, sub := range subs {
-		sub.Ch <- m
+		if sub.Ch != nil {
+			sub.Ch <- m
+		}
 	}
 	return nil
 }
 
+// prepareSyncTxn has each TxnSyncer subscribed to the event without a channel add its
+// updates to the transaction.  If any fails, the ones already prepared are abandoned.
+func (r *repoT) prepareSyncTxn(txn *storage.Transaction, e SyncEvent, m SyncMessage) (func(committed bool), error) {
+	var dones []func(committed bool)
+	done := func(committed bool) {
+		for _, f := range dones {
+			f(committed)
+		}
+	}
+	for _, sub := range r.subs[e] {
+		if sub.Ch != nil {
+			continue
+		}
+		d, err := GetDataByDataUUID(sub.Notify)
+		if err != nil {
+			done(false)
+			return nil, err
+		}
+		syncer, ok := d.(TxnSyncer)
+		if !ok {
+			done(false)
+			return nil, fmt.Errorf("data %q subscribed to event %v without a channel but can't sync in transactions", d.DataName(), e)
+		}
+		f, err := syncer.SyncTxn(txn, m)
+		if err != nil {
+			done(false)
+			return nil, fmt.Errorf("data %q can't sync event %v: %v", d.DataName(), e, err)
+		}
+		dones = append(dones, f)
+	}
+	return done, nil
+}
+
 func (r *repoT) save() error {
 	compression, err := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
 	if err != nil {
@@ -2068,6 +2259,59 @@ func (r *repoT) remapLocalIDs() (dvid.InstanceMap, dvid.VersionMap, error) {
 	}
 
 	// Pass 2 on DAG: now that we know the version mapping, modify all nodes.
+	r.remapNodes(versionMap, newNodes)
+	return instanceMap, versionMap, nil
+}
+
+// remapToRepo is like remapLocalIDs for a transmitted repo with versions already in the
+// given local repo.  Versions and data instances already in the local repo keep their
+// local IDs, while new ones get new IDs.  A transmitted data instance must be the same
+// instance as any local one with its name.
+func (r *repoT) remapToRepo(local *repoT) (dvid.InstanceMap, dvid.VersionMap, error) {
+	if manager == nil {
+		return nil, nil, ErrManagerNotInitialized
+	}
+	local.RLock()
+	defer local.RUnlock()
+
+	instanceMap := make(dvid.InstanceMap, len(r.data))
+	for dataname, dataservice := range r.data {
+		if localData, found := local.data[dataname]; found {
+			if localData.DataUUID() != dataservice.DataUUID() {
+				return nil, nil, fmt.Errorf("transmitted data %q is not the same instance as the local one with that name", dataname)
+			}
+			instanceMap[dataservice.InstanceID()] = localData.InstanceID()
+			continue
+		}
+		instanceID, err := manager.newInstanceID()
+		if err != nil {
+			return nil, nil, err
+		}
+		instanceMap[dataservice.InstanceID()] = instanceID
+		r.data[dataname].SetInstanceID(instanceID)
+	}
+
+	newNodes := make(map[dvid.VersionID]*nodeT, len(r.dag.nodes))
+	versionMap := make(dvid.VersionMap, len(r.dag.nodes))
+	for oldVersionID, nodePtr := range r.dag.nodes {
+		newVersionID, err := manager.versionFromUUID(nodePtr.uuid)
+		if err == nil {
+			if _, found := local.dag.nodes[newVersionID]; !found {
+				return nil, nil, fmt.Errorf("transmitted version %s belongs to another repo on this server", nodePtr.uuid)
+			}
+		} else if newVersionID, err = manager.newVersionID(nodePtr.uuid, false); err != nil {
+			return nil, nil, err
+		}
+		versionMap[oldVersionID] = newVersionID
+		newNodes[newVersionID] = nodePtr
+	}
+	r.remapNodes(versionMap, newNodes)
+	return instanceMap, versionMap, nil
+}
+
+// remapNodes modifies all nodes of the DAG to use the mapped version IDs and replaces the
+// nodes with the given ones keyed by the mapped IDs.
+func (r *repoT) remapNodes(versionMap dvid.VersionMap, newNodes map[dvid.VersionID]*nodeT) {
 	for _, nodePtr := range r.dag.nodes {
 		nodePtr.version = versionMap[nodePtr.version]
 		for i, oldVersionID := range nodePtr.parents {
@@ -2078,7 +2322,6 @@ func (r *repoT) remapLocalIDs() (dvid.InstanceMap, dvid.VersionMap, error) {
 		}
 	}
 	r.dag.nodes = newNodes
-	return instanceMap, versionMap, nil
 }
 
 // Adds subscriptions for data instance events. making sure that duplicates are avoided.
@@ -2335,6 +2578,9 @@ type nodeT struct {
 	version dvid.VersionID
 	locked  bool
 
+	// lease is the current checkout of an uncommitted node, if any.  Leases are not persisted.
+	lease *leaseT
+
 	// In the case of multiple parents, parents[0] is the default traversal for
 	// an ancestor path.  It's assumed that any merger operation either creates
 	// a DataComplete node or any delta is off one of the parents.
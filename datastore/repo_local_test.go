@@ -3,10 +3,14 @@
 package datastore
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/rpc"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 func TestRepoGobEncoding(t *testing.T) {
@@ -204,3 +208,357 @@ func TestUUIDAssignment(t *testing.T) {
 	}
 
 }
+
+func TestPushIntoExistingRepo(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	root, err := NewRepo("test repo", "test repo description", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(root, "root node", nil); err != nil {
+		t.Fatal(err)
+	}
+	local, err := manager.repoFromUUID(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootV, err := manager.versionFromUUID(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Transmit the repo with a child the local server lacks using a remote version ID.
+	local.RLock()
+	txRepo, err := local.duplicate(local.versionSet(), nil)
+	local.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	childUUID := dvid.UUID("5c9e4a4b00b04bd6a4d8e5b91c6f14d2")
+	remoteV := dvid.VersionID(999)
+	child := newNode(childUUID, remoteV)
+	child.parents = []dvid.VersionID{rootV}
+	txRepo.dag.nodes[rootV].children = append(txRepo.dag.nodes[rootV].children, remoteV)
+	txRepo.dag.nodes[remoteV] = child
+	encoding, err := txRepo.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &pusher{startTime: time.Now()}
+	versions, err := p.readRepo(&repoTxMsg{Transmit: rpc.TransmitAll, UUID: childUUID, Repo: encoding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("Expected only the new child version to be requested, got %v\n", versions)
+	}
+	if _, found := versions[remoteV]; !found {
+		t.Fatalf("Expected remote version %d to be requested, got %v\n", remoteV, versions)
+	}
+	if p.versionMap[rootV] != rootV {
+		t.Errorf("Expected existing root version to keep local id %d, got %d\n", rootV, p.versionMap[rootV])
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	childRepo, err := manager.repoFromUUID(childUUID)
+	if err != nil {
+		t.Fatalf("Pushed child not added: %v\n", err)
+	}
+	if childRepo != local {
+		t.Fatalf("Pushed child added to a new repo instead of existing one\n")
+	}
+	childV, err := manager.versionFromUUID(childUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := GetChildrenByVersion(rootV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 1 || children[0] != childV {
+		t.Errorf("Expected root to have pushed child %d, got %v\n", childV, children)
+	}
+}
+
+func TestLackingVersions(t *testing.T) {
+	txRepo := &repoT{
+		dag: &dagT{nodes: map[dvid.VersionID]*nodeT{
+			1: newNode(dvid.NewUUID(), 1),
+			2: newNode(dvid.NewUUID(), 2),
+			3: newNode(dvid.NewUUID(), 3),
+		}},
+		data: map[dvid.InstanceName]DataService{"old": nil, "new": nil, "partial": nil},
+	}
+	delta := &pushDelta{NewData: []dvid.InstanceName{"new"}}
+	unfinished := map[dvid.InstanceName]map[dvid.VersionID]struct{}{
+		"partial": map[dvid.VersionID]struct{}{2: struct{}{}},
+	}
+	dataVersions := lackingVersions(txRepo, delta, unfinished)
+	if len(dataVersions) != 2 || len(dataVersions["new"]) != 3 || len(dataVersions["partial"]) != 1 {
+		t.Errorf("Expected all versions of new data and unfinished versions of partial data, got %v\n", dataVersions)
+	}
+
+	delta.Versions = map[dvid.VersionID]struct{}{3: struct{}{}}
+	dataVersions = lackingVersions(txRepo, delta, nil)
+	if len(dataVersions) != 3 || len(dataVersions["old"]) != 1 || len(dataVersions["new"]) != 3 {
+		t.Errorf("Expected missing version for all data and all versions of new data, got %v\n", dataVersions)
+	}
+}
+
+func TestPushCheckpoint(t *testing.T) {
+	OpenTest()
+
+	uuid := dvid.UUID("19b87f38f873481b9f3ac688877dff0d")
+	versions := map[dvid.VersionID]struct{}{3: struct{}{}, 4: struct{}{}}
+	dataVersions := map[dvid.InstanceName]map[dvid.VersionID]struct{}{
+		"grayscale": versions,
+		"labels":    map[dvid.VersionID]struct{}{4: struct{}{}},
+	}
+	cp := newPushCheckpoint("remote:8001", uuid, "transmit=1", dataVersions)
+	cp.ack("grayscale", &storage.KeyValue{K: storage.Key("key1"), V: []byte("value")})
+	cp.ack("grayscale", &storage.KeyValue{K: storage.Key("key2"), V: []byte("value")})
+	cp.instance("labels").Done = true
+	if err := cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	CloseReopenTest()
+	defer CloseTest()
+
+	saved, err := getPushCheckpoint("remote:8001", uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved == nil {
+		t.Fatalf("Push checkpoint not persisted\n")
+	}
+	ic := saved.instance("grayscale")
+	if string(ic.LastKey) != "key2" || ic.KeyValues != 2 || ic.Bytes != 18 || ic.Done {
+		t.Errorf("Bad instance checkpoint: %+v\n", ic)
+	}
+	if !saved.instance("labels").Done {
+		t.Errorf("Expected completed instance in checkpoint\n")
+	}
+	if !saved.resumable("transmit=1", map[dvid.VersionID]struct{}{}) {
+		t.Errorf("Expected checkpoint to be resumable when remote has its versions\n")
+	}
+	if saved.resumable("transmit=1", map[dvid.VersionID]struct{}{4: struct{}{}}) {
+		t.Errorf("Expected checkpoint not to be resumable when remote lacks its versions\n")
+	}
+	if saved.resumable("transmit=2", nil) {
+		t.Errorf("Expected checkpoint not to be resumable with different settings\n")
+	}
+	if len(saved.Versions) != 2 {
+		t.Errorf("Expected checkpoint versions to cover all instances, got %v\n", saved.Versions)
+	}
+	unfinished := saved.unfinished()
+	if len(unfinished) != 1 || len(unfinished["grayscale"]) != 2 {
+		t.Errorf("Expected only grayscale to be unfinished, got %v\n", unfinished)
+	}
+
+	if other, err := getPushCheckpoint("remote:8002", uuid); err != nil || other != nil {
+		t.Errorf("Expected no checkpoint for other target, got %v, %v\n", other, err)
+	}
+	if err := deletePushCheckpoint("remote:8001", uuid); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := getPushCheckpoint("remote:8001", uuid); err != nil || deleted != nil {
+		t.Errorf("Expected deleted checkpoint, got %v, %v\n", deleted, err)
+	}
+}
+
+// makePull opens a pull of a child version the local server lacks from a remote that
+// otherwise holds the local repo.  The remote's child has the given number of key-values
+// for the local data instance, stored under the remote version ID.
+func makePull(t *testing.T, numKV int) (d DataService, childUUID dvid.UUID, p *pusher, src *pullSource) {
+	root, err := NewRepo("test repo", "test repo description", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dtype := &TestType{Type{Name: "testtype", URL: "foo.bar.baz/testtype", Version: "1.0", Requirements: &storage.Requirements{}}}
+	if d, err = NewData(root, dtype, "pulldata", dvid.NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootV, err := manager.versionFromUUID(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(NewVersionedCtx(d, rootV), storage.NewTKey(1, []byte("root")), []byte("root value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(root, "root node", nil); err != nil {
+		t.Fatal(err)
+	}
+	local, err := manager.repoFromUUID(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local.RLock()
+	txRepo, err := local.duplicate(local.versionSet(), nil)
+	local.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	childUUID = dvid.UUID("7a4e61b3c2d54f0e9b8a1c6d5e4f3a2b")
+	remoteV := dvid.VersionID(999)
+	child := newNode(childUUID, remoteV)
+	child.parents = []dvid.VersionID{rootV}
+	txRepo.dag.nodes[rootV].children = append(txRepo.dag.nodes[rootV].children, remoteV)
+	txRepo.dag.nodes[remoteV] = child
+	remoteCtx := NewVersionedCtx(d, remoteV)
+	for i := 0; i < numKV; i++ {
+		tk := storage.NewTKey(1, []byte(fmt.Sprintf("key%06d", i)))
+		if err := db.RawPut(remoteCtx.ConstructKey(tk), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encoding, err := txRepo.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p = &pusher{startTime: time.Now()}
+	versions, err := p.readRepo(&repoTxMsg{Transmit: rpc.TransmitAll, UUID: childUUID, Repo: encoding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := versions[remoteV]; !found || len(versions) != 1 {
+		t.Fatalf("Expected only the new child version to be pulled, got %v\n", versions)
+	}
+	newData := p.newData()
+	if len(newData) != 0 {
+		t.Fatalf("Expected no new data for held repo, got %v\n", newData)
+	}
+	src = &pullSource{sessionID: 1, uuid: childUUID, repo: txRepo, transmit: rpc.TransmitAll, done: make(chan struct{})}
+	if err := src.startSend(versions, newData); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// versionKeys returns the number of stored key-values of a data instance at a version.
+func versionKeys(t *testing.T, d DataService, v dvid.VersionID) int {
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	minKey, maxKey := storage.NewDataContext(d, 0).KeyRange()
+	err = scanAllTKeys(db, minKey, maxKey, true, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		for _, kv := range kvs {
+			_, version, _, err := storage.DataKeyToLocalIDs(kv.K)
+			if err != nil {
+				return err
+			}
+			if version == v {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPullIntoHeldRepo(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	numKV := 2*pullBatchSize + 10
+	d, childUUID, p, src := makePull(t, numKV)
+	defer src.Close()
+
+	kvs, err := p.receivePull("remote", src.nextBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvs != uint64(numKV) {
+		t.Errorf("Expected %d pulled key-values, got %d\n", numKV, kvs)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	childV, err := manager.versionFromUUID(childUUID)
+	if err != nil {
+		t.Fatalf("Pulled child not added: %v\n", err)
+	}
+	if n := versionKeys(t, d, childV); n != numKV {
+		t.Errorf("Expected %d key-values stored for pulled child, got %d\n", numKV, n)
+	}
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	childCtx := NewVersionedCtx(d, childV)
+	for _, i := range []int{0, numKV - 1} {
+		value, err := db.Get(childCtx, storage.NewTKey(1, []byte(fmt.Sprintf("key%06d", i))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Bad pulled value for key %d: %q\n", i, string(value))
+		}
+	}
+	value, err := db.Get(childCtx, storage.NewTKey(1, []byte("root")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "root value" {
+		t.Errorf("Expected pulled child to inherit root value, got %q\n", string(value))
+	}
+}
+
+func TestPullFailureCleanup(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	numKV := 3 * pullBatchSize
+	d, childUUID, p, src := makePull(t, numKV)
+
+	// The remote session closes once key-values are received, which must stop its send.
+	repoBytes := p.received
+	var closed bool
+	_, err := p.receivePull("remote", func() (*pullBatch, error) {
+		if !closed && p.received > repoBytes {
+			src.Close()
+			closed = true
+		}
+		return src.nextBatch()
+	})
+	if err == nil {
+		t.Fatalf("Expected pull to fail after remote session closed\n")
+	}
+	localV := p.versionMap[dvid.VersionID(999)]
+	received := versionKeys(t, d, localV)
+	if received == 0 || received >= numKV {
+		t.Fatalf("Expected partial pull before failure, got %d of %d key-values\n", received, numKV)
+	}
+
+	p.abort()
+	if n := versionKeys(t, d, localV); n != 0 {
+		t.Errorf("Expected received key-values deleted after failed pull, got %d\n", n)
+	}
+	rootV, err := manager.versionFromUUID(d.RootUUID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := versionKeys(t, d, rootV); n != 1 {
+		t.Errorf("Expected root key-value kept after failed pull, got %d\n", n)
+	}
+	if _, err := manager.versionFromUUID(childUUID); err == nil {
+		t.Errorf("Expected child of failed pull not to be added\n")
+	}
+}
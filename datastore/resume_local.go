@@ -0,0 +1,250 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports resuming interrupted pushes.  While a push sends key-values, the
+	last key acknowledged by the remote for each data instance is periodically saved in a
+	checkpoint in the metadata store.  A later push of the same repo to the same remote
+	with the same settings skips the instances already sent and the keys already
+	acknowledged.  This file also handles reporting of push throughput and ETA.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/rpc"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/go-humanize"
+)
+
+const (
+	// interval between saves of a push checkpoint.
+	pushCheckpointInterval = 10 * time.Second
+
+	// interval between progress reports of a push.
+	pushProgressInterval = 30 * time.Second
+)
+
+// pushCheckpoint records the progress of a push so it can be resumed.  DataVersions are
+// the versions sent for each data instance and Versions are all of them, using this
+// server's version IDs.  Checkpoints without DataVersions send Versions for all instances.
+type pushCheckpoint struct {
+	Target       string
+	UUID         dvid.UUID
+	Settings     string // transmit, filter and data instances, which must match to resume
+	Versions     map[dvid.VersionID]struct{}
+	DataVersions map[dvid.InstanceName]map[dvid.VersionID]struct{}
+	Instances    map[dvid.InstanceName]*instanceCheckpoint
+	Updated      time.Time
+
+	saved time.Time
+}
+
+// instanceCheckpoint records the progress of a data instance within a push.
+type instanceCheckpoint struct {
+	Done      bool
+	LastKey   storage.Key // last key acknowledged by the remote
+	KeyValues uint64
+	Bytes     uint64
+}
+
+// pushSettings describes the settings of a push that must match for it to be resumed.
+func pushSettings(transmit rpc.Transmit, filter storage.FilterSpec, txRepo *repoT) string {
+	names := make([]string, 0, len(txRepo.data))
+	for name := range txRepo.data {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("transmit=%d filter=%s data=%s", transmit, filter, strings.Join(names, ","))
+}
+
+func pushCheckpointTKey(target string, uuid dvid.UUID) storage.TKey {
+	return storage.NewTKey(pushCheckpointKey, []byte(target+"/"+string(uuid)))
+}
+
+func newPushCheckpoint(target string, uuid dvid.UUID, settings string, dataVersions map[dvid.InstanceName]map[dvid.VersionID]struct{}) *pushCheckpoint {
+	versions := make(map[dvid.VersionID]struct{})
+	for _, vset := range dataVersions {
+		for v := range vset {
+			versions[v] = struct{}{}
+		}
+	}
+	return &pushCheckpoint{
+		Target:       target,
+		UUID:         uuid,
+		Settings:     settings,
+		Versions:     versions,
+		DataVersions: dataVersions,
+		Instances:    make(map[dvid.InstanceName]*instanceCheckpoint),
+	}
+}
+
+// getPushCheckpoint returns the checkpoint of an interrupted push or nil if there is none.
+func getPushCheckpoint(target string, uuid dvid.UUID) (*pushCheckpoint, error) {
+	var ctx storage.MetadataContext
+	value, err := manager.store.Get(ctx, pushCheckpointTKey(target, uuid))
+	if err != nil {
+		return nil, fmt.Errorf("Bad metadata GET: %v", err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	cp := new(pushCheckpoint)
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(cp); err != nil {
+		return nil, fmt.Errorf("Could not decode push checkpoint: %v", err)
+	}
+	if cp.Instances == nil {
+		cp.Instances = make(map[dvid.InstanceName]*instanceCheckpoint)
+	}
+	return cp, nil
+}
+
+func deletePushCheckpoint(target string, uuid dvid.UUID) error {
+	var ctx storage.MetadataContext
+	return manager.store.Delete(ctx, pushCheckpointTKey(target, uuid))
+}
+
+func (cp *pushCheckpoint) save() error {
+	cp.Updated = time.Now()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cp); err != nil {
+		return err
+	}
+	var ctx storage.MetadataContext
+	if err := manager.store.Put(ctx, pushCheckpointTKey(cp.Target, cp.UUID), buf.Bytes()); err != nil {
+		return err
+	}
+	cp.saved = cp.Updated
+	return nil
+}
+
+// resumable returns true if the remote has all the checkpointed versions.  If the remote
+// lacks any, the interrupted push never added them, and key-values already sent for them
+// aren't usable by the remote.
+func (cp *pushCheckpoint) resumable(settings string, missing map[dvid.VersionID]struct{}) bool {
+	if cp.Settings != settings {
+		return false
+	}
+	for v := range cp.Versions {
+		if _, found := missing[v]; found {
+			return false
+		}
+	}
+	return true
+}
+
+// versionsOf returns the versions to send for a data instance or false if it has none.
+func (cp *pushCheckpoint) versionsOf(name dvid.InstanceName) (map[dvid.VersionID]struct{}, bool) {
+	if cp.DataVersions == nil {
+		return cp.Versions, true
+	}
+	versions, found := cp.DataVersions[name]
+	return versions, found && len(versions) != 0
+}
+
+// unfinished returns the versions of data instances that have not been completely sent.
+func (cp *pushCheckpoint) unfinished() map[dvid.InstanceName]map[dvid.VersionID]struct{} {
+	unfinished := make(map[dvid.InstanceName]map[dvid.VersionID]struct{})
+	for name, ic := range cp.Instances {
+		if versions, found := cp.versionsOf(name); found && !ic.Done {
+			unfinished[name] = versions
+		}
+	}
+	return unfinished
+}
+
+func (cp *pushCheckpoint) instance(name dvid.InstanceName) *instanceCheckpoint {
+	ic, found := cp.Instances[name]
+	if !found {
+		ic = new(instanceCheckpoint)
+		cp.Instances[name] = ic
+	}
+	return ic
+}
+
+// ack records a key-value acknowledged by the remote, periodically saving the checkpoint.
+func (cp *pushCheckpoint) ack(name dvid.InstanceName, kv *storage.KeyValue) {
+	ic := cp.instance(name)
+	ic.LastKey = kv.K
+	ic.KeyValues++
+	ic.Bytes += uint64(len(kv.K) + len(kv.V))
+	if time.Since(cp.saved) > pushCheckpointInterval {
+		if err := cp.save(); err != nil {
+			dvid.Errorf("Unable to save checkpoint of push of repo %s to %q: %v\n", cp.UUID, cp.Target, err)
+		}
+	}
+}
+
+// pushProgress reports throughput and, if the stores can estimate sizes, the ETA of a push.
+type pushProgress struct {
+	cp         *pushCheckpoint
+	start      time.Time
+	lastReport time.Time
+	kvs        uint64
+	sent       uint64 // bytes sent since start
+	estimate   uint64 // approximate bytes to send since start, or 0 if unknown
+}
+
+func newPushProgress(cp *pushCheckpoint, txRepo *repoT) *pushProgress {
+	pp := &pushProgress{cp: cp, start: time.Now(), lastReport: time.Now()}
+	for name, d := range txRepo.data {
+		if _, found := cp.versionsOf(name); !found {
+			continue
+		}
+		ic := cp.instance(name)
+		if ic.Done {
+			continue
+		}
+		// Sizes cover all versions of an instance, so estimates are high for partial pushes.
+		store, err := d.BackendStore()
+		if err != nil {
+			pp.estimate = 0
+			break
+		}
+		sizes, err := storage.GetDataSizes(store, []dvid.InstanceID{d.InstanceID()})
+		if err != nil {
+			pp.estimate = 0
+			break
+		}
+		if size := sizes[d.InstanceID()]; size > ic.Bytes {
+			pp.estimate += size - ic.Bytes
+		}
+	}
+	return pp
+}
+
+func (pp *pushProgress) add(kv *storage.KeyValue) {
+	pp.kvs++
+	pp.sent += uint64(len(kv.K) + len(kv.V))
+	if time.Since(pp.lastReport) > pushProgressInterval {
+		pp.report()
+		pp.lastReport = time.Now()
+	}
+}
+
+func (pp *pushProgress) report() {
+	elapsed := time.Since(pp.start)
+	throughput := float64(pp.sent) / 1000000 / elapsed.Seconds()
+	eta := "unknown"
+	if pp.estimate > pp.sent && pp.sent > 0 {
+		secs := elapsed.Seconds() * float64(pp.estimate-pp.sent) / float64(pp.sent)
+		remaining := time.Duration(secs) * time.Second
+		eta = fmt.Sprintf("about %s (%s)", remaining, time.Now().Add(remaining).Format(time.RFC3339))
+	}
+	dvid.Infof("Push of repo %s to %q: sent %d key-value pairs (%s) in %s at %5.2f MB/s, ETA %s\n",
+		pp.cp.UUID, pp.cp.Target, pp.kvs, humanize.Bytes(pp.sent), elapsed, throughput, eta)
+}
+
+func (pp *pushProgress) finish() {
+	elapsed := time.Since(pp.start)
+	throughput := float64(pp.sent) / 1000000 / elapsed.Seconds()
+	dvid.Infof("Finished push of repo %s to %q: sent %d key-value pairs (%s) in %s at %5.2f MB/s\n",
+		pp.cp.UUID, pp.cp.Target, pp.kvs, humanize.Bytes(pp.sent), elapsed, throughput)
+}
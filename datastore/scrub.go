@@ -0,0 +1,112 @@
+/*
+	This file supports online verification of stored values against data instance checksums.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Scrubber is a DataService whose stored values are serialized via dvid.SerializeData
+// and can therefore be verified by a scrub.
+type Scrubber interface {
+	DataService
+
+	Checksum() dvid.Checksum
+
+	// DeserializeData deserializes a stored value, enforcing the instance's checksum.
+	DeserializeData(s []byte, uncompress bool) ([]byte, dvid.CompressionFormat, error)
+
+	// ScrubKey returns a description of a type-specific key, e.g., a block coordinate,
+	// or false if the key's value is not a serialization that can be verified.
+	ScrubKey(storage.TKey) (string, bool)
+}
+
+// CorruptValue describes a stored value that could not be deserialized.
+type CorruptValue struct {
+	Key     string // hexadecimal type-specific key
+	Decoded string // type-specific description of key, e.g., block coordinate
+	Error   string
+}
+
+// ScrubReport gives the results of scrubbing a data instance at a version.
+type ScrubReport struct {
+	DataName dvid.InstanceName
+	UUID     dvid.UUID
+	Checksum string
+	Scanned  uint64 // number of values verified
+	Bytes    uint64 // bytes of values verified
+	Skipped  uint64 // number of values with keys that aren't serializations
+	Corrupt  []CorruptValue
+	Elapsed  string
+}
+
+// Scrub reads every key-value pair of a data instance visible at the given version and
+// verifies each value, including its checksum.  Corrupt values are noted in the returned
+// report rather than ending the scrub.
+func Scrub(uuid dvid.UUID, name dvid.InstanceName) (*ScrubReport, error) {
+	data, err := GetDataByUUIDName(uuid, name)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := data.(Scrubber)
+	if !ok {
+		return nil, fmt.Errorf("data %q of type %q does not support scrubbing", name, data.TypeName())
+	}
+	v, err := VersionFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !d.Versioned() {
+		if v, err = GetRepoRootVersion(v); err != nil {
+			return nil, err
+		}
+	}
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	report := &ScrubReport{
+		DataName: name,
+		UUID:     uuid,
+		Checksum: d.Checksum().String(),
+		Corrupt:  []CorruptValue{},
+	}
+
+	ctx := NewVersionedCtx(d, v)
+	begTKey := storage.MinTKey(storage.TKeyMinClass)
+	endTKey := storage.MaxTKey(storage.TKeyMaxClass)
+	err = db.ProcessRange(ctx, begTKey, endTKey, nil, func(c *storage.Chunk) error {
+		if c == nil || c.TKeyValue == nil || isJournalKey(c.K) {
+			return nil
+		}
+		decoded, ok := d.ScrubKey(c.K)
+		if !ok {
+			report.Skipped++
+			return nil
+		}
+		report.Scanned++
+		report.Bytes += uint64(len(c.V))
+		if _, _, err := d.DeserializeData(c.V, true); err != nil {
+			report.Corrupt = append(report.Corrupt, CorruptValue{
+				Key:     fmt.Sprintf("%x", []byte(c.K)),
+				Decoded: decoded,
+				Error:   err.Error(),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Elapsed = time.Since(start).String()
+	dvid.Infof("Scrubbed %d values of data %q, version %s: %d corrupt, %s\n", report.Scanned, name, uuid, len(report.Corrupt), report.Elapsed)
+	return report, nil
+}
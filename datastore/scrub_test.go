@@ -0,0 +1,72 @@
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestScrub(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, versionID := initTestRepo()
+
+	config := dvid.NewConfig()
+	config.Set("Checksum", "crc32")
+	dataservice, err := datastore.NewData(uuid, kvtype, "scrubbed", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	kvdata := dataservice.(*keyvalue.Data)
+	ctx := datastore.NewVersionedCtx(dataservice, versionID)
+	for _, key := range []string{"a", "b", "c"} {
+		if err = kvdata.PutData(ctx, key, []byte("value for "+key)); err != nil {
+			t.Fatalf("Could not put keyvalue data: %v\n", err)
+		}
+	}
+
+	// Corrupt one value and store another without the required checksum.
+	db, err := kvdata.GetOrderedKeyValueDB()
+	if err != nil {
+		t.Fatalf("Could not get store: %v\n", err)
+	}
+	tk, _ := keyvalue.NewTKey("b")
+	serialization, err := db.Get(ctx, tk)
+	if err != nil {
+		t.Fatalf("Could not get serialization: %v\n", err)
+	}
+	serialization[len(serialization)-1] ^= 0x04
+	if err = db.Put(ctx, tk, serialization); err != nil {
+		t.Fatalf("Could not put corrupt value: %v\n", err)
+	}
+	tk, _ = keyvalue.NewTKey("c")
+	serialization, _ = dvid.SerializeData([]byte("unprotected"), kvdata.Compression(), dvid.NoChecksum)
+	if err = db.Put(ctx, tk, serialization); err != nil {
+		t.Fatalf("Could not put value without checksum: %v\n", err)
+	}
+
+	if _, _, err = kvdata.GetData(ctx, "b"); err == nil {
+		t.Errorf("Expected error reading corrupt value\n")
+	}
+	if _, _, err = kvdata.GetData(ctx, "c"); err == nil {
+		t.Errorf("Expected error reading value without required checksum\n")
+	}
+
+	scrubreq := fmt.Sprintf("%snode/%s/scrubbed/scrub", server.WebAPIPath, uuid)
+	var report datastore.ScrubReport
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", scrubreq, nil), &report); err != nil {
+		t.Fatalf("Unable to parse scrub report: %v\n", err)
+	}
+	if report.Scanned != 3 || len(report.Corrupt) != 2 {
+		t.Fatalf("Expected 3 scanned and 2 corrupt values, got %+v\n", report)
+	}
+	if report.Corrupt[0].Decoded != "b" || report.Corrupt[1].Decoded != "c" {
+		t.Errorf("Bad corrupt keys in scrub report: %+v\n", report.Corrupt)
+	}
+}
@@ -0,0 +1,446 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports pruning the version DAG: deleting leaf nodes and squashing linear
+	chains of nodes into a single node.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// SquashBatchSize is the maximum number of key-value pairs rewritten per transaction when
+// squashing nodes or purging a deleted node.
+const SquashBatchSize = 1000
+
+// SquashResult gives the node resulting from a squash and the UUIDs that now resolve to it.
+type SquashResult struct {
+	Node     dvid.UUID   `json:"node"`
+	Squashed []dvid.UUID `json:"squashed"`
+}
+
+// DeleteLeaf removes an uncommitted leaf node from its repo's DAG and purges the key-value
+// pairs written at that version from all data instances.  The root cannot be deleted;
+// delete the repo instead.
+func DeleteLeaf(uuid dvid.UUID) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.deleteLeaf(uuid)
+}
+
+// Squash collapses the linear chain of committed nodes from an ancestor down to a
+// descendant into the ancestor node.  Each key takes the value visible at the descendant,
+// and the children of the descendant become children of the ancestor.  Node logs are kept
+// and the UUIDs of squashed nodes continue to resolve to the ancestor.  If the note is
+// empty, the descendant's note is used.
+func Squash(from, to dvid.UUID, note string) (*SquashResult, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.squash(from, to, note)
+}
+
+func (m *repoManager) deleteLeaf(uuid dvid.UUID) error {
+	instances, v, err := m.removeLeaf(uuid)
+	if err != nil {
+		return err
+	}
+
+	// The version is no longer reachable, so its key-value pairs are purged without locks.
+	for _, d := range instances {
+		if !d.Versioned() {
+			continue
+		}
+		deleted, err := purgeVersion(d, v)
+		if err != nil {
+			return fmt.Errorf("unable to purge version %s from data %q: %v", uuid, d.DataName(), err)
+		}
+		dvid.Infof("Deleted %d key-value pairs of data %q for deleted node %s\n", deleted, d.DataName(), uuid)
+	}
+	return nil
+}
+
+// removeLeaf removes a leaf node from its repo's DAG and returns the repo's data instances
+// and the version of the removed node.
+func (m *repoManager) removeLeaf(uuid dvid.UUID) ([]DataService, dvid.VersionID, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	v, err := m.versionFromUUID(uuid)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := m.repoFromUUID(uuid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r.Lock()
+	node, found := r.dag.nodes[v]
+	if !found {
+		r.Unlock()
+		return nil, 0, ErrInvalidVersion
+	}
+	switch {
+	case v == r.dag.rootV:
+		err = fmt.Errorf("node %s is the root of its repo; delete the repo instead", uuid)
+	case len(node.children) != 0:
+		err = fmt.Errorf("node %s has %d children and is not a leaf", uuid, len(node.children))
+	case node.locked:
+		err = fmt.Errorf("node %s is committed; only uncommitted leaf nodes can be deleted", uuid)
+	}
+	if err != nil {
+		r.Unlock()
+		return nil, 0, err
+	}
+	for _, parent := range node.parents {
+		pnode, found := r.dag.nodes[parent]
+		if !found {
+			continue
+		}
+		children := pnode.children[:0]
+		for _, child := range pnode.children {
+			if child != v {
+				children = append(children, child)
+			}
+		}
+		pnode.children = children
+		pnode.updated = time.Now()
+	}
+	delete(r.dag.nodes, v)
+	if len(node.parents) != 0 {
+		if pnode, found := r.dag.nodes[node.parents[0]]; found {
+			r.moveRefs(node.uuid, pnode.uuid, BranchRef)
+		}
+	}
+	r.updated = time.Now()
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	err = r.save()
+	r.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m.idMutex.Lock()
+	delete(m.versionToUUID, v)
+	delete(m.uuidToVersion, node.uuid)
+	delete(m.repos, node.uuid)
+	for u, aliasV := range m.uuidAliases {
+		if aliasV == v {
+			delete(m.uuidToVersion, u)
+			delete(m.repos, u)
+			delete(m.uuidAliases, u)
+		}
+	}
+	err = m.putCaches()
+	m.idMutex.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	return instances, v, nil
+}
+
+// purgeVersion deletes all key-value pairs of a data instance written at a version,
+// including its mutation journal entries.
+// Deletions are committed in batches of up to SquashBatchSize key-value pairs.
+func purgeVersion(d dvid.Data, v dvid.VersionID) (deleted uint64, err error) {
+	db, batcher, err := squashStore(d)
+	if err != nil {
+		return 0, err
+	}
+	txn := storage.NewTransaction()
+	minKey, maxKey := storage.NewDataContext(d, 0).KeyRange()
+	err = scanAllTKeys(db, minKey, maxKey, true, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		for _, kv := range kvs {
+			_, kvV, _, err := storage.DataKeyToLocalIDs(kv.K)
+			if err != nil {
+				return err
+			}
+			if kvV != v {
+				continue
+			}
+			txn.RawDelete(batcher, kv.K)
+			deleted++
+		}
+		if txn.Len() >= SquashBatchSize {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = storage.NewTransaction()
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	err = txn.Commit()
+	return
+}
+
+// squashStore returns the ordered and batch-enabled store of a data instance.
+func squashStore(d dvid.Data) (storage.OrderedKeyValueDB, storage.KeyValueBatcher, error) {
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return nil, nil, fmt.Errorf("data %q needs a batch-enabled store, which %s is not", d.DataName(), db)
+	}
+	return db, batcher, nil
+}
+
+// checkSquashing returns an error if the version is in a chain being squashed, whose
+// DAG links can't change until the squash finishes.  The repo must be locked.
+func (r *repoT) checkSquashing(v dvid.VersionID) error {
+	if _, found := r.squashing[v]; found {
+		return fmt.Errorf("node %s is being squashed", r.dag.nodes[v].uuid)
+	}
+	return nil
+}
+
+func (m *repoManager) squash(from, to dvid.UUID, note string) (*SquashResult, error) {
+	r, chain, depth, err := m.startSquash(from, to)
+	if err != nil {
+		return nil, err
+	}
+	fromV := chain[len(chain)-1]
+
+	// Rewrite the keys of each versioned data instance before changing the DAG.  The chain
+	// is marked as squashing so the manager and repo aren't locked during the rewrite.  Since
+	// the value visible at the descendant is written to the ancestor along with the deletion
+	// of other versions, reads along the chain stay correct during the rewrite.
+	r.RLock()
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	r.RUnlock()
+	for _, d := range instances {
+		if !d.Versioned() {
+			continue
+		}
+		err = squashData(d, fromV, depth)
+		storage.InvalidateGroupcache(d.InstanceID(), fromV)
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		r.Lock()
+		for _, v := range chain {
+			delete(r.squashing, v)
+		}
+		r.Unlock()
+		return nil, fmt.Errorf("unable to squash %s to %s: %v", from, to, err)
+	}
+	return m.finishSquash(r, chain, depth, note)
+}
+
+// startSquash checks that the nodes from an ancestor down to a descendant are a linear chain
+// of committed nodes and marks them as squashing.  It returns the chain from the descendant
+// up to the ancestor and the depth of each version in the chain.
+func (m *repoManager) startSquash(from, to dvid.UUID) (*repoT, []dvid.VersionID, map[dvid.VersionID]int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	fromV, err := m.versionFromUUID(from)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	toV, err := m.versionFromUUID(to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, err := m.repoFromUUID(from)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// Walk up from the descendant, making sure the chain is linear and committed.
+	chain := []dvid.VersionID{toV}
+	for cur := toV; cur != fromV; {
+		node, found := r.dag.nodes[cur]
+		if !found {
+			return nil, nil, nil, fmt.Errorf("node %s is not in the same repo as node %s", to, from)
+		}
+		if len(node.parents) != 1 {
+			return nil, nil, nil, fmt.Errorf("node %s has %d parents so %s to %s is not a linear chain of descendants", node.uuid, len(node.parents), from, to)
+		}
+		cur = node.parents[0]
+		parent, found := r.dag.nodes[cur]
+		if !found {
+			return nil, nil, nil, ErrInvalidVersion
+		}
+		if len(parent.children) != 1 {
+			return nil, nil, nil, fmt.Errorf("node %s has %d children so %s to %s is not a linear chain", parent.uuid, len(parent.children), from, to)
+		}
+		chain = append(chain, cur)
+	}
+	if len(chain) < 2 {
+		return nil, nil, nil, fmt.Errorf("squash requires a descendant of node %s", from)
+	}
+	depth := make(map[dvid.VersionID]int, len(chain))
+	for i, v := range chain {
+		depth[v] = len(chain) - 1 - i
+		if !r.dag.nodes[v].locked {
+			return nil, nil, nil, fmt.Errorf("node %s is uncommitted; only committed nodes can be squashed", r.dag.nodes[v].uuid)
+		}
+		if err := r.checkSquashing(v); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if r.squashing == nil {
+		r.squashing = make(map[dvid.VersionID]struct{})
+	}
+	for _, v := range chain {
+		r.squashing[v] = struct{}{}
+	}
+	return r, chain, depth, nil
+}
+
+// finishSquash folds a chain whose key-value pairs were squashed into its ancestor node.
+func (m *repoManager) finishSquash(r *repoT, chain []dvid.VersionID, depth map[dvid.VersionID]int, note string) (*SquashResult, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, v := range chain {
+		delete(r.squashing, v)
+	}
+	toV, fromV := chain[0], chain[len(chain)-1]
+
+	// Fold the chain into the ancestor node.
+	t := time.Now()
+	fromNode := r.dag.nodes[fromV]
+	toNode := r.dag.nodes[toV]
+	result := &SquashResult{Node: fromNode.uuid}
+	for i := len(chain) - 2; i >= 0; i-- {
+		node := r.dag.nodes[chain[i]]
+		fromNode.log = append(fromNode.log, node.log...)
+		result.Squashed = append(result.Squashed, node.uuid)
+		r.moveRefs(node.uuid, fromNode.uuid, "")
+		delete(r.dag.nodes, chain[i])
+	}
+	if note != "" {
+		fromNode.note = note
+	} else if toNode.note != "" {
+		fromNode.note = toNode.note
+	}
+	squashed := make([]string, len(result.Squashed))
+	for i, uuid := range result.Squashed {
+		squashed[i] = string(uuid)
+	}
+	fromNode.addToLog([]string{fmt.Sprintf("Squashed nodes %s into this node", strings.Join(squashed, ", "))})
+	fromNode.children = toNode.children
+	for _, child := range fromNode.children {
+		cnode, found := r.dag.nodes[child]
+		if !found {
+			continue
+		}
+		for i, parent := range cnode.parents {
+			if parent == toV {
+				cnode.parents[i] = fromV
+			}
+		}
+	}
+	fromNode.updated, r.updated = t, t
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+
+	m.idMutex.Lock()
+	defer m.idMutex.Unlock()
+	for _, v := range chain[:len(chain)-1] {
+		uuid := m.versionToUUID[v]
+		delete(m.versionToUUID, v)
+		m.uuidToVersion[uuid] = fromV
+		m.uuidAliases[uuid] = fromV
+	}
+	for uuid, v := range m.uuidAliases {
+		if _, found := depth[v]; found {
+			m.uuidToVersion[uuid] = fromV
+			m.uuidAliases[uuid] = fromV
+		}
+	}
+	dvid.Infof("Squashed %d nodes into node %s\n", len(result.Squashed), fromNode.uuid)
+	return result, m.putCaches()
+}
+
+// squashData moves the value of each key visible at the deepest version of a chain to the
+// chain's first version, deleting the key-value pairs of other versions in the chain.  The
+// rewrite of each key is committed atomically, in batches of up to SquashBatchSize key-value
+// pairs.  Mutation journal entries, which scanTKeys skips, are kept at their versions.
+func squashData(d dvid.Data, fromV dvid.VersionID, depth map[dvid.VersionID]int) error {
+	db, batcher, err := squashStore(d)
+	if err != nil {
+		return err
+	}
+	txn := storage.NewTransaction()
+	ctx := storage.NewDataContext(d, fromV)
+	minKey, maxKey := ctx.KeyRange()
+	err = scanTKeys(db, minKey, maxKey, false, func(tk storage.TKey, kvs []*storage.KeyValue) error {
+		var keep *storage.KeyValue
+		var chainKVs []*storage.KeyValue
+		keepDepth := -1
+		for _, kv := range kvs {
+			_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+			if err != nil {
+				return err
+			}
+			vDepth, found := depth[v]
+			if !found {
+				continue
+			}
+			chainKVs = append(chainKVs, kv)
+			if vDepth > keepDepth {
+				keep, keepDepth = kv, vDepth
+			}
+		}
+		if keep == nil {
+			return nil
+		}
+		var newKey storage.Key
+		if keep.K.IsTombstone() {
+			newKey = ctx.TombstoneKey(tk)
+		} else {
+			newKey = ctx.ConstructKey(tk)
+		}
+		for _, kv := range chainKVs {
+			if !bytes.Equal(kv.K, newKey) {
+				txn.RawDelete(batcher, kv.K)
+			}
+		}
+		if !bytes.Equal(newKey, keep.K) {
+			txn.RawPut(batcher, newKey, keep.V)
+		}
+		if txn.Len() >= SquashBatchSize {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = storage.NewTransaction()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return txn.Commit()
+}
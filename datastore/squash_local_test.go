@@ -0,0 +1,126 @@
+// +build !clustered,!gcloud
+
+package datastore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestDeleteLeafAndSquash(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	_, err := datastore.NewData(uuid, kvtype, "squashtest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/squashtest/key/%s", server.WebAPIPath, uuid, key)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid, "a"), strings.NewReader("root a"))
+	server.TestHTTP(t, "POST", keyreq(uuid, "b"), strings.NewReader("root b"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+
+	// Make a chain root -> n1 -> n2 -> n3 with changes in each committed node.
+	n1, err := datastore.NewVersion(uuid, "n1", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyreq(n1, "a"), strings.NewReader("n1 a"))
+	server.TestHTTP(t, "POST", keyreq(n1, "c"), strings.NewReader("n1 c"))
+	if err = datastore.Commit(n1, "n1", []string{"n1 log"}); err != nil {
+		t.Fatalf("Unable to commit: %v\n", err)
+	}
+	n2, err := datastore.NewVersion(n1, "n2", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyreq(n2, "a"), strings.NewReader("n2 a"))
+	server.TestHTTP(t, "DELETE", keyreq(n2, "b"), nil)
+	if err = datastore.Commit(n2, "n2", []string{"n2 log"}); err != nil {
+		t.Fatalf("Unable to commit: %v\n", err)
+	}
+	n3, err := datastore.NewVersion(n2, "n3", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyreq(n3, "d"), strings.NewReader("n3 d"))
+
+	// Only uncommitted leaves can be deleted.
+	server.TestBadHTTP(t, "DELETE", fmt.Sprintf("%snode/%s", server.WebAPIPath, n2), nil)
+	leaf, err := datastore.NewVersion(n2, "abandoned", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyreq(leaf, "a"), strings.NewReader("abandoned a"))
+	server.TestHTTP(t, "DELETE", fmt.Sprintf("%snode/%s", server.WebAPIPath, leaf), nil)
+	if _, err := datastore.VersionFromUUID(leaf); err == nil {
+		t.Errorf("Expected deleted leaf %s to be gone\n", leaf)
+	}
+
+	// The chain can't be squashed past the uncommitted node.
+	squashreq := fmt.Sprintf("%srepo/%s/squash", server.WebAPIPath, uuid)
+	payload := fmt.Sprintf(`{"from":%q,"to":%q}`, n1, n3)
+	server.TestBadHTTP(t, "POST", squashreq, strings.NewReader(payload))
+
+	payload = fmt.Sprintf(`{"from":%q,"to":%q,"note":"squashed"}`, n1, n2)
+	var result datastore.SquashResult
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", squashreq, strings.NewReader(payload)), &result); err != nil {
+		t.Fatalf("Bad squash response: %v\n", err)
+	}
+	if result.Node != n1 || len(result.Squashed) != 1 || result.Squashed[0] != n2 {
+		t.Errorf("Bad squash result: %v\n", result)
+	}
+
+	// The old UUID resolves to the squashed node, which has the data as of n2.
+	v1, err := datastore.VersionFromUUID(n1)
+	if err != nil {
+		t.Fatalf("Unable to get version of squashed node: %v\n", err)
+	}
+	if v2, err := datastore.VersionFromUUID(n2); err != nil || v1 != v2 {
+		t.Errorf("Expected squashed UUID %s to resolve to version %d, got %d (%v)\n", n2, v1, v2, err)
+	}
+	expected := map[string]string{"a": "n2 a", "c": "n1 c"}
+	for _, node := range []dvid.UUID{n1, n2} {
+		for key, value := range expected {
+			if got := server.TestHTTP(t, "GET", keyreq(node, key), nil); string(got) != value {
+				t.Errorf("Expected %q for key %q at %s, got %q\n", value, key, node, string(got))
+			}
+		}
+		server.TestBadHTTP(t, "GET", keyreq(node, "b"), nil)
+	}
+	if got := server.TestHTTP(t, "GET", keyreq(n3, "d"), nil); string(got) != "n3 d" {
+		t.Errorf("Expected child of squashed node to keep its data, got %q\n", string(got))
+	}
+	if got := server.TestHTTP(t, "GET", keyreq(n3, "a"), nil); string(got) != "n2 a" {
+		t.Errorf("Expected child of squashed node to see squashed data, got %q\n", string(got))
+	}
+	if got := server.TestHTTP(t, "GET", keyreq(uuid, "a"), nil); string(got) != "root a" {
+		t.Errorf("Expected root to be unchanged by squash, got %q\n", string(got))
+	}
+	log, err := datastore.GetNodeLog(n1)
+	if err != nil {
+		t.Fatalf("Unable to get node log: %v\n", err)
+	}
+	if len(log) != 3 || !strings.HasSuffix(log[0], "n1 log") || !strings.HasSuffix(log[1], "n2 log") {
+		t.Errorf("Expected node logs to be kept after squash, got %v\n", log)
+	}
+
+	// Aliases persist across restarts.
+	datastore.CloseReopenTest()
+	if got := server.TestHTTP(t, "GET", keyreq(n2, "a"), nil); string(got) != "n2 a" {
+		t.Errorf("Expected squashed UUID to resolve after restart, got %q\n", string(got))
+	}
+}
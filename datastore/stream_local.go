@@ -0,0 +1,259 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports streaming of a data instance's sync events to clients, e.g., viewers
+	that refresh when labels change.  Streams are fed from the same notifications as internal
+	subscribers.  Publishing never blocks: a stream that can't keep up is dropped, and its
+	client can resume from the last event it received using the instance's event history.
+	Event IDs include an epoch of the server run, so IDs from before a restart are rejected
+	rather than silently matching new events.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// number of recent events kept per data instance so dropped clients can resume.
+	streamHistorySize = 1000
+
+	// number of events buffered for a stream before it is dropped.
+	streamBufferSize = 100
+)
+
+// StreamEvent is a sync event of a data instance sent to event streams.  IDs are of the
+// form "<epoch>-<n>", where the epoch identifies the run of the server and n increases
+// with each event of an instance.
+type StreamEvent struct {
+	ID      string          `json:"id"`
+	Event   string          `json:"event"`
+	UUID    dvid.UUID       `json:"uuid"`
+	Time    time.Time       `json:"time"`
+	Extents *dvid.Extents3d `json:"extents,omitempty"` // voxel extents of the change, if known
+	Delta   interface{}     `json:"delta,omitempty"`
+
+	n uint64
+	v dvid.VersionID
+}
+
+// streamEpoch distinguishes the event IDs of this run of the server from earlier runs.
+var streamEpoch = time.Now().UnixNano()
+
+func streamEventID(n uint64) string {
+	return fmt.Sprintf("%d-%d", streamEpoch, n)
+}
+
+// parseStreamEventID returns the event number of an event ID from this run of the server.
+func parseStreamEventID(id string) (uint64, error) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("bad event id %q", id)
+	}
+	epoch, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad event id %q", id)
+	}
+	n, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad event id %q", id)
+	}
+	if epoch != streamEpoch {
+		return 0, fmt.Errorf("event id %q is from before the server restarted, so events may have been missed", id)
+	}
+	return n, nil
+}
+
+// EventFilter returns true if an event should be sent to a stream.
+type EventFilter func(*StreamEvent) bool
+
+// EventStream receives the events of a data instance version that pass its filter.
+type EventStream struct {
+	C <-chan *StreamEvent // closed when the stream is closed or dropped
+
+	ch      chan *StreamEvent
+	data    dvid.UUID
+	v       dvid.VersionID
+	filter  EventFilter
+	dropped bool
+}
+
+// Dropped returns true if the stream was closed because its client fell behind.
+func (s *EventStream) Dropped() bool {
+	streams.Lock()
+	defer streams.Unlock()
+	return s.dropped
+}
+
+// Close stops the stream.
+func (s *EventStream) Close() {
+	streams.Lock()
+	defer streams.Unlock()
+	if is, found := streams.instances[s.data]; found {
+		if _, subscribed := is.subs[s]; subscribed {
+			delete(is.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// instanceStreams holds the event history and streams of a data instance.  Publishing is
+// serialized per instance so events are numbered and sent in order.
+type instanceStreams struct {
+	publishMu sync.Mutex
+	lastID    uint64
+	history   []*StreamEvent
+	blockSize dvid.Point3d // zero if the instance isn't stored in blocks of voxels
+	subs      map[*EventStream]struct{}
+}
+
+var streams = struct {
+	sync.Mutex
+	instances map[dvid.UUID]*instanceStreams
+}{instances: make(map[dvid.UUID]*instanceStreams)}
+
+// blockSizer is implemented by data instances stored in blocks of voxels.
+type blockSizer interface {
+	BlockSize() dvid.Point
+}
+
+// SubscribeEvents returns a stream of events for the given data instance and version that
+// pass the filter, which may be nil.  If lastID is not empty, events in the instance's
+// history after that ID are also returned so a client can resume a dropped stream.  An
+// error is returned if events after lastID may have been missed, e.g., because the server
+// restarted or they are no longer in the history.  Histories are only kept for instances
+// that have been streamed.
+func SubscribeEvents(d dvid.Data, v dvid.VersionID, lastID string, filter EventFilter) (*EventStream, []*StreamEvent, error) {
+	if manager == nil {
+		return nil, nil, ErrManagerNotInitialized
+	}
+	var lastN uint64
+	if lastID != "" {
+		var err error
+		if lastN, err = parseStreamEventID(lastID); err != nil {
+			return nil, nil, err
+		}
+	}
+	streams.Lock()
+	defer streams.Unlock()
+
+	is, found := streams.instances[d.DataUUID()]
+	if lastID != "" {
+		switch {
+		case !found || lastN > is.lastID:
+			return nil, nil, fmt.Errorf("unknown event id %q for data %q", lastID, d.DataName())
+		case len(is.history) != 0 && is.history[0].n > lastN+1:
+			return nil, nil, fmt.Errorf("events after id %q of data %q are no longer kept", lastID, d.DataName())
+		}
+	}
+	if !found {
+		is = &instanceStreams{subs: make(map[*EventStream]struct{})}
+		if sizer, ok := d.(blockSizer); ok {
+			if size, ok := sizer.BlockSize().(dvid.Point3d); ok {
+				is.blockSize = size
+			}
+		}
+		streams.instances[d.DataUUID()] = is
+	}
+	s := &EventStream{
+		ch:     make(chan *StreamEvent, streamBufferSize),
+		data:   d.DataUUID(),
+		v:      v,
+		filter: filter,
+	}
+	s.C = s.ch
+	is.subs[s] = struct{}{}
+
+	var missed []*StreamEvent
+	if lastID != "" {
+		for _, evt := range is.history {
+			if evt.n > lastN && s.wants(evt) {
+				missed = append(missed, evt)
+			}
+		}
+	}
+	return s, missed, nil
+}
+
+func (s *EventStream) wants(evt *StreamEvent) bool {
+	return evt.v == s.v && (s.filter == nil || s.filter(evt))
+}
+
+// publishStreamEvent sends an event to the streams of its data instance without blocking,
+// dropping any stream whose buffer is full.  The extents of the change are only computed if
+// a stream of the event's version exists, and without holding the global streams lock.
+// Events kept in the history without extents are sent to resuming streams regardless of
+// bounds.
+func publishStreamEvent(e SyncEvent, m SyncMessage) {
+	streams.Lock()
+	is, found := streams.instances[e.Data]
+	var located bool
+	if found {
+		for s := range is.subs {
+			if s.v == m.Version {
+				located = true
+				break
+			}
+		}
+	}
+	streams.Unlock()
+	if !found {
+		return
+	}
+	is.publishMu.Lock()
+	defer is.publishMu.Unlock()
+
+	uuid, err := manager.uuidFromVersion(m.Version)
+	if err != nil {
+		dvid.Errorf("Unable to get UUID of version %d for event stream: %v\n", m.Version, err)
+		return
+	}
+	evt := &StreamEvent{
+		Event: e.Event,
+		UUID:  uuid,
+		Time:  time.Now(),
+		v:     m.Version,
+	}
+	if locator, ok := m.Delta.(DeltaLocator); ok && located {
+		if extents, ok := locator.Extents(is.blockSize); ok {
+			evt.Extents = &extents
+		}
+	}
+	if summarizer, ok := m.Delta.(DeltaSummarizer); ok {
+		evt.Delta = summarizer.Summary()
+	}
+
+	streams.Lock()
+	defer streams.Unlock()
+
+	is.lastID++
+	evt.n = is.lastID
+	evt.ID = streamEventID(evt.n)
+	if len(is.history) == streamHistorySize {
+		copy(is.history, is.history[1:])
+		is.history[len(is.history)-1] = evt
+	} else {
+		is.history = append(is.history, evt)
+	}
+
+	for s := range is.subs {
+		if !s.wants(evt) {
+			continue
+		}
+		select {
+		case s.ch <- evt:
+		default:
+			dvid.Infof("Dropping event stream of data %s that fell behind at event %s\n", e.Data, evt.ID)
+			s.dropped = true
+			delete(is.subs, s)
+			close(s.ch)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package datastore_test
+
+import (
+	"log"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// The datastore tests that need a data type with an HTTP API use keyvalue instances.
+var (
+	kvtype datastore.TypeService
+	testMu sync.Mutex
+)
+
+func initTestRepo() (dvid.UUID, dvid.VersionID) {
+	testMu.Lock()
+	defer testMu.Unlock()
+	if kvtype == nil {
+		var err error
+		kvtype, err = datastore.TypeServiceByName(keyvalue.TypeName)
+		if err != nil {
+			log.Fatalf("Can't get keyvalue type: %s\n", err)
+		}
+	}
+	return datastore.NewTestRepo()
+}
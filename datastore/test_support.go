@@ -20,6 +20,7 @@ const (
 type testStoreT struct {
 	sync.Mutex
 	backend *storage.Backend
+	engine  storage.TestableEngine // engine that created the test stores
 }
 
 var (
@@ -42,14 +43,16 @@ func NewTestRepo() (dvid.UUID, dvid.VersionID) {
 
 // getTestStoreConfig returns a configuration, amenable to testing, based on compiled-in engines.
 func getTestStoreConfig() (*storage.Backend, error) {
-	testableEng := storage.GetTestableEngine()
-	if testableEng == nil {
-		return nil, fmt.Errorf("Could not find a storage engine that was testable")
+	if testStore.engine == nil {
+		testStore.engine = storage.GetTestableEngine()
+		if testStore.engine == nil {
+			return nil, fmt.Errorf("Could not find a storage engine that was testable")
+		}
 	}
-	return testableEng.GetTestConfig()
+	return testStore.engine.GetTestConfig()
 }
 
-func openStore(create bool) {
+func openStore(create bool, aliases ...storage.Alias) {
 	dvid.Infof("Opening test datastore.  Create = %v\n", create)
 	if create {
 		var err error
@@ -57,6 +60,15 @@ func openStore(create bool) {
 		if err != nil {
 			log.Fatalf("Unable to get testable storage configuration: %v\n", err)
 		}
+		for _, alias := range aliases {
+			extra, err := getTestStoreConfig()
+			if err != nil {
+				log.Fatalf("Unable to get testable storage configuration: %v\n", err)
+			}
+			testStore.backend.Stores[alias] = extra.Stores["default"]
+			testStore.backend.Default = "default"
+			testStore.backend.Metadata = "default"
+		}
 	}
 	initMetadata, err := storage.Initialize(dvid.Config{}, testStore.backend)
 	if err != nil {
@@ -74,6 +86,14 @@ func OpenTest() {
 	openStore(true)
 }
 
+// OpenTestWithStores opens a test datastore with additional testable stores under the
+// given aliases, e.g., for testing migration between stores.
+func OpenTestWithStores(aliases ...storage.Alias) {
+	testStore.Lock()
+	dvid.Infof("Opening test datastore with stores %v...\n", aliases)
+	openStore(true, aliases...)
+}
+
 // CloseReopenTest forces close and then reopening of the datastore, useful for testing
 // persistence.  We only allow close/reopen when all tests not avaiting close/reopen are finished.
 func CloseReopenTest() {
@@ -85,11 +105,12 @@ func CloseReopenTest() {
 
 func CloseTest() {
 	dvid.Infof("Closing and deleting test datastore...\n")
-	testableEng := storage.GetTestableEngine()
-	if testableEng == nil {
-		log.Fatalf("Could not find a storage engine that was testable")
+	if testStore.engine == nil {
+		log.Fatalf("Could not find the storage engine that opened the test datastore")
+	}
+	for _, config := range testStore.backend.Stores {
+		testStore.engine.Delete(config)
 	}
-	config, _ := testStore.backend.StoreConfig("default")
-	testableEng.Delete(config)
+	testStore.engine = nil
 	testStore.Unlock()
 }
@@ -0,0 +1,314 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports webhooks: URLs outside the server that are POSTed a JSON summary of
+	sync events for a repo or one of its data instances.  Webhooks are fed from the same
+	notifications as internal subscribers, and deliveries are queued separately for each
+	webhook so slow or failing endpoints never stall mutations or other webhooks.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// number of deliveries that can be queued for a webhook before its new events are dropped.
+	webhookQueueSize = 1000
+
+	// number of attempts to deliver an event before giving up.
+	webhookMaxAttempts = 5
+
+	// delay before the first retry of a failed delivery.  It doubles with each retry.
+	webhookBackoff = time.Second
+
+	// timeout for each POST to a webhook URL.
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookT is the persisted form of a webhook.  If Data is the nil UUID, the webhook
+// receives events from all data instances of the repo.
+type webhookT struct {
+	URL     string
+	Data    dvid.UUID
+	Events  []string
+	Created time.Time
+}
+
+func (h webhookT) matches(e SyncEvent) bool {
+	if h.Data != dvid.NilUUID && h.Data != e.Data {
+		return false
+	}
+	for _, event := range h.Events {
+		if event == e.Event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook describes a URL that is POSTed a WebhookEvent for each of the given sync events,
+// e.g., "MERGE_END", for a data instance or, if Data is empty, any instance of the repo.
+type Webhook struct {
+	ID      string            `json:"id"`
+	URL     string            `json:"url"`
+	Data    dvid.InstanceName `json:"data,omitempty"`
+	Events  []string          `json:"events"`
+	Created time.Time         `json:"created"`
+}
+
+// WebhookEvent is the JSON summary of a sync event POSTed to a webhook.
+type WebhookEvent struct {
+	Hook  string            `json:"hook"`
+	Event string            `json:"event"`
+	Repo  dvid.UUID         `json:"repo"`
+	UUID  dvid.UUID         `json:"uuid"`
+	Data  dvid.InstanceName `json:"data"`
+	Time  time.Time         `json:"time"`
+	Delta interface{}       `json:"delta,omitempty"`
+}
+
+type webhooksByCreation []Webhook
+
+func (h webhooksByCreation) Len() int           { return len(h) }
+func (h webhooksByCreation) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h webhooksByCreation) Less(i, j int) bool { return h[i].Created.Before(h[j].Created) }
+
+// GetWebhooks returns the webhooks of the repo containing the given UUID in order of creation.
+func GetWebhooks(uuid dvid.UUID) ([]Webhook, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	r.RLock()
+	defer r.RUnlock()
+	hooks := make([]Webhook, 0, len(r.hooks))
+	for id, hook := range r.hooks {
+		hooks = append(hooks, r.describeWebhook(id, hook))
+	}
+	sort.Sort(webhooksByCreation(hooks))
+	return hooks, nil
+}
+
+// AddWebhook adds a webhook to the repo containing the given UUID and returns it with its
+// new ID.  The ID of the passed webhook is ignored.
+func AddWebhook(uuid dvid.UUID, hook Webhook) (*Webhook, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return nil, fmt.Errorf("bad webhook URL %q: %v", hook.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook URL %q must be an absolute http or https URL", hook.URL)
+	}
+	if len(hook.Events) == 0 {
+		return nil, fmt.Errorf("webhook must specify at least one event")
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	h := webhookT{URL: hook.URL, Events: hook.Events, Created: time.Now()}
+	if hook.Data != "" {
+		d, found := r.data[hook.Data]
+		if !found {
+			return nil, ErrInvalidDataName
+		}
+		h.Data = d.DataUUID()
+	}
+	id := string(dvid.NewUUID())
+	r.hooks[id] = h
+	r.updated = time.Now()
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	added := r.describeWebhook(id, h)
+	return &added, nil
+}
+
+// DeleteWebhook deletes a webhook from the repo containing the given UUID.
+func DeleteWebhook(uuid dvid.UUID, id string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	if _, found := r.hooks[id]; !found {
+		return fmt.Errorf("repo %s has no webhook %q", r.uuid, id)
+	}
+	delete(r.hooks, id)
+	r.updated = time.Now()
+	return r.save()
+}
+
+// describeWebhook returns the public form of a webhook.  The repo must be locked by the caller.
+func (r *repoT) describeWebhook(id string, h webhookT) Webhook {
+	return Webhook{
+		ID:      id,
+		URL:     h.URL,
+		Data:    r.dataName(h.Data),
+		Events:  h.Events,
+		Created: h.Created,
+	}
+}
+
+// dataName returns the name of the repo's data instance with the given data UUID or an
+// empty name if there is none.  The repo must be locked by the caller.
+func (r *repoT) dataName(dataUUID dvid.UUID) dvid.InstanceName {
+	if dataUUID == dvid.NilUUID {
+		return ""
+	}
+	for name, d := range r.data {
+		if d.DataUUID() == dataUUID {
+			return name
+		}
+	}
+	return ""
+}
+
+// notifyWebhooks queues delivery of an event to every matching webhook of the repo.
+func (r *repoT) notifyWebhooks(e SyncEvent, m SyncMessage) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.hooks) == 0 {
+		return
+	}
+	var evt *WebhookEvent
+	for id, hook := range r.hooks {
+		if !hook.matches(e) {
+			continue
+		}
+		if evt == nil {
+			uuid, err := manager.uuidFromVersion(m.Version)
+			if err != nil {
+				dvid.Errorf("Unable to get UUID of version %d for webhook: %v\n", m.Version, err)
+				return
+			}
+			evt = &WebhookEvent{
+				Event: e.Event,
+				Repo:  r.uuid,
+				UUID:  uuid,
+				Data:  r.dataName(e.Data),
+				Time:  time.Now(),
+			}
+			if summarizer, ok := m.Delta.(DeltaSummarizer); ok {
+				evt.Delta = summarizer.Summary()
+			}
+		}
+		hookEvt := *evt
+		hookEvt.Hook = id
+		payload, err := json.Marshal(hookEvt)
+		if err != nil {
+			dvid.Errorf("Unable to encode %s event for webhook %s: %v\n", e.Event, id, err)
+			continue
+		}
+		webhooks.queue(webhookDelivery{hook: id, url: hook.URL, payload: payload})
+	}
+}
+
+type webhookDelivery struct {
+	hook    string
+	url     string
+	payload []byte
+}
+
+// webhookDispatcher delivers queued events with retries.  Each webhook with pending events
+// has its own queue and goroutine, so retries of a failing endpoint only delay its own events.
+type webhookDispatcher struct {
+	mu     sync.Mutex
+	queues map[string]chan webhookDelivery
+	client *http.Client
+}
+
+var webhooks = webhookDispatcher{
+	queues: make(map[string]chan webhookDelivery),
+	client: &http.Client{Timeout: webhookTimeout},
+}
+
+// queue adds a delivery without blocking, dropping it if the webhook's queue is full.
+func (wd *webhookDispatcher) queue(delivery webhookDelivery) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	ch, found := wd.queues[delivery.hook]
+	if !found {
+		ch = make(chan webhookDelivery, webhookQueueSize)
+		wd.queues[delivery.hook] = ch
+		go wd.deliver(delivery.hook, ch)
+	}
+	select {
+	case ch <- delivery:
+	default:
+		dvid.Errorf("Queue of webhook %s is full; dropping event for %s\n", delivery.hook, delivery.url)
+	}
+}
+
+// deliver sends the queued events of a webhook in order, returning once its queue is empty.
+func (wd *webhookDispatcher) deliver(hook string, ch chan webhookDelivery) {
+	for {
+		wd.mu.Lock()
+		select {
+		case delivery := <-ch:
+			wd.mu.Unlock()
+			wd.send(delivery)
+		default:
+			delete(wd.queues, hook)
+			wd.mu.Unlock()
+			return
+		}
+	}
+}
+
+// send posts an event, retrying with exponential backoff.
+func (wd *webhookDispatcher) send(delivery webhookDelivery) {
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := wd.post(delivery)
+		if err == nil {
+			return
+		}
+		if attempt == webhookMaxAttempts {
+			dvid.Errorf("Giving up on webhook %s after %d attempts: %v\n", delivery.url, attempt, err)
+			return
+		}
+		dvid.Infof("Webhook %s failed (attempt %d), retrying in %s: %v\n", delivery.url, attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (wd *webhookDispatcher) post(delivery webhookDelivery) error {
+	resp, err := wd.client.Post(delivery.url, "application/json", bytes.NewReader(delivery.payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("received status %s", resp.Status)
+	}
+	return nil
+}
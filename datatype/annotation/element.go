@@ -1599,6 +1599,61 @@ func (d *Data) MoveElement(ctx *datastore.VersionedCtx, from, to dvid.Point3d) e
 	return nil
 }
 
+// VersionDiff lists the elements added, removed or modified between two versions.
+// Modified elements are given as they are at the later version, and moved elements are
+// listed as removed from the old position and added at the new one.
+type VersionDiff struct {
+	Added    Elements `json:"added"`
+	Removed  Elements `json:"removed"`
+	Modified Elements `json:"modified"`
+}
+
+// DiffVersions returns the elements changed going from version v1 to v2.  Only the blocks
+// of elements are compared since label and tag indices are derived from them.
+func (d *Data) DiffVersions(v1, v2 dvid.VersionID) (interface{}, error) {
+	diff := VersionDiff{Added: Elements{}, Removed: Elements{}, Modified: Elements{}}
+	begTKey := storage.MinTKey(keyBlock)
+	endTKey := storage.MaxTKey(keyBlock)
+	err := datastore.ChangedKeys(d, v1, v2, begTKey, endTKey, func(tk storage.TKey, value1, value2 []byte) error {
+		var elems1, elems2 Elements
+		if value1 != nil {
+			if err := json.Unmarshal(value1, &elems1); err != nil {
+				return err
+			}
+		}
+		if value2 != nil {
+			if err := json.Unmarshal(value2, &elems2); err != nil {
+				return err
+			}
+		}
+		old := make(map[dvid.Point3d]Element, len(elems1))
+		for _, elem := range elems1 {
+			old[elem.Pos] = elem
+		}
+		for _, elem := range elems2 {
+			oldElem, found := old[elem.Pos]
+			if !found {
+				diff.Added = append(diff.Added, elem)
+				continue
+			}
+			delete(old, elem.Pos)
+			if !reflect.DeepEqual(oldElem, elem) {
+				diff.Modified = append(diff.Modified, elem)
+			}
+		}
+		for _, elem := range elems1 {
+			if _, found := old[elem.Pos]; found {
+				diff.Removed = append(diff.Removed, elem)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
 // GetByDataUUID returns a pointer to annotation data given a data UUID.
 func GetByDataUUID(dataUUID dvid.UUID) (*Data, error) {
 	source, err := datastore.GetDataByDataUUID(dataUUID)
@@ -1830,10 +1885,19 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			timedLog.Infof("HTTP %s: synapse elements in subvolume (size %s, offset %s) (%s)", r.Method, sizeStr, offsetStr, r.URL)
 
 		case "post":
-			if err := d.StoreSynapses(ctx, r.Body); err != nil {
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			if err := d.StoreSynapses(ctx, bytes.NewReader(data)); err != nil {
 				server.BadRequest(w, r, err)
 				return
 			}
+			if _, err := datastore.RecordMutation(d, ctx.VersionID(), r, data, ""); err != nil {
+				server.BadRequest(w, r, "elements stored but mutation not journaled: %v", err)
+				return
+			}
 		default:
 			server.BadRequest(w, r, "Only GET or POST action is available on 'elements' endpoint.")
 			return
@@ -1858,6 +1922,10 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			server.BadRequest(w, r, err)
 			return
 		}
+		if _, err := datastore.RecordMutation(d, ctx.VersionID(), r, nil, ""); err != nil {
+			server.BadRequest(w, r, "element deleted but mutation not journaled: %v", err)
+			return
+		}
 		timedLog.Infof("HTTP %s: delete synaptic element at %s (%s)", r.Method, pt, r.URL)
 
 	case "move":
@@ -1884,6 +1952,10 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			server.BadRequest(w, r, err)
 			return
 		}
+		if _, err := datastore.RecordMutation(d, ctx.VersionID(), r, nil, ""); err != nil {
+			server.BadRequest(w, r, "element moved but mutation not journaled: %v", err)
+			return
+		}
 		timedLog.Infof("HTTP %s: move synaptic element from %s to %s (%s)", r.Method, fromPt, toPt, r.URL)
 
 	default:
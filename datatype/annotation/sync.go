@@ -31,6 +31,63 @@ type DeltaSetElements struct {
 	Set []ElementPos
 }
 
+// elementSummary describes an added or deleted element for webhook notifications.
+type elementSummary struct {
+	Label uint64       `json:"label"`
+	Kind  ElementType  `json:"kind"`
+	Pos   dvid.Point3d `json:"pos"`
+}
+
+func summarizeElements(elems []ElementPos) []elementSummary {
+	summary := make([]elementSummary, len(elems))
+	for i, elem := range elems {
+		summary[i] = elementSummary{elem.Label, elem.Kind, elem.Pos}
+	}
+	return summary
+}
+
+// Summary returns the added and deleted elements for webhook notifications.
+func (d DeltaModifyElements) Summary() interface{} {
+	return struct {
+		Add []elementSummary `json:"add"`
+		Del []elementSummary `json:"del"`
+	}{summarizeElements(d.Add), summarizeElements(d.Del)}
+}
+
+// elementsExtents returns the extents of the given elements' positions.
+func elementsExtents(elems ...[]ElementPos) (dvid.Extents3d, bool) {
+	var ext dvid.Extents3d
+	var found bool
+	for _, list := range elems {
+		for _, elem := range list {
+			if !found {
+				ext = dvid.Extents3d{MinPoint: elem.Pos, MaxPoint: elem.Pos}
+				found = true
+			} else {
+				ext.Extend(elem.Pos)
+			}
+		}
+	}
+	return ext, found
+}
+
+// Extents returns the extents of the added and deleted elements for event streams.
+func (d DeltaModifyElements) Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	return elementsExtents(d.Add, d.Del)
+}
+
+// Extents returns the extents of the elements now assigned to a label for event streams.
+func (d DeltaSetElements) Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	return elementsExtents(d.Set)
+}
+
+// Summary returns the elements now assigned to a label for webhook notifications.
+func (d DeltaSetElements) Summary() interface{} {
+	return struct {
+		Set []elementSummary `json:"set"`
+	}{summarizeElements(d.Set)}
+}
+
 // Annotation number change event identifiers.
 const (
 	ModifyElementsEvent = "ANNOTATION_MOD_ELEMENTS"
@@ -119,16 +176,15 @@ func (d *Data) GetSyncSubs(synced dvid.Data) (subs datastore.SyncSubs) {
 			},
 		}
 	case "labelvol":
+		// Merges and splits are applied within the labelvol transaction via SyncTxn.
 		subs = datastore.SyncSubs{
 			datastore.SyncSub{
 				Event:  datastore.SyncEvent{synced.DataUUID(), labels.MergeBlockEvent},
 				Notify: d.DataUUID(),
-				Ch:     d.syncCh,
 			},
 			datastore.SyncSub{
 				Event:  datastore.SyncEvent{synced.DataUUID(), labels.SplitLabelEvent},
 				Notify: d.DataUUID(),
-				Ch:     d.syncCh,
 			},
 		}
 	default:
@@ -173,6 +229,10 @@ func (d *Data) processEvents() {
 }
 
 func (d *Data) handleSyncMessage(ctx *datastore.VersionedCtx, msg datastore.SyncMessage, batcher storage.KeyValueBatcher) {
+	// Block changes are serialized with labelvol merges and splits applied via SyncTxn.
+	d.Lock()
+	defer d.Unlock()
+
 	d.StartUpdate()
 	defer d.StopUpdate()
 
@@ -185,34 +245,57 @@ func (d *Data) handleSyncMessage(ctx *datastore.VersionedCtx, msg datastore.Sync
 	case labels.DeleteBlock:
 		d.deleteBlock(ctx, delta, batcher)
 
-	case labels.DeltaMergeStart:
-		// ignore
-	case labels.DeltaMerge:
-		// process annotation type
-		if err := d.mergeLabels(batcher, msg.Version, delta.MergeOp); err != nil {
-			dvid.Errorf("error on merging labels for data %q: %v\n", d.DataName(), err)
-			return
-		}
+	default:
+		dvid.Criticalf("Got unexpected delta: %v\n", msg)
+	}
+}
+
+// SyncTxn implements the datastore.TxnSyncer interface, adding the changes to label
+// annotations for a labelvol merge or split to the labelvol transaction.  The annotations
+// are locked until the transaction is done.
+func (d *Data) SyncTxn(txn *storage.Transaction, msg datastore.SyncMessage) (func(committed bool), error) {
+	batcher, err := d.GetKeyValueBatcher()
+	if err != nil {
+		return nil, err
+	}
 
-	case labels.DeltaSplitStart:
-		// ignore for now
+	d.Lock()
+	d.StartUpdate()
+
+	var delta DeltaModifyElements
+	switch op := msg.Delta.(type) {
+	case labels.DeltaMerge:
+		delta, err = d.mergeLabels(txn, batcher, msg.Version, op.MergeOp)
 	case labels.DeltaSplit:
-		if delta.Split == nil {
+		if op.Split == nil {
 			// This is a coarse split.
-			if err := d.splitLabelsCoarse(batcher, msg.Version, delta); err != nil {
-				dvid.Errorf("error on splitting label for data %q: %v\n", d.DataName(), err)
-				return
-			}
+			delta, err = d.splitLabelsCoarse(txn, batcher, msg.Version, op)
 		} else {
-			if err := d.splitLabelsFine(batcher, msg.Version, delta); err != nil {
-				dvid.Errorf("error on splitting label for data %q: %v\n", d.DataName(), err)
-				return
-			}
+			delta, err = d.splitLabelsFine(txn, batcher, msg.Version, op)
 		}
-
 	default:
-		dvid.Criticalf("Got unexpected delta: %v\n", msg)
+		err = fmt.Errorf("unexpected delta for transaction: %v", msg)
 	}
+	if err != nil {
+		d.StopUpdate()
+		d.Unlock()
+		return nil, err
+	}
+
+	return func(committed bool) {
+		d.StopUpdate()
+		d.Unlock()
+		if !committed || (len(delta.Add) == 0 && len(delta.Del) == 0) {
+			return
+		}
+
+		// Notify any subscribers of label annotation changes.
+		evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
+		msg := datastore.SyncMessage{Event: ModifyElementsEvent, Version: msg.Version, Delta: delta}
+		if err := datastore.NotifySubscribers(evt, msg); err != nil {
+			dvid.Criticalf("unable to notify subscribers of event %s: %v\n", evt, err)
+		}
+	}, nil
 }
 
 // If a block of labels is ingested, adjust each label's synaptic element list.
@@ -445,34 +528,31 @@ func (d *Data) deleteBlock(ctx *datastore.VersionedCtx, block labels.DeleteBlock
 	}
 }
 
-func (d *Data) mergeLabels(batcher storage.KeyValueBatcher, v dvid.VersionID, op labels.MergeOp) error {
-	d.Lock()
-	defer d.Unlock()
-
-	d.StartUpdate()
+// mergeLabels adds the moves of annotations from merged labels to the target label
+// to the transaction.
+func (d *Data) mergeLabels(txn *storage.Transaction, batcher storage.KeyValueBatcher, v dvid.VersionID, op labels.MergeOp) (delta DeltaModifyElements, err error) {
 	ctx := datastore.NewVersionedCtx(d, v)
-	batch := batcher.NewBatch(ctx)
 
 	// Get the target label
 	targetTk := NewLabelTKey(op.Target)
 	targetElems, err := getElements(ctx, targetTk)
 	if err != nil {
-		return fmt.Errorf("get annotations for instance %q, target %d, in syncMerge: %v\n", d.DataName(), op.Target, err)
+		err = fmt.Errorf("get annotations for instance %q, target %d, in syncMerge: %v\n", d.DataName(), op.Target, err)
+		return
 	}
 
 	// Iterate through each merged label, read old elements, delete that k/v, then add it to the current target elements.
-	var delta DeltaModifyElements
 	elemsAdded := 0
 	for label := range op.Merged {
 		tk := NewLabelTKey(label)
 		elems, err := getElements(ctx, tk)
 		if err != nil {
-			return fmt.Errorf("unable to get annotation elements for instance %q, label %d in syncMerge: %v\n", d.DataName(), label, err)
+			return delta, fmt.Errorf("unable to get annotation elements for instance %q, label %d in syncMerge: %v\n", d.DataName(), label, err)
 		}
 		if elems == nil || len(elems) == 0 {
 			continue
 		}
-		batch.Delete(tk)
+		txn.Delete(batcher, ctx, tk)
 		elemsAdded += len(elems)
 		targetElems = append(targetElems, elems...)
 
@@ -485,39 +565,22 @@ func (d *Data) mergeLabels(batcher storage.KeyValueBatcher, v dvid.VersionID, op
 	if elemsAdded > 0 {
 		val, err := json.Marshal(targetElems)
 		if err != nil {
-			return fmt.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
-		}
-		batch.Put(targetTk, val)
-		if err := batch.Commit(); err != nil {
-			return fmt.Errorf("unable to commit merge for instance %q: %v\n", d.DataName(), err)
+			return delta, fmt.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
 		}
+		txn.Put(batcher, ctx, targetTk, val)
 	}
-	d.StopUpdate()
-
-	// Notify any subscribers of label annotation changes.
-	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
-	msg := datastore.SyncMessage{Event: ModifyElementsEvent, Version: ctx.VersionID(), Delta: delta}
-	if err := datastore.NotifySubscribers(evt, msg); err != nil {
-		dvid.Criticalf("unable to notify subscribers of event %s: %v\n", evt, err)
-	}
-	return nil
+	return delta, nil
 }
 
-func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.VersionID, op labels.DeltaSplit) error {
-	d.Lock()
-	defer d.Unlock()
-
-	d.StartUpdate()
-	defer d.StopUpdate()
-
+// splitLabelsCoarse adds the moves of annotations within the split blocks to the transaction.
+func (d *Data) splitLabelsCoarse(txn *storage.Transaction, batcher storage.KeyValueBatcher, v dvid.VersionID, op labels.DeltaSplit) (delta DeltaModifyElements, err error) {
 	ctx := datastore.NewVersionedCtx(d, v)
-	batch := batcher.NewBatch(ctx)
 
 	// Get the elements for the old label.
 	oldTk := NewLabelTKey(op.OldLabel)
 	oldElems, err := getElements(ctx, oldTk)
 	if err != nil {
-		return fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), op.OldLabel, err)
+		return delta, fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), op.OldLabel, err)
 	}
 
 	// Create a map to test each point.
@@ -527,7 +590,6 @@ func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.Version
 	}
 
 	// Move any elements that are within the split blocks.
-	var delta DeltaModifyElements
 	toDel := make(map[int]struct{})
 	toAdd := Elements{}
 	blockSize := d.blockSize()
@@ -543,21 +605,21 @@ func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.Version
 		}
 	}
 	if len(toDel) == 0 {
-		return nil
+		return delta, nil
 	}
 
 	// Store split elements into new label elements.
 	newTk := NewLabelTKey(op.NewLabel)
 	newElems, err := getElements(ctx, newTk)
 	if err != nil {
-		return fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), op.NewLabel, err)
+		return delta, fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), op.NewLabel, err)
 	}
 	newElems.add(toAdd)
 	val, err := json.Marshal(newElems)
 	if err != nil {
-		return fmt.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
+		return delta, fmt.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
 	}
-	batch.Put(newTk, val)
+	txn.Put(batcher, ctx, newTk, val)
 
 	// Delete any split from old label elements without removing the relationships.
 	// This filters without allocating, using fact that a slice shares the same backing array and
@@ -571,39 +633,21 @@ func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.Version
 
 	// Delete or store k/v depending on what remains.
 	if len(filtered) == 0 {
-		batch.Delete(oldTk)
+		txn.Delete(batcher, ctx, oldTk)
 	} else {
 		val, err := json.Marshal(filtered)
 		if err != nil {
-			return fmt.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
+			return delta, fmt.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
 		}
-		batch.Put(oldTk, val)
-	}
-
-	if err := batch.Commit(); err != nil {
-		return fmt.Errorf("bad commit in annotations %q after split: %v\n", d.DataName(), err)
-	}
-
-	// Notify any subscribers of label annotation changes.
-	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
-	msg := datastore.SyncMessage{Event: ModifyElementsEvent, Version: ctx.VersionID(), Delta: delta}
-	if err := datastore.NotifySubscribers(evt, msg); err != nil {
-		dvid.Criticalf("unable to notify subscribers of event %s: %v\n", evt, err)
+		txn.Put(batcher, ctx, oldTk, val)
 	}
-	return nil
+	return delta, nil
 }
 
-func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID, op labels.DeltaSplit) error {
-	d.Lock()
-	defer d.Unlock()
-
-	d.StartUpdate()
-	defer d.StopUpdate()
-
+// splitLabelsFine adds the moves of annotations within the split voxels to the transaction.
+func (d *Data) splitLabelsFine(txn *storage.Transaction, batcher storage.KeyValueBatcher, v dvid.VersionID, op labels.DeltaSplit) (delta DeltaModifyElements, err error) {
 	ctx := datastore.NewVersionedCtx(d, v)
-	batch := batcher.NewBatch(ctx)
 
-	var delta DeltaModifyElements
 	toAdd := Elements{}
 	toDel := make(map[string]struct{})
 
@@ -612,7 +656,7 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 		// Get the elements for this block.
 		blockPt, err := izyx.ToChunkPoint3d()
 		if err != nil {
-			return err
+			return delta, err
 		}
 		tk := NewBlockTKey(blockPt)
 		elems, err := getElements(ctx, tk)
@@ -651,13 +695,13 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 				}
 			}
 			if len(filtered) == 0 {
-				batch.Delete(tk)
+				txn.Delete(batcher, ctx, tk)
 			} else {
 				val, err := json.Marshal(filtered)
 				if err != nil {
 					dvid.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
 				} else {
-					batch.Put(tk, val)
+					txn.Put(batcher, ctx, tk, val)
 				}
 			}
 		}
@@ -675,20 +719,10 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 			if err != nil {
 				dvid.Errorf("couldn't serialize annotation elements in instance %q: %v\n", d.DataName(), err)
 			} else {
-				batch.Put(tk, val)
+				txn.Put(batcher, ctx, tk, val)
 			}
 		}
 	}
 
-	if err := batch.Commit(); err != nil {
-		return fmt.Errorf("bad commit in annotations %q after split: %v\n", d.DataName(), err)
-	}
-
-	// Notify any subscribers of label annotation changes.
-	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
-	msg := datastore.SyncMessage{Event: ModifyElementsEvent, Version: ctx.VersionID(), Delta: delta}
-	if err := datastore.NotifySubscribers(evt, msg); err != nil {
-		dvid.Criticalf("unable to notify subscribers of event %s: %v\n", evt, err)
-	}
-	return nil
+	return delta, nil
 }
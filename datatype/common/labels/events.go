@@ -2,6 +2,7 @@ package labels
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/janelia-flyem/dvid/datatype/imageblk"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -81,6 +82,12 @@ type DeltaMergeEnd struct {
 	MergeOp
 }
 
+// DeltaMergeAbort is the data sent during a MergeAbortEvent, when a started merge
+// could not be committed and no labels were changed.
+type DeltaMergeAbort struct {
+	MergeOp
+}
+
 // DeltaSplit describes the voxels modified during a split operation.
 // The Split field may be null if this is a coarse split only defined by block indices.
 type DeltaSplit struct {
@@ -102,6 +109,95 @@ type DeltaSplitEnd struct {
 	NewLabel uint64
 }
 
+// DeltaSplitAbort is the data sent during a SplitAbortEvent, when a started split
+// could not be committed and no labels were changed.
+type DeltaSplitAbort struct {
+	OldLabel uint64
+	NewLabel uint64
+}
+
+// Summary returns the target and merged labels for webhook notifications.
+func (d DeltaMergeEnd) Summary() interface{} {
+	merged := make([]uint64, 0, len(d.Merged))
+	for label := range d.Merged {
+		merged = append(merged, label)
+	}
+	sort.Sort(labelSlice(merged))
+	return struct {
+		Target uint64   `json:"target"`
+		Merged []uint64 `json:"merged"`
+	}{d.Target, merged}
+}
+
+// Summary returns the target and merged labels for webhook notifications.
+func (d DeltaMergeAbort) Summary() interface{} {
+	return DeltaMergeEnd{d.MergeOp}.Summary()
+}
+
+// Summary returns the original and new labels for webhook notifications.
+func (d DeltaSplitEnd) Summary() interface{} {
+	return struct {
+		OldLabel uint64 `json:"old"`
+		NewLabel uint64 `json:"new"`
+	}{d.OldLabel, d.NewLabel}
+}
+
+// Summary returns the original and new labels for webhook notifications.
+func (d DeltaSplitAbort) Summary() interface{} {
+	return DeltaSplitEnd{d.OldLabel, d.NewLabel}.Summary()
+}
+
+// Extents returns the voxel extents of the merged blocks for event streams.
+func (d DeltaMerge) Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	blocks := make([]dvid.IZYXString, 0, len(d.Blocks))
+	for izyx := range d.Blocks {
+		blocks = append(blocks, izyx)
+	}
+	return blocksExtents(blocks, blockSize)
+}
+
+// Extents returns the voxel extents of the split blocks for event streams.
+func (d DeltaSplit) Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	if d.SortedBlocks != nil {
+		return blocksExtents(d.SortedBlocks, blockSize)
+	}
+	return blocksExtents(d.Split.SortedKeys(), blockSize)
+}
+
+// Extents returns the voxel extents of the modified blocks for event streams.
+func (d DeltaSparsevol) Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	return blocksExtents(d.Mods.SortedKeys(), blockSize)
+}
+
+// blocksExtents returns the voxel extents of the given blocks, or false if there are no
+// blocks or the block size is unknown.
+func blocksExtents(blocks []dvid.IZYXString, blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	var ext dvid.Extents3d
+	if len(blocks) == 0 || blockSize[0] == 0 {
+		return ext, false
+	}
+	for i, izyx := range blocks {
+		index, err := izyx.IndexZYX()
+		if err != nil {
+			return ext, false
+		}
+		minPt, maxPt := dvid.ChunkPoint3d(index).BoundingVoxels(blockSize)
+		if i == 0 {
+			ext = dvid.Extents3d{MinPoint: minPt, MaxPoint: maxPt}
+		} else {
+			ext.Extend(minPt)
+			ext.Extend(maxPt)
+		}
+	}
+	return ext, true
+}
+
+type labelSlice []uint64
+
+func (s labelSlice) Len() int           { return len(s) }
+func (s labelSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s labelSlice) Less(i, j int) bool { return s[i] < s[j] }
+
 // DeltaSparsevol describes a change to an existing label.
 type DeltaSparsevol struct {
 	Label uint64
@@ -120,7 +216,9 @@ const (
 	MergeStartEvent     = "MERGE_START"
 	MergeBlockEvent     = "MERGE_BLOCK"
 	MergeEndEvent       = "MERGE_END"
+	MergeAbortEvent     = "MERGE_ABORT"
 	SplitStartEvent     = "SPLIT_START"
 	SplitLabelEvent     = "SPLIT_LABEL"
 	SplitEndEvent       = "SPLIT_END"
+	SplitAbortEvent     = "SPLIT_ABORT"
 )
@@ -90,6 +90,11 @@ func SplitStop(iv dvid.InstanceVersion, op DeltaSplitEnd) {
 	labelsSplitting.Decr(iv, op.OldLabel)
 }
 
+// Mutating returns true if the label is undergoing a merge or split.
+func Mutating(iv dvid.InstanceVersion, label uint64) bool {
+	return labelsMerging.IsDirty(iv, label) || labelsSplitting.IsDirty(iv, label)
+}
+
 type mergeCache struct {
 	sync.RWMutex
 	m map[dvid.InstanceVersion]*Mapping
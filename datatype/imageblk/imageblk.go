@@ -1200,7 +1200,7 @@ func (d *Data) foregroundROI(v dvid.VersionID, dest *roi.Data, background dvid.P
 		if chunk == nil || chunk.V == nil {
 			return nil
 		}
-		data, _, err := dvid.DeserializeData(chunk.V, true)
+		data, _, err := d.DeserializeData(chunk.V, true)
 		if err != nil {
 			return fmt.Errorf("Error decoding block: %v\n", err)
 		}
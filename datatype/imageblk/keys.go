@@ -7,6 +7,7 @@ package imageblk
 import (
 	"fmt"
 
+	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
 )
@@ -45,3 +46,51 @@ func DecodeTKey(tk storage.TKey) (*dvid.IndexZYX, error) {
 	}
 	return &zyx, nil
 }
+
+// ScrubKey returns the block coordinate of an image block key for scrub reports.
+func (d *Data) ScrubKey(tk storage.TKey) (string, bool) {
+	indexZYX, err := DecodeTKey(tk)
+	if err != nil {
+		return "", false
+	}
+	return indexZYX.String(), true
+}
+
+// VersionDiff lists the block coordinates of blocks added, removed or modified between
+// two versions.
+type VersionDiff struct {
+	Added    []dvid.ChunkPoint3d `json:"added"`
+	Removed  []dvid.ChunkPoint3d `json:"removed"`
+	Modified []dvid.ChunkPoint3d `json:"modified"`
+}
+
+// DiffVersions returns the coordinates of blocks changed going from version v1 to v2.
+func (d *Data) DiffVersions(v1, v2 dvid.VersionID) (interface{}, error) {
+	diff := VersionDiff{
+		Added:    []dvid.ChunkPoint3d{},
+		Removed:  []dvid.ChunkPoint3d{},
+		Modified: []dvid.ChunkPoint3d{},
+	}
+	begTKey := storage.MinTKey(keyImageBlock)
+	endTKey := storage.MaxTKey(keyImageBlock)
+	err := datastore.ChangedKeys(d, v1, v2, begTKey, endTKey, func(tk storage.TKey, value1, value2 []byte) error {
+		indexZYX, err := DecodeTKey(tk)
+		if err != nil {
+			return err
+		}
+		blockCoord := dvid.ChunkPoint3d(*indexZYX)
+		switch {
+		case value1 == nil:
+			diff.Added = append(diff.Added, blockCoord)
+		case value2 == nil:
+			diff.Removed = append(diff.Removed, blockCoord)
+		default:
+			diff.Modified = append(diff.Modified, blockCoord)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
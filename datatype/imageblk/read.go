@@ -462,7 +462,7 @@ func (d *Data) GetBlocks(v dvid.VersionID, start dvid.ChunkPoint3d, span int32)
 
 		// Spawn goroutine to transfer data
 		wg.Add(1)
-		go xferBlock(buf[i:j], c, &wg)
+		go d.xferBlock(buf[i:j], c, &wg)
 		return nil
 	})
 	if err != nil {
@@ -472,12 +472,12 @@ func (d *Data) GetBlocks(v dvid.VersionID, start dvid.ChunkPoint3d, span int32)
 	return buf, nil
 }
 
-func xferBlock(buf []byte, chunk *storage.Chunk, wg *sync.WaitGroup) {
+func (d *Data) xferBlock(buf []byte, chunk *storage.Chunk, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	kv := chunk.TKeyValue
 	uncompress := true
-	block, _, err := dvid.DeserializeData(kv.V, uncompress)
+	block, _, err := d.DeserializeData(kv.V, uncompress)
 	if err != nil {
 		dvid.Errorf("Unable to deserialize block (%v): %v", kv.K, err)
 		return
@@ -501,7 +501,7 @@ func (d *Data) loadOldBlock(v dvid.VersionID, k storage.TKey) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	data, _, err := dvid.DeserializeData(serialization, true)
+	data, _, err := d.DeserializeData(serialization, true)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to deserialize block, %s: %v", ctx, err)
 	}
@@ -540,7 +540,7 @@ func (d *Data) loadOldBlocks(v dvid.VersionID, vox *Voxels, blocks storage.TKeyV
 			if err != nil {
 				return err
 			}
-			block, _, err := dvid.DeserializeData(kv.V, true)
+			block, _, err := d.DeserializeData(kv.V, true)
 			if err != nil {
 				return fmt.Errorf("Unable to deserialize block, %s: %v", ctx, err)
 			}
@@ -628,7 +628,7 @@ func (d *Data) readChunk(chunk *storage.Chunk) {
 	if zeroOut || chunk.V == nil {
 		blockData = d.BackgroundBlock()
 	} else {
-		blockData, _, err = dvid.DeserializeData(chunk.V, true)
+		blockData, _, err = d.DeserializeData(chunk.V, true)
 		if err != nil {
 			dvid.Errorf("Unable to deserialize block in '%s': %v\n", d.DataName(), err)
 			return
@@ -23,3 +23,35 @@ type MutatedBlock struct {
 	Prev  []byte
 	Data  []byte
 }
+
+// Summary returns the block coordinate for webhook notifications.
+func (b Block) Summary() interface{} {
+	return struct {
+		Block dvid.ChunkPoint3d `json:"block"`
+	}{dvid.ChunkPoint3d(*b.Index)}
+}
+
+// Summary returns the block coordinate for webhook notifications.
+func (b MutatedBlock) Summary() interface{} {
+	return struct {
+		Block dvid.ChunkPoint3d `json:"block"`
+	}{dvid.ChunkPoint3d(*b.Index)}
+}
+
+// Extents returns the voxel extents of the block for event streams.
+func (b Block) Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	return blockExtents(b.Index, blockSize)
+}
+
+// Extents returns the voxel extents of the block for event streams.
+func (b MutatedBlock) Extents(blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	return blockExtents(b.Index, blockSize)
+}
+
+func blockExtents(index *dvid.IndexZYX, blockSize dvid.Point3d) (dvid.Extents3d, bool) {
+	if index == nil || blockSize[0] == 0 {
+		return dvid.Extents3d{}, false
+	}
+	minPt, maxPt := dvid.ChunkPoint3d(*index).BoundingVoxels(blockSize)
+	return dvid.Extents3d{MinPoint: minPt, MaxPoint: maxPt}, true
+}
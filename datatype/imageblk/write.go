@@ -347,7 +347,7 @@ func (d *Data) putChunk(chunk *storage.Chunk, putbuffer storage.RequestBuffer) {
 	if chunk.V == nil {
 		blockData = d.BackgroundBlock()
 	} else {
-		blockData, _, err = dvid.DeserializeData(chunk.V, true)
+		blockData, _, err = d.DeserializeData(chunk.V, true)
 		if err != nil {
 			dvid.Errorf("Unable to deserialize block in %q: %v\n", d.DataName(), err)
 			return
@@ -5,8 +5,11 @@
 package keyvalue
 
 import (
+	"bytes"
 	"fmt"
 
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
 )
 
@@ -44,3 +47,82 @@ func DecodeTKey(tk storage.TKey) (string, error) {
 	}
 	return string(ibytes[:sz]), nil
 }
+
+// ScrubKey returns the string key for scrub reports.
+func (d *Data) ScrubKey(tk storage.TKey) (string, bool) {
+	key, err := DecodeTKey(tk)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+// MergeKey returns the string key for three-way merge reports.
+func (d *Data) MergeKey(tk storage.TKey) string {
+	key, err := DecodeTKey(tk)
+	if err != nil {
+		return fmt.Sprintf("%v", tk)
+	}
+	return key
+}
+
+// MergeValues is called for keys changed in both branches of a three-way merge.  Values are
+// opaque, so differing values are a conflict resolved in favor of the first branch.
+func (d *Data) MergeValues(tk storage.TKey, base, a, b []byte) ([]byte, int, error) {
+	if a == nil || b == nil {
+		return a, 1, nil
+	}
+	valueA, _, err := d.DeserializeData(a, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	valueB, _, err := d.DeserializeData(b, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	if bytes.Equal(valueA, valueB) {
+		return a, 0, nil
+	}
+	return a, 1, nil
+}
+
+// VersionDiff lists the keys added, removed or modified between two versions.
+type VersionDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// DiffVersions returns the keys changed going from version v1 to v2.
+func (d *Data) DiffVersions(v1, v2 dvid.VersionID) (interface{}, error) {
+	diff := VersionDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	begTKey := storage.MinTKey(keyStandard)
+	endTKey := storage.MaxTKey(keyStandard)
+	err := datastore.ChangedKeys(d, v1, v2, begTKey, endTKey, func(tk storage.TKey, value1, value2 []byte) error {
+		key, err := DecodeTKey(tk)
+		if err != nil {
+			return err
+		}
+		switch {
+		case value1 == nil:
+			diff.Added = append(diff.Added, key)
+		case value2 == nil:
+			diff.Removed = append(diff.Removed, key)
+		default:
+			diff.Modified = append(diff.Modified, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// KeyClassName returns a name for the keyvalue key class for storage accounting.
+func (d *Data) KeyClassName(class storage.TKeyClass) string {
+	if class == keyStandard {
+		return "key"
+	}
+	return ""
+}
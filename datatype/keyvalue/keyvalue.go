@@ -282,7 +282,7 @@ func (d *Data) GetData(ctx storage.Context, keyStr string) ([]byte, bool, error)
 		return nil, false, nil
 	}
 	uncompress := true
-	value, _, err := dvid.DeserializeData(data, uncompress)
+	value, _, err := d.DeserializeData(data, uncompress)
 	if err != nil {
 		return nil, false, fmt.Errorf("Unable to deserialize data for key '%s': %v\n", keyStr, err)
 	}
@@ -486,6 +486,10 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, err)
 				return
 			}
+			if _, err := datastore.RecordMutation(d, ctx.VersionID(), r, nil, ""); err != nil {
+				server.BadRequest(w, r, "key deleted but mutation not journaled: %v", err)
+				return
+			}
 			comment = fmt.Sprintf("HTTP DELETE data with key %q of keyvalue %q (%s)\n", keyStr, d.DataName(), url)
 
 		case "post":
@@ -499,6 +503,10 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, err)
 				return
 			}
+			if _, err := datastore.RecordMutation(d, ctx.VersionID(), r, data, ""); err != nil {
+				server.BadRequest(w, r, "value stored but mutation not journaled: %v", err)
+				return
+			}
 			comment = fmt.Sprintf("HTTP POST keyvalue '%s': %d bytes (%s)\n", d.DataName(), len(data), url)
 		default:
 			server.BadRequest(w, r, "key endpoint does not support %q HTTP verb", action)
@@ -141,7 +141,7 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 	}
 	curZMutex.Unlock()
 
-	labelData, _, err := dvid.DeserializeData(chunk.V, true)
+	labelData, _, err := d.DeserializeData(chunk.V, true)
 	if err != nil {
 		dvid.Infof("Unable to deserialize block in '%s': %v\n", d.DataName(), err)
 		return
@@ -164,7 +164,7 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 		dvid.Errorf("Error getting grayscale block for index %s\n", zyx)
 		return
 	}
-	grayscaleData, _, err := dvid.DeserializeData(blockData, true)
+	grayscaleData, _, err := op.grayscale.DeserializeData(blockData, true)
 	if err != nil {
 		dvid.Errorf("Unable to deserialize block in '%s': %v\n", op.grayscale.DataName(), err)
 		return
@@ -44,3 +44,12 @@ func DecodeTKey(tk storage.TKey) (*dvid.IndexZYX, error) {
 	}
 	return &zyx, nil
 }
+
+// MergeKey returns the block coordinate of a label block key for three-way merge reports.
+func (d *Data) MergeKey(tk storage.TKey) string {
+	indexZYX, err := DecodeTKey(tk)
+	if err != nil {
+		return fmt.Sprintf("%v", tk)
+	}
+	return dvid.ChunkPoint3d(*indexZYX).String()
+}
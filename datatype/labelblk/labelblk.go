@@ -196,7 +196,7 @@ GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format
     Query-string Options:
 
     roi       	  Name of roi data instance used to mask the requested data.
-    compression   Allows retrieval or submission of 3d data in "lz4" and "gzip"
+    compression   Allows retrieval or submission of 3d data in "lz4", "gzip", and "zstd"
                     compressed format.  The 2d data will ignore this and use
                     the image-based codec.
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
@@ -241,7 +241,7 @@ GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?qu
     Query-string Options:
 
     roi           Name of roi data instance used to mask the requested data.
-    compression   Allows retrieval or submission of 3d data in "lz4","gzip", "google"
+    compression   Allows retrieval or submission of 3d data in "lz4","gzip", "zstd", "google"
 		    (neuroglancer compression format), "googlegzip" (google + gzip)
                     compressed format.  The 2d data will ignore this and use
                     the image-based codec.
@@ -273,7 +273,7 @@ POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
                     Use "true" to indicate the POST is a mutation of prior data, which allows any
                     synced data instance to cleanup prior denormalizations.  If "mutate=true", the
                     POST operations will be slower due to a required GET to retrieve past data.
-    compression   Allows retrieval or submission of 3d data in "lz4" and "gzip"
+    compression   Allows retrieval or submission of 3d data in "lz4", "gzip", and "zstd"
                     compressed format.
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
                     are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
@@ -302,7 +302,7 @@ GET  <api URL>/node/<UUID>/<data name>/pseudocolor/<dims>/<size>/<offset>[?query
     Query-string Options:
 
     roi       	  Name of roi data instance used to mask the requested data.
-    compression   Allows retrieval or submission of 3d data in "lz4" and "gzip"
+    compression   Allows retrieval or submission of 3d data in "lz4", "gzip", and "zstd"
                     compressed format.
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
                     are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
@@ -387,7 +387,7 @@ GET  <api URL>/node/<UUID>/<data name>/blocks/<size>/<offset>[?queryopts]
 
     Query-string Options:
 
-    compression   Allows retrieval of block data in "lz4" (default) or "uncompressed".
+    compression   Allows retrieval of block data in "lz4" (default), "zstd", or "uncompressed".
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
                     are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
                     status code is returned.
@@ -778,12 +778,9 @@ func (d *Data) addLabelZ(geom dvid.Geometry, data32 []uint8, stride int32) ([]by
 	return data64, nil
 }
 
-func sendBlockLZ4(w http.ResponseWriter, x, y, z int32, v []byte, compression string) error {
-	// Check internal format and see if it's valid with compression choice.
-	format, checksum := dvid.DecodeSerializationFormat(dvid.SerializationFormat(v[0]))
-	if (compression == "lz4" || compression == "") && format != dvid.LZ4 {
-		return fmt.Errorf("Expected internal block data to be LZ4, was %s instead.", format)
-	}
+func (d *Data) sendBlockLZ4(w http.ResponseWriter, x, y, z int32, v []byte, compression string) error {
+	// Check internal format to see if stored data can be sent as is.
+	format, _ := dvid.DecodeSerializationFormat(dvid.SerializationFormat(v[0]))
 
 	// Send block coordinate and size of data.
 	if err := binary.Write(w, binary.LittleEndian, x); err != nil {
@@ -796,21 +793,47 @@ func sendBlockLZ4(w http.ResponseWriter, x, y, z int32, v []byte, compression st
 		return err
 	}
 
-	start := 5
-	if checksum == dvid.CRC32 {
-		start += 4
-	}
-
 	// Do any adjustment of sent data based on compression request
 	var data []byte
-	if compression == "uncompressed" {
+	switch compression {
+	case "uncompressed":
 		var err error
-		data, _, err = dvid.DeserializeData(v, true)
+		data, _, err = d.DeserializeData(v, true)
 		if err != nil {
 			return err
 		}
-	} else {
-		data = v[start:]
+	case "zstd":
+		uncompressed, _, err := d.DeserializeData(v, true)
+		if err != nil {
+			return err
+		}
+		if data, err = dvid.ZstdCompress(uncompressed); err != nil {
+			return err
+		}
+	default:
+		if format == dvid.LZ4 {
+			// Verify any checksum but send the stored LZ4 data without its uncompressed length.
+			cdata, _, err := d.DeserializeData(v, false)
+			if err != nil {
+				return err
+			}
+			if len(cdata) < 4 {
+				return fmt.Errorf("stored LZ4 block data has only %d bytes", len(cdata))
+			}
+			data = cdata[4:]
+		} else {
+			// Blocks stored with other compression, e.g., zstd, are recompressed as LZ4.
+			uncompressed, _, err := d.DeserializeData(v, true)
+			if err != nil {
+				return err
+			}
+			data = make([]byte, lz4.CompressBound(uncompressed))
+			outSize, err := lz4.Compress(uncompressed, data)
+			if err != nil {
+				return err
+			}
+			data = data[:outSize]
+		}
 	}
 	n := len(data)
 	if err := binary.Write(w, binary.LittleEndian, int32(n)); err != nil {
@@ -831,7 +854,7 @@ func sendBlockLZ4(w http.ResponseWriter, x, y, z int32, v []byte, compression st
 func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, subvol *dvid.Subvolume, compression string) error {
 	w.Header().Set("Content-type", "application/octet-stream")
 
-	if compression != "uncompressed" && compression != "lz4" && compression != "" {
+	if compression != "uncompressed" && compression != "lz4" && compression != "zstd" && compression != "" {
 		return fmt.Errorf("don't understand 'compression' query string value: %s", compression)
 	}
 
@@ -857,7 +880,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, su
 			return err
 		}
 		if len(value) > 0 {
-			return sendBlockLZ4(w, blockoffset.Value(0), blockoffset.Value(1), blockoffset.Value(2), value, compression)
+			return d.sendBlockLZ4(w, blockoffset.Value(0), blockoffset.Value(1), blockoffset.Value(2), value, compression)
 		}
 		return nil
 	}
@@ -903,7 +926,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, su
 				if z != sz || y != sy || x < sx || x >= sx+int32(blocksize.Value(0)) {
 					return nil
 				}
-				if err := sendBlockLZ4(w, x, y, z, kv.V, compression); err != nil {
+				if err := d.sendBlockLZ4(w, x, y, z, kv.V, compression); err != nil {
 					return err
 				}
 				return nil
@@ -965,7 +988,7 @@ func (d *Data) DeleteBlocks(ctx *datastore.VersionedCtx, start dvid.ChunkPoint3d
 		batch.Delete(kv.K)
 
 		// Send data to delete associated labelvol for labels in this block
-		block, _, err := dvid.DeserializeData(kv.V, uncompress)
+		block, _, err := d.DeserializeData(kv.V, uncompress)
 		if err != nil {
 			return fmt.Errorf("Unable to deserialize block, %s (%v): %v", ctx, kv.K, err)
 		}
@@ -1003,6 +1026,50 @@ func RavelerSuperpixelBytes(slice, superpixel32 uint32) []byte {
 	return b
 }
 
+// MergeValues merges label blocks changed in both branches of a three-way merge at the voxel
+// level.  Voxels changed differently in both branches are conflicts and keep the label of the
+// first branch.  Since synced label volumes would not be updated for merged blocks, three-way
+// merges are refused while the instance has syncs.
+func (d *Data) MergeValues(tk storage.TKey, base, a, b []byte) ([]byte, int, error) {
+	blockBytes := int(d.BlockSize().Prod() * 8)
+	var blocks [3][]byte
+	for i, serialization := range [][]byte{base, a, b} {
+		if serialization == nil {
+			blocks[i] = make([]byte, blockBytes)
+			continue
+		}
+		block, _, err := d.DeserializeData(serialization, true)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to deserialize block %v in %q: %v", tk, d.DataName(), err)
+		}
+		if len(block) != blockBytes {
+			return nil, 0, fmt.Errorf("expected %d bytes in block %v of %q, got %d", blockBytes, tk, d.DataName(), len(block))
+		}
+		blocks[i] = block
+	}
+	baseBlock, blockA, blockB := blocks[0], blocks[1], blocks[2]
+
+	var conflicts int
+	merged := make([]byte, blockBytes)
+	for i := 0; i < blockBytes; i += 8 {
+		labelBase := binary.LittleEndian.Uint64(baseBlock[i : i+8])
+		labelA := binary.LittleEndian.Uint64(blockA[i : i+8])
+		labelB := binary.LittleEndian.Uint64(blockB[i : i+8])
+		label := labelA
+		if labelA == labelBase {
+			label = labelB
+		} else if labelB != labelBase && labelB != labelA {
+			conflicts++
+		}
+		binary.LittleEndian.PutUint64(merged[i:i+8], label)
+	}
+	serialization, err := dvid.SerializeData(merged, d.Compression(), d.Checksum())
+	if err != nil {
+		return nil, 0, err
+	}
+	return serialization, conflicts, nil
+}
+
 // --- datastore.DataService interface ---------
 
 // PushData pushes labelblk data to a remote DVID.
@@ -1274,6 +1341,14 @@ func sendBinaryData(compression string, data []byte, subvol *dvid.Subvolume, w h
 		if err = gw.Close(); err != nil {
 			return err
 		}
+	case "zstd":
+		compressed, err := dvid.ZstdCompress(data)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(compressed); err != nil {
+			return err
+		}
 	case "google", "googlegzip": // see neuroglancer for details of compressed segmentation format
 		datagoogle, err := compressGoogle(data, subvol)
 		if err != nil {
@@ -1344,6 +1419,16 @@ func getBinaryData(compression string, in io.ReadCloser, estsize int64) ([]byte,
 			return nil, err
 		}
 		tlog.Debugf("read and uncompress 3d gzip POST")
+	case "zstd":
+		tlog := dvid.NewTimeLog()
+		data, err = ioutil.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = dvid.ZstdDecompress(data); err != nil {
+			return nil, err
+		}
+		tlog.Debugf("read and uncompress 3d zstd POST")
 	default:
 		return nil, fmt.Errorf("unknown compression type %q", compression)
 	}
@@ -1720,8 +1805,14 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 					server.BadRequest(w, r, "cannot store labels in non-block aligned geometry %s -> %s", subvol.StartPoint(), subvol.EndPoint())
 					return
 				}
+				// Keep the posted bytes, which may be compressed, to hash for the mutation journal.
+				posted, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					server.BadRequest(w, r, err)
+					return
+				}
 				estsize := subvol.NumVoxels() * 8
-				data, err := getBinaryData(compression, r.Body, estsize)
+				data, err := getBinaryData(compression, ioutil.NopCloser(bytes.NewReader(posted)), estsize)
 				if err != nil {
 					server.BadRequest(w, r, err)
 					return
@@ -1742,6 +1833,10 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 						return
 					}
 				}
+				if _, err := datastore.RecordMutationRef(d, ctx.VersionID(), r, posted, ""); err != nil {
+					server.BadRequest(w, r, "labels stored but mutation not journaled: %v", err)
+					return
+				}
 			}
 			timedLog.Infof("HTTP %s: %s (%s)", r.Method, subvol, r.URL)
 		default:
@@ -1773,7 +1868,7 @@ func (d *Data) GetLabelBlock(v dvid.VersionID, blockCoord dvid.ChunkPoint3d) ([]
 	if serialization == nil {
 		return []byte{}, nil
 	}
-	labelData, _, err := dvid.DeserializeData(serialization, true)
+	labelData, _, err := d.DeserializeData(serialization, true)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to deserialize block %s in '%s': %v\n", blockCoord, d.DataName(), err)
 	}
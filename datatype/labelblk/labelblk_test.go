@@ -198,6 +198,66 @@ func TestLabelblkRepoPersistence(t *testing.T) {
 	}
 }
 
+func TestLabelblkMergeValues(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	labels := newDataInstance(uuid, t, "mergelabels")
+
+	numVoxels := int(labels.BlockSize().Prod())
+	serialize := func(block []uint64) []byte {
+		buf := make([]byte, numVoxels*8)
+		for i, label := range block {
+			binary.LittleEndian.PutUint64(buf[i*8:i*8+8], label)
+		}
+		serialization, err := dvid.SerializeData(buf, labels.Compression(), labels.Checksum())
+		if err != nil {
+			t.Fatalf("Unable to serialize block: %v\n", err)
+		}
+		return serialization
+	}
+	base := make([]uint64, numVoxels)
+	a := make([]uint64, numVoxels)
+	b := make([]uint64, numVoxels)
+	for i := range base {
+		base[i], a[i], b[i] = 1, 1, 1
+	}
+	a[0], a[1], a[2] = 2, 2, 2 // voxel 1 changed identically, voxel 2 conflicts
+	b[1], b[2], b[3] = 2, 3, 3
+
+	tk := NewTKey(&dvid.IndexZYX{1, 2, 3})
+	if key := labels.MergeKey(tk); key != "(1,2,3)" {
+		t.Errorf("Expected merge key (1,2,3), got %s\n", key)
+	}
+	merged, conflicts, err := labels.MergeValues(tk, serialize(base), serialize(a), serialize(b))
+	if err != nil {
+		t.Fatalf("Error merging blocks: %v\n", err)
+	}
+	if conflicts != 1 {
+		t.Errorf("Expected 1 conflicting voxel, got %d\n", conflicts)
+	}
+	block, _, err := labels.DeserializeData(merged, true)
+	if err != nil {
+		t.Fatalf("Unable to deserialize merged block: %v\n", err)
+	}
+	expected := []uint64{2, 2, 2, 3, 1}
+	for i, label := range expected {
+		if got := binary.LittleEndian.Uint64(block[i*8 : i*8+8]); got != label {
+			t.Errorf("Expected label %d at voxel %d of merged block, got %d\n", label, i, got)
+		}
+	}
+
+	// A block absent in the base is treated as all zeros, so voxels differing in the
+	// new blocks are conflicts.
+	if _, conflicts, err = labels.MergeValues(tk, nil, serialize(a), serialize(b)); err != nil {
+		t.Fatalf("Error merging blocks: %v\n", err)
+	}
+	if conflicts != 3 {
+		t.Errorf("Expected 3 conflicting voxels for new blocks, got %d\n", conflicts)
+	}
+}
+
 type tuple [4]int32
 
 var labelsROI = []tuple{
@@ -623,6 +683,35 @@ type labelResp struct {
 	Label uint64
 }
 
+func TestLabelblkBlocksStoredCompression(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid := dvid.UUID(server.NewTestRepo(t))
+	compressions := []string{"gzip"}
+	if dvid.ZstdAvailable {
+		compressions = append(compressions, "zstd")
+	}
+	for _, compression := range compressions {
+		// Blocks not stored as LZ4 are still returned as LZ4 by default.
+		name := compression + "labels"
+		config := dvid.NewConfig()
+		config.Set("Compression", compression)
+		server.CreateTestInstance(t, uuid, "labelblk", name, config)
+
+		vol := labelVol{
+			startLabel: 2,
+			size:       dvid.Point3d{5, 5, 5}, // in blocks
+			blockSize:  dvid.Point3d{32, 32, 32},
+			offset:     dvid.Point3d{32, 64, 96},
+			name:       name,
+		}
+		vol.postLabelVolume(t, uuid, "", "", 0)
+		vol.testBlocks(t, uuid, "", "")
+		vol.testBlocks(t, uuid, "uncompressed", "")
+	}
+}
+
 func TestLabels(t *testing.T) {
 	datastore.OpenTest()
 	defer datastore.CloseTest()
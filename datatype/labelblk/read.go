@@ -189,7 +189,7 @@ func (d *Data) GetBlocks(v dvid.VersionID, start dvid.ChunkPoint3d, span int) ([
 	// Write the block indices in XYZ little-endian format + the size of each block
 	uncompress := true
 	for _, kv := range keyvalues {
-		block, _, err := dvid.DeserializeData(kv.V, uncompress)
+		block, _, err := d.DeserializeData(kv.V, uncompress)
 		if err != nil {
 			return nil, fmt.Errorf("Unable to deserialize block, %s (%v): %v", ctx, kv.K, err)
 		}
@@ -248,7 +248,7 @@ func (d *Data) loadOldBlocks(v dvid.VersionID, vox *Labels, blocks storage.TKeyV
 			if err != nil {
 				return err
 			}
-			block, _, err := dvid.DeserializeData(kv.V, true)
+			block, _, err := d.DeserializeData(kv.V, true)
 			if err != nil {
 				return fmt.Errorf("Unable to deserialize block, %s: %v", ctx, err)
 			}
@@ -332,7 +332,7 @@ func (d *Data) readChunk(chunk *storage.Chunk) {
 	if zeroOut || chunk.V == nil {
 		blockData = d.BackgroundBlock()
 	} else {
-		blockData, _, err = dvid.DeserializeData(chunk.V, true)
+		blockData, _, err = d.DeserializeData(chunk.V, true)
 		if err != nil {
 			dvid.Errorf("Unable to deserialize block in '%s': %v\n", d.DataName(), err)
 			return
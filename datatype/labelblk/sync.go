@@ -148,7 +148,7 @@ func (d *Data) GetSyncSubs(synced dvid.Data) datastore.SyncSubs {
 		//         of just handling split/merge ops, we need to get those events.
 		// evts = []string{labels.IngestBlockEvent, labels.MutateBlockEvent, labels.DeleteBlockEvent}
 	case "labelvol":
-		evts = []string{labels.MergeStartEvent, labels.MergeBlockEvent, labels.SplitStartEvent, labels.SplitLabelEvent}
+		evts = []string{labels.MergeStartEvent, labels.MergeBlockEvent, labels.MergeAbortEvent, labels.SplitStartEvent, labels.SplitLabelEvent}
 	default:
 		dvid.Errorf("Unable to sync %s with %s since datatype %q is not supported.", d.DataName(), synced.DataName(), synced.TypeName())
 		return nil
@@ -212,7 +212,7 @@ func (d *Data) downsizeCommit(v dvid.VersionID) {
 				continue
 			}
 			uncompress := true
-			deserialized, _, err := dvid.DeserializeData(serialization, uncompress)
+			deserialized, _, err := d.DeserializeData(serialization, uncompress)
 			if err != nil {
 				dvid.Criticalf("Unable to deserialize data for %q, block %s: %v", d.DataName(), block, err)
 				continue
@@ -396,6 +396,11 @@ func (d *Data) processEvents() {
 			case labels.DeltaMerge:
 				d.processMerge(msg.Version, delta)
 
+			case labels.DeltaMergeAbort:
+				// The merge was never committed, so just remove it from the cache.
+				iv := dvid.InstanceVersion{d.DataUUID(), msg.Version}
+				labels.MergeStop(iv, delta.MergeOp)
+
 			case labels.DeltaSplit:
 				d.processSplit(msg.Version, delta)
 
@@ -560,7 +565,7 @@ func (d *Data) mergeBlock(ctx *datastore.VersionedCtx, op mergeOp) {
 		return
 	}
 
-	blockData, _, err := dvid.DeserializeData(data, true)
+	blockData, _, err := d.DeserializeData(data, true)
 	if err != nil {
 		dvid.Criticalf("unable to deserialize label block in '%s': %v\n", d.DataName(), err)
 		return
@@ -612,7 +617,7 @@ func (d *Data) splitBlock(ctx *datastore.VersionedCtx, op splitOp) {
 		dvid.Errorf("nil label block where split was done, coord %v\n", []byte(op.block))
 		return
 	}
-	blockData, _, err := dvid.DeserializeData(data, true)
+	blockData, _, err := d.DeserializeData(data, true)
 	if err != nil {
 		dvid.Criticalf("unable to deserialize label block in '%s' key %v: %v\n", d.DataName(), []byte(op.block), err)
 		return
@@ -1123,7 +1123,7 @@ func (d *Data) handlePropertyTransaction(ctx *datastore.VersionedCtx, db storage
 			var data_serialized []byte
 			if (err == nil) && len(dataout) > 0 {
 				uncompress := true
-				data_serialized, _, err = dvid.DeserializeData(dataout, uncompress)
+				data_serialized, _, err = d.DeserializeData(dataout, uncompress)
 				if err != nil {
 					return fmt.Errorf("Unable to deserialize data for property '%s': %v\n", propertyname, err)
 				}
@@ -1205,7 +1205,7 @@ func (d *Data) handleProperty(ctx *datastore.VersionedCtx, db storage.GraphDB, w
 			return fmt.Errorf("Failed to get property %s: %v\n", propertyname, err)
 		}
 		uncompress := true
-		value, _, e := dvid.DeserializeData(data, uncompress)
+		value, _, e := d.DeserializeData(data, uncompress)
 		if e != nil {
 			err = fmt.Errorf("Unable to deserialize data for property '%s': %v\n", propertyname, e.Error())
 			return err
@@ -7,6 +7,7 @@ package labelvol
 import (
 	"encoding/binary"
 
+	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
 )
@@ -23,6 +24,10 @@ const (
 	keyLabelMax = 228
 
 	keyRepoLabelMax = 229
+
+	// keyUndo have keys of form 'mutation id' and hold the sparse volumes needed to
+	// undo a merge or split.
+	keyUndo = 230
 )
 
 // NewTKey returns a TKey for storing a "label + spatial index", where
@@ -48,7 +53,103 @@ func DecodeTKey(tk storage.TKey) (label uint64, block dvid.IZYXString, err error
 	return
 }
 
+// undoTKey returns a TKey for the undo record of a mutation.
+func undoTKey(mutID uint64) storage.TKey {
+	ibytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ibytes, mutID)
+	return storage.NewTKey(keyUndo, ibytes)
+}
+
 var (
 	maxLabelTKey     = storage.NewTKey(keyLabelMax, nil)
 	maxRepoLabelTKey = storage.NewTKey(keyRepoLabelMax, nil)
 )
+
+// KeyClassName returns a name for each labelvol key class for storage accounting.
+func (d *Data) KeyClassName(class storage.TKeyClass) string {
+	switch class {
+	case keyLabelBlockRLE:
+		return "sparsevol"
+	case keyLabelMax:
+		return "label max"
+	case keyRepoLabelMax:
+		return "repo label max"
+	case keyUndo:
+		return "undo"
+	default:
+		return ""
+	}
+}
+
+// VersionDiff lists the labels added, removed or modified between two versions.
+type VersionDiff struct {
+	Added    []uint64 `json:"added"`
+	Removed  []uint64 `json:"removed"`
+	Modified []uint64 `json:"modified"`
+}
+
+// labelChange notes whether the changed blocks of a label have RLEs at each version.
+type labelChange struct {
+	label      uint64
+	has1, has2 bool
+}
+
+// DiffVersions returns the labels with sparse volumes changed going from version v1 to v2.
+// A label is added or removed if it has no blocks at v1 or v2, respectively.
+func (d *Data) DiffVersions(v1, v2 dvid.VersionID) (interface{}, error) {
+	var changes []labelChange
+	begTKey := storage.MinTKey(keyLabelBlockRLE)
+	endTKey := storage.MaxTKey(keyLabelBlockRLE)
+	err := datastore.ChangedKeys(d, v1, v2, begTKey, endTKey, func(tk storage.TKey, value1, value2 []byte) error {
+		label, _, err := DecodeTKey(tk)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 || changes[len(changes)-1].label != label {
+			changes = append(changes, labelChange{label: label})
+		}
+		change := &changes[len(changes)-1]
+		change.has1 = change.has1 || value1 != nil
+		change.has2 = change.has2 || value2 != nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Unchanged blocks of a label are only checked if its changed blocks don't show it exists.
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	hasBlocks := func(v dvid.VersionID, label uint64) (bool, error) {
+		ctx := datastore.NewVersionedCtx(d, v)
+		begTKey := NewTKey(label, dvid.MinIndexZYX.ToIZYXString())
+		endTKey := NewTKey(label, dvid.MaxIndexZYX.ToIZYXString())
+		keys, err := store.KeysInRange(ctx, begTKey, endTKey)
+		return len(keys) != 0, err
+	}
+	diff := VersionDiff{Added: []uint64{}, Removed: []uint64{}, Modified: []uint64{}}
+	for _, change := range changes {
+		exists1, exists2 := change.has1, change.has2
+		if !exists1 {
+			if exists1, err = hasBlocks(v1, change.label); err != nil {
+				return nil, err
+			}
+		}
+		if !exists2 {
+			if exists2, err = hasBlocks(v2, change.label); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case !exists1:
+			diff.Added = append(diff.Added, change.label)
+		case !exists2:
+			diff.Removed = append(diff.Removed, change.label)
+		default:
+			diff.Modified = append(diff.Modified, change.label)
+		}
+	}
+	return diff, nil
+}
@@ -142,7 +142,7 @@ GET  <api URL>/node/<UUID>/<data name>/sparsevol/<label>?<options>
     exact   "false" if RLEs can extend a bit outside voxel bounds within border blocks.
             This will give slightly faster responses. 
 
-    compression   Allows retrieval of data in "lz4" and "gzip"
+    compression   Allows retrieval of data in "lz4", "gzip", and "zstd"
                   compressed format.
 
 
@@ -300,6 +300,21 @@ POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X]
 	        int32   Length of run
 
 	The Notes for "split" endpoint above are applicable to this "split-coarse" endpoint.
+
+POST <api URL>/node/<UUID>/<data name>/undo/<mutation id>
+POST <api URL>/node/<UUID>/<data name>/redo/<mutation id>
+
+	Undoes a merge, split or split-coarse given its mutation id from the instance's mutation
+	journal, or redoes an undone one.  Undoing a merge splits each merged label back out
+	with its original voxels and undoing a split merges the split label back.  Synced data
+	like labelblk, labelsz and annotations are updated through the usual merge and split
+	events.  Returns the following JSON:
+
+		{ "undone": <mutation id> }   or   { "redone": <mutation id> }
+
+	Operations can't be undone if later mutations changed their labels, e.g., the target
+	of an undone merge no longer has all voxels of the merged labels, a merged label has been
+	reused, or a split label has been modified.
 `
 
 var (
@@ -500,6 +515,9 @@ type Data struct {
 	// channels for mutations and downres caching.
 	syncCh   chan datastore.SyncMessage
 	syncDone chan *sync.WaitGroup
+
+	// labels held by undos and redos
+	holds labelHolds
 }
 
 // RemapVersions modifies internal data instance properties that rely
@@ -952,6 +970,16 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 					server.BadRequest(w, r, err)
 					return
 				}
+			case "zstd":
+				compressed, err := dvid.ZstdCompress(data)
+				if err != nil {
+					server.BadRequest(w, r, err)
+					return
+				}
+				if _, err = w.Write(compressed); err != nil {
+					server.BadRequest(w, r, err)
+					return
+				}
 			default:
 				server.BadRequest(w, r, "unknown compression type %q", compression)
 				return
@@ -1119,13 +1147,28 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, "Bad parameter for 'splitlabel' query string (%q).  Must be uint64.\n", splitStr)
 			}
 		}
-		toLabel, err := d.SplitLabels(ctx.VersionID(), fromLabel, splitLabel, r.Body)
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		mutID, err := datastore.NewMutationID(d)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		toLabel, err := d.splitLabels(ctx.VersionID(), fromLabel, splitLabel, ioutil.NopCloser(bytes.NewReader(data)), mutID)
 		if err != nil {
 			server.BadRequest(w, r, fmt.Sprintf("split: %v", err))
 			return
 		}
+		result := fmt.Sprintf("{%q: %d}", "label", toLabel)
+		if err := datastore.RecordMutationID(d, ctx.VersionID(), mutID, r, data, result); err != nil {
+			server.BadRequest(w, r, "label %d split off but mutation not journaled: %v", toLabel, err)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+		fmt.Fprint(w, result)
 		timedLog.Infof("HTTP split request (%s)", r.URL)
 
 	case "split-coarse":
@@ -1156,13 +1199,28 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, "Bad parameter for 'splitlabel' query string (%q).  Must be uint64.\n", splitStr)
 			}
 		}
-		toLabel, err := d.SplitCoarseLabels(ctx.VersionID(), fromLabel, splitLabel, r.Body)
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		mutID, err := datastore.NewMutationID(d)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		toLabel, err := d.splitCoarseLabels(ctx.VersionID(), fromLabel, splitLabel, ioutil.NopCloser(bytes.NewReader(data)), mutID)
 		if err != nil {
 			server.BadRequest(w, r, fmt.Sprintf("split-coarse: %v", err))
 			return
 		}
+		result := fmt.Sprintf("{%q: %d}", "label", toLabel)
+		if err := datastore.RecordMutationID(d, ctx.VersionID(), mutID, r, data, result); err != nil {
+			server.BadRequest(w, r, "label %d split off but mutation not journaled: %v", toLabel, err)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+		fmt.Fprint(w, result)
 		timedLog.Infof("HTTP split-coarse request (%s)", r.URL)
 
 	case "merge":
@@ -1186,12 +1244,58 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			server.BadRequest(w, r, err)
 			return
 		}
-		if err := d.MergeLabels(ctx.VersionID(), mergeOp); err != nil {
+		mutID, err := datastore.NewMutationID(d)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if err := d.mergeLabels(ctx.VersionID(), mergeOp, mutID); err != nil {
 			server.BadRequest(w, r, fmt.Sprintf("Error on merge: %v", err))
 			return
 		}
+		if err := datastore.RecordMutationID(d, ctx.VersionID(), mutID, r, data, ""); err != nil {
+			server.BadRequest(w, r, "labels merged but mutation not journaled: %v", err)
+			return
+		}
 		timedLog.Infof("HTTP merge request (%s)", r.URL)
 
+	case "undo", "redo":
+		// POST <api URL>/node/<UUID>/<data name>/undo/<mutation id>
+		if action != "post" {
+			server.BadRequest(w, r, "%s requests must be POST actions.", parts[3])
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "ERROR: DVID requires mutation id to follow %q command", parts[3])
+			return
+		}
+		mutID, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		redo := (parts[3] == "redo")
+		undoID, err := datastore.NewMutationID(d)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if err := d.UndoMutation(ctx.VersionID(), mutID, undoID, redo); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("%s: %v", parts[3], err))
+			return
+		}
+		if err := datastore.RecordMutationID(d, ctx.VersionID(), undoID, r, nil, ""); err != nil {
+			server.BadRequest(w, r, "%s of mutation %d applied but not journaled: %v", parts[3], mutID, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if redo {
+			fmt.Fprintf(w, "{%q: %d}", "redone", mutID)
+		} else {
+			fmt.Fprintf(w, "{%q: %d}", "undone", mutID)
+		}
+		timedLog.Infof("HTTP %s request (%s)", parts[3], r.URL)
+
 	default:
 		server.BadAPIRequest(w, r, d)
 	}
@@ -1257,15 +1361,18 @@ func (d *Data) NewLabel(v dvid.VersionID) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buf, d.MaxRepoLabel)
-	ctx := datastore.NewVersionedCtx(d, v)
-	if err := store.Put(ctx, maxLabelTKey, buf); err != nil {
-		return 0, err
+	batcher, ok := store.(storage.KeyValueBatcher)
+	if !ok {
+		return 0, fmt.Errorf("Data type labelvol requires batch-enabled store, which %q is not\n", store)
 	}
 
-	ctx2 := storage.NewDataContext(d, 0)
-	if err := store.Put(ctx2, maxRepoLabelTKey, buf); err != nil {
+	// Store the version and repo max labels together.
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, d.MaxRepoLabel)
+	txn := storage.NewTransaction()
+	txn.Put(batcher, datastore.NewVersionedCtx(d, v), maxLabelTKey, buf)
+	txn.Put(batcher, storage.NewDataContext(d, 0), maxRepoLabelTKey, buf)
+	if err := txn.Commit(); err != nil {
 		return 0, err
 	}
 
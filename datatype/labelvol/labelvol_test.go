@@ -5,17 +5,22 @@
 package labelvol
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -624,6 +629,460 @@ func TestSplitLabel(t *testing.T) {
 	body4.checkSparseVol(t, encoding, dvid.Bounds{})
 }
 
+// splitEncoding returns the sparse volume encoding of a body for a split request.
+func splitEncoding(t *testing.T, body testBody) *bytes.Buffer {
+	numspans := len(body.voxelSpans)
+	rles := make(dvid.RLEs, numspans, numspans)
+	for i, span := range body.voxelSpans {
+		start := dvid.Point3d{span[2], span[1], span[0]}
+		length := span[3] - span[2] + 1
+		rles[i] = dvid.NewRLE(start, length)
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3))         // # of dimensions
+	binary.Write(buf, binary.LittleEndian, byte(0))          // dimension of run (X = 0)
+	buf.WriteByte(byte(0))                                   // reserved for later
+	binary.Write(buf, binary.LittleEndian, uint32(0))        // Placeholder for # voxels
+	binary.Write(buf, binary.LittleEndian, uint32(numspans)) // Placeholder for # spans
+	rleBytes, err := rles.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unable to serialize RLEs: %v\n", err)
+	}
+	buf.Write(rleBytes)
+	return buf
+}
+
+// lastMutation returns the ID of the last journaled mutation of a data instance.
+func lastMutation(t *testing.T, uuid dvid.UUID, name dvid.InstanceName) uint64 {
+	reqStr := fmt.Sprintf("%snode/%s/%s/mutations", server.WebAPIPath, uuid, name)
+	r := server.TestHTTP(t, "GET", reqStr, nil)
+	var entries []datastore.MutationEntry
+	if err := json.Unmarshal(r, &entries); err != nil {
+		t.Fatalf("Unable to parse mutations: %v\n", err)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("Expected journaled mutations for %q, got none\n", name)
+	}
+	return entries[len(entries)-1].ID
+}
+
+func TestUndoMergeSplit(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelblk", "labels", config)
+	server.CreateTestInstance(t, uuid, "labelvol", "bodies", config)
+	server.CreateTestSync(t, uuid, "labels", "bodies")
+	server.CreateTestSync(t, uuid, "bodies", "labels")
+
+	original := createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+		t.Fatalf("Error blocking on sync of labels -> bodies: %v\n", err)
+	}
+
+	// Merge 3 into 2 and then undo it.
+	testMerge := mergeJSON(`[2, 3]`)
+	testMerge.send(t, uuid, "bodies")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	mergeID := lastMutation(t, uuid, "bodies")
+
+	// Non-merge or split mutations can't be undone.
+	reqStr := fmt.Sprintf("%snode/%s/bodies/undo/%d", server.WebAPIPath, uuid, mergeID+100)
+	server.TestBadHTTP(t, "POST", reqStr, nil)
+
+	reqStr = fmt.Sprintf("%snode/%s/bodies/undo/%d", server.WebAPIPath, uuid, mergeID)
+	server.TestHTTP(t, "POST", reqStr, nil)
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	retrieved := newTestVolume(128, 128, 128)
+	retrieved.get(t, uuid, "labels")
+	if !retrieved.equals(original) {
+		t.Errorf("Label volume after undo of merge not equal to original volume\n")
+	}
+	reqStr = fmt.Sprintf("%snode/%s/bodies/sparsevol/3", server.WebAPIPath, uuid)
+	encoding := server.TestHTTP(t, "GET", reqStr, nil)
+	body3.checkSparseVol(t, encoding, dvid.Bounds{})
+	reqStr = fmt.Sprintf("%snode/%s/bodies/sparsevol/2", server.WebAPIPath, uuid)
+	encoding = server.TestHTTP(t, "GET", reqStr, nil)
+	body2.checkSparseVol(t, encoding, dvid.Bounds{})
+
+	// A mutation can't be undone twice.
+	reqStr = fmt.Sprintf("%snode/%s/bodies/undo/%d", server.WebAPIPath, uuid, mergeID)
+	server.TestBadHTTP(t, "POST", reqStr, nil)
+
+	// Redo the merge.
+	reqStr = fmt.Sprintf("%snode/%s/bodies/redo/%d", server.WebAPIPath, uuid, mergeID)
+	server.TestHTTP(t, "POST", reqStr, nil)
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	merged := newTestVolume(128, 128, 128)
+	copy(merged.data, original.data)
+	merged.add(body3, 2)
+	retrieved.get(t, uuid, "labels")
+	if !retrieved.equals(merged) {
+		t.Errorf("Label volume after redo of merge not equal to merged volume\n")
+	}
+	if retrieved.hasLabel(3, &body3) {
+		t.Errorf("Found label 3 after redo of merge into label 2\n")
+	}
+
+	// Split body 4 and then undo it.
+	reqStr = fmt.Sprintf("%snode/%s/bodies/split/4", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", reqStr, splitEncoding(t, bodysplit))
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	splitID := lastMutation(t, uuid, "bodies")
+
+	reqStr = fmt.Sprintf("%snode/%s/bodies/undo/%d", server.WebAPIPath, uuid, splitID)
+	server.TestHTTP(t, "POST", reqStr, nil)
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	retrieved.get(t, uuid, "labels")
+	if !retrieved.equals(merged) {
+		t.Errorf("Label volume after undo of split not equal to volume before split\n")
+	}
+	reqStr = fmt.Sprintf("%snode/%s/bodies/sparsevol/4", server.WebAPIPath, uuid)
+	encoding = server.TestHTTP(t, "GET", reqStr, nil)
+	body4.checkSparseVol(t, encoding, dvid.Bounds{})
+
+	// Redo the split, then merge the split label elsewhere so the split can't be undone.
+	reqStr = fmt.Sprintf("%snode/%s/bodies/redo/%d", server.WebAPIPath, uuid, splitID)
+	server.TestHTTP(t, "POST", reqStr, nil)
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	reqStr = fmt.Sprintf("%snode/%s/bodies/sparsevol/5", server.WebAPIPath, uuid)
+	encoding = server.TestHTTP(t, "GET", reqStr, nil)
+	bodysplit.checkSparseVol(t, encoding, dvid.Bounds{})
+
+	testMerge = mergeJSON(`[1, 5]`)
+	testMerge.send(t, uuid, "bodies")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	reqStr = fmt.Sprintf("%snode/%s/bodies/undo/%d", server.WebAPIPath, uuid, splitID)
+	server.TestBadHTTP(t, "POST", reqStr, nil)
+}
+
+func TestMergeSplitWebhooks(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelblk", "labels", config)
+	server.CreateTestInstance(t, uuid, "labelvol", "bodies", config)
+	server.CreateTestSync(t, uuid, "labels", "bodies")
+	server.CreateTestSync(t, uuid, "bodies", "labels")
+
+	// The webhook endpoint fails the first delivery to exercise retries.
+	received := make(chan datastore.WebhookEvent, 10)
+	var mu sync.Mutex
+	var failed bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := !failed
+		failed = true
+		mu.Unlock()
+		if fail {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Unable to read webhook body: %v\n", err)
+			return
+		}
+		var evt datastore.WebhookEvent
+		if err := json.Unmarshal(data, &evt); err != nil {
+			t.Errorf("Unable to parse webhook body %q: %v\n", string(data), err)
+		}
+		received <- evt
+	}))
+	defer ts.Close()
+
+	reqStr := fmt.Sprintf("%srepo/%s/webhooks", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"url": "ftp://foo", "events": ["MERGE_END"]}`))
+	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBufferString(fmt.Sprintf(`{"url": %q, "data": "nothere", "events": ["MERGE_END"]}`, ts.URL)))
+	hookJSON := fmt.Sprintf(`{"url": %q, "data": "bodies", "events": ["MERGE_END", "SPLIT_END"]}`, ts.URL)
+	r := server.TestHTTP(t, "POST", reqStr, bytes.NewBufferString(hookJSON))
+	var hook datastore.Webhook
+	if err := json.Unmarshal(r, &hook); err != nil {
+		t.Fatalf("Unable to parse added webhook: %v\n", err)
+	}
+	if hook.ID == "" || hook.Data != "bodies" || len(hook.Events) != 2 {
+		t.Errorf("Bad webhook returned: %v\n", hook)
+	}
+
+	createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+		t.Fatalf("Error blocking on sync of labels -> bodies: %v\n", err)
+	}
+
+	// Merge and split should each deliver one event.
+	testMerge := mergeJSON(`[2, 3]`)
+	testMerge.send(t, uuid, "bodies")
+	var evt datastore.WebhookEvent
+	select {
+	case evt = <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for merge webhook\n")
+	}
+	if evt.Hook != hook.ID || evt.Event != "MERGE_END" || evt.UUID != uuid || evt.Data != "bodies" {
+		t.Errorf("Bad merge webhook event: %v\n", evt)
+	}
+	delta, _ := json.Marshal(evt.Delta)
+	if string(delta) != `{"merged":[3],"target":2}` {
+		t.Errorf("Bad merge webhook delta: %s\n", string(delta))
+	}
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+
+	reqStr = fmt.Sprintf("%snode/%s/bodies/split/4", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", reqStr, splitEncoding(t, bodysplit))
+	select {
+	case evt = <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for split webhook\n")
+	}
+	delta, _ = json.Marshal(evt.Delta)
+	if evt.Event != "SPLIT_END" || string(delta) != `{"new":5,"old":4}` {
+		t.Errorf("Bad split webhook event %v with delta %s\n", evt, string(delta))
+	}
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+
+	// After deleting the webhook, no more events should be delivered.
+	reqStr = fmt.Sprintf("%srepo/%s/webhooks/%s", server.WebAPIPath, uuid, hook.ID)
+	server.TestHTTP(t, "DELETE", reqStr, nil)
+	reqStr = fmt.Sprintf("%srepo/%s/webhooks", server.WebAPIPath, uuid)
+	r = server.TestHTTP(t, "GET", reqStr, nil)
+	if string(r) != "[]" {
+		t.Errorf("Expected no webhooks after deletion, got %s\n", string(r))
+	}
+	testMerge = mergeJSON(`[1, 5]`)
+	testMerge.send(t, uuid, "bodies")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	select {
+	case evt = <-received:
+		t.Errorf("Received webhook event after deletion: %v\n", evt)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSplitAbortWebhook(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelblk", "labels", config)
+	server.CreateTestInstance(t, uuid, "labelvol", "bodies", config)
+	server.CreateTestSync(t, uuid, "labels", "bodies")
+	server.CreateTestSync(t, uuid, "bodies", "labels")
+
+	received := make(chan datastore.WebhookEvent, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var evt datastore.WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+			t.Errorf("Unable to parse webhook body: %v\n", err)
+		}
+		received <- evt
+	}))
+	defer ts.Close()
+
+	reqStr := fmt.Sprintf("%srepo/%s/webhooks", server.WebAPIPath, uuid)
+	hookJSON := fmt.Sprintf(`{"url": %q, "data": "bodies", "events": ["SPLIT_ABORT", "SPLIT_END"]}`, ts.URL)
+	server.TestHTTP(t, "POST", reqStr, bytes.NewBufferString(hookJSON))
+
+	createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+		t.Fatalf("Error blocking on sync of labels -> bodies: %v\n", err)
+	}
+
+	// A split of voxels outside the label fails after the split has started.
+	reqStr = fmt.Sprintf("%snode/%s/bodies/split/1", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", reqStr, splitEncoding(t, bodysplit))
+	var evt datastore.WebhookEvent
+	select {
+	case evt = <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for split abort webhook\n")
+	}
+	delta, _ := json.Marshal(evt.Delta)
+	if evt.Event != "SPLIT_ABORT" || string(delta) != `{"new":5,"old":1}` {
+		t.Errorf("Bad split abort webhook event %v with delta %s\n", evt, string(delta))
+	}
+
+	// The aborted split leaves no labels marked as being split.
+	reqStr = fmt.Sprintf("%snode/%s/bodies/split/4", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", reqStr, splitEncoding(t, bodysplit))
+	select {
+	case evt = <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for split webhook\n")
+	}
+	delta, _ = json.Marshal(evt.Delta)
+	if evt.Event != "SPLIT_END" || string(delta) != `{"new":6,"old":4}` {
+		t.Errorf("Bad split webhook event %v with delta %s\n", evt, string(delta))
+	}
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+}
+
+// readStreamEvent returns the next server-sent event from an event stream.
+func readStreamEvent(t *testing.T, r *bufio.Reader) datastore.StreamEvent {
+	type result struct {
+		evt datastore.StreamEvent
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var res result
+		var id, event string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				res.err = err
+				break
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				res.err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res.evt)
+			}
+			if line == "" && id != "" {
+				if res.evt.ID != id || res.evt.Event != event {
+					res.err = fmt.Errorf("event id %s and type %s don't match data %v", id, event, res.evt)
+				}
+				break
+			}
+		}
+		ch <- res
+	}()
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			t.Fatalf("Error reading event stream: %v\n", res.err)
+		}
+		return res.evt
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for event on stream\n")
+	}
+	return datastore.StreamEvent{}
+}
+
+func TestMergeEventStream(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelblk", "labels", config)
+	server.CreateTestInstance(t, uuid, "labelvol", "bodies", config)
+	server.CreateTestSync(t, uuid, "labels", "bodies")
+	server.CreateTestSync(t, uuid, "bodies", "labels")
+
+	createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+		t.Fatalf("Error blocking on sync of labels -> bodies: %v\n", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(server.ServeSingleHTTP))
+	defer ts.Close()
+
+	streamURL := fmt.Sprintf("%s%snode/%s/bodies/events?events=MERGE_END,SPLIT_END", ts.URL, server.WebAPIPath, uuid)
+	resp, err := http.Get(streamURL)
+	if err != nil {
+		t.Fatalf("Unable to open event stream: %v\n", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Bad event stream response: %s, %s\n", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	testMerge := mergeJSON(`[2, 3]`)
+	testMerge.send(t, uuid, "bodies")
+	evt := readStreamEvent(t, bufio.NewReader(resp.Body))
+	delta, _ := json.Marshal(evt.Delta)
+	if evt.Event != "MERGE_END" || evt.UUID != uuid || string(delta) != `{"merged":[3],"target":2}` {
+		t.Errorf("Bad merge event %v with delta %s\n", evt, string(delta))
+	}
+	resp.Body.Close()
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+
+	// Events made while disconnected are sent when resuming from the last event id.
+	testMerge = mergeJSON(`[1, 4]`)
+	testMerge.send(t, uuid, "bodies")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of bodies -> labels: %v\n", err)
+	}
+	req, err := http.NewRequest("GET", streamURL, nil)
+	if err != nil {
+		t.Fatalf("Unable to create event stream request: %v\n", err)
+	}
+	req.Header.Set("Last-Event-ID", evt.ID)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to resume event stream: %v\n", err)
+	}
+	resumed := readStreamEvent(t, bufio.NewReader(resp.Body))
+	delta, _ = json.Marshal(resumed.Delta)
+	if resumed.ID == evt.ID || resumed.Event != "MERGE_END" || string(delta) != `{"merged":[4],"target":1}` {
+		t.Errorf("Bad resumed merge event %v with delta %s\n", resumed, string(delta))
+	}
+	resp.Body.Close()
+
+	// Event ids from an earlier run of the server are rejected.
+	req.Header.Set("Last-Event-ID", "1-"+strings.SplitN(evt.ID, "-", 2)[1])
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to request event stream: %v\n", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected stale event id to be rejected, got %s\n", resp.Status)
+	}
+	resp.Body.Close()
+
+	// Block mutations of labelblk are filtered by bounds.
+	streamURL = fmt.Sprintf("%s%snode/%s/labels/events?events=BLOCK_MUTATE&minx=40&maxx=50&miny=0&maxy=10&minz=70&maxz=80", ts.URL, server.WebAPIPath, uuid)
+	resp, err = http.Get(streamURL)
+	if err != nil {
+		t.Fatalf("Unable to open event stream: %v\n", err)
+	}
+	defer resp.Body.Close()
+	volume := newTestVolume(128, 128, 128)
+	volume.add(body1, 1)
+	volume.putMutable(t, uuid, "labels")
+	evt = readStreamEvent(t, bufio.NewReader(resp.Body))
+	expected := dvid.Extents3d{MinPoint: dvid.Point3d{32, 0, 64}, MaxPoint: dvid.Point3d{63, 31, 95}}
+	if evt.Event != "BLOCK_MUTATE" || evt.Extents == nil || *evt.Extents != expected {
+		t.Errorf("Bad block mutation event: %v\n", evt)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+		t.Fatalf("Error blocking on sync of labels -> bodies: %v\n", err)
+	}
+}
+
 // Same as TestSplitLabel but now designate the actual split label
 func TestSplitGivenLabel(t *testing.T) {
 	datastore.OpenTest()
@@ -999,6 +1458,71 @@ func TestMergeSplitLabel(t *testing.T) {
 }
 
 // Test that mutable labelblk POST will accurately remove prior bodies.
+func TestMaxLabelAfterGC(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, versionID := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelvol", "bodies", config)
+	if err := datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+
+	// Labels handed out in a deleted leaf must not be reused, so the repo-wide max label
+	// stored at version 0 has to survive garbage collection.
+	child, err := datastore.NewVersion(uuid, "child", nil)
+	if err != nil {
+		t.Fatalf("Unable to branch: %v\n", err)
+	}
+	childV, err := datastore.VersionFromUUID(child)
+	if err != nil {
+		t.Fatalf("Unable to get version of child: %v\n", err)
+	}
+	d, err := GetByUUIDName(child, "bodies")
+	if err != nil {
+		t.Fatalf("Unable to get labelvol instance: %v\n", err)
+	}
+	var label uint64
+	for i := 0; i < 10; i++ {
+		if label, err = d.NewLabel(childV); err != nil {
+			t.Fatalf("Unable to get new label: %v\n", err)
+		}
+	}
+	server.TestHTTP(t, "DELETE", fmt.Sprintf("%snode/%s", server.WebAPIPath, child), nil)
+
+	gcreq := fmt.Sprintf("%srepo/%s/gc", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", gcreq, nil)
+	var stats datastore.GCStats
+	for {
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", gcreq, nil), &stats); err != nil {
+			t.Fatalf("Unable to parse gc stats: %v\n", err)
+		}
+		if !stats.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.Error != "" {
+		t.Fatalf("Error in gc: %s\n", stats.Error)
+	}
+
+	// Reload max labels from the store as done on server restart.
+	if _, err := d.LoadMutable(versionID, 1, 1); err != nil {
+		t.Fatalf("Unable to reload max labels: %v\n", err)
+	}
+	var next struct {
+		NextLabel uint64 `json:"nextlabel"`
+	}
+	reqStr := fmt.Sprintf("%snode/%s/bodies/nextlabel", server.WebAPIPath, uuid)
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", reqStr, nil), &next); err != nil {
+		t.Fatalf("Unable to parse nextlabel: %v\n", err)
+	}
+	if next.NextLabel <= label {
+		t.Errorf("Expected next label after %d following gc, got %d\n", label, next.NextLabel)
+	}
+}
+
 func TestMutableLabelblkPOST(t *testing.T) {
 	datastore.OpenTest()
 	defer datastore.CloseTest()
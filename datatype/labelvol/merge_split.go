@@ -45,12 +45,33 @@ func (d *Data) getMergeIV(v dvid.VersionID) dvid.InstanceVersion {
 //
 // labels.MergeEndEvent occurs at end of merge and transmits labels.DeltaMergeEnd struct.
 //
+// labels.MergeAbortEvent occurs instead of the block and end events if the merge could not be
+// committed and transmits labels.DeltaMergeAbort struct.
+//
+// The labelvol RLEs, and the label indices of synced annotations, are committed in one
+// transaction before any block events are sent.  Synced labelblk voxels are relabeled
+// asynchronously on the block event since a label's blocks can be too large for one transaction.
+//
 func (d *Data) MergeLabels(v dvid.VersionID, m labels.MergeOp) error {
+	return d.mergeLabels(v, m, 0)
+}
+
+// mergeLabels merges labels and, if the mutation ID is non-zero, stores the sparse volumes
+// of the merged labels so the merge can be undone.
+func (d *Data) mergeLabels(v dvid.VersionID, m labels.MergeOp, mutID uint64) error {
 	dvid.Debugf("Merging %s into label %d ...\n", m.Merged, m.Target)
 	d.StartUpdate()
 
 	// Mark these labels as dirty until done.
-	if err := labels.MergeStart(d.getMergeIV(v), m); err != nil {
+	lbls := make([]uint64, 0, len(m.Merged)+1)
+	for label := range m.Merged {
+		lbls = append(lbls, label)
+	}
+	lbls = append(lbls, m.Target)
+	err := d.startMutation(v, mutID, lbls, func() error {
+		return labels.MergeStart(d.getMergeIV(v), m)
+	})
+	if err != nil {
 		return err
 	}
 	d.StopUpdate()
@@ -64,15 +85,23 @@ func (d *Data) MergeLabels(v dvid.VersionID, m labels.MergeOp) error {
 
 	// Asynchronously perform merge and handle any concurrent requests using the cache map until
 	// labelvol and labelblk are updated and consistent.
-	go d.asyncMergeLabels(v, m)
+	go d.asyncMergeLabels(v, m, mutID)
 
 	return nil
 }
 
-func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
+func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp, mutID uint64) {
 	// Remove dirty labels and updating flag when done.
 	defer labels.MergeStop(d.getMergeIV(v), m)
 
+	// If the merge isn't committed, synced data drops it without changing any labels.
+	var committed bool
+	defer func() {
+		if !committed {
+			d.abortMerge(v, m)
+		}
+	}()
+
 	// Get storage objects
 	store, err := d.GetOrderedKeyValueDB()
 	if err != nil {
@@ -97,8 +126,15 @@ func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
 	}
 	toLabelSize := toLabelRLEs.NumVoxels()
 
+	// All RLE deletions and updates are committed together so a crash can't leave
+	// voxels in both or neither of the merged labels.
+	ctx := datastore.NewVersionedCtx(d, v)
+	txn := storage.NewTransaction()
+
 	// Iterate through all labels to be merged.
 	var addedVoxels uint64
+	var deletedSizes []labels.DeltaDeleteSize
+	undo := undoRecord{Op: "merge", Target: toLabel, Labels: make(map[uint64][]byte, len(m.Merged))}
 	for fromLabel := range m.Merged {
 		dvid.Debugf("Merging label %d to label %d...\n", fromLabel, toLabel)
 
@@ -113,18 +149,19 @@ func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
 			continue
 		}
 		addedVoxels += fromLabelSize
+		if mutID != 0 {
+			if undo.Labels[fromLabel], err = flattenRLEs(fromLabelRLEs).MarshalBinary(); err != nil {
+				dvid.Errorf("Can't serialize RLEs of label %d for undo: %v\n", fromLabel, err)
+				return
+			}
+		}
 
-		// Notify linked labelsz instances
-		delta := labels.DeltaDeleteSize{
+		// Linked labelsz instances are notified after the commit.
+		deletedSizes = append(deletedSizes, labels.DeltaDeleteSize{
 			Label:    fromLabel,
 			OldSize:  fromLabelSize,
 			OldKnown: true,
-		}
-		evt := datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
-		msg := datastore.SyncMessage{labels.ChangeSizeEvent, v, delta}
-		if err := datastore.NotifySubscribers(evt, msg); err != nil {
-			dvid.Criticalf("can't notify subscribers for event %v: %v\n", evt, err)
-		}
+		})
 
 		// Append or insert RLE runs from fromLabel blocks into toLabel blocks.
 		for blockStr, fromRLEs := range fromLabelRLEs {
@@ -139,14 +176,9 @@ func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
 				toRLEs = fromRLEs
 			}
 			toLabelRLEs[blockStr] = toRLEs
-		}
 
-		// Delete all fromLabel RLEs since they are all integrated into toLabel RLEs
-		minTKey := NewTKey(fromLabel, dvid.MinIndexZYX.ToIZYXString())
-		maxTKey := NewTKey(fromLabel, dvid.MaxIndexZYX.ToIZYXString())
-		ctx := datastore.NewVersionedCtx(d, v)
-		if err := store.DeleteRange(ctx, minTKey, maxTKey); err != nil {
-			dvid.Criticalf("Can't delete label %d RLEs: %v", fromLabel, err)
+			// Delete the fromLabel RLEs since they are integrated into toLabel RLEs
+			txn.Delete(batcher, ctx, NewTKey(fromLabel, blockStr))
 		}
 	}
 
@@ -155,27 +187,53 @@ func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
 		return
 	}
 
-	// Publish block-level merge
-	evt := datastore.SyncEvent{d.DataUUID(), labels.MergeBlockEvent}
-	msg := datastore.SyncMessage{labels.MergeBlockEvent, v, labels.DeltaMerge{m, blocksChanged}}
-	if err := datastore.NotifySubscribers(evt, msg); err != nil {
-		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
-	}
-
 	// Update datastore with all toLabel RLEs that were changed
-	ctx := datastore.NewVersionedCtx(d, v)
-	batch := batcher.NewBatch(ctx)
 	for blockStr := range blocksChanged {
 		tk := NewTKey(toLabel, blockStr)
 		serialization, err := toLabelRLEs[blockStr].MarshalBinary()
 		if err != nil {
 			dvid.Errorf("Error serializing RLEs for label %d: %v\n", toLabel, err)
+			return
+		}
+		txn.Put(batcher, ctx, tk, serialization)
+	}
+	if mutID != 0 {
+		if err := putUndoRecord(txn, batcher, ctx, mutID, &undo); err != nil {
+			dvid.Errorf("Unable to store undo of merge into label %d: %v\n", toLabel, err)
+		}
+	}
+
+	// Synced data that merges within our transaction, e.g., annotation label indices,
+	// adds its updates before the commit.
+	evt := datastore.SyncEvent{d.DataUUID(), labels.MergeBlockEvent}
+	msg := datastore.SyncMessage{labels.MergeBlockEvent, v, labels.DeltaMerge{m, blocksChanged}}
+	done, err := datastore.PrepareSyncTxn(txn, evt, msg)
+	if err != nil {
+		dvid.Criticalf("Abandoning merge into label %d: %v\n", toLabel, err)
+		return
+	}
+	err = txn.Commit()
+	done(err == nil)
+	if err != nil {
+		dvid.Criticalf("Error on merging RLEs into label %d, abandoning merge: %v\n", toLabel, err)
+		return
+	}
+	committed = true
+
+	// Notify linked labelsz instances
+	for _, delta := range deletedSizes {
+		evt := datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
+		msg := datastore.SyncMessage{labels.ChangeSizeEvent, v, delta}
+		if err := datastore.NotifySubscribers(evt, msg); err != nil {
+			dvid.Criticalf("can't notify subscribers for event %v: %v\n", evt, err)
 		}
-		batch.Put(tk, serialization)
 	}
-	if err := batch.Commit(); err != nil {
-		dvid.Errorf("Error on updating RLEs for label %d: %v\n", toLabel, err)
+
+	// Publish block-level merge
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
 	}
+
 	delta := labels.DeltaReplaceSize{
 		Label:   toLabel,
 		OldSize: toLabelSize,
@@ -194,6 +252,24 @@ func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
 	}
 }
 
+// abortMerge tells synced data that a started merge was abandoned without changes.
+func (d *Data) abortMerge(v dvid.VersionID, m labels.MergeOp) {
+	evt := datastore.SyncEvent{d.DataUUID(), labels.MergeAbortEvent}
+	msg := datastore.SyncMessage{labels.MergeAbortEvent, v, labels.DeltaMergeAbort{MergeOp: m}}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+}
+
+// abortSplit tells synced data that a started split was abandoned without changes.
+func (d *Data) abortSplit(v dvid.VersionID, fromLabel, toLabel uint64) {
+	evt := datastore.SyncEvent{d.DataUUID(), labels.SplitAbortEvent}
+	msg := datastore.SyncMessage{labels.SplitAbortEvent, v, labels.DeltaSplitAbort{OldLabel: fromLabel, NewLabel: toLabel}}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+}
+
 // SplitLabels splits a portion of a label's voxels into a given split label or, if the given split
 // label is 0, a new label, which is returned.  The input is a binary sparse volume and should
 // preferably be the smaller portion of a labeled region.  In other words, the caller should chose
@@ -209,18 +285,19 @@ func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
 //
 // labels.SplitEndEvent occurs at end of split and transmits labels.DeltaSplitEnd struct.
 //
+// labels.SplitAbortEvent occurs instead of the split and end events if the split could not be
+// committed and transmits labels.DeltaSplitAbort struct.
+//
+// As with merges, the split is committed with the label indices of synced annotations
+// before any split event besides the start is sent.
+//
 func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.ReadCloser) (toLabel uint64, err error) {
-	store, err := d.GetOrderedKeyValueDB()
-	if err != nil {
-		err = fmt.Errorf("Data type labelvol had error initializing store: %v\n", err)
-		return
-	}
-	batcher, ok := store.(storage.KeyValueBatcher)
-	if !ok {
-		err = fmt.Errorf("Data type labelvol requires batch-enabled store, which %q is not\n", store)
-		return
-	}
+	return d.splitLabels(v, fromLabel, splitLabel, r, 0)
+}
 
+// splitLabels splits labels and, if the mutation ID is non-zero, stores the sparse volume
+// of the split label so the split can be undone.
+func (d *Data) splitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.ReadCloser, mutID uint64) (toLabel uint64, err error) {
 	// Create a new label id for this version that will persist to store
 	if splitLabel != 0 {
 		toLabel = splitLabel
@@ -233,28 +310,56 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 		dvid.Debugf("Splitting subset of label %d into new label %d ...\n", fromLabel, toLabel)
 	}
 
+	// Read the sparse volume from reader.
+	var split dvid.RLEs
+	split, err = dvid.ReadRLEs(r)
+	if err != nil {
+		return
+	}
+	err = d.splitRLEs(v, fromLabel, toLabel, split, mutID)
+	return
+}
+
+// splitRLEs moves the voxels of the given sparse volume from one label to another.
+func (d *Data) splitRLEs(v dvid.VersionID, fromLabel, toLabel uint64, split dvid.RLEs, mutID uint64) (err error) {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		err = fmt.Errorf("Data type labelvol had error initializing store: %v\n", err)
+		return
+	}
+	batcher, ok := store.(storage.KeyValueBatcher)
+	if !ok {
+		err = fmt.Errorf("Data type labelvol requires batch-enabled store, which %q is not\n", store)
+		return
+	}
+
 	evt := datastore.SyncEvent{d.DataUUID(), labels.SplitStartEvent}
 	splitOpStart := labels.DeltaSplitStart{fromLabel, toLabel}
 	splitOpEnd := labels.DeltaSplitEnd{fromLabel, toLabel}
 
 	// Make sure we can split given current merges in progress
-	if err := labels.SplitStart(d.getMergeIV(v), splitOpStart); err != nil {
-		return toLabel, err
+	err = d.startMutation(v, mutID, []uint64{fromLabel, toLabel}, func() error {
+		return labels.SplitStart(d.getMergeIV(v), splitOpStart)
+	})
+	if err != nil {
+		return err
 	}
 	defer labels.SplitStop(d.getMergeIV(v), splitOpEnd)
 
 	// Signal that we are starting a split.
 	msg := datastore.SyncMessage{labels.SplitStartEvent, v, splitOpStart}
 	if err := datastore.NotifySubscribers(evt, msg); err != nil {
-		return 0, err
+		return err
 	}
 
-	// Read the sparse volume from reader.
-	var split dvid.RLEs
-	split, err = dvid.ReadRLEs(r)
-	if err != nil {
-		return
-	}
+	// If the split isn't committed, synced data drops it without changing any labels.
+	var committed bool
+	defer func() {
+		if !committed {
+			d.abortSplit(v, fromLabel, toLabel)
+		}
+	}()
+
 	toLabelSize, _ := split.Stats()
 
 	// Partition the split spans into blocks.
@@ -269,11 +374,11 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 
 	// Iterate through the split blocks, read the original block.  If the RLEs
 	// are identical, just delete the original.  If not, modify the original.
-	// TODO: Modifications should be transactional since it's GET-PUT, therefore use
-	// hash on block coord to direct it to blockLabel, splitLabel-specific goroutine; we serialize
-	// requests to handle concurrency.
+	// The modifications and the new label's RLEs are committed in one transaction.
+	// TODO: Use hash on block coord to direct GET-PUT to blockLabel, splitLabel-specific
+	// goroutine; we serialize requests to handle concurrency.
 	ctx := datastore.NewVersionedCtx(d, v)
-	batch := batcher.NewBatch(ctx)
+	txn := storage.NewTransaction()
 
 	for _, splitblk := range splitblks {
 
@@ -281,47 +386,71 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 		tk := NewTKey(fromLabel, splitblk)
 		val, err := store.Get(ctx, tk)
 		if err != nil {
-			return toLabel, err
+			return err
 		}
 
 		if val == nil {
-			return toLabel, fmt.Errorf("Split RLEs at block %s are not part of original label %d", splitblk, fromLabel)
+			return fmt.Errorf("Split RLEs at block %s are not part of original label %d", splitblk, fromLabel)
 		}
 		var rles dvid.RLEs
 		if err := rles.UnmarshalBinary(val); err != nil {
-			return toLabel, fmt.Errorf("Unable to unmarshal RLE for original labels in block %s", splitblk)
+			return fmt.Errorf("Unable to unmarshal RLE for original labels in block %s", splitblk)
 		}
 
 		// Compare and process based on modifications required.
 		remain, err := rles.Split(splitmap[splitblk])
 		if err != nil {
-			return toLabel, err
+			return err
 		}
 		if len(remain) == 0 {
-			batch.Delete(tk)
+			txn.Delete(batcher, ctx, tk)
 		} else {
 			rleBytes, err := remain.MarshalBinary()
 			if err != nil {
-				return toLabel, fmt.Errorf("can't serialize remain RLEs for split of %d: %v\n", fromLabel, err)
+				return fmt.Errorf("can't serialize remain RLEs for split of %d: %v\n", fromLabel, err)
 			}
-			batch.Put(tk, rleBytes)
+			txn.Put(batcher, ctx, tk, rleBytes)
 		}
 	}
 
-	if err = batch.Commit(); err != nil {
-		err = fmt.Errorf("Batch PUT during split of %q label %d: %v\n", d.DataName(), fromLabel, err)
+	// Add the split sparse vol.
+	if err = addLabelVol(txn, batcher, ctx, toLabel, splitmap, splitblks); err != nil {
 		return
 	}
+	if mutID != 0 {
+		// Splits of an undone merge share the mutation ID of the undo.
+		var undo *undoRecord
+		if undo, err = d.getUndoRecord(ctx, mutID); err != nil {
+			return
+		}
+		if undo == nil || undo.Op != "split" || undo.Target != fromLabel {
+			undo = &undoRecord{Op: "split", Target: fromLabel, Labels: make(map[uint64][]byte, 1)}
+		}
+		if undo.Labels[toLabel], err = split.MarshalBinary(); err != nil {
+			return
+		}
+		if err = putUndoRecord(txn, batcher, ctx, mutID, undo); err != nil {
+			return
+		}
+	}
 
-	// Publish split event
+	// Synced data that splits within our transaction adds its updates before the commit.
 	evt = datastore.SyncEvent{d.DataUUID(), labels.SplitLabelEvent}
 	msg = datastore.SyncMessage{labels.SplitLabelEvent, v, labels.DeltaSplit{fromLabel, toLabel, splitmap, splitblks}}
-	if err = datastore.NotifySubscribers(evt, msg); err != nil {
+	done, err := datastore.PrepareSyncTxn(txn, evt, msg)
+	if err != nil {
+		return
+	}
+	err = txn.Commit()
+	done(err == nil)
+	if err != nil {
+		err = fmt.Errorf("Transaction during split of %q label %d: %v\n", d.DataName(), fromLabel, err)
 		return
 	}
+	committed = true
 
-	// Write the split sparse vol.
-	if err = d.writeLabelVol(v, toLabel, splitmap, splitblks); err != nil {
+	// Publish split event
+	if err = datastore.NotifySubscribers(evt, msg); err != nil {
 		return
 	}
 
@@ -353,7 +482,7 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 		return
 	}
 
-	return toLabel, nil
+	return nil
 }
 
 // SplitCoarseLabels splits a portion of a label's voxels into a given split label or, if the given split
@@ -368,7 +497,16 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 //
 // labels.SplitEndEvent occurs at end of split and transmits labels.DeltaSplitEnd struct.
 //
+// labels.SplitAbortEvent occurs instead of the split and end events if the split could not be
+// committed and transmits labels.DeltaSplitAbort struct.
+//
 func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.ReadCloser) (toLabel uint64, err error) {
+	return d.splitCoarseLabels(v, fromLabel, splitLabel, r, 0)
+}
+
+// splitCoarseLabels splits labels by block and, if the mutation ID is non-zero, stores the
+// sparse volume of the split label so the split can be undone.
+func (d *Data) splitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.ReadCloser, mutID uint64) (toLabel uint64, err error) {
 	store, err := d.GetOrderedKeyValueDB()
 	if err != nil {
 		err = fmt.Errorf("Data type labelvol had error initializing store: %v\n", err)
@@ -397,7 +535,10 @@ func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64,
 	splitOpEnd := labels.DeltaSplitEnd{fromLabel, toLabel}
 
 	// Make sure we can split given current merges in progress
-	if err := labels.SplitStart(d.getMergeIV(v), splitOpStart); err != nil {
+	err = d.startMutation(v, mutID, []uint64{fromLabel, toLabel}, func() error {
+		return labels.SplitStart(d.getMergeIV(v), splitOpStart)
+	})
+	if err != nil {
 		return toLabel, err
 	}
 	defer labels.SplitStop(d.getMergeIV(v), splitOpEnd)
@@ -408,6 +549,14 @@ func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64,
 		return 0, err
 	}
 
+	// If the split isn't committed, synced data drops it without changing any labels.
+	var committed bool
+	defer func() {
+		if !committed {
+			d.abortSplit(v, fromLabel, toLabel)
+		}
+	}()
+
 	// Read the sparse volume from reader.
 	var splits dvid.RLEs
 	splits, err = dvid.ReadRLEs(r)
@@ -431,13 +580,14 @@ func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64,
 	sort.Sort(splitblks)
 
 	// Iterate through the split blocks, read the original block and change labels.
-	// TODO: Modifications should be transactional since it's GET-PUT, therefore use
-	// hash on block coord to direct it to block-specific goroutine; we serialize
+	// All relabeled blocks are committed in one transaction.
+	// TODO: Use hash on block coord to direct GET-PUT to block-specific goroutine; we serialize
 	// requests to handle concurrency.
 	ctx := datastore.NewVersionedCtx(d, v)
-	batch := batcher.NewBatch(ctx)
+	txn := storage.NewTransaction()
 
 	var toLabelSize uint64
+	var splitRLEs dvid.RLEs
 	for _, splitblk := range splitblks {
 		// Get original block
 		tk := NewTKey(fromLabel, splitblk)
@@ -454,20 +604,40 @@ func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64,
 		}
 		numVoxels, _ := rles.Stats()
 		toLabelSize += numVoxels
+		if mutID != 0 {
+			splitRLEs = append(splitRLEs, rles...)
+		}
 
 		// Delete the old block and save the sparse volume but under a new label.
-		batch.Delete(tk)
+		txn.Delete(batcher, ctx, tk)
 		tk2 := NewTKey(toLabel, splitblk)
-		batch.Put(tk2, val)
+		txn.Put(batcher, ctx, tk2, val)
 	}
-
-	if err := batch.Commit(); err != nil {
-		dvid.Errorf("Batch PUT during split of %q label %d: %v\n", d.DataName(), fromLabel, err)
+	if mutID != 0 {
+		undo := undoRecord{Op: "split", Target: fromLabel, Labels: make(map[uint64][]byte, 1)}
+		if undo.Labels[toLabel], err = splitRLEs.MarshalBinary(); err != nil {
+			return
+		}
+		if err = putUndoRecord(txn, batcher, ctx, mutID, &undo); err != nil {
+			return
+		}
 	}
 
-	// Publish split event
+	// Synced data that splits within our transaction adds its updates before the commit.
 	evt = datastore.SyncEvent{d.DataUUID(), labels.SplitLabelEvent}
 	msg = datastore.SyncMessage{labels.SplitLabelEvent, v, labels.DeltaSplit{fromLabel, toLabel, nil, splitblks}}
+	done, err := datastore.PrepareSyncTxn(txn, evt, msg)
+	if err != nil {
+		return toLabel, err
+	}
+	err = txn.Commit()
+	done(err == nil)
+	if err != nil {
+		return toLabel, fmt.Errorf("Transaction during split of %q label %d: %v\n", d.DataName(), fromLabel, err)
+	}
+	committed = true
+
+	// Publish split event
 	if err := datastore.NotifySubscribers(evt, msg); err != nil {
 		return 0, err
 	}
@@ -504,26 +674,15 @@ func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64,
 	return toLabel, nil
 }
 
-// write label volume in sorted order if available.
-func (d *Data) writeLabelVol(v dvid.VersionID, label uint64, brles dvid.BlockRLEs, sortblks []dvid.IZYXString) error {
-	store, err := d.GetOrderedKeyValueDB()
-	if err != nil {
-		return fmt.Errorf("Data type labelvol had error initializing store: %v\n", err)
-	}
-	batcher, ok := store.(storage.KeyValueBatcher)
-	if !ok {
-		return fmt.Errorf("Data type labelvol requires batch-enabled store, which %q is not\n", store)
-	}
-
-	ctx := datastore.NewVersionedCtx(d, v)
-	batch := batcher.NewBatch(ctx)
+// addLabelVol adds puts of a label volume to a transaction in sorted order if available.
+func addLabelVol(txn *storage.Transaction, db storage.KeyValueBatcher, ctx storage.Context, label uint64, brles dvid.BlockRLEs, sortblks []dvid.IZYXString) error {
 	if sortblks != nil {
 		for _, izyxStr := range sortblks {
 			serialization, err := brles[izyxStr].MarshalBinary()
 			if err != nil {
 				return fmt.Errorf("Error serializing RLEs for label %d: %v\n", label, err)
 			}
-			batch.Put(NewTKey(label, izyxStr), serialization)
+			txn.Put(db, ctx, NewTKey(label, izyxStr), serialization)
 		}
 	} else {
 		for izyxStr, rles := range brles {
@@ -531,11 +690,8 @@ func (d *Data) writeLabelVol(v dvid.VersionID, label uint64, brles dvid.BlockRLE
 			if err != nil {
 				return fmt.Errorf("Error serializing RLEs for label %d: %v\n", label, err)
 			}
-			batch.Put(NewTKey(label, izyxStr), serialization)
+			txn.Put(db, ctx, NewTKey(label, izyxStr), serialization)
 		}
 	}
-	if err := batch.Commit(); err != nil {
-		return fmt.Errorf("Error on updating RLEs for label %d: %v\n", label, err)
-	}
 	return nil
 }
@@ -0,0 +1,279 @@
+/*
+	This file supports undoing and redoing journaled merges and splits.
+*/
+
+package labelvol
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// undoRecord holds the sparse volumes needed to reverse a merge or split.  For a merge,
+// Target is the label merged into and Labels holds the RLEs of each merged label.  For a
+// split, Target is the label split from and Labels holds the RLEs of the split label.
+type undoRecord struct {
+	Op     string
+	Target uint64
+	Labels map[uint64][]byte
+	Undone bool
+}
+
+// sortedLabels returns the labels of the record in increasing order.
+func (rec *undoRecord) sortedLabels() []uint64 {
+	lbls := make([]uint64, 0, len(rec.Labels))
+	for label := range rec.Labels {
+		lbls = append(lbls, label)
+	}
+	sort.Sort(labelSlice(lbls))
+	return lbls
+}
+
+type labelSlice []uint64
+
+func (s labelSlice) Len() int           { return len(s) }
+func (s labelSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s labelSlice) Less(i, j int) bool { return s[i] < s[j] }
+
+// labelHolds records the labels of each version held by an undo or redo, along with the
+// mutation ID of the undo or redo.
+type labelHolds struct {
+	sync.Mutex
+	held map[dvid.VersionID]map[uint64]uint64
+}
+
+// holdLabels prevents merges and splits of the labels, other than those with the given
+// mutation ID, until the labels are released.  It fails if any of the labels are held or
+// undergoing a merge or split.
+func (d *Data) holdLabels(v dvid.VersionID, mutID uint64, lbls []uint64) error {
+	d.holds.Lock()
+	defer d.holds.Unlock()
+
+	iv := d.getMergeIV(v)
+	for _, label := range lbls {
+		if _, found := d.holds.held[v][label]; found {
+			return fmt.Errorf("label %d is being undone or redone", label)
+		}
+		if labels.Mutating(iv, label) {
+			return fmt.Errorf("label %d is being merged or split", label)
+		}
+	}
+	if d.holds.held == nil {
+		d.holds.held = make(map[dvid.VersionID]map[uint64]uint64)
+	}
+	if d.holds.held[v] == nil {
+		d.holds.held[v] = make(map[uint64]uint64, len(lbls))
+	}
+	for _, label := range lbls {
+		d.holds.held[v][label] = mutID
+	}
+	return nil
+}
+
+func (d *Data) releaseLabels(v dvid.VersionID, lbls []uint64) {
+	d.holds.Lock()
+	defer d.holds.Unlock()
+
+	for _, label := range lbls {
+		delete(d.holds.held[v], label)
+	}
+	if len(d.holds.held[v]) == 0 {
+		delete(d.holds.held, v)
+	}
+}
+
+// startMutation calls start, which marks the labels as merging or splitting, unless any of
+// the labels are held by an undo or redo other than the one with the given mutation ID.
+func (d *Data) startMutation(v dvid.VersionID, mutID uint64, lbls []uint64, start func() error) error {
+	d.holds.Lock()
+	defer d.holds.Unlock()
+
+	for _, label := range lbls {
+		if holder, found := d.holds.held[v][label]; found && holder != mutID {
+			return fmt.Errorf("label %d is being undone or redone", label)
+		}
+	}
+	return start()
+}
+
+func putUndoRecord(txn *storage.Transaction, db storage.KeyValueBatcher, ctx storage.Context, mutID uint64, rec *undoRecord) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return err
+	}
+	txn.Put(db, ctx, undoTKey(mutID), buf.Bytes())
+	return nil
+}
+
+// getUndoRecord returns the undo record of a mutation or nil if there is none.
+func (d *Data) getUndoRecord(ctx storage.Context, mutID uint64) (*undoRecord, error) {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.Get(ctx, undoTKey(mutID))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	rec := new(undoRecord)
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// flattenRLEs returns the RLEs of all blocks in block order.
+func flattenRLEs(brles dvid.BlockRLEs) dvid.RLEs {
+	var rles dvid.RLEs
+	for _, izyx := range brles.SortedKeys() {
+		rles = append(rles, brles[izyx]...)
+	}
+	return rles
+}
+
+// containsRLEs returns whether all voxels of the given RLEs currently have the label, as
+// well as the total number of voxels with the label.
+func (d *Data) containsRLEs(v dvid.VersionID, label uint64, rles dvid.RLEs) (contained bool, numVoxels uint64, err error) {
+	current, err := d.GetLabelRLEs(v, label)
+	if err != nil {
+		return false, 0, err
+	}
+	numVoxels = current.NumVoxels()
+	split, err := rles.Partition(d.BlockSize)
+	if err != nil {
+		return false, 0, err
+	}
+	for izyx, splitRLEs := range split {
+		curRLEs, found := current[izyx]
+		if !found {
+			return false, numVoxels, nil
+		}
+		remain, err := curRLEs.Split(splitRLEs)
+		if err != nil {
+			return false, numVoxels, nil
+		}
+		curVoxels, _ := curRLEs.Stats()
+		remainVoxels, _ := remain.Stats()
+		splitVoxels, _ := splitRLEs.Stats()
+		if curVoxels-remainVoxels != splitVoxels {
+			return false, numVoxels, nil
+		}
+	}
+	return true, numVoxels, nil
+}
+
+// UndoMutation reverses a merge or split with the given mutation ID or, if redo is true,
+// reapplies an undone merge or split.  The inverse operation is performed through the
+// usual merge and split code so synced data is updated via DeltaMerge and DeltaSplit
+// events.  Operations whose labels were changed by later mutations can't be reversed.
+// The undo or redo itself is stored under the mutation ID undoID for journaling, and its
+// labels are held from the checks through the inverse operation.
+func (d *Data) UndoMutation(v dvid.VersionID, mutID, undoID uint64, redo bool) error {
+	ctx := datastore.NewVersionedCtx(d, v)
+	rec, err := d.getUndoRecord(ctx, mutID)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return fmt.Errorf("mutation %d of data %q is not a merge or split that can be undone", mutID, d.DataName())
+	}
+	if rec.Undone && !redo {
+		return fmt.Errorf("mutation %d of data %q is already undone", mutID, d.DataName())
+	}
+	if !rec.Undone && redo {
+		return fmt.Errorf("mutation %d of data %q has not been undone", mutID, d.DataName())
+	}
+
+	lbls := rec.sortedLabels()
+	held := append([]uint64{rec.Target}, lbls...)
+	if err := d.holdLabels(v, undoID, held); err != nil {
+		return err
+	}
+	defer d.releaseLabels(v, held)
+
+	// Check the record again now that its labels can't change.
+	if rec, err = d.getUndoRecord(ctx, mutID); err != nil {
+		return err
+	}
+	if rec.Undone != redo {
+		return fmt.Errorf("mutation %d of data %q was undone or redone concurrently", mutID, d.DataName())
+	}
+
+	rles := make(map[uint64]dvid.RLEs, len(lbls))
+	for _, label := range lbls {
+		var r dvid.RLEs
+		if err := r.UnmarshalBinary(rec.Labels[label]); err != nil {
+			return fmt.Errorf("bad undo record for mutation %d: %v", mutID, err)
+		}
+		rles[label] = r
+	}
+
+	// Labels split out of the target must still be entirely in the target with their own
+	// label unused, and labels merged into the target must be unchanged.
+	splitOut := (rec.Op == "merge") != redo
+	for _, label := range lbls {
+		if splitOut {
+			contained, _, err := d.containsRLEs(v, rec.Target, rles[label])
+			if err != nil {
+				return err
+			}
+			if !contained {
+				return fmt.Errorf("label %d no longer holds the voxels of label %d from mutation %d", rec.Target, label, mutID)
+			}
+			current, err := d.GetLabelRLEs(v, label)
+			if err != nil {
+				return err
+			}
+			if current.NumVoxels() != 0 {
+				return fmt.Errorf("label %d has been reused since mutation %d", label, mutID)
+			}
+		} else {
+			contained, numVoxels, err := d.containsRLEs(v, label, rles[label])
+			if err != nil {
+				return err
+			}
+			if size, _ := rles[label].Stats(); !contained || numVoxels != size {
+				return fmt.Errorf("label %d has changed since mutation %d", label, mutID)
+			}
+		}
+	}
+
+	if splitOut {
+		for _, label := range lbls {
+			if err := d.splitRLEs(v, rec.Target, label, rles[label], undoID); err != nil {
+				return err
+			}
+		}
+	} else {
+		op := labels.MergeOp{Target: rec.Target, Merged: make(labels.Set, len(lbls))}
+		for _, label := range lbls {
+			op.Merged[label] = struct{}{}
+		}
+		if err := d.mergeLabels(v, op, undoID); err != nil {
+			return err
+		}
+	}
+
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return err
+	}
+	batcher, ok := store.(storage.KeyValueBatcher)
+	if !ok {
+		return fmt.Errorf("Data type labelvol requires batch-enabled store, which %q is not\n", store)
+	}
+	rec.Undone = !redo
+	txn := storage.NewTransaction()
+	if err := putUndoRecord(txn, batcher, ctx, mutID, rec); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
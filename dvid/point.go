@@ -1566,6 +1566,16 @@ func (ext *Extents3d) VoxelWithin(pt Point3d) bool {
 	return true
 }
 
+// Intersects returns true if the given extents overlap these extents.
+func (ext *Extents3d) Intersects(other Extents3d) bool {
+	for dim := 0; dim < 3; dim++ {
+		if other.MaxPoint[dim] < ext.MinPoint[dim] || other.MinPoint[dim] > ext.MaxPoint[dim] {
+			return false
+		}
+	}
+	return true
+}
+
 // BlockWithin returns true if given block coord is within the extents partitioned
 // using the give block size, or false if it is not given a 3d point size or block coord.
 func (ext *Extents3d) BlockWithin(size Point3d, coord ChunkPoint3d) bool {
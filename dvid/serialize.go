@@ -14,6 +14,7 @@ import (
 	"hash/crc32"
 	"io"
 	_ "log"
+	"sync"
 
 	lz4 "github.com/janelia-flyem/go/golz4"
 	"github.com/golang/snappy"
@@ -24,6 +25,7 @@ import (
 type Compression struct {
 	format CompressionFormat
 	level  CompressionLevel
+	dict   uint32 // ID of a registered zstd dictionary or 0 if none.
 }
 
 func (c Compression) Format() CompressionFormat {
@@ -34,41 +36,66 @@ func (c Compression) Level() CompressionLevel {
 	return c.level
 }
 
+// Dictionary returns the ID of the zstd dictionary used for compression or 0 if none.
+func (c Compression) Dictionary() uint32 {
+	return c.dict
+}
+
 // MarshalJSON implements the json.Marshaler interface.
 func (c Compression) MarshalJSON() ([]byte, error) {
+	if c.dict != 0 {
+		return []byte(fmt.Sprintf(`{"Format":%d,"Level":%d,"Dictionary":%d}`, c.format, c.level, c.dict)), nil
+	}
 	return []byte(fmt.Sprintf(`{"Format":%d,"Level":%d}`, c.format, c.level)), nil
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (c *Compression) UnmarshalJSON(b []byte) error {
 	var m struct {
-		Format CompressionFormat
-		Level  CompressionLevel
+		Format     CompressionFormat
+		Level      CompressionLevel
+		Dictionary uint32
 	}
 	if err := json.Unmarshal(b, &m); err != nil {
 		return err
 	}
 	c.format = m.Format
 	c.level = m.Level
+	c.dict = m.Dictionary
 	return nil
 }
 
-// MarshalBinary fulfills the encoding.BinaryMarshaler interface.
+// MarshalBinary fulfills the encoding.BinaryMarshaler interface.  The dictionary ID is
+// only appended if set so compressions without dictionaries keep their 2 byte encoding.
 func (c Compression) MarshalBinary() ([]byte, error) {
-	return []byte{byte(c.format), byte(c.level)}, nil
+	if c.dict == 0 {
+		return []byte{byte(c.format), byte(c.level)}, nil
+	}
+	data := make([]byte, 6)
+	data[0] = byte(c.format)
+	data[1] = byte(c.level)
+	binary.LittleEndian.PutUint32(data[2:6], c.dict)
+	return data, nil
 }
 
 // UnmarshalBinary fulfills the encoding.BinaryUnmarshaler interface.
 func (c *Compression) UnmarshalBinary(data []byte) error {
-	if len(data) != 2 {
+	if len(data) != 2 && len(data) != 6 {
 		return fmt.Errorf("Cannot unmarshal %d bytes into Compression", len(data))
 	}
 	c.format = CompressionFormat(data[0])
 	c.level = CompressionLevel(data[1])
+	c.dict = 0
+	if len(data) == 6 {
+		c.dict = binary.LittleEndian.Uint32(data[2:6])
+	}
 	return nil
 }
 
 func (c Compression) String() string {
+	if c.dict != 0 {
+		return fmt.Sprintf("%s, level %d, dictionary %08x", c.format, c.level, c.dict)
+	}
 	return fmt.Sprintf("%s, level %d", c.format, c.level)
 }
 
@@ -80,23 +107,47 @@ func NewCompression(format CompressionFormat, level CompressionLevel) (Compressi
 	}
 	switch format {
 	case Uncompressed:
-		return Compression{format, DefaultCompression}, nil
+		return Compression{format: format, level: DefaultCompression}, nil
 	case Snappy:
-		return Compression{format, DefaultCompression}, nil
+		return Compression{format: format, level: DefaultCompression}, nil
 	case LZ4:
-		return Compression{format, DefaultCompression}, nil
+		return Compression{format: format, level: DefaultCompression}, nil
 	case Gzip:
 		if level != DefaultCompression && (level < 1 || level > 9) {
 			return Compression{}, fmt.Errorf("Gzip compression level must be between 1 and 9")
 		}
-		return Compression{format, level}, nil
+		return Compression{format: format, level: level}, nil
+	case Zstd:
+		return NewZstdCompression(level, 0)
 	default:
 		return Compression{}, fmt.Errorf("Unrecognized compression format requested: %d", format)
 	}
 }
 
+// NewZstdCompression returns a zstd Compression at the given level using an optional
+// dictionary, which must have been registered via RegisterZstdDictionary.  A dictionary
+// ID of 0 denotes no dictionary.
+func NewZstdCompression(level CompressionLevel, dict uint32) (Compression, error) {
+	if !ZstdAvailable {
+		return Compression{}, errZstdUnavailable
+	}
+	if level == DefaultCompression {
+		level = ZstdDefaultLevel
+	}
+	if level < 1 || level > ZstdMaxLevel {
+		return Compression{}, fmt.Errorf("Zstd compression level must be between 1 and %d", ZstdMaxLevel)
+	}
+	if dict != 0 {
+		if _, found := getZstdDictionary(dict); !found {
+			return Compression{}, fmt.Errorf("Zstd dictionary %08x has not been registered", dict)
+		}
+	}
+	return Compression{format: Zstd, level: level, dict: dict}, nil
+}
+
 // CompressionLevel goes from 1 (fastest) to 9 (highest compression)
-// as in deflate.  Default compression is -1 so need signed int8.
+// as in deflate.  Zstd levels go up to ZstdMaxLevel.  Default compression
+// is -1 so need signed int8.
 type CompressionLevel int8
 
 const (
@@ -104,6 +155,9 @@ const (
 	BestSpeed                           = 1
 	BestCompression                     = 9
 	DefaultCompression                  = -1
+
+	ZstdDefaultLevel CompressionLevel = 3
+	ZstdMaxLevel     CompressionLevel = 22
 )
 
 // CompressionFormat specifies the compression algorithm.
@@ -114,6 +168,9 @@ const (
 	Snappy                         = 1 << (iota - 1)
 	Gzip                           // Gzip stores length and checksum automatically.
 	LZ4
+
+	// Zstd takes an unused 3-bit code since the single-bit codes above are exhausted.
+	Zstd CompressionFormat = 3
 )
 
 func (format CompressionFormat) String() string {
@@ -126,11 +183,100 @@ func (format CompressionFormat) String() string {
 		return "LZ4 compression"
 	case Gzip:
 		return "gzip compression"
+	case Zstd:
+		return "zstd compression"
 	default:
 		return "Unknown compression"
 	}
 }
 
+var errZstdUnavailable = fmt.Errorf("zstd compression requires DVID built with the \"zstd\" tag")
+
+// zstdDicts holds zstd dictionaries available for compression and decompression.
+var zstdDicts = struct {
+	sync.RWMutex
+	m map[uint32][]byte
+}{m: make(map[uint32][]byte)}
+
+// ZstdDictionaryID returns the ID of a zstd dictionary, which is a CRC32 of its contents
+// with 0 reserved for no dictionary.
+func ZstdDictionaryID(dict []byte) uint32 {
+	id := crc32.ChecksumIEEE(dict)
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// RegisterZstdDictionary makes a zstd dictionary, e.g., one trained on a data instance's
+// blocks, available for serialization and returns its ID.  Since serialized data only
+// stores the dictionary ID, a dictionary must be registered before any data compressed
+// with it can be deserialized.
+func RegisterZstdDictionary(dict []byte) (uint32, error) {
+	if len(dict) == 0 {
+		return 0, fmt.Errorf("cannot register empty zstd dictionary")
+	}
+	id := ZstdDictionaryID(dict)
+	zstdDicts.Lock()
+	defer zstdDicts.Unlock()
+	if old, found := zstdDicts.m[id]; found && !bytes.Equal(old, dict) {
+		return 0, fmt.Errorf("zstd dictionary ID %08x collides with a different registered dictionary", id)
+	}
+	zstdDicts.m[id] = dict
+	return id, nil
+}
+
+func getZstdDictionary(id uint32) ([]byte, bool) {
+	zstdDicts.RLock()
+	defer zstdDicts.RUnlock()
+	dict, found := zstdDicts.m[id]
+	return dict, found
+}
+
+// compressZstd returns zstd compressed data preceded by the 4 byte ID of any dictionary used.
+func compressZstd(data []byte, compress Compression) ([]byte, error) {
+	var dict []byte
+	if compress.dict != 0 {
+		var found bool
+		if dict, found = getZstdDictionary(compress.dict); !found {
+			return nil, fmt.Errorf("zstd dictionary %08x has not been registered", compress.dict)
+		}
+	}
+	out, err := zstdCompress(data, int(compress.level), dict)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 4, 4+len(out))
+	binary.LittleEndian.PutUint32(b, compress.dict)
+	return append(b, out...), nil
+}
+
+// uncompressZstd reverses compressZstd, using the registered dictionary given by the data.
+func uncompressZstd(cdata []byte) ([]byte, error) {
+	if len(cdata) < 4 {
+		return nil, fmt.Errorf("zstd compressed data is only %d bytes", len(cdata))
+	}
+	var dict []byte
+	if id := binary.LittleEndian.Uint32(cdata[0:4]); id != 0 {
+		var found bool
+		if dict, found = getZstdDictionary(id); !found {
+			return nil, fmt.Errorf("zstd dictionary %08x needed for decompression has not been registered", id)
+		}
+	}
+	return zstdDecompress(cdata[4:], dict)
+}
+
+// ZstdCompress returns zstd compressed data at the default level without a dictionary,
+// e.g., for sending data to clients requesting zstd compression.
+func ZstdCompress(data []byte) ([]byte, error) {
+	return zstdCompress(data, int(ZstdDefaultLevel), nil)
+}
+
+// ZstdDecompress reverses ZstdCompress.
+func ZstdDecompress(cdata []byte) ([]byte, error) {
+	return zstdDecompress(cdata, nil)
+}
+
 // Checksum is the type of checksum employed for error checking stored data.
 // NOTE: Should be no more than 4 (2 bits) of checksum types.
 type Checksum uint8
@@ -223,6 +369,10 @@ func SerializeData(data []byte, compress Compression, checksum Checksum) ([]byte
 			return nil, err
 		}
 		byteData = b.Bytes()
+	case Zstd:
+		if byteData, err = compressZstd(data, compress); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("Illegal compression (%s) during serialization", compress)
 	}
@@ -261,9 +411,32 @@ func Serialize(object interface{}, compress Compression, checksum Checksum) ([]b
 	return SerializeData(buffer.Bytes(), compress, checksum)
 }
 
+// ChecksumError is returned when serialized data fails its stored checksum or lacks a
+// checksum that is required.
+type ChecksumError struct {
+	Required Checksum // checksum that was required, or NoChecksum if only stored one was tested.
+	Missing  bool     // true if the data did not carry the required checksum.
+	Stored   uint32
+	Computed uint32
+}
+
+func (e ChecksumError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("Data is missing required %s", e.Required)
+	}
+	return fmt.Sprintf("Bad checksum.  Stored %x got %x", e.Stored, e.Computed)
+}
+
 // DeserializeData deserializes a slice of bytes using stored compression, checksum.
 // If uncompress parameter is false, the data is not uncompressed.
 func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, error) {
+	return DeserializeCheckedData(s, uncompress, NoChecksum)
+}
+
+// DeserializeCheckedData deserializes a slice of bytes like DeserializeData but also
+// returns a ChecksumError if the data was not serialized with the required checksum.
+// Gzip-compressed data always passes since gzip has its own integrity checks.
+func DeserializeCheckedData(s []byte, uncompress bool, required Checksum) ([]byte, CompressionFormat, error) {
 	if s == nil || len(s) == 0 {
 		return []byte{}, Uncompressed, nil
 	}
@@ -275,6 +448,9 @@ func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, erro
 		return nil, 0, fmt.Errorf("Could not read serialization format info from %d byte input: %v", len(s), err)
 	}
 	compression, checksum := DecodeSerializationFormat(format)
+	if required != NoChecksum && checksum != required && compression != Gzip {
+		return nil, 0, ChecksumError{Required: required, Missing: true}
+	}
 
 	// Get any checksum.
 	var storedCrc32 uint32
@@ -296,7 +472,7 @@ func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, erro
 	case CRC32:
 		crcChecksum := crc32.ChecksumIEEE(cdata)
 		if crcChecksum != storedCrc32 {
-			return nil, 0, fmt.Errorf("Bad checksum.  Stored %x got %x", storedCrc32, crcChecksum)
+			return nil, 0, ChecksumError{Required: required, Stored: storedCrc32, Computed: crcChecksum}
 		}
 	}
 
@@ -336,6 +512,12 @@ func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, erro
             return nil, 0, err
         }
         return buffer.Bytes(), compression, nil
+    case Zstd:
+        data, err := uncompressZstd(cdata)
+        if err != nil {
+            return nil, 0, err
+        }
+        return data, compression, nil
     default:
         return nil, 0, fmt.Errorf("Illegal compression format (%d) in deserialization", compression)
     }
@@ -1,7 +1,10 @@
 package dvid
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
+
 	. "github.com/janelia-flyem/go/gocheck"
 )
 
@@ -31,7 +34,7 @@ func (suite *DataSuite) TestSerialization(c *C) {
 		},
 	}
 
-	for _, format := range []CompressionFormat{Uncompressed, Snappy, LZ4, Gzip} {
+	for _, format := range testFormats() {
 		for _, checksum := range []Checksum{NoChecksum, CRC32} {
 			compression, err := NewCompression(format, DefaultCompression)
 			c.Assert(err, IsNil)
@@ -75,6 +78,96 @@ func (suite *DataSuite) TestSerialization(c *C) {
 	}
 }
 
+func (suite *DataSuite) TestRequiredChecksum(c *C) {
+	data := []byte("some block data that should be protected")
+	for _, format := range testFormats() {
+		compression, err := NewCompression(format, DefaultCompression)
+		c.Assert(err, IsNil)
+
+		s, err := SerializeData(data, compression, NoChecksum)
+		c.Assert(err, IsNil)
+		_, _, err = DeserializeCheckedData(s, true, CRC32)
+		if format == Gzip {
+			c.Assert(err, IsNil)
+		} else {
+			csumErr, ok := err.(ChecksumError)
+			c.Assert(ok, Equals, true, Commentf("format %s did not require checksum", format))
+			c.Assert(csumErr.Missing, Equals, true)
+		}
+
+		s, err = SerializeData(data, compression, CRC32)
+		c.Assert(err, IsNil)
+		out, _, err := DeserializeCheckedData(s, true, CRC32)
+		c.Assert(err, IsNil)
+		c.Assert(out, DeepEquals, data)
+	}
+}
+
+// testFormats returns the compression formats available in this build.
+func testFormats() []CompressionFormat {
+	formats := []CompressionFormat{Uncompressed, Snappy, LZ4, Gzip}
+	if ZstdAvailable {
+		formats = append(formats, Zstd)
+	}
+	return formats
+}
+
+func (suite *DataSuite) TestZstdCompression(c *C) {
+	if !ZstdAvailable {
+		_, err := NewZstdCompression(DefaultCompression, 0)
+		c.Assert(err, NotNil, Commentf("zstd compression should require the zstd build tag"))
+		return
+	}
+	data := bytes.Repeat([]byte("label 23 label 23 label 42 "), 100)
+
+	_, err := NewZstdCompression(23, 0)
+	c.Assert(err, NotNil)
+	_, err = NewZstdCompression(DefaultCompression, 1234)
+	c.Assert(err, NotNil, Commentf("unregistered dictionary should not be allowed"))
+
+	dict := []byte("label 23 label 42 label 0")
+	id, err := RegisterZstdDictionary(dict)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, ZstdDictionaryID(dict))
+
+	for _, dictID := range []uint32{0, id} {
+		compression, err := NewZstdCompression(19, dictID)
+		c.Assert(err, IsNil)
+		c.Assert(compression.Dictionary(), Equals, dictID)
+
+		s, err := SerializeData(data, compression, CRC32)
+		c.Assert(err, IsNil)
+		c.Assert(len(s) < len(data), Equals, true)
+		out, format, err := DeserializeData(s, true)
+		c.Assert(err, IsNil)
+		c.Assert(format, Equals, Zstd)
+		c.Assert(out, DeepEquals, data)
+
+		b, err := compression.MarshalBinary()
+		c.Assert(err, IsNil)
+		var compression2 Compression
+		c.Assert(compression2.UnmarshalBinary(b), IsNil)
+		c.Assert(compression2, Equals, compression)
+
+		j, err := json.Marshal(compression)
+		c.Assert(err, IsNil)
+		var compression3 Compression
+		c.Assert(json.Unmarshal(j, &compression3), IsNil)
+		c.Assert(compression3, Equals, compression)
+	}
+
+	// Data compressed with a dictionary can't be read without it.
+	compression, err := NewZstdCompression(DefaultCompression, id)
+	c.Assert(err, IsNil)
+	s, err := SerializeData(data, compression, NoChecksum)
+	c.Assert(err, IsNil)
+	zstdDicts.Lock()
+	delete(zstdDicts.m, id)
+	zstdDicts.Unlock()
+	_, _, err = DeserializeData(s, true)
+	c.Assert(err, NotNil)
+}
+
 func (suite *DataSuite) testUncompressed(b *testing.B, checksum Checksum) {
 	stringObj := "Hi there!"
 	var returnObj string
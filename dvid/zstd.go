@@ -0,0 +1,49 @@
+// +build zstd
+
+/*
+	This file supports zstd compression through the zstd C library, which requires cgo
+	and building DVID with the "zstd" tag.
+*/
+
+package dvid
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/DataDog/zstd"
+)
+
+// ZstdAvailable is true if DVID was built with zstd support.
+const ZstdAvailable = true
+
+func zstdCompress(data []byte, level int, dict []byte) ([]byte, error) {
+	if len(dict) == 0 {
+		return zstd.CompressLevel(nil, data, level)
+	}
+	var b bytes.Buffer
+	w := zstd.NewWriterLevelDict(&b, level, dict)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func zstdDecompress(cdata []byte, dict []byte) ([]byte, error) {
+	if len(dict) == 0 {
+		return zstd.Decompress(nil, cdata)
+	}
+	r := zstd.NewReaderDict(bytes.NewReader(cdata), dict)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
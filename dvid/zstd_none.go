@@ -0,0 +1,14 @@
+// +build !zstd
+
+package dvid
+
+// ZstdAvailable is true if DVID was built with zstd support.
+const ZstdAvailable = false
+
+func zstdCompress(data []byte, level int, dict []byte) ([]byte, error) {
+	return nil, errZstdUnavailable
+}
+
+func zstdDecompress(cdata []byte, dict []byte) ([]byte, error) {
+	return nil, errZstdUnavailable
+}
@@ -7,6 +7,7 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -36,6 +37,12 @@ Commands executed on the server (rpc address = %s):
 	
 	repos delete <UUID> <repo passcode if any>
 
+	repos import <archive file>
+
+		Adds the repo in an archive file written by "repo <UUID> export" to this server.
+		The archive is verified as it is read, and none of its versions can already be
+		present on this server.  Runs in the background and logs the result.
+
 	repo <UUID> branch [optional UUID]
 
 	repo <UUID> new <datatype name> <data name> <datatype-specific config>...
@@ -44,8 +51,49 @@ Commands executed on the server (rpc address = %s):
 
 	repo <UUID> rename <old data name> <new data name> <repo passcode if any>
 
+	repo <UUID> gc <settings...>
+
+		Deletes key-value pairs that can never be returned from any version in the repo's
+		DAG, i.e., values from versions no longer in the DAG and tombstones that no longer
+		mask any ancestor value.  Runs in the background and logs the bytes reclaimed.
+		Progress is available via GET /api/repo/{uuid}/gc.
+
+		dryrun=true
+
+			Only reports what would be deleted.
+
+		batchsize=<n>
+
+			Number of key-value pairs deleted per batch (default 1000).
+
 	node <UUID> <data name> <type-specific commands>
 
+	node <UUID> <data name> scrub
+
+		Reads every value of the data instance visible at the given version and verifies it
+		against the instance's checksum.  Replies with a JSON report of any corrupt keys,
+		including block coordinates for block-indexed data.  Only data types with serialized
+		values, e.g., imageblk, labelblk, and keyvalue, support scrubbing.
+
+	storage resync <store alias> <settings...>
+
+		Reconciles a mirrored store after one of its stores was unavailable by making the
+		stale store an exact copy of the other.  Writes are briefly blocked while each batch
+		of keys is reconciled.  Progress is shown in the "Stats" for the store returned by
+		the /api/storage HTTP endpoint.
+
+		source=<store alias>
+
+			If supplied, the given store is copied to the other store.  By default, the
+			store that is not stale is copied, or the primary store if neither is stale.
+
+	storage rekey <store alias>
+
+		Re-encrypts all values of an encrypted store that were not encrypted with the
+		newest key in its key file.  Writes are briefly blocked while each batch of values
+		is re-encrypted.  Progress is shown in the "Stats" for the store returned by the
+		/api/storage HTTP endpoint.
+
 EXPERIMENTAL COMMANDS
 
 	repo <UUID> migrate <instance name> <old store config nickname> <settings...>
@@ -85,7 +133,13 @@ EXPERIMENTAL COMMANDS
 	repo <UUID> push <remote DVID address> <settings...>
 
         A DVID-to-DVID repo copy with optional datatype-specific delimiter,
-		where <settings> are optional "key=value" strings:
+		where <settings> are optional "key=value" strings.  Only versions the
+		remote lacks are sent, along with all versions of data instances the
+		remote lacks, so a repo can be pushed again to send new versions or data.
+		Progress is checkpointed, and if a push is interrupted, the next push of
+		the repo to the same remote with the same settings resumes it.  With
+		other settings, data instances left unfinished are sent again in full.
+		Throughput and an ETA are logged as the push runs.
 
 		data=<data1>[,<data2>[,<data3>...]]
 		
@@ -109,6 +163,23 @@ EXPERIMENTAL COMMANDS
 			A transmit "branch" will send just the ancestor path of the
 			version specified.
 
+	repo <UUID> pull <remote DVID address> <settings...>
+
+		Adds a repo from a remote DVID server to this server, where the full UUID
+		is that of the remote node to pull.  The remote is sent the same "data",
+		"filter", and "transmit" settings used for push and applies them just as
+		it would for a push from the remote.  Only versions and data instances
+		this server lacks are pulled, so a repo can be pulled again to add newer
+		versions.  Key-values of a failed pull are deleted.  Runs in the
+		background and logs progress.
+
+	repo <UUID> export <archive file> <settings...>
+
+		Writes the repo to a portable archive file on this server that can be added to
+		another DVID server using "repos import".  The settings are the same "data",
+		"filter", and "transmit" settings used for push.  Runs in the background and
+		logs the result.
+
 	repo <UUID> merge <UUID> [, <UUID>, ...]
 
 		This requires all UUIDs to be committed and generates a new
@@ -200,6 +271,54 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			reply.Text = typeservice.Help()
 		}
 
+	case "storage":
+		var subcommand, alias string
+		cmd.CommandArgs(1, &subcommand, &alias)
+
+		switch subcommand {
+		case "resync":
+			var store dvid.Store
+			if store, err = storage.GetStoreByAlias(storage.Alias(alias)); err != nil {
+				return
+			}
+			resyncer, ok := store.(storage.Resyncer)
+			if !ok {
+				err = fmt.Errorf("store %q cannot be resynced", alias)
+				return
+			}
+			var source string
+			if source, _, err = cmd.Settings().GetString("source"); err != nil {
+				return
+			}
+			go func() {
+				if err := resyncer.Resync(storage.Alias(source)); err != nil {
+					dvid.Errorf("resync error: %v\n", err)
+				}
+			}()
+			reply.Text = fmt.Sprintf("Started resync of store %q...\n", alias)
+
+		case "rekey":
+			var store dvid.Store
+			if store, err = storage.GetStoreByAlias(storage.Alias(alias)); err != nil {
+				return
+			}
+			rekeyer, ok := store.(storage.Rekeyer)
+			if !ok {
+				err = fmt.Errorf("store %q cannot be rekeyed", alias)
+				return
+			}
+			go func() {
+				if err := rekeyer.Rekey(); err != nil {
+					dvid.Errorf("rekey error: %v\n", err)
+				}
+			}()
+			reply.Text = fmt.Sprintf("Started rekey of store %q...\n", alias)
+
+		default:
+			err = fmt.Errorf("Unknown storage command: %q", subcommand)
+			return
+		}
+
 	case "repos":
 		var subcommand string
 		cmd.CommandArgs(1, &subcommand)
@@ -251,6 +370,20 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			}
 			reply.Text = fmt.Sprintf("Started deletion of repo %s.\n", uuid)
 
+		case "import":
+			var filename string
+			cmd.CommandArgs(2, &filename)
+			if filename == "" {
+				err = fmt.Errorf("repos import requires an archive file")
+				return
+			}
+			go func() {
+				if _, err := datastore.ImportRepo(filename); err != nil {
+					dvid.Errorf("import error: %v\n", err)
+				}
+			}()
+			reply.Text = fmt.Sprintf("Started import of repo archive %q...\n", filename)
+
 		default:
 			err = fmt.Errorf("Unknown repos command: %q", subcommand)
 			return
@@ -259,6 +392,22 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 	case "repo":
 		var uuidStr, subcommand string
 		cmd.CommandArgs(1, &uuidStr, &subcommand)
+
+		// A pulled repo isn't on this server yet, so its UUID must be given in full.
+		if subcommand == "pull" {
+			var target string
+			cmd.CommandArgs(3, &target)
+			uuid := dvid.UUID(uuidStr)
+			config := cmd.Settings()
+			go func() {
+				if err := datastore.PullRepo(uuid, target, config); err != nil {
+					dvid.Errorf("pull error: %v\n", err)
+				}
+			}()
+			reply.Text = fmt.Sprintf("Started pull of repo %s from %q...\n", uuid, target)
+			return
+		}
+
 		var uuid dvid.UUID
 		if uuid, _, err = datastore.MatchingUUID(uuidStr); err != nil {
 			return
@@ -353,6 +502,12 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			}()
 			reply.Text = fmt.Sprintf("Started migration of uuid %s data instance %q from old store %q...\n", uuid, source, oldStoreName)
 
+		case "gc":
+			if err = datastore.StartGarbageCollection(uuid, cmd.Settings()); err != nil {
+				return
+			}
+			reply.Text = fmt.Sprintf("Started garbage collection of repo %s...\n", uuid)
+
 		case "copy":
 			var source, target string
 			cmd.CommandArgs(3, &source, &target)
@@ -375,16 +530,20 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			}()
 			reply.Text = fmt.Sprintf("Started push of repo %s to %q...\n", uuid, target)
 
-			/*
-				case "pull":
-					var target string
-					cmd.CommandArgs(3, &target)
-					config := cmd.Settings()
-					if err = datastore.Pull(uuid, target, config); err != nil {
-						return
-					}
-					reply.Text = fmt.Sprintf("Repo %s pulled from %q\n", uuid, target)
-			*/
+		case "export":
+			var filename string
+			cmd.CommandArgs(3, &filename)
+			if filename == "" {
+				err = fmt.Errorf("repo export requires an archive file")
+				return
+			}
+			config := cmd.Settings()
+			go func() {
+				if err := datastore.ExportRepo(uuid, filename, config); err != nil {
+					dvid.Errorf("export error: %v\n", err)
+				}
+			}()
+			reply.Text = fmt.Sprintf("Started export of repo %s to %q...\n", uuid, filename)
 
 		case "delete":
 			var dataname, passcode string
@@ -428,6 +587,18 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			reply.Text = dataservice.Help()
 			return
 		}
+		if subcommand == "scrub" {
+			var report *datastore.ScrubReport
+			if report, err = datastore.Scrub(uuid, dataname); err != nil {
+				return
+			}
+			var jsonBytes []byte
+			if jsonBytes, err = json.MarshalIndent(report, "", "  "); err != nil {
+				return
+			}
+			reply.Text = string(jsonBytes)
+			return
+		}
 		err = dataservice.DoRPC(*cmd, reply)
 		return
 
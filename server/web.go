@@ -6,17 +6,20 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -101,6 +104,46 @@ const WebHelp = `
 		"Bytes": ...
 	}
 
+GET  /api/storage/stats
+
+	Returns a JSON object with the runtime statistics of each backend store that keeps them,
+	e.g., tiered cache stores with their hit and miss counts, where the key is the backend 
+	store name.
+
+ GET  /api/storage/accounting
+
+	Returns JSON with the progress of any storage accounting scan and the cached results of
+	the last completed scan.  Results give, for each backend store and local instance ID,
+	the keys and bytes of the instance broken down by version UUID and by type-specific key
+	class:
+
+	{
+		"Running": false,
+		"Started": "2016-05-11T10:21:43.192-04:00",
+		"Completed": "2016-05-11T10:24:12.838-04:00",
+		"Progress": {"Store": "", "StoresDone": 1, "StoresTotal": 1, "KeysScanned": ..., 
+			"BytesScanned": ..., "BytesEstimated": ...},
+		"Stores": {
+			"default": {
+				"5": {
+					"Name": "bodies",
+					"DataType": "labelvol",
+					"DataUUID": ...,
+					"Total": {"Keys": ..., "Bytes": ...},
+					"Tombstones": {"Keys": ..., "Bytes": ...},
+					"Versions": {"<UUID>": {"Keys": ..., "Bytes": ...}, ...},
+					"KeyClasses": {"227": {"Name": "sparsevol", "Keys": ..., "Bytes": ...}, ...}
+				},
+				...
+			}
+		}
+	}
+
+POST  /api/storage/accounting
+
+	Starts a background storage accounting scan of all backend stores.  Only one scan can run 
+	at a time.  Use GET to monitor progress and retrieve results.
+
  GET  /api/server/info
 
 	Returns JSON for server properties.
@@ -118,7 +161,19 @@ const WebHelp = `
  GET  /api/server/groupcache
 
  	Returns JSON for groupcache statistics for this server.  See github.com/golang/groupcache package
-	Stats and CacheStats for MainCache and HotCache.
+	Stats and CacheStats for MainCache and HotCache.  The "Peers" object gives, for each peer URL,
+	the number of keys requested from that peer, errors, bytes received, and average latency.
+
+ GET  /api/server/groupcache/peers
+
+	Returns JSON for the groupcache peers of this server other than itself:
+	{ "peers": ["http://10.0.0.2:8003", "http://10.0.0.3:8003"] }
+
+POST  /api/server/groupcache/peers
+
+	Replaces the groupcache peers at runtime.  Expects JSON of the same form as the GET.  Keys
+	are redistributed among this server and the new peers by consistent hashing, and all
+	DVID frontends sharing the cache should be given the same set of peers.
 
 POST  /api/server/settings
 
@@ -204,6 +259,13 @@ Repo-Level REST endpoints
 	REQUIRED "dataname"   Name of the new instance
 	OPTIONAL "versioned"  If "false" or "0", the data is unversioned and acts as if 
 	                      all UUIDs within a repo become the root repo UUID.  (True by default.)
+	OPTIONAL "Compression"  Compression of stored values: "none", "snappy", "lz4" (default), 
+	                      "gzip", "gzip:<level>", "zstd", or "zstd:<level>" where zstd levels
+	                      go from 1 to 22.  Zstd requires DVID built with the "zstd" tag.
+	OPTIONAL "ZstdDictionary"  Base64-encoded zstd dictionary, e.g., one trained on typical
+	                      blocks via "zstd --train", used by zstd compression of new values.
+	                      Previous dictionaries are kept to read older values.  Use "none"
+	                      to stop using a dictionary.
 	
   GET /api/repo/{uuid}/log
  POST /api/repo/{uuid}/log
@@ -234,7 +296,7 @@ Repo-Level REST endpoints
 
 	The elements of the JSON object are:
 
-		mergeType:  must be "conflict-free".  We will introduce other merge options in future.
+		mergeType:  must be "conflict-free" or "three-way".
 		parents:    a list of the parent UUIDs to be merged. 
 		note:       any note that should be set for the child version.
 
@@ -244,6 +306,172 @@ Repo-Level REST endpoints
 
 	The response includes the UUID of the new merged, child node.
 
+	A "three-way" merge requires exactly two parents.  Keys changed in both parents since
+	their nearest common ancestor are merged into the child by datatype-specific code:
+	keyvalue values must be identical and labelblk blocks are merged voxel by voxel.  Changes
+	that really conflict keep the value of the first parent and are listed in the response:
+
+	{
+		"child": "3f01a8856",
+		"ancestor": "2b8e3f1a7",
+		"instances": {
+			"segmentation": {
+				"taken": 213,
+				"merged": 12,
+				"conflicts": [ { "key": "(10,3,2)", "conflicts": 57 } ]
+			}
+		},
+		"skipped": [ "grayscale" ]
+	}
+
+	"taken" is the number of keys changed in only one parent, "merged" is the number of keys
+	changed in both parents without conflict, and "conflicts" gives the number of conflicting
+	elements, e.g., voxels, for each conflicting key.  Data instances of datatypes that do
+	not support three-way merges are listed under "skipped" and merged as if conflict-free.
+
+ POST /api/repo/{uuid}/instance/{data name}/migrate
+
+	Moves all key-value pairs of a data instance to another store while the server keeps
+	serving requests.  Expects JSON with the alias of a store in the configuration TOML:
+
+	{ "store": "ssd" }
+
+	The copy runs in the background while mutations are written to both the old and new
+	stores.  When the copy completes, the instance switches to the new store, the new
+	assignment is saved so it overrides the [backend] configuration on restart, and the
+	instance's data is deleted from the old store.
+
+ GET /api/repo/{uuid}/instance/{data name}/migrate
+
+	Returns JSON describing the last or current online migration of the data instance:
+
+	{
+		"Data": "grayscale",
+		"From": "basholeveldb @ /data/dbs/basholeveldb",
+		"To": "ssd",
+		"Running": false,
+		"Started": "2016-11-02T10:21:07.1-04:00",
+		"Switched": "2016-11-02T11:03:55.2-04:00",
+		"Finished": "2016-11-02T11:04:12.9-04:00",
+		"Copied": 1203944,
+		"Bytes": 92833020011
+	}
+
+ POST /api/repo/{uuid}/gc
+
+	Starts a background garbage collection that deletes key-value pairs for all data instances
+	in the repo that can never be returned from any version in the repo's DAG, i.e., values
+	from versions no longer in the DAG and tombstones that no longer mask any ancestor value.
+	Unversioned data stored at version 0 is never collected.  Only one collection per repo
+	can run at a time.
+
+	Query-string Options:
+
+	dryrun        If "true", nothing is deleted but the results are computed.
+	batchsize     Number of key-value pairs deleted per batch (default 1000).
+
+ GET /api/repo/{uuid}/gc
+
+	Returns JSON describing the last or current garbage collection of the repo.  "Data" is
+	the data instance being collected while "Running" is true.
+
+	{
+		"DryRun": false,
+		"Running": false,
+		"Started": "2016-11-02T10:21:07.1-04:00",
+		"Finished": "2016-11-02T10:23:10.2-04:00",
+		"Instances": 3,
+		"Scanned": 1000000,
+		"Deleted": 12034,
+		"Orphans": 11000,
+		"Tombstones": 1034,
+		"BytesReclaimed": 391045632,
+		"Elapsed": "2m3.1s"
+	}
+
+ POST /api/repo/{uuid}/squash
+
+	Collapses a linear chain of committed nodes in the repo into its first node, e.g., to
+	prune a long series of small commits.  The post body should be JSON of the following
+	format:
+
+	{
+		"from": "ancestor-uuid",
+		"to": "descendant-uuid",
+		"note": "optional note for the squashed node"
+	}
+
+	Every node from the ancestor down to the descendant must be committed, and each node
+	except the descendant must have exactly one child.  The ancestor keeps its UUID and gets
+	the data as of the descendant, the logs of all squashed nodes, and the children of the
+	descendant.  The UUIDs of squashed nodes still resolve to the ancestor.  If no note is
+	given, the descendant's note is used.  A JSON response will be sent with the following
+	format:
+
+	{ "node": "3f01a8856", "squashed": [ "8a90ec2", "99ef22c" ] }
+
+ GET  /api/repo/{uuid}/refs
+ POST /api/repo/{uuid}/refs
+
+	Gets or sets the named refs of the repo.  A branch is a mutable name that follows the
+	newest descendant of its node: when a child version is created from the node, or the node
+	is the first parent of a merge, the branch moves to the new child.  A tag is an immutable
+	name for a committed node.  Refs can be used anywhere a UUID is accepted by prefixing the
+	name with a colon, e.g., "/api/node/:master/segmentation/..." uses the node of the
+	"master" branch.  If more than one repo has a ref with that name, limit the search by
+	prefixing with a partial UUID of the repo, e.g., "/api/node/3f8c:master/...".
+
+	A GET returns a sorted list of refs:
+
+	[
+		{ "name": "master", "type": "branch", "uuid": "8a90ec2...", "updated": "..." },
+		{ "name": "v1.0", "type": "tag", "uuid": "3f01a88...", "updated": "..." }
+	]
+
+	A POST creates or moves a ref and should have JSON of the following format:
+
+	{ "name": "master", "type": "branch", "uuid": "8a90ec2" }
+
+	The "type" is "branch" (default) or "tag".  Names can't contain ':', '/' or whitespace.
+	Tags can't be moved or deleted.
+
+ DELETE /api/repo/{uuid}/refs/{name}
+
+	Deletes the given branch.
+
+ GET  /api/repo/{uuid}/webhooks
+ POST /api/repo/{uuid}/webhooks
+
+	Gets or adds webhooks: URLs that are POSTed a JSON summary of sync events, e.g., merges
+	and splits, for a data instance or for all data instances of the repo.  A POST should
+	have JSON of the following format:
+
+	{ "url": "http://mesher:8000/changed", "data": "bodies", "events": [ "MERGE_END", "SPLIT_END" ] }
+
+	The "data" is optional and, if omitted, events from every data instance of the repo are
+	sent.  Useful events include "MERGE_END", "SPLIT_END", "BLOCK_MUTATE" and
+	"ANNOTATION_MOD_ELEMENTS".  The new webhook with its "id" is returned, and a GET returns a
+	list of all webhooks.  Each event is POSTed as JSON of the following format:
+
+	{
+		"hook": "<webhook id>",
+		"event": "MERGE_END",
+		"repo": "<root uuid>",
+		"uuid": "<uuid of node>",
+		"data": "bodies",
+		"time": "...",
+		"delta": { "target": 2, "merged": [3] }
+	}
+
+	The "delta" is an event-specific summary and is omitted if the event has none.  Failed
+	deliveries, including non-2xx responses, are retried with exponential backoff, which
+	only delays later events of the same webhook.  Webhooks are not transferred to other
+	servers on push.
+
+ DELETE /api/repo/{uuid}/webhooks/{id}
+
+	Deletes the given webhook.
+
  POST /api/repo/{uuid}/resolve
 
 	Forces a merge of a set of committed parent UUIDs into a child by specifying a
@@ -282,6 +510,15 @@ Repo-Level REST endpoints
 Node-Level REST endpoints
 -------------------------
 
+ DELETE /api/node/{uuid}
+
+	Deletes an uncommitted leaf node and all key-value pairs written to that version in
+	every data instance of the repo.  Use this to remove abandoned branches.  The root
+	node cannot be deleted; delete the repo instead.  A JSON response will be sent with
+	the following format:
+
+	{ "deleted": "3f01a8856" }
+
   GET /api/node/{uuid}/log
  POST /api/node/{uuid}/log
 
@@ -300,6 +537,10 @@ Node-Level REST endpoints
 
 	{ "Locked": true }
 
+	If the node is checked out (see below), the lease holder is also returned:
+
+	{ "Locked": false, "Lease": { "owner": "jane", "acquired": "...", "expires": "..." } }
+
  POST /api/node/{uuid}/commit
 
 	Commits (locks) the node/version with given UUID.  This is required before a version can 
@@ -318,6 +559,38 @@ Node-Level REST endpoints
 
 	{ "committed": "3f01a8856" }
 
+ POST /api/node/{uuid}/checkout
+
+	Checks out an uncommitted node so only the owner can modify it for a time.  The post
+	body should be JSON of the following format:
+
+	{ "owner": "jane", "ttl": 600 }
+
+	where "ttl" is the lease duration in seconds, up to one day.  A JSON response will be
+	sent with the following format:
+
+	{ "owner": "jane", "token": "8a90ec2...", "acquired": "...", "expires": "..." }
+
+	While the lease is active, any request other than GET or HEAD on the node, including its
+	data instances, is refused unless it carries the token either in an "X-Dvid-Lease" header
+	or a "lease" query string.  Leases end on release, expiration, or commit of the node and
+	are not kept across server restarts.
+
+ POST /api/node/{uuid}/renew
+
+	Extends a lease to a new duration from now.  The post body should be JSON of the
+	following format:
+
+	{ "token": "8a90ec2...", "ttl": 600 }
+
+	The response is the same as for checkout.
+
+ POST /api/node/{uuid}/release
+
+	Ends a lease.  The post body should be JSON of the following format:
+
+	{ "token": "8a90ec2..." }
+
  POST /api/node/{uuid}/branch
 
 	Creates a new child node (version) of the node with given UUID.  
@@ -332,6 +605,114 @@ Node-Level REST endpoints
 
 	The response includes the UUID of the new child node.
 
+ GET /api/node/{uuid}/{data name}/scrub
+
+	Reads every value of the data instance visible at the given version and verifies it
+	against the instance's checksum.  Returns a JSON report like the following:
+
+	{
+		"DataName": "grayscale",
+		"UUID": "3f01a8856...",
+		"Checksum": "CRC32 checksum",
+		"Scanned": 1200,
+		"Bytes": 39321600,
+		"Skipped": 0,
+		"Corrupt": [
+			{ "Key": "17000000...", "Decoded": "(10, 3, 2)", "Error": "Bad checksum.  Stored 5a1c got 9e02" }
+		],
+		"Elapsed": "1.56s"
+	}
+
+	Only data types with serialized values, e.g., imageblk, labelblk, and keyvalue, support
+	scrubbing.
+
+ GET /api/node/{uuid}/{data name}/diff/{other uuid}
+
+	Lists what changed in the data instance going from the version {uuid} to the version
+	{other uuid}, which must be in the same repo but need not be a descendant.  Only stored
+	keys are read, so whole volumes are never materialized.  Returns JSON like:
+
+	{
+		"added": [ ... ],
+		"removed": [ ... ],
+		"modified": [ ... ]
+	}
+
+	The listed items depend on the data type:
+
+		imageblk, labelblk:  block coordinates, e.g., [10, 3, 2]
+		keyvalue:            keys
+		annotation:          elements, where modified elements are given as they are at
+		                     {other uuid} and moved elements are removed and then added
+		labelvol:            labels, where added labels have no voxels at {uuid} and removed
+		                     labels have no voxels at {other uuid}
+
+ GET  /api/node/{uuid}/{data name}/mutations[?since=<mutation id>]
+ POST /api/node/{uuid}/{data name}/mutations
+
+	Each data instance keeps an append-only journal of mutations for each version, covering
+	labelvol merges and splits, labelblk raw POSTs, annotation element POSTs, DELETEs and
+	moves, and keyvalue POSTs and DELETEs.  The user making a mutation can be recorded by
+	adding a "u=<user>" query string to the mutation request.  A mutation that succeeds but
+	can't be journaled returns an error.  Label volumes posted to labelblk are too large to
+	keep, so their entries record only the payload size and SHA-256 hash in "payload_size"
+	and "payload_sha256" in place of "payload".
+
+	A GET returns the journal entries of the version {uuid} in order, optionally only those
+	after the given mutation id:
+
+	[
+		{
+			"id": 12,
+			"time": "2016-10-03T11:12:55.1-04:00",
+			"user": "jane",
+			"method": "POST",
+			"endpoint": "merge",
+			"query": "u=jane",
+			"payload": "<base64 encoded request body>",
+			"result": ""
+		}, ...
+	]
+
+	A POST replays the journal of another instance of the same type into this instance by
+	re-issuing each mutation against it.  The post body should be JSON of the following
+	format:
+
+	{ "uuid": "source-uuid", "data": "source data name", "since": 0 }
+
+	Replay stops at the first mutation that fails or whose payload wasn't kept.  A JSON
+	response will be sent with the following format:
+
+	{ "replayed": 12, "last": 37 }
+
+ GET  /api/node/{uuid}/{data name}/events[?events=<csv>&minx=...&maxz=...&lastid=<id>]
+
+	Streams the sync events of the data instance at version {uuid}, e.g., merges, splits and
+	block mutations, as server-sent events (content type "text/event-stream"):
+
+	id: 1476468000000000000-42
+	event: MERGE_END
+	data: {"id": "1476468000000000000-42", "event": "MERGE_END", "uuid": "...", "time": "...", "delta": {...}}
+
+	Event ids are an epoch identifying the run of the server followed by a number that
+	increases for each event of the instance.  Events that change known regions of space
+	have an "extents" with "MinPoint" and "MaxPoint" voxel coordinates.  Block changes are
+	located for instances stored in blocks, e.g., labelblk.  The optional query strings are:
+
+		events:  Comma-separated event types to send, e.g., "MERGE_END,SPLIT_END".
+		minx, maxx, miny, maxy, minz, maxz:  Voxel bounds.  Only events whose extents
+		         intersect the bounds are sent.  Events without extents are always sent.
+		lastid:  Resume after the given event id.  The "Last-Event-ID" header sent by
+		         reconnecting EventSource clients takes precedence.
+
+	Recent events are kept so streams can be resumed.  A client that can't keep up with
+	events is disconnected rather than delaying mutations, and should reconnect with the
+	id of the last event it received.  Resuming fails if events after that id may have been
+	missed, e.g., after a server restart, in which case the client should reload its state
+	and reconnect without an id.  Kept events are only located if a stream of their version
+	was open, so resumed events without extents are sent regardless of bounds.  Streams are
+	also closed after the server's write timeout.
+
 		</pre>
 
 		<h4>Data type commands</h4>
@@ -499,6 +880,9 @@ func initRoutes() {
 	mainMux.Get("/api/help/:typename", typehelpHandler)
 
 	mainMux.Get("/api/storage", serverStorageHandler)
+	mainMux.Get("/api/storage/stats", serverStorageStatsHandler)
+	mainMux.Get("/api/storage/accounting", serverAccountingHandler)
+	mainMux.Post("/api/storage/accounting", serverStartAccountingHandler)
 
 	mainMux.Get("/api/server/info", serverInfoHandler)
 	mainMux.Get("/api/server/info/", serverInfoHandler)
@@ -506,6 +890,8 @@ func initRoutes() {
 	mainMux.Get("/api/server/types/", serverTypesHandler)
 	mainMux.Get("/api/server/compiled-types", serverCompiledTypesHandler)
 	mainMux.Get("/api/server/compiled-types/", serverCompiledTypesHandler)
+	mainMux.Get("/api/server/groupcache/peers", serverGroupcachePeersHandler)
+	mainMux.Post("/api/server/groupcache/peers", serverSetGroupcachePeersHandler)
 	mainMux.Get("/api/server/groupcache", serverGroupcacheHandler)
 	mainMux.Get("/api/server/groupcache/", serverGroupcacheHandler)
 	mainMux.Post("/api/server/settings", serverSettingsHandler)
@@ -531,17 +917,66 @@ func initRoutes() {
 	repoMux.Post("/api/repo/:uuid/log", postRepoLogHandler)
 	repoMux.Post("/api/repo/:uuid/merge", repoMergeHandler)
 	repoMux.Post("/api/repo/:uuid/resolve", repoResolveHandler)
+	repoMux.Get("/api/repo/:uuid/gc", repoGCStatsHandler)
+	repoMux.Post("/api/repo/:uuid/gc", repoGCHandler)
+	repoMux.Post("/api/repo/:uuid/squash", repoSquashHandler)
+	repoMux.Get("/api/repo/:uuid/refs", getRepoRefsHandler)
+	repoMux.Post("/api/repo/:uuid/refs", postRepoRefsHandler)
+
+	refsMux := web.New()
+	mainMux.Handle("/api/repo/:uuid/refs/:name", refsMux)
+	refsMux.Use(repoSelector)
+	refsMux.Delete("/api/repo/:uuid/refs/:name", deleteRepoRefHandler)
+
+	repoMux.Get("/api/repo/:uuid/webhooks", getRepoWebhooksHandler)
+	repoMux.Post("/api/repo/:uuid/webhooks", postRepoWebhooksHandler)
+
+	webhooksMux := web.New()
+	mainMux.Handle("/api/repo/:uuid/webhooks/:id", webhooksMux)
+	webhooksMux.Use(repoSelector)
+	webhooksMux.Delete("/api/repo/:uuid/webhooks/:id", deleteRepoWebhookHandler)
+
+	migrateMux := web.New()
+	mainMux.Handle("/api/repo/:uuid/instance/:dataname/migrate", migrateMux)
+	migrateMux.Use(repoSelector)
+	migrateMux.Get("/api/repo/:uuid/instance/:dataname/migrate", repoMigrationStatsHandler)
+	migrateMux.Post("/api/repo/:uuid/instance/:dataname/migrate", repoMigrateHandler)
 
 	nodeMux := web.New()
 	mainMux.Handle("/api/node/:uuid", nodeMux)
 	mainMux.Handle("/api/node/:uuid/:action", nodeMux)
 	nodeMux.Use(nodeSelector)
+	nodeMux.Delete("/api/node/:uuid", nodeDeleteHandler)
 	nodeMux.Get("/api/node/:uuid/log", getNodeLogHandler)
 	nodeMux.Post("/api/node/:uuid/note", postNodeNoteHandler)
 	nodeMux.Post("/api/node/:uuid/log", postNodeLogHandler)
 	nodeMux.Get("/api/node/:uuid/commit", repoCommitStateHandler)
 	nodeMux.Post("/api/node/:uuid/commit", repoCommitHandler)
 	nodeMux.Post("/api/node/:uuid/branch", repoBranchHandler)
+	nodeMux.Post("/api/node/:uuid/checkout", nodeCheckoutHandler)
+	nodeMux.Post("/api/node/:uuid/renew", nodeRenewHandler)
+	nodeMux.Post("/api/node/:uuid/release", nodeReleaseHandler)
+
+	scrubMux := web.New()
+	mainMux.Handle("/api/node/:uuid/:dataname/scrub", scrubMux)
+	scrubMux.Use(nodeSelector)
+	scrubMux.Get("/api/node/:uuid/:dataname/scrub", instanceScrubHandler)
+
+	diffMux := web.New()
+	mainMux.Handle("/api/node/:uuid/:dataname/diff/:otheruuid", diffMux)
+	diffMux.Use(nodeSelector)
+	diffMux.Get("/api/node/:uuid/:dataname/diff/:otheruuid", instanceDiffHandler)
+
+	mutationsMux := web.New()
+	mainMux.Handle("/api/node/:uuid/:dataname/mutations", mutationsMux)
+	mutationsMux.Use(nodeSelector)
+	mutationsMux.Get("/api/node/:uuid/:dataname/mutations", instanceMutationsHandler)
+	mutationsMux.Post("/api/node/:uuid/:dataname/mutations", instanceReplayHandler)
+
+	eventsMux := web.New()
+	mainMux.Handle("/api/node/:uuid/:dataname/events", eventsMux)
+	eventsMux.Use(nodeSelector)
+	eventsMux.Get("/api/node/:uuid/:dataname/events", instanceEventsHandler)
 
 	instanceMux := web.New()
 	mainMux.Handle("/api/node/:uuid/:dataname/:keyword", instanceMux)
@@ -705,6 +1140,22 @@ func nodeSelector(c *web.C, h http.Handler) http.Handler {
 			BadRequest(w, r, "Cannot do %s on locked node %s", action, uuid)
 			return
 		}
+
+		// Checked out nodes can only be modified by the lease holder.
+		switch c.URLParams["action"] {
+		case "checkout", "renew", "release":
+		default:
+			if action != "get" && action != "head" {
+				token := r.Header.Get("X-Dvid-Lease")
+				if token == "" {
+					token = r.URL.Query().Get("lease")
+				}
+				if err := datastore.CheckLease(uuid, token); err != nil {
+					BadRequest(w, r, err)
+					return
+				}
+			}
+		}
 		h.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(fn)
@@ -896,6 +1347,35 @@ func serverStorageHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, jsonStr)
 }
 
+func serverStorageStatsHandler(w http.ResponseWriter, r *http.Request) {
+	jsonStr, err := datastore.GetStorageStats()
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, jsonStr)
+}
+
+func serverAccountingHandler(w http.ResponseWriter, r *http.Request) {
+	jsonStr, err := datastore.GetStorageAccounting()
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, jsonStr)
+}
+
+func serverStartAccountingHandler(w http.ResponseWriter, r *http.Request) {
+	if err := datastore.StartStorageAccounting(); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "Started storage accounting scan.")
+}
+
 func serverInfoHandler(w http.ResponseWriter, r *http.Request) {
 	jsonStr, err := AboutJSON()
 	if err != nil {
@@ -958,6 +1438,40 @@ func serverGroupcacheHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, string(m))
 }
 
+// groupcachePeers is the JSON for groupcache peer requests.
+type groupcachePeers struct {
+	Peers []string `json:"peers"`
+}
+
+func serverGroupcachePeersHandler(w http.ResponseWriter, r *http.Request) {
+	peers, err := storage.GetGroupcachePeers()
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	m, err := json.Marshal(groupcachePeers{Peers: peers})
+	if err != nil {
+		BadRequest(w, r, fmt.Sprintf("Cannot marshal JSON groupcache peers: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(m))
+}
+
+func serverSetGroupcachePeersHandler(w http.ResponseWriter, r *http.Request) {
+	var req groupcachePeers
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Error decoding POSTed JSON groupcache peers: %v", err))
+		return
+	}
+	if err := storage.SetGroupcachePeers(req.Peers); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Set %d groupcache peers.\n", len(req.Peers))
+}
+
 func serverSettingsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	config := dvid.NewConfig()
 	if err := config.SetByJSON(r.Body); err != nil {
@@ -1205,10 +1719,101 @@ func repoCommitStateHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	locked, err := datastore.LockedUUID(uuid)
 	if err != nil {
 		BadRequest(w, r, err)
-	} else {
-		w.Header().Set("Content-Type", "application/json")
+		return
+	}
+	lease, err := datastore.GetLease(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if lease == nil {
 		fmt.Fprintf(w, `{"Locked":%t}`, locked)
+		return
+	}
+	jsonBytes, err := json.Marshal(lease)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	fmt.Fprintf(w, `{"Locked":%t,"Lease":%s}`, locked, jsonBytes)
+}
+
+// leaseRequest is the JSON posted to checkout, renew and release nodes.
+type leaseRequest struct {
+	Owner string `json:"owner"`
+	Token string `json:"token"`
+	TTL   int    `json:"ttl"` // seconds
+}
+
+func getLeaseRequest(r *http.Request) (*leaseRequest, error) {
+	if r.Body == nil {
+		return nil, fmt.Errorf("expected JSON to be POSTed per API documentation")
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	req := new(leaseRequest)
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("Malformed JSON request in body: %v", err)
+	}
+	return req, nil
+}
+
+func writeLease(w http.ResponseWriter, r *http.Request, lease *datastore.Lease) {
+	jsonBytes, err := json.Marshal(lease)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func nodeCheckoutHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	req, err := getLeaseRequest(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	lease, err := datastore.Checkout(uuid, req.Owner, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	writeLease(w, r, lease)
+}
+
+func nodeRenewHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	req, err := getLeaseRequest(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	lease, err := datastore.RenewLease(uuid, req.Token, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
 	}
+	writeLease(w, r, lease)
+}
+
+func nodeReleaseHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	req, err := getLeaseRequest(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if err := datastore.ReleaseLease(uuid, req.Token); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "released", uuid)
 }
 
 func repoCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
@@ -1239,6 +1844,16 @@ func repoCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 
 // TODO -- Might allow specification of UUID for child via HTTP, or only
 // allow this potentially dangerous op via command line.
+func nodeDeleteHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	if err := datastore.DeleteLeaf(uuid); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "deleted", uuid)
+}
+
 func repoBranchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	uuid := c.Env["uuid"].(dvid.UUID)
 	jsonData := struct {
@@ -1268,53 +1883,402 @@ func repoBranchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		BadRequest(w, r, "merge requires JSON to be POSTed per API documentation")
+func instanceScrubHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+	report, err := datastore.Scrub(uuid, dataname)
+	if err != nil {
+		BadRequest(w, r, err)
 		return
 	}
-	data, err := ioutil.ReadAll(r.Body)
+	jsonBytes, err := json.Marshal(report)
 	if err != nil {
 		BadRequest(w, r, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
 
-	jsonData := struct {
-		MergeType string   `json:"mergeType"`
-		Note      string   `json:"note"`
-		Parents   []string `json:"parents"`
-	}{}
-	if err := json.Unmarshal(data, &jsonData); err != nil {
-		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
+func instanceDiffHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+	otheruuid, _, err := datastore.MatchingUUID(c.URLParams["otheruuid"])
+	if err != nil {
+		BadRequest(w, r, err)
 		return
 	}
-	if len(jsonData.Parents) < 2 {
-		BadRequest(w, r, "Must specify at least two parent UUIDs using 'parents' field")
+	diff, err := datastore.Diff(uuid, dataname, otheruuid)
+	if err != nil {
+		BadRequest(w, r, err)
 		return
 	}
-
-	// Convert JSON of parents into []UUID
-	parents := make([]dvid.UUID, len(jsonData.Parents))
-	for i, uuidFrag := range jsonData.Parents {
-		uuid, _, err := datastore.MatchingUUID(uuidFrag)
-		if err != nil {
-			BadRequest(w, r, fmt.Sprintf("can't match parent %q: %v", uuidFrag, err))
-			return
-		}
-		parents[i] = uuid
-	}
-
-	// Convert merge type designation
-	var mt datastore.MergeType
-	switch jsonData.MergeType {
-	case "conflict-free":
-		mt = datastore.MergeConflictFree
-	default:
-		BadRequest(w, r, fmt.Sprintf("'mergeType' must be 'conflict-free' at this time"))
+	jsonBytes, err := json.Marshal(diff)
+	if err != nil {
+		BadRequest(w, r, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
 
-	// Do the merge
+// streamKeepAlive is the interval between comments sent to idle event streams so proxies
+// and clients don't time out the connection.
+const streamKeepAlive = 15 * time.Second
+
+func instanceEventsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	v := c.Env["versionID"].(dvid.VersionID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+	d, err := datastore.GetDataByUUIDName(uuid, dataname)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		BadRequest(w, r, "event streaming is not supported by this connection")
+		return
+	}
+	queryStrings := r.URL.Query()
+
+	// Resume from the last event received by the client, if any.
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = queryStrings.Get("lastid")
+	}
+
+	// Filter by event type and bounding box.
+	var events map[string]struct{}
+	if eventsStr := queryStrings.Get("events"); eventsStr != "" {
+		events = make(map[string]struct{})
+		for _, event := range strings.Split(eventsStr, ",") {
+			events[event] = struct{}{}
+		}
+	}
+	bounds := dvid.Extents3d{
+		MinPoint: dvid.Point3d{math.MinInt32, math.MinInt32, math.MinInt32},
+		MaxPoint: dvid.Point3d{math.MaxInt32, math.MaxInt32, math.MaxInt32},
+	}
+	var bounded bool
+	for dim, axis := range []string{"x", "y", "z"} {
+		for _, bound := range []string{"min", "max"} {
+			valStr := queryStrings.Get(bound + axis)
+			if valStr == "" {
+				continue
+			}
+			val, err := strconv.ParseInt(valStr, 10, 32)
+			if err != nil {
+				BadRequest(w, r, "Bad %s%s query string (%q): %v", bound, axis, valStr, err)
+				return
+			}
+			if bound == "min" {
+				bounds.MinPoint[dim] = int32(val)
+			} else {
+				bounds.MaxPoint[dim] = int32(val)
+			}
+			bounded = true
+		}
+	}
+	filter := func(evt *datastore.StreamEvent) bool {
+		if events != nil {
+			if _, found := events[evt.Event]; !found {
+				return false
+			}
+		}
+		return !bounded || evt.Extents == nil || bounds.Intersects(*evt.Extents)
+	}
+
+	stream, missed, err := datastore.SubscribeEvents(d, v, lastID, filter)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(evt *datastore.StreamEvent) error {
+		jsonBytes, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", evt.ID, evt.Event, jsonBytes)
+		return err
+	}
+	for _, evt := range missed {
+		if err := send(evt); err != nil {
+			dvid.Errorf("Unable to send event on stream of data %q: %v\n", dataname, err)
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case evt, ok := <-stream.C:
+			if !ok {
+				if stream.Dropped() {
+					dvid.Infof("Event stream of data %q fell behind and was closed\n", dataname)
+				}
+				return
+			}
+			if err := send(evt); err != nil {
+				dvid.Errorf("Unable to send event on stream of data %q: %v\n", dataname, err)
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func instanceMutationsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	v := c.Env["versionID"].(dvid.VersionID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+	var since uint64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		var err error
+		if since, err = strconv.ParseUint(sinceStr, 10, 64); err != nil {
+			BadRequest(w, r, "Bad 'since' query string (%q).  Must be a mutation id.", sinceStr)
+			return
+		}
+	}
+	d, err := datastore.GetDataByUUIDName(uuid, dataname)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	entries, err := datastore.GetMutations(d, v, since)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(entries)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+// replayResponse is the http.ResponseWriter for a replayed mutation, keeping its status
+// and body so failures can be reported.
+type replayResponse struct {
+	header http.Header
+	code   int // zero until the status is written
+	body   bytes.Buffer
+}
+
+func newReplayResponse() *replayResponse {
+	return &replayResponse{header: make(http.Header)}
+}
+
+func (resp *replayResponse) Header() http.Header {
+	return resp.header
+}
+
+func (resp *replayResponse) Write(b []byte) (int, error) {
+	resp.WriteHeader(http.StatusOK)
+	return resp.body.Write(b)
+}
+
+// WriteHeader keeps only the first status written, as with a network response.
+func (resp *replayResponse) WriteHeader(code int) {
+	if resp.code == 0 {
+		resp.code = code
+	}
+}
+
+// instanceReplayHandler re-issues the journaled mutations of a source instance against the
+// requested instance through the HTTP API.
+func instanceReplayHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+	var jsonData struct {
+		UUID  string            `json:"uuid"`
+		Data  dvid.InstanceName `json:"data"`
+		Since uint64            `json:"since"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Error decoding POSTed JSON for replay: %v", err))
+		return
+	}
+	srcUUID, srcV, err := datastore.MatchingUUID(jsonData.UUID)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	src, err := datastore.GetDataByUUIDName(srcUUID, jsonData.Data)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	dst, err := datastore.GetDataByUUIDName(uuid, dataname)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if src.TypeName() != dst.TypeName() {
+		BadRequest(w, r, "can't replay mutations of %q data %q into %q data %q", src.TypeName(), src.DataName(), dst.TypeName(), dst.DataName())
+		return
+	}
+	if src.DataUUID() == dst.DataUUID() && srcUUID == uuid {
+		BadRequest(w, r, "can't replay mutations of data %q into the same version", dataname)
+		return
+	}
+	entries, err := datastore.GetMutations(src, srcV, jsonData.Since)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+
+	// Replayed requests carry the lease token of this request, if any.
+	token := r.Header.Get("X-Dvid-Lease")
+	if token == "" {
+		token = r.URL.Query().Get("lease")
+	}
+	var last uint64
+	for i, entry := range entries {
+		if !entry.Replayable() {
+			BadRequest(w, r, "replay stopped after %d mutations: mutation %d (%s %s) kept only a hash of its payload", i, entry.ID, entry.Method, entry.Endpoint)
+			return
+		}
+		urlStr := fmt.Sprintf("%snode/%s/%s/%s", WebAPIPath, uuid, dataname, entry.Endpoint)
+		if entry.Query != "" {
+			urlStr += "?" + entry.Query
+		}
+		req, err := http.NewRequest(entry.Method, urlStr, bytes.NewReader(entry.Payload))
+		if err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		if token != "" {
+			req.Header.Set("X-Dvid-Lease", token)
+		}
+		resp := newReplayResponse()
+		ServeSingleHTTP(resp, req)
+		if resp.code != http.StatusOK && resp.code != 0 {
+			BadRequest(w, r, "replay stopped after %d mutations: mutation %d (%s %s) failed: %s", i, entry.ID, entry.Method, entry.Endpoint, strings.TrimSpace(resp.body.String()))
+			return
+		}
+		last = entry.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"replayed": %d, "last": %d}`, len(entries), last)
+}
+
+func repoMigrateHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+	var jsonData struct {
+		Store storage.Alias `json:"store"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Error decoding POSTed JSON for migrate: %v", err))
+		return
+	}
+	if jsonData.Store == "" {
+		BadRequest(w, r, "migrate requires a target \"store\" alias")
+		return
+	}
+	if err := datastore.MigrateInstanceOnline(uuid, dataname, jsonData.Store); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Started migration of data %q to store %q\n", dataname, jsonData.Store)
+}
+
+func repoMigrationStatsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+	stats, err := datastore.GetMigrationStats(uuid, dataname)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(stats)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		BadRequest(w, r, "merge requires JSON to be POSTed per API documentation")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+
+	jsonData := struct {
+		MergeType string   `json:"mergeType"`
+		Note      string   `json:"note"`
+		Parents   []string `json:"parents"`
+	}{}
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
+		return
+	}
+	if len(jsonData.Parents) < 2 {
+		BadRequest(w, r, "Must specify at least two parent UUIDs using 'parents' field")
+		return
+	}
+
+	// Convert JSON of parents into []UUID
+	parents := make([]dvid.UUID, len(jsonData.Parents))
+	for i, uuidFrag := range jsonData.Parents {
+		uuid, _, err := datastore.MatchingUUID(uuidFrag)
+		if err != nil {
+			BadRequest(w, r, fmt.Sprintf("can't match parent %q: %v", uuidFrag, err))
+			return
+		}
+		parents[i] = uuid
+	}
+
+	// Convert merge type designation
+	var mt datastore.MergeType
+	switch jsonData.MergeType {
+	case "conflict-free":
+		mt = datastore.MergeConflictFree
+	case "three-way":
+		report, err := datastore.ThreeWayMerge(parents, jsonData.Note)
+		if err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		jsonBytes, err := json.Marshal(report)
+		if err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonBytes)
+		return
+	default:
+		BadRequest(w, r, fmt.Sprintf("'mergeType' must be 'conflict-free' or 'three-way'"))
+		return
+	}
+
+	// Do the merge
 	newuuid, err := datastore.Merge(parents, jsonData.Note, mt)
 	if err != nil {
 		BadRequest(w, r, err)
@@ -1324,6 +2288,223 @@ func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func repoSquashHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	if r.Body == nil {
+		BadRequest(w, r, "squash requires JSON to be POSTed per API documentation")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonData := struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		Note string `json:"note"`
+	}{}
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
+		return
+	}
+	root, err := datastore.GetRepoRoot(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	var nodes [2]dvid.UUID
+	for i, uuidFrag := range []string{jsonData.From, jsonData.To} {
+		if uuidFrag == "" {
+			BadRequest(w, r, "Must specify both 'from' and 'to' UUIDs for squash")
+			return
+		}
+		nodes[i], _, err = datastore.MatchingUUID(uuidFrag)
+		if err != nil {
+			BadRequest(w, r, fmt.Sprintf("can't match node %q: %v", uuidFrag, err))
+			return
+		}
+		nodeRoot, err := datastore.GetRepoRoot(nodes[i])
+		if err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		if nodeRoot != root {
+			BadRequest(w, r, fmt.Sprintf("node %s is not in repo %s", nodes[i], uuid))
+			return
+		}
+	}
+	result, err := datastore.Squash(nodes[0], nodes[1], jsonData.Note)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func getRepoRefsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	refs, err := datastore.GetRefs(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(refs)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func postRepoRefsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	if r.Body == nil {
+		BadRequest(w, r, "setting a ref requires JSON to be POSTed per API documentation")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonData := struct {
+		Name string `json:"name"`
+		Kind string `json:"type"`
+		UUID string `json:"uuid"`
+	}{}
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
+		return
+	}
+	if jsonData.Kind == "" {
+		jsonData.Kind = datastore.BranchRef
+	}
+	if jsonData.UUID == "" {
+		BadRequest(w, r, "Must specify 'uuid' of node for ref")
+		return
+	}
+	target, _, err := datastore.MatchingUUID(jsonData.UUID)
+	if err != nil {
+		BadRequest(w, r, fmt.Sprintf("can't match node %q: %v", jsonData.UUID, err))
+		return
+	}
+	if err := datastore.SetRef(uuid, jsonData.Name, jsonData.Kind, target); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", jsonData.Name, target)
+}
+
+func deleteRepoRefHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	name := c.URLParams["name"]
+	if err := datastore.DeleteRef(uuid, name); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "deleted", name)
+}
+
+func getRepoWebhooksHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	hooks, err := datastore.GetWebhooks(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(hooks)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func postRepoWebhooksHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	if r.Body == nil {
+		BadRequest(w, r, "adding a webhook requires JSON to be POSTed per API documentation")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	var hook datastore.Webhook
+	if err := json.Unmarshal(data, &hook); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
+		return
+	}
+	added, err := datastore.AddWebhook(uuid, hook)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(added)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func deleteRepoWebhookHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	id := c.URLParams["id"]
+	if err := datastore.DeleteWebhook(uuid, id); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "deleted", id)
+}
+
+func repoGCHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	queryStrings := r.URL.Query()
+	config := dvid.NewConfig()
+	for _, key := range []string{"dryrun", "batchsize"} {
+		if value := queryStrings.Get(key); value != "" {
+			config.Set(key, value)
+		}
+	}
+	if err := datastore.StartGarbageCollection(uuid, config); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Started garbage collection of repo %s\n", uuid)
+}
+
+func repoGCStatsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	stats, err := datastore.GetGCStats(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(stats)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
 func repoResolveHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	uuid, _, err := datastore.MatchingUUID(c.URLParams["uuid"])
 	if err != nil {
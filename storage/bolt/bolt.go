@@ -0,0 +1,936 @@
+// +build bolt
+
+/*
+	Package bolt implements a DVID storage engine using the pure Go, embedded Bolt
+	key-value store.  Since no cgo is required, a DVID executable built with this
+	engine can be statically linked and deployed as a single binary.
+
+	Bolt allows only one read-write transaction at a time, so large writes are
+	committed in batches.  Range queries copy key-value pairs out of read-only
+	transactions in batches so that the chunk handlers, which may write to the store,
+	are never called while a transaction is open.
+*/
+package bolt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	boltdb "github.com/boltdb/bolt"
+)
+
+const (
+	// Filename of the bolt database within the configured path directory.
+	DBFilename = "dvid.bolt"
+
+	// Number of key-value pairs read per read-only transaction during range queries.
+	ScanBatchSize = 1000
+
+	// Number of deletions per read-write transaction for DeleteRange and DeleteAll.
+	DeleteBatchSize = 10000
+
+	// Timeout for acquiring the file lock on the database.
+	OpenTimeout = 5 * time.Second
+)
+
+var bucketName = []byte("dvid")
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in bolt: %v\n", err)
+	}
+	e := Engine{"bolt", "Bolt pure Go embedded key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a bolt store.  The passed Config must contain "path" string, which
+// is the directory that will hold the database file.  An optional "nosync" bool setting
+// skips fsync after each commit, which is much faster but risks corruption on power loss.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newBoltDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, testing, nosync bool, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for bolt configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+		return
+	}
+	v, found = c["testing"]
+	if found {
+		testing, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "testing", v)
+			return
+		}
+	}
+	if testing {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	v, found = c["nosync"]
+	if found {
+		nosync, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "nosync", v)
+			return
+		}
+	}
+	return
+}
+
+// newBoltDB returns a bolt backend, creating the database at the path if it
+// doesn't already exist.
+func (e Engine) newBoltDB(config dvid.StoreConfig) (*BoltDB, bool, error) {
+	path, _, nosync, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Is there a database already at this path?  If not, create.
+	var created bool
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dvid.Infof("Database not already at path (%s). Creating directory...\n", path)
+		created = true
+		if err := os.MkdirAll(path, 0744); err != nil {
+			return nil, true, fmt.Errorf("Can't make directory at %s: %v", path, err)
+		}
+	} else {
+		dvid.Infof("Found directory at %s (err = %v)\n", path, err)
+	}
+
+	filename := filepath.Join(path, DBFilename)
+	dvid.Infof("Opening bolt @ path %s\n", filename)
+	db, err := boltdb.Open(filename, 0644, &boltdb.Options{Timeout: OpenTimeout})
+	if err != nil {
+		return nil, false, err
+	}
+	db.NoSync = nosync
+	err = db.Update(func(tx *boltdb.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+
+	bdb := &BoltDB{
+		directory: path,
+		config:    config,
+		db:        db,
+	}
+	if created {
+		return bdb, created, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := bdb.metadataExists()
+	if err != nil {
+		bdb.Close()
+		return nil, false, err
+	}
+	return bdb, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// GetTestConfig returns a set of store configurations suitable for testing
+// a bolt storage system.
+func (e Engine) GetTestConfig() (*storage.Backend, error) {
+	tc := map[string]interface{}{
+		"path":    fmt.Sprintf("dvid-test-%x", uuid.NewV4().Bytes()),
+		"testing": true,
+		"nosync":  true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	testConfig := map[storage.Alias]dvid.StoreConfig{
+		"default": dvid.StoreConfig{Config: c, Engine: "bolt"},
+	}
+	backend := storage.Backend{
+		Stores: testConfig,
+	}
+	return &backend, nil
+}
+
+// Delete implements the TestableEngine interface by providing a way to dispose
+// of testing databases.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, _, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Delete the directory if it exists
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Can't delete old datastore %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// --- The bolt implementation must satisfy a Engine interface ----
+
+type BoltDB struct {
+	// Directory of datastore
+	directory string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	db *boltdb.DB
+}
+
+func (bdb *BoltDB) String() string {
+	return fmt.Sprintf("bolt @ %s", bdb.directory)
+}
+
+// Close closes the bolt database.
+func (bdb *BoltDB) Close() {
+	if bdb != nil && bdb.db != nil {
+		if err := bdb.db.Close(); err != nil {
+			dvid.Errorf("Error closing %s: %v\n", bdb, err)
+		}
+		bdb.db = nil
+	}
+}
+
+// Equal returns true if the bolt database matches the given store configuration.
+func (bdb *BoltDB) Equal(config dvid.StoreConfig) bool {
+	path, _, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return bdb.directory == path
+}
+
+func (bdb *BoltDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	var exists bool
+	err := bdb.db.View(func(tx *boltdb.Tx) error {
+		k, _ := tx.Bucket(bucketName).Cursor().Seek(keyBeg)
+		exists = k != nil && bytes.Compare(k, keyEnd) <= 0
+		return nil
+	})
+	if err == nil && !exists {
+		dvid.Infof("No metadata found for %s...\n", bdb)
+	}
+	return exists, err
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// scan copies up to max key-value pairs with begKey <= key <= endKey.  Bolt values
+// are only valid during a transaction so all returned bytes are copies.
+func (bdb *BoltDB) scan(begKey, endKey storage.Key, keysOnly bool, max int) ([]*storage.KeyValue, error) {
+	var kvs []*storage.KeyValue
+	err := bdb.db.View(func(tx *boltdb.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		for k, v := c.Seek(begKey); k != nil && len(kvs) < max; k, v = c.Next() {
+			if bytes.Compare(k, endKey) > 0 {
+				break
+			}
+			kv := &storage.KeyValue{K: copyBytes(k)}
+			if !keysOnly {
+				kv.V = copyBytes(v)
+			}
+			kvs = append(kvs, kv)
+		}
+		return nil
+	})
+	return kvs, err
+}
+
+// iterate calls f for each key-value pair with begKey <= key <= endKey in ascending
+// key order.  No transaction is open when f is called, so f may write to the store.
+func (bdb *BoltDB) iterate(begKey, endKey storage.Key, keysOnly bool, f func(*storage.KeyValue) error) error {
+	seekKey := begKey
+	for {
+		kvs, err := bdb.scan(seekKey, endKey, keysOnly, ScanBatchSize)
+		if err != nil {
+			return err
+		}
+
+		// The smallest key following the last one read, found before f can modify it.
+		var nextKey storage.Key
+		if len(kvs) == ScanBatchSize {
+			nextKey = append(copyBytes(kvs[len(kvs)-1].K), 0)
+		}
+		for _, kv := range kvs {
+			storage.StoreKeyBytesRead <- len(kv.K)
+			if !keysOnly {
+				storage.StoreValueBytesRead <- len(kv.V)
+			}
+			if err := f(kv); err != nil {
+				return err
+			}
+		}
+		if nextKey == nil {
+			return nil
+		}
+		seekKey = nextKey
+	}
+}
+
+// errStopIteration signals the early, successful end of an iteration.
+var errStopIteration = fmt.Errorf("iteration stopped")
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (bdb *BoltDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if bdb == nil {
+		return nil, fmt.Errorf("Can't call GET on nil BoltDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := bdb.getSingleKeyVersions(vctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	var v []byte
+	err := bdb.db.View(func(tx *boltdb.Tx) error {
+		v = copyBytes(tx.Bucket(bucketName).Get(key))
+		return nil
+	})
+	storage.StoreValueBytesRead <- len(v)
+	return v, err
+}
+
+// getSingleKeyVersions returns all versions of a key.  These key-value pairs will be sorted
+// in ascending key order and could include a tombstone key.
+func (bdb *BoltDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	values := []*storage.KeyValue{}
+	err = bdb.iterate(begKey, endKey, false, func(kv *storage.KeyValue) error {
+		values = append(values, kv)
+		return nil
+	})
+	return values, err
+}
+
+// versionedRange calls f with the key-value pair appropriate for the context's version
+// for each type-specific key in the range.
+func (bdb *BoltDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		return err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		values = []*storage.KeyValue{}
+		if err != nil {
+			return err
+		}
+		if kv != nil {
+			return f(kv)
+		}
+		return nil
+	}
+
+	err = bdb.iterate(minKey, maxKey, keysOnly, func(kv *storage.KeyValue) error {
+		// Did we pass all versions for last key read?
+		if bytes.Compare(kv.K, maxVersionKey) > 0 {
+			tk, err := storage.TKeyFromKey(kv.K)
+			if err != nil {
+				return err
+			}
+			maxVersionKey, err = vctx.MaxVersionKey(tk)
+			if err != nil {
+				return err
+			}
+			if err := sendKV(); err != nil {
+				return err
+			}
+		}
+		values = append(values, kv)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return sendKV()
+}
+
+// unversionedRange calls f for each key-value pair in the range.
+func (bdb *BoltDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	begKey := ctx.ConstructKey(begTKey)
+	endKey := ctx.ConstructKey(endTKey)
+	return bdb.iterate(begKey, endKey, keysOnly, f)
+}
+
+// processRange runs the versioned or unversioned range query appropriate for the context.
+func (bdb *BoltDB) processRange(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	if !ctx.Versioned() {
+		return bdb.unversionedRange(ctx, kStart, kEnd, keysOnly, f)
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	return bdb.versionedRange(vctx, kStart, kEnd, keysOnly, f)
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (bdb *BoltDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if bdb == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil BoltDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := bdb.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (bdb *BoltDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil BoltDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := bdb.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		kch <- kv.K
+		return nil
+	})
+	kch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (bdb *BoltDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if bdb == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil BoltDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := bdb.processRange(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (bdb *BoltDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil BoltDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	return bdb.processRange(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		return f(chunk)
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (bdb *BoltDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil BoltDB")
+	}
+	err := bdb.iterate(kStart, kEnd, keysOnly, func(kv *storage.KeyValue) error {
+		select {
+		case out <- kv:
+			return nil
+		case <-cancel:
+			return errStopIteration
+		}
+	})
+	if err == errStopIteration {
+		return nil
+	}
+	out <- nil
+	return err
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (bdb *BoltDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call Put on nil BoltDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := bdb.NewBatch(ctx)
+	batch.Put(tk, v)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Put: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Put: %v", err)
+	}
+	return nil
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (bdb *BoltDB) RawPut(k storage.Key, v []byte) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call RawPut on nil BoltDB")
+	}
+	err := bdb.db.Update(func(tx *boltdb.Tx) error {
+		return tx.Bucket(bucketName).Put(k, v)
+	})
+	if err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (bdb *BoltDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call Delete on nil BoltDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := bdb.NewBatch(ctx)
+	batch.Delete(tk)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Delete: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Delete: %v", err)
+	}
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (bdb *BoltDB) RawDelete(k storage.Key) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call RawDelete on nil BoltDB")
+	}
+	return bdb.db.Update(func(tx *boltdb.Tx) error {
+		return tx.Bucket(bucketName).Delete(k)
+	})
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+// All key-value pairs are written in a single transaction.
+func (bdb *BoltDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call PutRange on nil BoltDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := bdb.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of PutRange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (bdb *BoltDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil BoltDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+
+	// Gather the visible keys, then tombstone or delete them using batches.
+	var tkeys []storage.TKey
+	err := bdb.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := bdb.NewBatch(ctx)
+	for i, tk := range tkeys {
+		batch.Delete(tk)
+		if (i+1)%DeleteBatchSize == 0 {
+			if err := batch.Commit(); err != nil {
+				dvid.Criticalf("Error on batch commit of DeleteRange at key-value pair %d: %v\n", i, err)
+				return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v\n", i, err)
+			}
+			batch = bdb.NewBatch(ctx)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on last batch commit of DeleteRange: %v\n", err)
+		return fmt.Errorf("Error on last batch commit of DeleteRange: %v\n", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (bdb *BoltDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if bdb == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil BoltDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	if allVersions {
+		return bdb.deleteAllVersions(ctx)
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	return bdb.deleteSingleVersion(vctx)
+}
+
+// deleteKeys deletes all full keys within the range that pass the given filter, which
+// can be nil.  Deletions are committed in transactions of at most DeleteBatchSize keys.
+func (bdb *BoltDB) deleteKeys(minKey, maxKey storage.Key, filter func(storage.Key) (bool, error)) (int, error) {
+	var numKV int
+	var keys []storage.Key
+	commit := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		err := bdb.db.Update(func(tx *boltdb.Tx) error {
+			b := tx.Bucket(bucketName)
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			dvid.Criticalf("Error on batch commit of DeleteAll at key-value pair %d: %v\n", numKV, err)
+			return fmt.Errorf("Error on batch commit of DeleteAll at key-value pair %d: %v", numKV, err)
+		}
+		numKV += len(keys)
+		keys = nil
+		return nil
+	}
+	err := bdb.iterate(minKey, maxKey, true, func(kv *storage.KeyValue) error {
+		if filter != nil {
+			del, err := filter(kv.K)
+			if err != nil {
+				return err
+			}
+			if !del {
+				return nil
+			}
+		}
+		keys = append(keys, kv.K)
+		if len(keys) == DeleteBatchSize {
+			return commit()
+		}
+		return nil
+	})
+	if err != nil {
+		return numKV, err
+	}
+	err = commit()
+	return numKV, err
+}
+
+func (bdb *BoltDB) deleteSingleVersion(vctx storage.VersionedCtx) error {
+	minTKey := storage.MinTKey(storage.TKeyMinClass)
+	maxTKey := storage.MaxTKey(storage.TKeyMaxClass)
+	minKey, err := vctx.MinVersionKey(minTKey)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(maxTKey)
+	if err != nil {
+		return err
+	}
+	deleteVersion := vctx.VersionID()
+	numKV, err := bdb.deleteKeys(minKey, maxKey, func(k storage.Key) (bool, error) {
+		_, v, _, err := storage.DataKeyToLocalIDs(k)
+		if err != nil {
+			return false, fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+		}
+		return v == deleteVersion, nil
+	})
+	if err != nil {
+		return err
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, vctx)
+	return nil
+}
+
+func (bdb *BoltDB) deleteAllVersions(ctx storage.Context) error {
+	var err error
+	var minKey, maxKey storage.Key
+
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if versioned {
+		// Don't have to worry about tombstones.  Delete all keys from all versions for this instance id.
+		minTKey := storage.MinTKey(storage.TKeyMinClass)
+		maxTKey := storage.MaxTKey(storage.TKeyMaxClass)
+		minKey, err = vctx.MinVersionKey(minTKey)
+		if err != nil {
+			return err
+		}
+		maxKey, err = vctx.MaxVersionKey(maxTKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		minKey, maxKey = ctx.KeyRange()
+	}
+	numKV, err := bdb.deleteKeys(minKey, maxKey, nil)
+	if err != nil {
+		return err
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	op    storage.Op
+	key   storage.Key
+	value []byte
+}
+
+type goBatch struct {
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	db   *boltdb.DB
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  The batch is
+// committed in a single bolt read-write transaction.
+func (bdb *BoltDB) NewBatch(ctx storage.Context) storage.Batch {
+	if bdb == nil {
+		dvid.Criticalf("Can't call NewBatch on nil BoltDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	var ok bool
+	vctx, ok = ctx.(storage.VersionedCtx)
+	if !ok {
+		vctx = nil
+	}
+	return &goBatch{ctx: ctx, vctx: vctx, db: bdb.db}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{storage.PutOp, tombstone, dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{storage.DeleteOp, key, nil})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{storage.DeleteOp, tombstone, nil})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{storage.PutOp, key, copyBytes(v)})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	if len(batch.ops) == 0 {
+		return nil
+	}
+	err := batch.db.Update(func(tx *boltdb.Tx) error {
+		b := tx.Bucket(bucketName)
+		for _, op := range batch.ops {
+			var err error
+			switch op.op {
+			case storage.PutOp:
+				err = b.Put(op.key, op.value)
+			case storage.DeleteOp:
+				err = b.Delete(op.key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	batch.ops = nil
+	return err
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the number of key and value bytes within each of the
+// given key ranges.  Bolt keeps no size statistics, so this requires a scan of each
+// range and can be slow for large ranges.
+func (bdb *BoltDB) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	sizes := make([]uint64, len(ranges))
+	err := bdb.db.View(func(tx *boltdb.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		for i, kr := range ranges {
+			for k, v := c.Seek(kr.Start); k != nil; k, v = c.Next() {
+				if bytes.Compare(k, kr.OpenEnd) >= 0 {
+					break
+				}
+				sizes[i] += uint64(len(k) + len(v))
+			}
+		}
+		return nil
+	})
+	return sizes, err
+}
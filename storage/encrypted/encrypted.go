@@ -0,0 +1,1151 @@
+/*
+	Package encrypted implements a store wrapper that encrypts values, and optionally the
+	suffixes of type-specific keys, with AES-256-GCM before they reach another store
+	declared in the [store] section of the configuration TOML:
+
+		[store.protected]
+		engine = "encrypted"
+		store = "raid6"
+		keyfile = "/secure/dvid.keys"
+		encryptkeys = true
+		plainkeybytes = 8
+
+	The key file holds one key per line as a key ID from 1 to 255 and a base64-encoded
+	32-byte key, with lines beginning with "#" ignored:
+
+		1 mKZs3J3i0yQ3NEVp1u0Q9k0k1qWqVQ3tLQ9fVn7d2lE=
+		2 wC1cR2xW3v4vK3jz2j4t6bOQ8n1m0Y5pX7uE4sA9dHk=
+
+	Values are encrypted with the key having the largest ID and are prefixed with that ID,
+	so keys can be rotated by adding a new key to the file, restarting, and running the
+	"storage rekey" RPC command, which re-encrypts all values in the background.  Old keys
+	can be removed from the file once the rekey has finished.  Each value is authenticated
+	together with its plaintext key, less any version, so values moved among keys in the
+	underlying store can't be decrypted.
+
+	If "encryptkeys" is true, the type-specific part of each data key after the first
+	"plainkeybytes" bytes is deterministically encrypted with keys derived from key 1, which
+	therefore must always remain in the key file.  The data instance, version, key class,
+	and plaintext key prefix remain visible to the underlying store, so range queries that
+	span few plaintext key prefixes are efficient.  Results within a plaintext prefix are
+	buffered and sorted to preserve key order, so ranges within a plaintext prefix read all
+	keys with that prefix.  Metadata keys are never encrypted.
+
+	The underlying store should not be used directly or by other encrypted stores.
+*/
+package encrypted
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+)
+
+// RekeyBatchSize is the maximum number of key-value pairs re-encrypted while writes are
+// blocked during a rekey.
+const RekeyBatchSize = 1000
+
+const (
+	keySize   = 32 // AES-256
+	nonceSize = 12 // standard GCM nonce
+)
+
+var (
+	// dataKeyPrefix is the first byte of all data keys, which directly follows metadata keys.
+	dataKeyPrefix byte
+
+	// tkeyStandardByte follows the class byte of type-specific keys holding data.
+	tkeyStandardByte byte
+
+	// keyTailSize is the size of the version, client, and tombstone marker ending data keys.
+	keyTailSize = dvid.VersionIDSize + dvid.ClientIDSize + 1
+
+	// maxSuffix follows a plaintext key prefix to bound all encrypted suffixes.
+	maxSuffix = bytes.Repeat([]byte{0xFF}, 32)
+)
+
+func init() {
+	_, dataKey := storage.NewMetadataContext().KeyRange()
+	dataKeyPrefix = dataKey[0]
+	tkeyStandardByte = storage.NewTKey(0, nil)[1]
+
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in encrypted: %v\n", err)
+	}
+	e := Engine{"encrypted", "AES-GCM encryption of another store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore is not supported since encrypted stores wrap another store.  Encrypted stores are
+// opened by the storage manager through the CompositeEngine interface.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return nil, false, fmt.Errorf("encrypted stores must be opened as composite stores")
+}
+
+// ComponentAliases returns the alias of the encrypted store.
+func (e Engine) ComponentAliases(config dvid.StoreConfig) ([]storage.Alias, error) {
+	c, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return []storage.Alias{c.store}, nil
+}
+
+// NewCompositeStore returns an encrypted store.  Metadata initialization is required if
+// the underlying store has no metadata.
+func (e Engine) NewCompositeStore(config dvid.StoreConfig, components map[storage.Alias]dvid.Store) (dvid.Store, bool, error) {
+	c, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	db, ok := components[c.store].(storage.OrderedKeyValueDB)
+	if !ok {
+		return nil, false, fmt.Errorf("encrypted store %q is not an ordered key-value store", c.store)
+	}
+	if _, ok := db.(storage.KeyValueBatcher); !ok {
+		return nil, false, fmt.Errorf("encrypted store %q must support batches", c.store)
+	}
+	s := &Store{config: c, db: db}
+	if err := s.loadKeys(); err != nil {
+		return nil, false, err
+	}
+	exists, err := storage.MetadataExists(s)
+	if err != nil {
+		return nil, false, err
+	}
+	return s, !exists, nil
+}
+
+type encryptedConfig struct {
+	store         storage.Alias
+	keyfile       string
+	encryptKeys   bool
+	plainKeyBytes int
+}
+
+func parseConfig(config dvid.StoreConfig) (c encryptedConfig, err error) {
+	var alias string
+	var found bool
+	if alias, found, err = config.GetString("store"); err != nil {
+		return
+	}
+	if !found || alias == "" {
+		err = fmt.Errorf("%q store alias must be specified for encrypted configuration", "store")
+		return
+	}
+	c.store = storage.Alias(alias)
+	if c.keyfile, found, err = config.GetString("keyfile"); err != nil {
+		return
+	}
+	if !found || c.keyfile == "" {
+		err = fmt.Errorf("%q must be specified for encrypted configuration", "keyfile")
+		return
+	}
+	if v, found := config.Get("encryptkeys"); found {
+		switch b := v.(type) {
+		case bool:
+			c.encryptKeys = b
+		case string:
+			c.encryptKeys, _, err = config.GetBool("encryptkeys")
+		default:
+			err = fmt.Errorf("%q setting must be a bool (%v)", "encryptkeys", v)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if v, found := config.Get("plainkeybytes"); found {
+		var n int64
+		switch i := v.(type) {
+		case int64:
+			n = i
+		case int:
+			n = int64(i)
+		case float64:
+			n = int64(i)
+		case string:
+			if n, err = strconv.ParseInt(i, 10, 64); err != nil {
+				return
+			}
+		default:
+			err = fmt.Errorf("%q setting must be an integer (%v)", "plainkeybytes", v)
+			return
+		}
+		if n < 0 {
+			err = fmt.Errorf("%q setting cannot be negative", "plainkeybytes")
+			return
+		}
+		c.plainKeyBytes = int(n)
+	}
+	return
+}
+
+// readKeyFile returns the keys in a key file by ID.
+func readKeyFile(filename string) (map[byte][]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open encryption key file: %v", err)
+	}
+	defer f.Close()
+	keys := make(map[byte][]byte)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d of key file %s should be a key ID and base64 key", lineNum, filename)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("line %d of key file %s has bad key ID %q", lineNum, filename, fields[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("line %d of key file %s must have base64 encoding of %d byte key", lineNum, filename, keySize)
+		}
+		if _, found := keys[byte(id)]; found {
+			return nil, fmt.Errorf("key ID %d is repeated in key file %s", id, filename)
+		}
+		keys[byte(id)] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in key file %s", filename)
+	}
+	return keys, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey returns a key for a given purpose derived from a key in the key file.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// RekeyStats describes the last or current rekey.
+type RekeyStats struct {
+	KeyID    int
+	Running  bool
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	Scanned  uint64
+	Rekeyed  uint64
+	Error    string `json:",omitempty"`
+}
+
+// Stats holds statistics for an encrypted store.
+type Stats struct {
+	Store         storage.Alias
+	KeyIDs        []int
+	CurrentKeyID  int
+	EncryptKeys   bool
+	PlainKeyBytes int
+	Rekey         *RekeyStats `json:",omitempty"`
+}
+
+// Store is an encrypted store that fulfills the storage.OrderedKeyValueDB interface.
+type Store struct {
+	config encryptedConfig
+	db     storage.OrderedKeyValueDB
+
+	current  byte
+	aeads    map[byte]cipher.AEAD
+	nameAEAD cipher.AEAD // deterministic encryption of key suffixes
+	nameMAC  []byte      // key for synthetic nonces of key suffixes
+
+	// writeMu is held for reading during writes and for writing by rekey batches.
+	writeMu sync.RWMutex
+
+	mu    sync.Mutex // protects rekey
+	rekey *RekeyStats
+}
+
+func (s *Store) loadKeys() error {
+	keys, err := readKeyFile(s.config.keyfile)
+	if err != nil {
+		return err
+	}
+	s.aeads = make(map[byte]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		if s.aeads[id], err = newAEAD(key); err != nil {
+			return err
+		}
+		if id > s.current {
+			s.current = id
+		}
+	}
+	if s.config.encryptKeys {
+		key, found := keys[1]
+		if !found {
+			return fmt.Errorf("key ID 1 must be in key file %s to encrypt keys", s.config.keyfile)
+		}
+		if s.nameAEAD, err = newAEAD(deriveKey(key, "dvid key encryption")); err != nil {
+			return err
+		}
+		s.nameMAC = deriveKey(key, "dvid key nonce")
+	}
+	return nil
+}
+
+func (s *Store) String() string {
+	return fmt.Sprintf("encrypted %q", s.config.store)
+}
+
+// Equal returns true if the configuration is for the same encryption of the same store.
+func (s *Store) Equal(config dvid.StoreConfig) bool {
+	if config.Engine != "encrypted" {
+		return false
+	}
+	c, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return c == s.config
+}
+
+// Close does nothing since the underlying store is closed by the storage manager.
+func (s *Store) Close() {}
+
+// Stats returns the current encryption statistics.
+func (s *Store) Stats() interface{} {
+	stats := Stats{
+		Store:         s.config.store,
+		CurrentKeyID:  int(s.current),
+		EncryptKeys:   s.config.encryptKeys,
+		PlainKeyBytes: s.config.plainKeyBytes,
+	}
+	for id := range s.aeads {
+		stats.KeyIDs = append(stats.KeyIDs, int(id))
+	}
+	sort.Ints(stats.KeyIDs)
+	s.mu.Lock()
+	if s.rekey != nil {
+		rekey := *s.rekey
+		stats.Rekey = &rekey
+	}
+	s.mu.Unlock()
+	return stats
+}
+
+// --- values ---
+
+// valueAAD returns the additional authenticated data binding a value to its plaintext key,
+// so values can't be moved among keys in the underlying store.  Data keys leave out their
+// version, client, and tombstone tail since versioned reads return values stored at
+// ancestor versions.
+func valueAAD(k storage.Key) []byte {
+	if _, ok := splitKey(k); ok {
+		return k[:len(k)-keyTailSize]
+	}
+	return k
+}
+
+// encrypt returns the current key ID, a random nonce, and the value sealed with its
+// plaintext key.
+func (s *Store) encrypt(k storage.Key, v []byte) ([]byte, error) {
+	out := make([]byte, 1+nonceSize, 1+nonceSize+len(v)+s.aeads[s.current].Overhead())
+	out[0] = s.current
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %v", err)
+	}
+	return s.aeads[s.current].Seal(out, out[1:], v, valueAAD(k)), nil
+}
+
+func (s *Store) decrypt(k storage.Key, v []byte) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if len(v) < 1+nonceSize {
+		return nil, fmt.Errorf("encrypted value of %d bytes is too short", len(v))
+	}
+	aead, found := s.aeads[v[0]]
+	if !found {
+		return nil, fmt.Errorf("value encrypted with key ID %d, which is not in key file %s", v[0], s.config.keyfile)
+	}
+	plain, err := aead.Open(nil, v[1:1+nonceSize], v[1+nonceSize:], valueAAD(k))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt value: %v", err)
+	}
+	return plain, nil
+}
+
+// --- keys ---
+
+// Contexts either use type-specific keys within data keys, use metadata keys, which are
+// never encrypted, or are raw contexts that pass full keys as type-specific keys.
+type keyMode int
+
+const (
+	plainKeys keyMode = iota
+	dataTKeys
+	fullKeys
+)
+
+func (s *Store) keyMode(ctx storage.Context) keyMode {
+	if !s.config.encryptKeys {
+		return plainKeys
+	}
+	k := ctx.ConstructKey(storage.TKey{})
+	switch {
+	case len(k) == 0:
+		return fullKeys
+	case k[0] == dataKeyPrefix:
+		return dataTKeys
+	default:
+		return plainKeys
+	}
+}
+
+// plainLen returns the length of the plaintext prefix of a type-specific key or -1 if
+// the entire key is plaintext.
+func (s *Store) plainLen(tk []byte) int {
+	n := 2 + s.config.plainKeyBytes
+	if len(tk) <= n || tk[1] != tkeyStandardByte {
+		return -1
+	}
+	return n
+}
+
+func (s *Store) encTKey(tk storage.TKey) storage.TKey {
+	n := s.plainLen(tk)
+	if n < 0 {
+		return tk
+	}
+	mac := hmac.New(sha256.New, s.nameMAC)
+	mac.Write(tk)
+	out := make([]byte, n+nonceSize, n+nonceSize+len(tk)-n+s.nameAEAD.Overhead())
+	copy(out, tk[:n])
+	copy(out[n:], mac.Sum(nil)[:nonceSize])
+	return storage.TKey(s.nameAEAD.Seal(out, out[n:], tk[n:], tk[:n]))
+}
+
+func (s *Store) decTKey(tk storage.TKey) (storage.TKey, error) {
+	n := s.plainLen(tk)
+	if n < 0 {
+		return tk, nil
+	}
+	if len(tk) < n+nonceSize {
+		return nil, fmt.Errorf("encrypted key suffix is too short")
+	}
+	out := make([]byte, n, len(tk))
+	copy(out, tk[:n])
+	out, err := s.nameAEAD.Open(out, tk[n:n+nonceSize], tk[n+nonceSize:], tk[:n])
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt key: %v", err)
+	}
+	return storage.TKey(out), nil
+}
+
+// lowerTKey and upperTKey return encrypted bounds for all keys with the plaintext
+// prefixes of the given range bounds.
+func (s *Store) lowerTKey(tk storage.TKey) storage.TKey {
+	if n := s.plainLen(tk); n >= 0 {
+		return tk[:n]
+	}
+	return tk
+}
+
+func (s *Store) upperTKey(tk storage.TKey) storage.TKey {
+	if n := s.plainLen(tk); n >= 0 {
+		return storage.TKey(append(append([]byte{}, tk[:n]...), maxSuffix...))
+	}
+	return tk
+}
+
+// groupTKey returns the plaintext prefix of an encrypted type-specific key.
+func (s *Store) groupTKey(tk storage.TKey) []byte {
+	if n := s.plainLen(tk); n >= 0 {
+		return tk[:n]
+	}
+	return tk
+}
+
+// splitKey returns the type-specific key of a full data key or false if not a data key.
+func splitKey(k storage.Key) (storage.TKey, bool) {
+	start := 1 + dvid.InstanceIDSize
+	if len(k) < start+keyTailSize || k[0] != dataKeyPrefix {
+		return nil, false
+	}
+	return storage.TKey(k[start : len(k)-keyTailSize]), true
+}
+
+func joinKey(k storage.Key, tk []byte) storage.Key {
+	start := 1 + dvid.InstanceIDSize
+	out := make([]byte, 0, start+len(tk)+keyTailSize)
+	out = append(out, k[:start]...)
+	out = append(out, tk...)
+	return storage.Key(append(out, k[len(k)-keyTailSize:]...))
+}
+
+func (s *Store) encKey(k storage.Key) storage.Key {
+	if !s.config.encryptKeys {
+		return k
+	}
+	if tk, ok := splitKey(k); ok {
+		return joinKey(k, s.encTKey(tk))
+	}
+	return k
+}
+
+func (s *Store) decKey(k storage.Key) (storage.Key, error) {
+	if !s.config.encryptKeys {
+		return k, nil
+	}
+	if tk, ok := splitKey(k); ok {
+		plain, err := s.decTKey(tk)
+		if err != nil {
+			return nil, err
+		}
+		return joinKey(k, plain), nil
+	}
+	return k, nil
+}
+
+func (s *Store) lowerKey(k storage.Key) storage.Key {
+	if tk, ok := splitKey(k); ok && s.config.encryptKeys {
+		return storage.Key(append(append([]byte{}, k[:1+dvid.InstanceIDSize]...), s.lowerTKey(tk)...))
+	}
+	return k
+}
+
+func (s *Store) upperKey(k storage.Key) storage.Key {
+	if tk, ok := splitKey(k); ok && s.config.encryptKeys && s.plainLen(tk) >= 0 {
+		return storage.Key(append(append([]byte{}, k[:1+dvid.InstanceIDSize]...), s.upperTKey(tk)...))
+	}
+	return k
+}
+
+func (s *Store) groupKey(k storage.Key) []byte {
+	if tk, ok := splitKey(k); ok {
+		return append(append([]byte{}, k[:1+dvid.InstanceIDSize]...), s.groupTKey(tk)...)
+	}
+	return k
+}
+
+// ctxTKey returns the key to use with the underlying store for a key in the context.
+func (s *Store) ctxTKey(ctx storage.Context, tk storage.TKey) storage.TKey {
+	switch s.keyMode(ctx) {
+	case dataTKeys:
+		return s.encTKey(tk)
+	case fullKeys:
+		return storage.TKey(s.encKey(storage.Key(tk)))
+	default:
+		return tk
+	}
+}
+
+// rangeMode returns the key mode for range queries, which cannot use raw contexts since
+// stores return type-specific keys extracted from full keys.
+func (s *Store) rangeMode(ctx storage.Context) (keyMode, error) {
+	mode := s.keyMode(ctx)
+	if mode == fullKeys {
+		return mode, fmt.Errorf("encrypted store %s cannot query ranges with raw context %s", s, ctx)
+	}
+	return mode, nil
+}
+
+// ctxRange returns the range to query in the underlying store for a range in the context.
+func (s *Store) ctxRange(mode keyMode, kStart, kEnd storage.TKey) (storage.TKey, storage.TKey) {
+	if mode == dataTKeys {
+		return s.lowerTKey(kStart), s.upperTKey(kEnd)
+	}
+	return kStart, kEnd
+}
+
+func inRange(k, kStart, kEnd []byte) bool {
+	return bytes.Compare(kStart, k) <= 0 && bytes.Compare(k, kEnd) <= 0
+}
+
+// sortItem holds a result with its plaintext key for sorting.
+type sortItem struct {
+	key []byte
+	v   interface{}
+}
+
+type sortItems []sortItem
+
+func (s sortItems) Len() int           { return len(s) }
+func (s sortItems) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortItems) Less(i, j int) bool { return bytes.Compare(s[i].key, s[j].key) < 0 }
+
+// groupSorter restores plaintext key order to results read in the underlying store's
+// key order.  Since encrypted keys only differ from plaintext keys after their plaintext
+// prefix, results are buffered and sorted for each plaintext prefix.
+type groupSorter struct {
+	group []byte
+	items sortItems
+	emit  func(interface{}) error
+}
+
+func (g *groupSorter) add(group, key []byte, v interface{}) error {
+	if len(g.items) != 0 && !bytes.Equal(group, g.group) {
+		if err := g.flush(); err != nil {
+			return err
+		}
+	}
+	if len(g.items) == 0 {
+		g.group = append(g.group[:0], group...)
+	}
+	g.items = append(g.items, sortItem{key, v})
+	return nil
+}
+
+func (g *groupSorter) flush() error {
+	sort.Sort(g.items)
+	for _, item := range g.items {
+		if err := g.emit(item.v); err != nil {
+			return err
+		}
+	}
+	g.items = g.items[:0]
+	return nil
+}
+
+// errStopIteration signals the early, successful end of an iteration.
+var errStopIteration = fmt.Errorf("iteration stopped")
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a decrypted value given a key.
+func (s *Store) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	v, err := s.db.Get(ctx, s.ctxTKey(ctx, tk))
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(ctx.ConstructKey(tk), v)
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).
+func (s *Store) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	mode, err := s.rangeMode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	beg, end := s.ctxRange(mode, kStart, kEnd)
+	keys, err := s.db.KeysInRange(ctx, beg, end)
+	if err != nil || mode == plainKeys {
+		return keys, err
+	}
+	items := make(sortItems, 0, len(keys))
+	for _, tk := range keys {
+		plain, err := s.decTKey(tk)
+		if err != nil {
+			return nil, err
+		}
+		if inRange(plain, kStart, kEnd) {
+			items = append(items, sortItem{plain, nil})
+		}
+	}
+	sort.Sort(items)
+	keys = make([]storage.TKey, len(items))
+	for i, item := range items {
+		keys[i] = storage.TKey(item.key)
+	}
+	return keys, nil
+}
+
+// GetRange returns a range of decrypted values spanning (kStart, kEnd) keys.
+func (s *Store) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	mode, err := s.rangeMode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	beg, end := s.ctxRange(mode, kStart, kEnd)
+	kvs, err := s.db.GetRange(ctx, beg, end)
+	if err != nil {
+		return nil, err
+	}
+	items := make(sortItems, 0, len(kvs))
+	for _, kv := range kvs {
+		dec := &storage.TKeyValue{K: kv.K}
+		if mode == dataTKeys {
+			if dec.K, err = s.decTKey(kv.K); err != nil {
+				return nil, err
+			}
+			if !inRange(dec.K, kStart, kEnd) {
+				continue
+			}
+		}
+		if dec.V, err = s.decrypt(ctx.ConstructKey(dec.K), kv.V); err != nil {
+			return nil, err
+		}
+		items = append(items, sortItem{dec.K, dec})
+	}
+	if mode != plainKeys {
+		sort.Sort(items)
+	}
+	kvs = make([]*storage.TKeyValue, len(items))
+	for i, item := range items {
+		kvs[i] = item.v.(*storage.TKeyValue)
+	}
+	return kvs, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  End of range is marked
+// by a nil key.
+func (s *Store) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	mode, err := s.rangeMode(ctx)
+	if err != nil {
+		return err
+	}
+	beg, end := s.ctxRange(mode, kStart, kEnd)
+	if mode == plainKeys {
+		return s.db.SendKeysInRange(ctx, beg, end, ch)
+	}
+	sorter := groupSorter{emit: func(v interface{}) error {
+		ch <- v.(storage.Key)
+		return nil
+	}}
+	mid := make(storage.KeyChan, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.db.SendKeysInRange(ctx, beg, end, mid)
+		close(mid)
+	}()
+	var decErr error
+	for k := range mid {
+		if k == nil || decErr != nil {
+			continue
+		}
+		plain, err := s.decKey(k)
+		if err != nil {
+			decErr = err
+			continue
+		}
+		cmp := []byte(plain)
+		if mode == dataTKeys {
+			if cmp, err = storage.TKeyFromKey(plain); err != nil {
+				decErr = err
+				continue
+			}
+		}
+		if inRange(cmp, kStart, kEnd) {
+			sorter.add(s.groupKey(k), plain, plain)
+		}
+	}
+	err = <-done
+	if err == nil {
+		err = decErr
+	}
+	sorter.flush()
+	ch <- nil
+	return err
+}
+
+// ProcessRange sends a range of decrypted key-value pairs to chunk handlers.
+func (s *Store) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	mode, err := s.rangeMode(ctx)
+	if err != nil {
+		return err
+	}
+	beg, end := s.ctxRange(mode, kStart, kEnd)
+	sorter := groupSorter{emit: func(v interface{}) error {
+		return f(v.(*storage.Chunk))
+	}}
+	err = s.db.ProcessRange(ctx, beg, end, op, func(c *storage.Chunk) error {
+		if c == nil || c.TKeyValue == nil {
+			return f(c)
+		}
+		var err error
+		if mode == plainKeys {
+			if c.V, err = s.decrypt(ctx.ConstructKey(c.K), c.V); err != nil {
+				return err
+			}
+			return f(c)
+		}
+		group := s.groupTKey(c.K)
+		if c.K, err = s.decTKey(c.K); err != nil {
+			return err
+		}
+		if !inRange(c.K, kStart, kEnd) {
+			if op != nil && op.Wg != nil {
+				op.Wg.Done()
+			}
+			return nil
+		}
+		if c.V, err = s.decrypt(ctx.ConstructKey(c.K), c.V); err != nil {
+			return err
+		}
+		return sorter.add(group, c.K, c)
+	})
+	if err != nil {
+		return err
+	}
+	return sorter.flush()
+}
+
+// RawRangeQuery sends a range of full keys with decrypted keys and values.  A nil is
+// sent down the channel when the range is complete.
+func (s *Store) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	mid := make(chan *storage.KeyValue, 100)
+	midCancel := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.db.RawRangeQuery(s.lowerKey(kStart), s.upperKey(kEnd), keysOnly, mid, midCancel)
+		close(mid)
+	}()
+	sorter := groupSorter{emit: func(v interface{}) error {
+		select {
+		case out <- v.(*storage.KeyValue):
+			return nil
+		case <-cancel:
+			return errStopIteration
+		}
+	}}
+	var err error
+	for kv := range mid {
+		if kv == nil || kv.K == nil || err != nil {
+			continue
+		}
+		// The received key-value may still be in use by the underlying store, so a new
+		// one holds the decrypted key and value.
+		group := s.groupKey(kv.K)
+		dec := new(storage.KeyValue)
+		if dec.K, err = s.decKey(kv.K); err != nil {
+			break
+		}
+		if !inRange(dec.K, kStart, kEnd) {
+			continue
+		}
+		if !keysOnly {
+			if dec.V, err = s.decrypt(dec.K, kv.V); err != nil {
+				break
+			}
+		}
+		if s.config.encryptKeys {
+			err = sorter.add(group, dec.K, dec)
+		} else {
+			err = sorter.emit(dec)
+		}
+	}
+	if err != nil {
+		// Stop the underlying query and drain it.
+		midCancel <- struct{}{}
+		for range mid {
+		}
+	}
+	if dbErr := <-done; err == nil {
+		err = dbErr
+	}
+	if err == nil {
+		err = sorter.flush()
+	}
+	if err == errStopIteration {
+		return nil
+	}
+	out <- nil
+	return err
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes an encrypted value with given key.
+func (s *Store) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	ev, err := s.encrypt(ctx.ConstructKey(tk), v)
+	if err != nil {
+		return err
+	}
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.db.Put(ctx, s.ctxTKey(ctx, tk), ev)
+}
+
+// Delete removes a value with given key.
+func (s *Store) Delete(ctx storage.Context, tk storage.TKey) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.db.Delete(ctx, s.ctxTKey(ctx, tk))
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+func (s *Store) RawPut(k storage.Key, v []byte) error {
+	ev, err := s.encrypt(k, v)
+	if err != nil {
+		return err
+	}
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.db.RawPut(s.encKey(k), ev)
+}
+
+// RawDelete is a low-level function that deletes a key-value pair using full keys.
+func (s *Store) RawDelete(k storage.Key) error {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.db.RawDelete(s.encKey(k))
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs with encrypted values.
+func (s *Store) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	ekvs := make([]storage.TKeyValue, len(kvs))
+	for i, kv := range kvs {
+		ev, err := s.encrypt(ctx.ConstructKey(kv.K), kv.V)
+		if err != nil {
+			return err
+		}
+		ekvs[i] = storage.TKeyValue{K: s.ctxTKey(ctx, kv.K), V: ev}
+	}
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.db.PutRange(ctx, ekvs)
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.  If keys are
+// encrypted, the keys in range are found and deleted individually.
+func (s *Store) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	if s.keyMode(ctx) == plainKeys {
+		s.writeMu.RLock()
+		defer s.writeMu.RUnlock()
+		return s.db.DeleteRange(ctx, kStart, kEnd)
+	}
+	keys, err := s.KeysInRange(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	batch := s.NewBatch(ctx)
+	for _, tk := range keys {
+		batch.Delete(tk)
+	}
+	return batch.Commit()
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (s *Store) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.db.DeleteAll(ctx, allVersions)
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the sizes of the given key ranges in the underlying store.
+func (s *Store) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	sv, ok := s.db.(storage.SizeViewer)
+	if !ok {
+		return nil, fmt.Errorf("store %s cannot report sizes", s.db)
+	}
+	eranges := make([]storage.KeyRange, len(ranges))
+	for i, r := range ranges {
+		eranges[i] = storage.KeyRange{Start: s.lowerKey(r.Start), OpenEnd: s.upperKey(r.OpenEnd)}
+	}
+	return sv.GetApproximateSizes(eranges)
+}
+
+// --- Batcher interface ----
+
+type batch struct {
+	s     *Store
+	batch storage.Batch
+	ctx   storage.Context
+	err   error // first encryption error, returned on commit
+}
+
+// NewBatch returns an implementation that allows batch writes of encrypted values.
+func (s *Store) NewBatch(ctx storage.Context) storage.Batch {
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	return &batch{s: s, batch: s.db.(storage.KeyValueBatcher).NewBatch(ctx), ctx: ctx}
+}
+
+func (b *batch) Delete(tk storage.TKey) {
+	b.batch.Delete(b.s.ctxTKey(b.ctx, tk))
+}
+
+func (b *batch) Put(tk storage.TKey, v []byte) {
+	ev, err := b.s.encrypt(b.ctx.ConstructKey(tk), v)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	b.batch.Put(b.s.ctxTKey(b.ctx, tk), ev)
+}
+
+func (b *batch) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.s.writeMu.RLock()
+	defer b.s.writeMu.RUnlock()
+	return b.batch.Commit()
+}
+
+// --- Rekey ---
+
+// Rekey re-encrypts all values not encrypted with the current key.  Writes are blocked
+// while each batch of key-value pairs is re-encrypted.
+func (s *Store) Rekey() error {
+	s.mu.Lock()
+	if s.rekey != nil && s.rekey.Running {
+		s.mu.Unlock()
+		return fmt.Errorf("rekey of %s already running", s)
+	}
+	stats := &RekeyStats{
+		KeyID:   int(s.current),
+		Running: true,
+		Started: time.Now(),
+	}
+	s.rekey = stats
+	s.mu.Unlock()
+
+	dvid.Infof("Starting rekey of %s with key ID %d...\n", s, s.current)
+	maxKey := storage.Key(bytes.Repeat([]byte{0xFF}, 32))
+	begKey := storage.Key{}
+	var err error
+	for {
+		var done bool
+		if done, begKey, err = s.rekeyBatch(begKey, maxKey, stats); err != nil || done {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	stats.Running = false
+	stats.Finished = time.Now()
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		dvid.Errorf("Rekey of %s failed: %v\n", s, err)
+		return err
+	}
+	dvid.Infof("Finished rekey of %s: scanned %d and re-encrypted %d key-value pairs in %s.\n",
+		s, stats.Scanned, stats.Rekeyed, time.Since(stats.Started))
+	return nil
+}
+
+// rekeyBatch re-encrypts one batch of key-value pairs starting at begKey and returns
+// the next key to process or done if all keys were processed.
+func (s *Store) rekeyBatch(begKey, maxKey storage.Key, stats *RekeyStats) (done bool, next storage.Key, err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var kvs []*storage.KeyValue
+	if kvs, err = storage.ReadRawBatch(s.db, begKey, maxKey, RekeyBatchSize); err != nil {
+		return
+	}
+	if len(kvs) < RekeyBatchSize {
+		done = true
+	}
+	var rekeyed uint64
+	for _, kv := range kvs {
+		if len(kv.V) == 0 || kv.V[0] == s.current {
+			continue
+		}
+		var k storage.Key
+		if k, err = s.decKey(kv.K); err != nil {
+			return
+		}
+		var v []byte
+		if v, err = s.decrypt(k, kv.V); err != nil {
+			return
+		}
+		if v, err = s.encrypt(k, v); err != nil {
+			return
+		}
+		if err = s.db.RawPut(kv.K, v); err != nil {
+			return
+		}
+		rekeyed++
+	}
+	if len(kvs) != 0 {
+		next = append(append(storage.Key{}, kvs[len(kvs)-1].K...), 0)
+	}
+
+	s.mu.Lock()
+	stats.Scanned += uint64(len(kvs))
+	stats.Rekeyed += rekeyed
+	s.mu.Unlock()
+	return
+}
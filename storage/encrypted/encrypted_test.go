@@ -0,0 +1,292 @@
+package encrypted
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/memstore"
+)
+
+func openMemstore(t *testing.T) (storage.OrderedKeyValueDB, func()) {
+	var e memstore.Engine
+	backend, err := e.GetTestConfig()
+	if err != nil {
+		t.Fatalf("unable to get test config: %v\n", err)
+	}
+	config := backend.Stores["default"]
+	store, _, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("unable to create memstore: %v\n", err)
+	}
+	return store.(storage.OrderedKeyValueDB), func() { e.Delete(config) }
+}
+
+// writeKeyFile writes a key file with random keys for the given IDs.
+func writeKeyFile(t *testing.T, filename string, ids ...int) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# test keys\n")
+	for _, id := range ids {
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatalf("unable to make key: %v\n", err)
+		}
+		fmt.Fprintf(&buf, "%d %s\n", id, base64.StdEncoding.EncodeToString(key))
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("unable to write key file: %v\n", err)
+	}
+}
+
+func appendKeyFile(t *testing.T, filename string, id int) {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("unable to open key file: %v\n", err)
+	}
+	fmt.Fprintf(f, "%d %s\n", id, base64.StdEncoding.EncodeToString(key))
+	f.Close()
+}
+
+func openEncrypted(t *testing.T, db storage.OrderedKeyValueDB, keyfile string) *Store {
+	var c dvid.Config
+	c.SetAll(map[string]interface{}{
+		"store":         "a",
+		"keyfile":       keyfile,
+		"encryptkeys":   true,
+		"plainkeybytes": 1,
+	})
+	config := dvid.StoreConfig{Config: c, Engine: "encrypted"}
+
+	var e Engine
+	store, _, err := e.NewCompositeStore(config, map[storage.Alias]dvid.Store{"a": db})
+	if err != nil {
+		t.Fatalf("unable to open encrypted store: %v\n", err)
+	}
+	return store.(*Store)
+}
+
+// dataKey returns a full data key for instance 1 and version 1.
+func dataKey(tk storage.TKey) storage.Key {
+	k := []byte{dataKeyPrefix}
+	k = append(k, dvid.InstanceID(1).Bytes()...)
+	k = append(k, tk...)
+	k = append(k, dvid.VersionID(1).Bytes()...)
+	k = append(k, dvid.ClientID(0).Bytes()...)
+	return storage.Key(append(k, 0x03))
+}
+
+func TestEncryptedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-encrypted")
+	if err != nil {
+		t.Fatalf("unable to make temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	keyfile := filepath.Join(dir, "dvid.keys")
+	writeKeyFile(t, keyfile, 1)
+
+	db, deleteDB := openMemstore(t)
+	defer deleteDB()
+	s := openEncrypted(t, db, keyfile)
+
+	// Values are encrypted in the underlying store.
+	ctx := storage.NewMetadataContext()
+	var kvs []storage.TKeyValue
+	for i := 0; i < 1500; i++ {
+		tk := storage.NewTKey(1, []byte(fmt.Sprintf("%06d", i)))
+		kvs = append(kvs, storage.TKeyValue{K: tk, V: []byte(fmt.Sprintf("value %d", i))})
+	}
+	if err := s.PutRange(ctx, kvs); err != nil {
+		t.Fatalf("error on PutRange: %v\n", err)
+	}
+	v, err := s.Get(ctx, kvs[7].K)
+	if err != nil || string(v) != "value 7" {
+		t.Fatalf("expected decrypted value 7, got %q, err %v\n", string(v), err)
+	}
+	if raw, _ := db.Get(ctx, kvs[7].K); raw == nil || bytes.Contains(raw, []byte("value 7")) {
+		t.Errorf("expected encrypted value in underlying store, got %q\n", string(raw))
+	}
+
+	// Values are bound to their keys, so one can't be swapped for another.
+	raw8, _ := db.Get(ctx, kvs[8].K)
+	if err := db.Put(ctx, kvs[9].K, raw8); err != nil {
+		t.Fatalf("error on underlying Put: %v\n", err)
+	}
+	if _, err := s.Get(ctx, kvs[9].K); err == nil {
+		t.Errorf("expected error reading value moved from another key\n")
+	}
+	if err := s.Put(ctx, kvs[9].K, kvs[9].V); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+	got, err := s.GetRange(ctx, kvs[10].K, kvs[19].K)
+	if err != nil {
+		t.Fatalf("error on GetRange: %v\n", err)
+	}
+	if len(got) != 10 || string(got[0].V) != "value 10" || string(got[9].V) != "value 19" {
+		t.Errorf("bad GetRange of metadata: %d values\n", len(got))
+	}
+
+	// Data key suffixes after the plaintext prefix are encrypted but ranges stay sorted.
+	var keys []storage.Key
+	for _, prefix := range []byte{'a', 'b'} {
+		for i := 0; i < 20; i++ {
+			tk := storage.NewTKey(2, []byte{prefix, byte(i), 'x'})
+			k := dataKey(tk)
+			keys = append(keys, k)
+			if err := s.RawPut(k, []byte(fmt.Sprintf("%c%d", prefix, i))); err != nil {
+				t.Fatalf("error on RawPut: %v\n", err)
+			}
+		}
+	}
+	if v, err := db.Get(ctx, kvs[0].K); err != nil || v == nil {
+		t.Errorf("expected metadata to be untouched by data writes: %v\n", err)
+	}
+	stored, err := storage.ReadRawBatch(db, keys[0][:1], storage.Key(maxSuffix), 1000)
+	if err != nil {
+		t.Fatalf("error reading underlying store: %v\n", err)
+	}
+	var numData int
+	for _, kv := range stored {
+		if kv.K[0] != dataKeyPrefix {
+			continue
+		}
+		numData++
+		for _, k := range keys {
+			if bytes.Equal(kv.K, k) {
+				t.Fatalf("expected encrypted key in underlying store, got plaintext %v\n", k)
+			}
+		}
+	}
+	if numData != len(keys) {
+		t.Fatalf("expected %d data keys in underlying store, got %d\n", len(keys), numData)
+	}
+
+	out := make(chan *storage.KeyValue)
+	go func() {
+		if err := s.RawRangeQuery(keys[5], keys[24], false, out, nil); err != nil {
+			t.Errorf("error on RawRangeQuery: %v\n", err)
+		}
+	}()
+	var i = 5
+	for kv := range out {
+		if kv == nil {
+			break
+		}
+		if !bytes.Equal(kv.K, keys[i]) {
+			t.Fatalf("expected key %d in range query, got %v\n", i, kv.K)
+		}
+		if expected := fmt.Sprintf("%c%d", keys[i][7], i%20); string(kv.V) != expected {
+			t.Errorf("expected value %q, got %q\n", expected, string(kv.V))
+		}
+		i++
+	}
+	if i != 25 {
+		t.Errorf("expected range query to end after key 24, got %d\n", i)
+	}
+
+	// Rotate keys and re-encrypt.
+	appendKeyFile(t, keyfile, 2)
+	s = openEncrypted(t, db, keyfile)
+	if err := s.Put(ctx, kvs[0].K, []byte("new value")); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+	if err := s.Rekey(); err != nil {
+		t.Fatalf("error on rekey: %v\n", err)
+	}
+	stats := s.Stats().(Stats)
+	if stats.CurrentKeyID != 2 || stats.Rekey == nil || stats.Rekey.Rekeyed != uint64(len(kvs)-1+len(keys)) {
+		t.Errorf("unexpected rekey stats: %v\n", stats.Rekey)
+	}
+	stored, err = storage.ReadRawBatch(db, storage.Key{}, storage.Key(maxSuffix), 10000)
+	if err != nil {
+		t.Fatalf("error reading underlying store: %v\n", err)
+	}
+	for _, kv := range stored {
+		if kv.V[0] != 2 {
+			t.Fatalf("expected all values re-encrypted with key 2, got key %d\n", kv.V[0])
+		}
+	}
+	if v, err := s.Get(ctx, kvs[1499].K); err != nil || string(v) != "value 1499" {
+		t.Errorf("expected value 1499 after rekey, got %q, err %v\n", string(v), err)
+	}
+}
+
+func TestRekeyEncryptedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-encrypted")
+	if err != nil {
+		t.Fatalf("unable to make temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	keyfile := filepath.Join(dir, "dvid.keys")
+	writeKeyFile(t, keyfile, 1)
+
+	db, deleteDB := openMemstore(t)
+	defer deleteDB()
+	s := openEncrypted(t, db, keyfile)
+
+	// Span several rekey batches of data keys with encrypted suffixes.
+	numKeys := 2*RekeyBatchSize + 500
+	keys := make([]storage.Key, numKeys)
+	for i := range keys {
+		tk := storage.NewTKey(2, []byte(fmt.Sprintf("%c%06d", 'a'+i%3, i)))
+		keys[i] = dataKey(tk)
+		if err := s.RawPut(keys[i], []byte(fmt.Sprintf("value %d", i))); err != nil {
+			t.Fatalf("error on RawPut: %v\n", err)
+		}
+	}
+
+	appendKeyFile(t, keyfile, 2)
+	s = openEncrypted(t, db, keyfile)
+	if err := s.Rekey(); err != nil {
+		t.Fatalf("error on rekey: %v\n", err)
+	}
+	stats := s.Stats().(Stats)
+	if stats.Rekey == nil || stats.Rekey.Scanned < uint64(numKeys) || stats.Rekey.Rekeyed != uint64(numKeys) {
+		t.Errorf("unexpected rekey stats: %v\n", stats.Rekey)
+	}
+	stored, err := storage.ReadRawBatch(db, storage.Key{}, storage.Key(maxSuffix), 2*numKeys)
+	if err != nil {
+		t.Fatalf("error reading underlying store: %v\n", err)
+	}
+	var numData int
+	for _, kv := range stored {
+		if kv.K[0] != dataKeyPrefix {
+			continue
+		}
+		numData++
+		if kv.V[0] != 2 {
+			t.Fatalf("expected all values re-encrypted with key 2, got key %d\n", kv.V[0])
+		}
+	}
+	if numData != numKeys {
+		t.Errorf("expected %d data keys after rekey, got %d\n", numKeys, numData)
+	}
+
+	// Every key decrypts to its value after the rekey.
+	values := make(map[string]string, numKeys)
+	out := make(chan *storage.KeyValue)
+	go func() {
+		if err := s.RawRangeQuery(keys[0][:6], storage.Key(maxSuffix), false, out, nil); err != nil {
+			t.Errorf("error on RawRangeQuery: %v\n", err)
+		}
+	}()
+	for kv := range out {
+		if kv == nil {
+			break
+		}
+		values[string(kv.K)] = string(kv.V)
+	}
+	for i, k := range keys {
+		if v := values[string(k)]; v != fmt.Sprintf("value %d", i) {
+			t.Fatalf("bad value for key %d after rekey: %q\n", i, v)
+		}
+	}
+}
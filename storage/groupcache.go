@@ -4,7 +4,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/golang/groupcache"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -27,6 +29,9 @@ type GroupcacheStats struct {
 
 	// Cache stats for replicated items from a peer.
 	HotCache groupcache.CacheStats
+
+	// Stats for requests to each peer, keyed by peer URL.
+	Peers map[string]GroupcachePeerStats
 }
 
 // GetGroupcacheStats returns all kinds of stats about the groupcache system.
@@ -51,6 +56,9 @@ func GetGroupcacheStats() (stats GroupcacheStats, err error) {
 
 	stats.MainCache = manager.gcache.cache.CacheStats(groupcache.MainCache)
 	stats.HotCache = manager.gcache.cache.CacheStats(groupcache.HotCache)
+	if manager.gcache.pool != nil {
+		stats.Peers = manager.gcache.pool.stats()
+	}
 	return
 }
 
@@ -60,6 +68,49 @@ type GroupcacheConfig struct {
 	Host      string   // The http address of this DVID server's groupcache port.
 	Peers     []string // The http addresses of the peer groupcache group.
 	Instances []string // Data instances that use groupcache in form "<name>:<uuid>""
+
+	// Auto caches GETs of all data instances at locked versions, which are immutable.
+	Auto bool
+}
+
+// GroupcacheResolver supplies groupcache with information only available from the
+// datastore.  It is set by the datastore package.
+type GroupcacheResolver interface {
+	// GroupcacheContext returns the context and store for a data instance at a version
+	// so keys requested by peers can be loaded.
+	GroupcacheContext(dvid.InstanceID, dvid.VersionID) (Context, KeyValueDB, error)
+
+	// LockedVersion returns true if the version is locked and therefore immutable.
+	LockedVersion(dvid.VersionID) (bool, error)
+}
+
+// SetGroupcacheResolver sets the resolver used for peer requests and automatic caching.
+func SetGroupcacheResolver(r GroupcacheResolver) {
+	manager.gcache.resolver = r
+}
+
+// GetGroupcachePeers returns the current groupcache peers other than this server.
+func GetGroupcachePeers() ([]string, error) {
+	if manager.gcache.pool == nil {
+		return nil, fmt.Errorf("groupcache is not configured for this server")
+	}
+	return manager.gcache.pool.list(), nil
+}
+
+// SetGroupcachePeers replaces the groupcache peers at runtime.  Keys are redistributed
+// among the new peers by consistent hashing.
+func SetGroupcachePeers(peers []string) error {
+	if manager.gcache.pool == nil {
+		return fmt.Errorf("groupcache is not configured for this server")
+	}
+	for _, peer := range peers {
+		if u, err := url.Parse(peer); err != nil || u.Host == "" {
+			return fmt.Errorf("bad groupcache peer %q: should be URL like \"http://10.0.0.2:8003\"", peer)
+		}
+	}
+	manager.gcache.pool.set(peers...)
+	dvid.Infof("Groupcache peers set to %v\n", peers)
+	return nil
 }
 
 // GroupcacheCtx packages both a storage.Context and a KeyValueDB for GETs.
@@ -70,68 +121,200 @@ type GroupcacheCtx struct {
 
 type groupcacheT struct {
 	cache     *groupcache.Group
+	pool      *peerPool
 	supported map[dvid.DataSpecifier]struct{} // set if the given data instance has groupcache support.
+	auto      bool                            // if true, all instances are cached at locked versions.
+	resolver  GroupcacheResolver
+}
+
+// groupcacheGroup and groupcachePool persist across storage initializations since
+// groupcache only allows one registration of each per process.
+var (
+	groupcacheGroup *groupcache.Group
+	groupcachePool  *peerPool
+	groupcacheOnce  sync.Once
+)
+
+// groupcacheGens holds the generation of each data instance version whose cached values
+// were invalidated.  Groupcache entries can't be removed, so the generation is part of the
+// groupcache key and bumping it makes later GETs miss older entries.
+var groupcacheGens = struct {
+	sync.RWMutex
+	gens map[groupcacheVersion]uint32
+}{
+	gens: make(map[groupcacheVersion]uint32),
+}
+
+type groupcacheVersion struct {
+	instance dvid.InstanceID
+	v        dvid.VersionID
+}
+
+// InvalidateGroupcache makes groupcache ignore values cached for a data instance at a
+// version, e.g., after a squash rewrites the values of a locked version.
+func InvalidateGroupcache(instanceID dvid.InstanceID, v dvid.VersionID) {
+	groupcacheGens.Lock()
+	groupcacheGens.gens[groupcacheVersion{instanceID, v}]++
+	groupcacheGens.Unlock()
+}
+
+func groupcacheGen(instanceID dvid.InstanceID, v dvid.VersionID) uint32 {
+	groupcacheGens.RLock()
+	defer groupcacheGens.RUnlock()
+	return groupcacheGens.gens[groupcacheVersion{instanceID, v}]
+}
+
+// loadGroupcache is the groupcache getter for keys owned by this server.  The context is
+// a GroupcacheCtx for local GETs and nil for requests from peers, whose stores are
+// resolved from the key.
+func loadGroupcache(c groupcache.Context, key string, dest groupcache.Sink) error {
+	// First eight bytes of key are instance and version IDs to isolate groupcache collisions,
+	// followed by four bytes of generation.
+	if len(key) < 12 {
+		return fmt.Errorf("bad groupcache key of %d bytes", len(key))
+	}
+	tk := TKey(key[12:])
+
+	var ctx Context
+	var db KeyValueDB
+	if gctx, ok := c.(GroupcacheCtx); ok {
+		ctx, db = gctx.Context, gctx.KeyValueDB
+	} else {
+		resolver := manager.gcache.resolver
+		if resolver == nil {
+			return fmt.Errorf("groupcache cannot resolve key requested by peer")
+		}
+		instanceID := dvid.InstanceID(binary.LittleEndian.Uint32([]byte(key[0:4])))
+		v := dvid.VersionID(binary.LittleEndian.Uint32([]byte(key[4:8])))
+		var err error
+		if ctx, db, err = resolver.GroupcacheContext(instanceID, v); err != nil {
+			return err
+		}
+
+		// Peers can only load what this server would cache for its own GETs.
+		cached, lockedOnly := groupcacheSettings(db)
+		if !cached {
+			return fmt.Errorf("groupcache not enabled for data instance %d requested by peer", instanceID)
+		}
+		if lockedOnly {
+			locked, err := resolver.LockedVersion(v)
+			if err != nil {
+				return err
+			}
+			if !locked {
+				return fmt.Errorf("groupcache only caches locked versions of data instance %d, not version %d requested by peer", instanceID, v)
+			}
+		}
+		db = unwrapGroupcache(db).(KeyValueDB)
+	}
+	data, err := db.Get(ctx, tk)
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(data)
 }
 
 func setupGroupcache(config GroupcacheConfig) error {
+	manager.gcache.cache = nil
+	manager.gcache.pool = nil
 	if config.GB == 0 {
 		return nil
 	}
+	if len(config.Peers) > 0 || config.Host != "" {
+		if _, err := url.Parse(config.Host); err != nil || config.Host == "" {
+			return fmt.Errorf("groupcache host %q must be URL like \"http://10.0.0.1:8003\"", config.Host)
+		}
+	}
 	var cacheBytes int64
 	cacheBytes = int64(config.GB) << 30
 
-	pool := groupcache.NewHTTPPool(config.Host)
-	if pool != nil {
-		dvid.Infof("Initializing groupcache with %d GB at %s...\n", config.GB, config.Host)
-		manager.gcache.cache = groupcache.NewGroup("immutable", cacheBytes, groupcache.GetterFunc(
-			func(c groupcache.Context, key string, dest groupcache.Sink) error {
-				// Use KeyValueDB defined as context.
-				gctx, ok := c.(GroupcacheCtx)
-				if !ok {
-					return fmt.Errorf("bad groupcache context: expected GroupcacheCtx, got %v", c)
-				}
-
-				// First four bytes of key is instance ID to isolate groupcache collisions.
-				tk := TKey(key[4:])
-				data, err := gctx.KeyValueDB.Get(gctx.Context, tk)
-				if err != nil {
-					return err
-				}
-				return dest.SetBytes(data)
-			}))
-		manager.gcache.supported = make(map[dvid.DataSpecifier]struct{})
-		for _, dataspec := range config.Instances {
-			name := strings.Trim(dataspec, "\"")
-			parts := strings.Split(name, ":")
-			switch len(parts) {
-			case 2:
-				dataid := dvid.GetDataSpecifier(dvid.InstanceName(parts[0]), dvid.UUID(parts[1]))
-				manager.gcache.supported[dataid] = struct{}{}
-			default:
-				dvid.Errorf("bad data instance specification %q given for groupcache support in config file\n", dataspec)
-			}
+	dvid.Infof("Initializing groupcache with %d GB at %s...\n", config.GB, config.Host)
+	var startServer bool
+	groupcacheOnce.Do(func() {
+		groupcachePool = newPeerPool(config.Host)
+		groupcache.RegisterPeerPicker(func() groupcache.PeerPicker { return groupcachePool })
+		groupcacheGroup = groupcache.NewGroup("immutable", cacheBytes, groupcache.GetterFunc(loadGroupcache))
+		startServer = true
+	})
+	if groupcachePool.self != strings.TrimSuffix(config.Host, "/") {
+		return fmt.Errorf("groupcache host cannot be changed from %q without restart", groupcachePool.self)
+	}
+	manager.gcache.cache = groupcacheGroup
+	manager.gcache.pool = groupcachePool
+	manager.gcache.auto = config.Auto
+	manager.gcache.supported = make(map[dvid.DataSpecifier]struct{})
+	for _, dataspec := range config.Instances {
+		name := strings.Trim(dataspec, "\"")
+		parts := strings.Split(name, ":")
+		switch len(parts) {
+		case 2:
+			dataid := dvid.GetDataSpecifier(dvid.InstanceName(parts[0]), dvid.UUID(parts[1]))
+			manager.gcache.supported[dataid] = struct{}{}
+		default:
+			dvid.Errorf("bad data instance specification %q given for groupcache support in config file\n", dataspec)
 		}
+	}
 
-		// If we have additional peers, add them and start a listener via the HTTP port.
-		if len(config.Peers) > 0 {
-			peers := []string{config.Host}
-			peers = append(peers, config.Peers...)
-			pool.Set(peers...)
-			dvid.Infof("Groupcache configuration has %d peers in addition to local host.\n", len(config.Peers))
-			dvid.Infof("Starting groupcache HTTP server on %s\n", config.Host)
-			http.ListenAndServe(config.Host, http.HandlerFunc(pool.ServeHTTP))
-		}
+	// Add any peers and serve requests from them via the host's port.
+	groupcachePool.set(config.Peers...)
+	if len(config.Peers) > 0 {
+		dvid.Infof("Groupcache configuration has %d peers in addition to local host.\n", len(config.Peers))
+	}
+	if startServer && config.Host != "" {
+		u, _ := url.Parse(config.Host)
+		dvid.Infof("Starting groupcache HTTP server on %s\n", u.Host)
+		go func() {
+			if err := http.ListenAndServe(u.Host, groupcachePool); err != nil {
+				dvid.Errorf("Groupcache HTTP server on %s stopped: %v\n", u.Host, err)
+			}
+		}()
 	}
 	return nil
 }
 
-// returns a store that tries groupcache before resorting to passed Store.
-func wrapGroupcache(store dvid.Store, cache *groupcache.Group) (dvid.Store, error) {
+// returns a store that tries groupcache before resorting to passed Store.  If lockedOnly,
+// groupcache is only used for GETs at locked versions.  Ordered, batch-enabled stores
+// keep those capabilities so any datatype can use an automatically cached store.
+func wrapGroupcache(store dvid.Store, cache *groupcache.Group, lockedOnly bool) (dvid.Store, error) {
 	kvstore, ok := store.(KeyValueDB)
 	if !ok {
 		return store, fmt.Errorf("store %s doesn't implement KeyValueDB", store)
 	}
-	return groupcacheStore{KeyValueDB: kvstore, cache: cache}, nil
+	g := groupcacheStore{KeyValueDB: kvstore, cache: cache, lockedOnly: lockedOnly}
+	ordered, isOrdered := store.(OrderedKeyValueDB)
+	batcher, isBatcher := store.(KeyValueBatcher)
+	if isOrdered && isBatcher {
+		return groupcacheOrderedStore{OrderedKeyValueDB: ordered, KeyValueBatcher: batcher, g: g}, nil
+	}
+	if lockedOnly {
+		return store, fmt.Errorf("store %s must be ordered and batch-enabled for automatic groupcache", store)
+	}
+	return g, nil
+}
+
+// unwrapGroupcache returns the store underlying any groupcache wrapper.
+func unwrapGroupcache(store dvid.Store) dvid.Store {
+	switch s := store.(type) {
+	case groupcacheStore:
+		return s.KeyValueDB
+	case groupcacheOrderedStore:
+		return s.OrderedKeyValueDB
+	default:
+		return store
+	}
+}
+
+// groupcacheSettings returns whether a store is wrapped by groupcache and, if so, whether
+// groupcache is only used for locked versions.
+func groupcacheSettings(store dvid.Store) (cached, lockedOnly bool) {
+	switch s := store.(type) {
+	case groupcacheStore:
+		return true, s.lockedOnly
+	case groupcacheOrderedStore:
+		return true, s.g.lockedOnly
+	default:
+		return false, false
+	}
 }
 
 type instanceProvider interface {
@@ -140,29 +323,54 @@ type instanceProvider interface {
 
 type groupcacheStore struct {
 	KeyValueDB
-	cache *groupcache.Group
+	cache      *groupcache.Group
+	lockedOnly bool
 }
 
 // only need to override the Get function of the wrapped KeyValueDB.
 func (g groupcacheStore) Get(ctx Context, k TKey) ([]byte, error) {
-	gctx := GroupcacheCtx{
-		Context:    ctx,
-		KeyValueDB: g.KeyValueDB,
-	}
 	// we only provide this server for data contexts that have InstanceID().
 	ip, ok := ctx.(instanceProvider)
 	if !ok {
 		dvid.Criticalf("groupcache Get passed a non-data context %v, falling back on normal kv store Get\n", ctx)
 		return g.KeyValueDB.Get(ctx, k)
 	}
+	if g.lockedOnly {
+		resolver := manager.gcache.resolver
+		if resolver == nil {
+			return g.KeyValueDB.Get(ctx, k)
+		}
+		locked, err := resolver.LockedVersion(ctx.VersionID())
+		if err != nil || !locked {
+			return g.KeyValueDB.Get(ctx, k)
+		}
+	}
+	gctx := GroupcacheCtx{
+		Context:    ctx,
+		KeyValueDB: g.KeyValueDB,
+	}
 
-	// the groupcache key has an instance identifier in first 4 bytes.
-	instanceBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(instanceBytes, uint32(ip.InstanceID()))
-	gkey := string(instanceBytes) + string(k)
+	// the groupcache key has instance and version identifiers in first 8 bytes followed by
+	// the generation of the instance version.
+	idBytes := make([]byte, 12)
+	binary.LittleEndian.PutUint32(idBytes[0:4], uint32(ip.InstanceID()))
+	binary.LittleEndian.PutUint32(idBytes[4:8], uint32(ctx.VersionID()))
+	binary.LittleEndian.PutUint32(idBytes[8:12], groupcacheGen(ip.InstanceID(), ctx.VersionID()))
+	gkey := string(idBytes) + string(k)
 
 	// Try to get data from groupcache, which if fails, will call the original KeyValueDB in passed Context.
 	var data []byte
 	err := g.cache.Get(groupcache.Context(gctx), gkey, groupcache.AllocatingByteSliceSink(&data))
 	return data, err
 }
+
+// groupcacheOrderedStore is a groupcache wrapper around an ordered, batch-enabled store.
+type groupcacheOrderedStore struct {
+	OrderedKeyValueDB
+	KeyValueBatcher
+	g groupcacheStore
+}
+
+func (o groupcacheOrderedStore) Get(ctx Context, k TKey) ([]byte, error) {
+	return o.g.Get(ctx, k)
+}
@@ -0,0 +1,219 @@
+/*
+	This file implements a groupcache peer pool over HTTP whose peers can be changed at
+	runtime and which keeps statistics for each peer.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/groupcache"
+	"github.com/golang/groupcache/consistenthash"
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// groupcachePath is the URL path prefix for peer requests, compatible with groupcache.HTTPPool.
+	groupcachePath = "/_groupcache/"
+
+	// number of virtual nodes per peer on the consistent hash ring.
+	groupcacheReplicas = 50
+)
+
+// GroupcachePeerStats gives the requests this server made to a groupcache peer.
+type GroupcachePeerStats struct {
+	Gets      int64  // keys requested from the peer
+	Errors    int64  // failed requests
+	Bytes     int64  // value bytes received
+	AvgMillis int64  // average latency of successful requests in milliseconds
+	LastError string `json:",omitempty"`
+}
+
+// peerGetter fetches keys from one peer and fulfills the groupcache.ProtoGetter interface.
+type peerGetter struct {
+	baseURL string
+
+	gets, errors, bytes, nanos int64 // accessed atomically
+
+	mu      sync.Mutex
+	lastErr string
+}
+
+func (p *peerGetter) Get(_ groupcache.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	atomic.AddInt64(&p.gets, 1)
+	start := time.Now()
+	n, err := p.get(in, out)
+	if err != nil {
+		atomic.AddInt64(&p.errors, 1)
+		p.mu.Lock()
+		p.lastErr = err.Error()
+		p.mu.Unlock()
+		return err
+	}
+	atomic.AddInt64(&p.bytes, int64(n))
+	atomic.AddInt64(&p.nanos, int64(time.Since(start)))
+	return nil
+}
+
+func (p *peerGetter) get(in *pb.GetRequest, out *pb.GetResponse) (int, error) {
+	u := p.baseURL + groupcachePath + url.QueryEscape(in.GetGroup()) + "/" + url.QueryEscape(in.GetKey())
+	resp, err := http.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("peer %s returned: %v", p.baseURL, resp.Status)
+	}
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, resp.Body); err != nil {
+		return 0, fmt.Errorf("reading response body from peer %s: %v", p.baseURL, err)
+	}
+	if err := proto.Unmarshal(b.Bytes(), out); err != nil {
+		return 0, fmt.Errorf("decoding response body from peer %s: %v", p.baseURL, err)
+	}
+	return len(out.Value), nil
+}
+
+func (p *peerGetter) stats() GroupcachePeerStats {
+	s := GroupcachePeerStats{
+		Gets:   atomic.LoadInt64(&p.gets),
+		Errors: atomic.LoadInt64(&p.errors),
+		Bytes:  atomic.LoadInt64(&p.bytes),
+	}
+	if ok := s.Gets - s.Errors; ok > 0 {
+		s.AvgMillis = atomic.LoadInt64(&p.nanos) / ok / int64(time.Millisecond)
+	}
+	p.mu.Lock()
+	s.LastError = p.lastErr
+	p.mu.Unlock()
+	return s
+}
+
+// peerPool picks the peer owning a key by consistent hashing and serves requests from
+// peers.  It fulfills the groupcache.PeerPicker and http.Handler interfaces.
+type peerPool struct {
+	self string // base URL of this server, e.g., "http://10.0.0.1:8003"
+
+	mu      sync.RWMutex
+	peers   *consistenthash.Map
+	getters map[string]*peerGetter // keyed by peer base URL, excluding self
+}
+
+func newPeerPool(self string) *peerPool {
+	p := &peerPool{self: strings.TrimSuffix(self, "/")}
+	p.set()
+	return p
+}
+
+// set replaces the peers, excluding self, while keeping stats of retained peers.
+func (p *peerPool) set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers = consistenthash.New(groupcacheReplicas, nil)
+	p.peers.Add(p.self)
+	getters := make(map[string]*peerGetter, len(peers))
+	for _, peer := range peers {
+		peer = strings.TrimSuffix(peer, "/")
+		if peer == p.self {
+			continue
+		}
+		p.peers.Add(peer)
+		if getter, found := p.getters[peer]; found {
+			getters[peer] = getter
+		} else {
+			getters[peer] = &peerGetter{baseURL: peer}
+		}
+	}
+	p.getters = getters
+}
+
+// list returns the sorted peers, excluding self.
+func (p *peerPool) list() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	peers := make([]string, 0, len(p.getters))
+	for peer := range p.getters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func (p *peerPool) stats() map[string]GroupcachePeerStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	stats := make(map[string]GroupcachePeerStats, len(p.getters))
+	for peer, getter := range p.getters {
+		stats[peer] = getter.stats()
+	}
+	return stats
+}
+
+// PickPeer returns the peer owning the key or false if this server owns it.
+func (p *peerPool) PickPeer(key string) (groupcache.ProtoGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.getters) == 0 {
+		return nil, false
+	}
+	owner := p.peers.Get(key)
+	if owner == p.self {
+		return nil, false
+	}
+	return p.getters[owner], true
+}
+
+// ServeHTTP handles requests from peers for keys owned by this server.
+func (p *peerPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, groupcachePath) {
+		http.Error(w, "bad groupcache path: "+r.URL.Path, http.StatusBadRequest)
+		return
+	}
+	parts := strings.SplitN(r.URL.Path[len(groupcachePath):], "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad groupcache request", http.StatusBadRequest)
+		return
+	}
+	groupName, err := url.QueryUnescape(parts[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := url.QueryUnescape(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group := groupcache.GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	group.Stats.ServerRequests.Add(1)
+
+	// A nil context makes the getter resolve the store from the key.
+	var value []byte
+	if err := group.Get(nil, key, groupcache.AllocatingByteSliceSink(&value)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := proto.Marshal(&pb.GetResponse{Value: value})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(body)
+}
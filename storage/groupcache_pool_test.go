@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/groupcache"
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestGroupcachePeerPool(t *testing.T) {
+	groupcache.NewGroup("pooltest", 1<<20, groupcache.GetterFunc(
+		func(_ groupcache.Context, key string, dest groupcache.Sink) error {
+			return dest.SetString("value of " + key)
+		}))
+	server := httptest.NewServer(newPeerPool("http://unused"))
+	defer server.Close()
+
+	pool := newPeerPool("http://10.0.0.1:8003")
+	if _, remote := pool.PickPeer("somekey"); remote {
+		t.Fatalf("expected all keys to be local without peers\n")
+	}
+	pool.set(server.URL, "http://10.0.0.1:8003/")
+	if peers := pool.list(); len(peers) != 1 || peers[0] != server.URL {
+		t.Fatalf("expected only peer %q, got %v\n", server.URL, peers)
+	}
+
+	// With two members of the ring, some keys should be owned by the peer.
+	var remoteKey string
+	for i := 0; i < 100 && remoteKey == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if _, remote := pool.PickPeer(key); remote {
+			remoteKey = key
+		}
+	}
+	if remoteKey == "" {
+		t.Fatalf("expected some keys to be owned by peer\n")
+	}
+	getter, _ := pool.PickPeer(remoteKey)
+	var resp pb.GetResponse
+	req := &pb.GetRequest{Group: proto.String("pooltest"), Key: proto.String(remoteKey)}
+	if err := getter.Get(nil, req, &resp); err != nil {
+		t.Fatalf("error getting key from peer: %v\n", err)
+	}
+	if string(resp.Value) != "value of "+remoteKey {
+		t.Errorf("bad value from peer: %q\n", string(resp.Value))
+	}
+	req.Group = proto.String("nosuchgroup")
+	if err := getter.Get(nil, req, &resp); err == nil {
+		t.Errorf("expected error getting key from nonexistent group\n")
+	}
+
+	// Stats are kept for retained peers when peers change.
+	pool.set(server.URL, "http://10.0.0.4:8003")
+	stats := pool.stats()
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for 2 peers, got %v\n", stats)
+	}
+	s := stats[server.URL]
+	if s.Gets != 2 || s.Errors != 1 || s.Bytes != int64(len("value of "+remoteKey)) || s.LastError == "" {
+		t.Errorf("bad peer stats: %+v\n", s)
+	}
+	pool.set()
+	if len(pool.stats()) != 0 {
+		t.Errorf("expected no peer stats after removing peers\n")
+	}
+}
+
+// peerTestDB returns the same value for any key.
+type peerTestDB struct {
+	KeyValueDB
+}
+
+func (db peerTestDB) Get(ctx Context, tk TKey) ([]byte, error) {
+	return []byte("cached"), nil
+}
+
+// peerTestResolver resolves any instance to a store and has only version 1 locked.
+type peerTestResolver struct {
+	db KeyValueDB
+}
+
+func (r peerTestResolver) GroupcacheContext(dvid.InstanceID, dvid.VersionID) (Context, KeyValueDB, error) {
+	return nil, r.db, nil
+}
+
+func (r peerTestResolver) LockedVersion(v dvid.VersionID) (bool, error) {
+	return v == 1, nil
+}
+
+func TestGroupcachePeerChecks(t *testing.T) {
+	oldResolver := manager.gcache.resolver
+	defer func() {
+		manager.gcache.resolver = oldResolver
+	}()
+
+	peerKey := func(v dvid.VersionID) string {
+		idBytes := make([]byte, 12)
+		binary.LittleEndian.PutUint32(idBytes[0:4], 7)
+		binary.LittleEndian.PutUint32(idBytes[4:8], uint32(v))
+		return string(idBytes) + "somekey"
+	}
+	load := func(v dvid.VersionID) error {
+		var data []byte
+		return loadGroupcache(nil, peerKey(v), groupcache.AllocatingByteSliceSink(&data))
+	}
+
+	// Instances without groupcache are never loaded for peers.
+	SetGroupcacheResolver(peerTestResolver{peerTestDB{}})
+	if err := load(1); err == nil {
+		t.Errorf("expected error loading instance without groupcache for peer\n")
+	}
+
+	// Instances configured for groupcache are loaded at any version.
+	SetGroupcacheResolver(peerTestResolver{groupcacheStore{KeyValueDB: peerTestDB{}}})
+	if err := load(2); err != nil {
+		t.Errorf("unexpected error loading configured instance for peer: %v\n", err)
+	}
+
+	// Automatically cached instances are only loaded at locked versions.
+	SetGroupcacheResolver(peerTestResolver{groupcacheStore{KeyValueDB: peerTestDB{}, lockedOnly: true}})
+	if err := load(1); err != nil {
+		t.Errorf("unexpected error loading locked version for peer: %v\n", err)
+	}
+	if err := load(2); err == nil {
+		t.Errorf("expected error loading unlocked version for peer\n")
+	}
+}
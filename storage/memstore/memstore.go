@@ -0,0 +1,790 @@
+/*
+	Package memstore implements a pure Go, in-memory ordered key-value store.  It
+	requires no cgo or external libraries so it can be compiled into any DVID
+	executable and is always available for testing.
+
+	Stores are identified by their "path" setting, which is only used as a name.
+	Closing a store does not discard its contents, so a server or test can close
+	and reopen a store with the same configuration.  Data is lost when the process
+	exits or the store is deleted via the TestableEngine interface.
+*/
+package memstore
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+)
+
+// Number of key-value pairs copied out of the store per lock acquisition
+// during range iteration.  Callbacks are never run while holding the lock.
+const scanBatchSize = 1000
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in memstore: %v\n", err)
+	}
+	e := Engine{"memstore", "In-memory ordered key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+var (
+	// all stores that have been created, keyed by path.
+	stores   map[string]*MemDB
+	storesMu sync.Mutex
+)
+
+func init() {
+	stores = make(map[string]*MemDB)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns an in-memory store.  The passed Config must contain a "path" string
+// that names the store.  If a store of that name already exists in this process, it
+// is returned with its contents intact.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	path, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	db, found := stores[path]
+	if !found {
+		dvid.Infof("Creating new memstore %q\n", path)
+		db = &MemDB{path: path, config: config, data: newSkiplist()}
+		stores[path] = db
+		return db, true, nil
+	}
+	dvid.Infof("Reopening memstore %q with %d key-value pairs\n", path, db.data.length)
+	return db, !db.metadataExists(), nil
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for memstore configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+	}
+	return
+}
+
+// ---- TestableEngine interface implementation -------
+
+// GetTestConfig returns a set of store configurations suitable for testing
+// a memstore storage system.
+func (e Engine) GetTestConfig() (*storage.Backend, error) {
+	tc := map[string]interface{}{
+		"path": fmt.Sprintf("dvid-test-%x", uuid.NewV4().Bytes()),
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	testConfig := map[storage.Alias]dvid.StoreConfig{
+		"default": dvid.StoreConfig{Config: c, Engine: "memstore"},
+	}
+	backend := storage.Backend{
+		Stores: testConfig,
+	}
+	return &backend, nil
+}
+
+// Delete implements the TestableEngine interface by discarding the named store.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	storesMu.Lock()
+	delete(stores, path)
+	storesMu.Unlock()
+	return nil
+}
+
+// --- The memstore implementation must satisfy a Engine interface ----
+
+// MemDB is an in-memory ordered key-value store.
+type MemDB struct {
+	path   string
+	config dvid.StoreConfig
+
+	sync.RWMutex
+	data *skiplist
+}
+
+func (db *MemDB) String() string {
+	return fmt.Sprintf("memstore @ %s", db.path)
+}
+
+// Close is a no-op since contents are retained until the engine deletes the store.
+func (db *MemDB) Close() {}
+
+// Equal returns true if the memstore matches the given store configuration.
+func (db *MemDB) Equal(config dvid.StoreConfig) bool {
+	path, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.path == path
+}
+
+func (db *MemDB) metadataExists() bool {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	db.RLock()
+	defer db.RUnlock()
+	n := db.data.seek(keyBeg)
+	return n != nil && bytes.Compare(n.key, keyEnd) <= 0
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// scan copies up to max key-value pairs with begKey <= key <= endKey.
+func (db *MemDB) scan(begKey, endKey storage.Key, keysOnly bool, max int) []*storage.KeyValue {
+	db.RLock()
+	defer db.RUnlock()
+
+	var kvs []*storage.KeyValue
+	for n := db.data.seek(begKey); n != nil && len(kvs) < max; n = n.next[0] {
+		if bytes.Compare(n.key, endKey) > 0 {
+			break
+		}
+		kv := &storage.KeyValue{K: copyBytes(n.key)}
+		if !keysOnly {
+			kv.V = copyBytes(n.value)
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+// iterate calls f for each key-value pair with begKey <= key <= endKey in ascending
+// key order.  The store lock is not held when f is called, so f may modify the store.
+func (db *MemDB) iterate(begKey, endKey storage.Key, keysOnly bool, f func(*storage.KeyValue) error) error {
+	seekKey := begKey
+	for {
+		kvs := db.scan(seekKey, endKey, keysOnly, scanBatchSize)
+
+		// The smallest key following the last one read, found before f can modify it.
+		var nextKey storage.Key
+		if len(kvs) == scanBatchSize {
+			nextKey = append(copyBytes(kvs[len(kvs)-1].K), 0)
+		}
+		for _, kv := range kvs {
+			storage.StoreKeyBytesRead <- len(kv.K)
+			if !keysOnly {
+				storage.StoreValueBytesRead <- len(kv.V)
+			}
+			if err := f(kv); err != nil {
+				return err
+			}
+		}
+		if nextKey == nil {
+			return nil
+		}
+		seekKey = nextKey
+	}
+}
+
+// errStopIteration signals the early, successful end of an iteration.
+var errStopIteration = fmt.Errorf("iteration stopped")
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *MemDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GET on nil memstore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	db.RLock()
+	v, found := db.data.get(key)
+	if found {
+		v = copyBytes(v)
+	}
+	db.RUnlock()
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// getSingleKeyVersions returns all versions of a key.  These key-value pairs will be sorted
+// in ascending key order and could include a tombstone key.
+func (db *MemDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	values := []*storage.KeyValue{}
+	err = db.iterate(begKey, endKey, false, func(kv *storage.KeyValue) error {
+		values = append(values, kv)
+		return nil
+	})
+	return values, err
+}
+
+// versionedRange calls f with the key-value pair appropriate for the context's version
+// for each type-specific key in the range.
+func (db *MemDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		return err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		values = []*storage.KeyValue{}
+		if err != nil {
+			return err
+		}
+		if kv != nil {
+			return f(kv)
+		}
+		return nil
+	}
+
+	err = db.iterate(minKey, maxKey, keysOnly, func(kv *storage.KeyValue) error {
+		// Did we pass all versions for last key read?
+		if bytes.Compare(kv.K, maxVersionKey) > 0 {
+			tk, err := storage.TKeyFromKey(kv.K)
+			if err != nil {
+				return err
+			}
+			maxVersionKey, err = vctx.MaxVersionKey(tk)
+			if err != nil {
+				return err
+			}
+			if err := sendKV(); err != nil {
+				return err
+			}
+		}
+		values = append(values, kv)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return sendKV()
+}
+
+// unversionedRange calls f for each key-value pair in the range.
+func (db *MemDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	begKey := ctx.ConstructKey(begTKey)
+	endKey := ctx.ConstructKey(endTKey)
+	return db.iterate(begKey, endKey, keysOnly, f)
+}
+
+// processRange runs the versioned or unversioned range query appropriate for the context.
+func (db *MemDB) processRange(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	if !ctx.Versioned() {
+		return db.unversionedRange(ctx, kStart, kEnd, keysOnly, f)
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	return db.versionedRange(vctx, kStart, kEnd, keysOnly, f)
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *MemDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil memstore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := db.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *MemDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil memstore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := db.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		kch <- kv.K
+		return nil
+	})
+	kch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *MemDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil memstore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := db.processRange(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *MemDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil memstore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	return db.processRange(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		return f(chunk)
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *MemDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil memstore")
+	}
+	err := db.iterate(kStart, kEnd, keysOnly, func(kv *storage.KeyValue) error {
+		select {
+		case out <- kv:
+			return nil
+		case <-cancel:
+			return errStopIteration
+		}
+	})
+	if err == errStopIteration {
+		return nil
+	}
+	out <- nil
+	return err
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *MemDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil memstore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *MemDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil memstore")
+	}
+	db.Lock()
+	db.data.put(copyBytes(k), copyBytes(v))
+	db.Unlock()
+
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *MemDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil memstore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *MemDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil memstore")
+	}
+	db.Lock()
+	db.data.delete(k)
+	db.Unlock()
+	return nil
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+// All puts are applied atomically.
+func (db *MemDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil memstore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *MemDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil memstore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+
+	// Collect the visible keys first, then tombstone or delete them in one batch.
+	batch := db.NewBatch(ctx)
+	numKV := 0
+	err := db.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		batch.Delete(tk)
+		numKV++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", numKV, ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *MemDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil memstore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	if allVersions {
+		return db.deleteAllVersions(ctx)
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	return db.deleteSingleVersion(vctx)
+}
+
+// deleteKeys deletes all full keys within the range that pass the given filter, which
+// can be nil.
+func (db *MemDB) deleteKeys(minKey, maxKey storage.Key, filter func(storage.Key) (bool, error)) (int, error) {
+	var keys []storage.Key
+	err := db.iterate(minKey, maxKey, true, func(kv *storage.KeyValue) error {
+		if filter != nil {
+			del, err := filter(kv.K)
+			if err != nil {
+				return err
+			}
+			if !del {
+				return nil
+			}
+		}
+		keys = append(keys, kv.K)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	db.Lock()
+	for _, k := range keys {
+		db.data.delete(k)
+	}
+	db.Unlock()
+	return len(keys), nil
+}
+
+func (db *MemDB) deleteSingleVersion(vctx storage.VersionedCtx) error {
+	minTKey := storage.MinTKey(storage.TKeyMinClass)
+	maxTKey := storage.MaxTKey(storage.TKeyMaxClass)
+	minKey, err := vctx.MinVersionKey(minTKey)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(maxTKey)
+	if err != nil {
+		return err
+	}
+	deleteVersion := vctx.VersionID()
+	numKV, err := db.deleteKeys(minKey, maxKey, func(k storage.Key) (bool, error) {
+		_, v, _, err := storage.DataKeyToLocalIDs(k)
+		if err != nil {
+			return false, fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+		}
+		return v == deleteVersion, nil
+	})
+	if err != nil {
+		return err
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, vctx)
+	return nil
+}
+
+func (db *MemDB) deleteAllVersions(ctx storage.Context) error {
+	var err error
+	var minKey, maxKey storage.Key
+
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if versioned {
+		// Don't have to worry about tombstones.  Delete all keys from all versions for this instance id.
+		minTKey := storage.MinTKey(storage.TKeyMinClass)
+		maxTKey := storage.MaxTKey(storage.TKeyMaxClass)
+		minKey, err = vctx.MinVersionKey(minTKey)
+		if err != nil {
+			return err
+		}
+		maxKey, err = vctx.MaxVersionKey(maxTKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		minKey, maxKey = ctx.KeyRange()
+	}
+	numKV, err := db.deleteKeys(minKey, maxKey, nil)
+	if err != nil {
+		return err
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	op    storage.Op
+	key   storage.Key
+	value []byte
+}
+
+type memBatch struct {
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	db   *MemDB
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  All operations
+// in the batch are applied atomically on Commit().
+func (db *MemDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil memstore\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	var ok bool
+	vctx, ok = ctx.(storage.VersionedCtx)
+	if !ok {
+		vctx = nil
+	}
+	return &memBatch{ctx: ctx, vctx: vctx, db: db}
+}
+
+// --- Batch interface ---
+
+func (batch *memBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{storage.PutOp, tombstone, dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{storage.DeleteOp, key, nil})
+}
+
+func (batch *memBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{storage.DeleteOp, tombstone, nil})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{storage.PutOp, key, copyBytes(v)})
+}
+
+func (batch *memBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	batch.db.Lock()
+	for _, op := range batch.ops {
+		switch op.op {
+		case storage.PutOp:
+			batch.db.data.put(op.key, op.value)
+		case storage.DeleteOp:
+			batch.db.data.delete(op.key)
+		}
+	}
+	batch.db.Unlock()
+	batch.ops = nil
+	return nil
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the exact number of key and value bytes stored within
+// each of the given key ranges.
+func (db *MemDB) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	sizes := make([]uint64, len(ranges))
+	for i, kr := range ranges {
+		for n := db.data.seek(kr.Start); n != nil; n = n.next[0] {
+			if bytes.Compare(n.key, kr.OpenEnd) >= 0 {
+				break
+			}
+			sizes[i] += uint64(len(n.key) + len(n.value))
+		}
+	}
+	return sizes, nil
+}
@@ -0,0 +1,206 @@
+package memstore
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func TestSkiplistOrdering(t *testing.T) {
+	s := newSkiplist()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%08d", rand.Intn(1000000))
+		s.put([]byte(keys[i]), []byte(keys[i]))
+	}
+	sort.Strings(keys)
+	var unique []string
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			unique = append(unique, k)
+		}
+	}
+	if s.length != len(unique) {
+		t.Fatalf("expected %d keys in skiplist, got %d\n", len(unique), s.length)
+	}
+	i := 0
+	for n := s.seek(nil); n != nil; n = n.next[0] {
+		if string(n.key) != unique[i] {
+			t.Fatalf("expected key %d to be %q, got %q\n", i, unique[i], string(n.key))
+		}
+		i++
+	}
+	for _, k := range unique[:len(unique)/2] {
+		if !s.delete([]byte(k)) {
+			t.Fatalf("unable to delete key %q\n", k)
+		}
+	}
+	if _, found := s.get([]byte(unique[0])); found {
+		t.Errorf("deleted key %q still found\n", unique[0])
+	}
+	if v, found := s.get([]byte(unique[len(unique)-1])); !found || string(v) != unique[len(unique)-1] {
+		t.Errorf("bad get of remaining key %q: %v\n", unique[len(unique)-1], v)
+	}
+	if s.length != len(unique)-len(unique)/2 {
+		t.Errorf("expected %d keys after deletion, got %d\n", len(unique)-len(unique)/2, s.length)
+	}
+}
+
+func newTestDB(t *testing.T) (*MemDB, Engine, dvid.StoreConfig) {
+	var e Engine
+	backend, err := e.GetTestConfig()
+	if err != nil {
+		t.Fatalf("unable to get test config: %v\n", err)
+	}
+	config := backend.Stores["default"]
+	store, created, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("unable to create memstore: %v\n", err)
+	}
+	if !created {
+		t.Fatalf("expected new memstore to be created\n")
+	}
+	return store.(*MemDB), e, config
+}
+
+func TestMetadataRoundtrip(t *testing.T) {
+	db, e, config := newTestDB(t)
+	defer e.Delete(config)
+
+	ctx := storage.NewMetadataContext()
+	var kvs []storage.TKeyValue
+	for i := 0; i < 2500; i++ {
+		tk := storage.NewTKey(1, []byte(fmt.Sprintf("%06d", i)))
+		kvs = append(kvs, storage.TKeyValue{K: tk, V: []byte(fmt.Sprintf("value %d", i))})
+	}
+	if err := db.PutRange(ctx, kvs); err != nil {
+		t.Fatalf("error on PutRange: %v\n", err)
+	}
+	value, err := db.Get(ctx, kvs[17].K)
+	if err != nil {
+		t.Fatalf("error on Get: %v\n", err)
+	}
+	if !bytes.Equal(value, kvs[17].V) {
+		t.Errorf("expected %q, got %q\n", string(kvs[17].V), string(value))
+	}
+
+	// Range query spans several scan batches.
+	got, err := db.GetRange(ctx, kvs[10].K, kvs[2100].K)
+	if err != nil {
+		t.Fatalf("error on GetRange: %v\n", err)
+	}
+	if len(got) != 2091 {
+		t.Fatalf("expected 2091 key-values from range, got %d\n", len(got))
+	}
+	for i, kv := range got {
+		if !bytes.Equal(kv.K, kvs[i+10].K) || !bytes.Equal(kv.V, kvs[i+10].V) {
+			t.Fatalf("bad key-value %d in range: %v\n", i, kv)
+		}
+	}
+
+	// Write during ProcessRange should not deadlock.
+	err = db.ProcessRange(ctx, kvs[0].K, kvs[9].K, nil, func(c *storage.Chunk) error {
+		return db.Put(ctx, c.K, []byte("modified"))
+	})
+	if err != nil {
+		t.Fatalf("error on ProcessRange: %v\n", err)
+	}
+	if value, _ = db.Get(ctx, kvs[5].K); string(value) != "modified" {
+		t.Errorf("expected modified value, got %q\n", string(value))
+	}
+
+	if err := db.DeleteRange(ctx, kvs[0].K, kvs[999].K); err != nil {
+		t.Fatalf("error on DeleteRange: %v\n", err)
+	}
+	keys, err := db.KeysInRange(ctx, kvs[0].K, kvs[2499].K)
+	if err != nil {
+		t.Fatalf("error on KeysInRange: %v\n", err)
+	}
+	if len(keys) != 1500 {
+		t.Errorf("expected 1500 keys after delete range, got %d\n", len(keys))
+	}
+
+	// Reopening the store retains its contents.
+	reopened, created, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("error reopening memstore: %v\n", err)
+	}
+	if created {
+		t.Errorf("reopened memstore with metadata should not require initialization\n")
+	}
+	if value, _ = reopened.(*MemDB).Get(ctx, kvs[1000].K); !bytes.Equal(value, kvs[1000].V) {
+		t.Errorf("reopened memstore returned %q, expected %q\n", string(value), string(kvs[1000].V))
+	}
+}
+
+func TestRawRangeQuery(t *testing.T) {
+	db, e, config := newTestDB(t)
+	defer e.Delete(config)
+
+	for i := 0; i < 100; i++ {
+		if err := db.RawPut(storage.Key{byte(i)}, []byte{byte(i), byte(i)}); err != nil {
+			t.Fatalf("error on RawPut: %v\n", err)
+		}
+	}
+	out := make(chan *storage.KeyValue)
+	cancel := make(chan struct{})
+	go func() {
+		if err := db.RawRangeQuery(storage.Key{10}, storage.Key{19}, false, out, cancel); err != nil {
+			t.Errorf("error on RawRangeQuery: %v\n", err)
+		}
+	}()
+	var n int
+	for kv := range out {
+		if kv == nil {
+			break
+		}
+		if kv.K[0] != byte(n+10) || len(kv.V) != 2 {
+			t.Fatalf("bad raw key-value: %v\n", kv)
+		}
+		n++
+	}
+	if n != 10 {
+		t.Errorf("expected 10 raw key-values, got %d\n", n)
+	}
+
+	sizes, err := db.GetApproximateSizes([]storage.KeyRange{{Start: storage.Key{0}, OpenEnd: storage.Key{50}}})
+	if err != nil {
+		t.Fatalf("error getting sizes: %v\n", err)
+	}
+	if sizes[0] != 150 {
+		t.Errorf("expected 150 bytes in range, got %d\n", sizes[0])
+	}
+}
+
+// Key-values handed to the iteration function may be modified without affecting the scan.
+func TestIterateModifiedKeys(t *testing.T) {
+	db, e, config := newTestDB(t)
+	defer e.Delete(config)
+
+	num := 2*scanBatchSize + 500
+	for i := 0; i < num; i++ {
+		if err := db.RawPut(storage.Key(fmt.Sprintf("%06d", i)), []byte{1}); err != nil {
+			t.Fatalf("error on RawPut: %v\n", err)
+		}
+	}
+	var n int
+	err := db.iterate(storage.Key("000000"), storage.Key("999999"), true, func(kv *storage.KeyValue) error {
+		if expected := fmt.Sprintf("%06d", n); string(kv.K) != expected {
+			t.Fatalf("expected key %s, got %s\n", expected, string(kv.K))
+		}
+		copy(kv.K, "000000")
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error on iterate: %v\n", err)
+	}
+	if n != num {
+		t.Errorf("expected %d keys, got %d\n", num, n)
+	}
+}
@@ -0,0 +1,118 @@
+package memstore
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+const (
+	maxLevel    = 24
+	probability = 0.25
+)
+
+// skiplist is an ordered map of byte slice keys to byte slice values.  It is not
+// safe for concurrent use; the owning store must provide locking.
+type skiplist struct {
+	head   *node
+	level  int
+	length int
+	nbytes uint64 // total bytes of keys and values
+	rnd    *rand.Rand
+}
+
+type node struct {
+	key   []byte
+	value []byte
+	next  []*node
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &node{next: make([]*node, maxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(0x5ca1ab1e)),
+	}
+}
+
+func (s *skiplist) randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && s.rnd.Float64() < probability {
+		lvl++
+	}
+	return lvl
+}
+
+// findPrev fills the update slice with the rightmost node at each level whose key
+// precedes the given key, and returns the first node with key >= given key.
+func (s *skiplist) findPrev(key []byte, update []*node) *node {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && bytes.Compare(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// get returns the value for a key and whether it was found.
+func (s *skiplist) get(key []byte) ([]byte, bool) {
+	x := s.findPrev(key, nil)
+	if x != nil && bytes.Equal(x.key, key) {
+		return x.value, true
+	}
+	return nil, false
+}
+
+// put inserts or replaces the value for the key.  Neither key nor value is copied.
+func (s *skiplist) put(key, value []byte) {
+	update := make([]*node, maxLevel)
+	x := s.findPrev(key, update)
+	if x != nil && bytes.Equal(x.key, key) {
+		s.nbytes += uint64(len(value)) - uint64(len(x.value))
+		x.value = value
+		return
+	}
+	lvl := s.randomLevel()
+	if lvl > s.level {
+		for i := s.level; i < lvl; i++ {
+			update[i] = s.head
+		}
+		s.level = lvl
+	}
+	n := &node{key: key, value: value, next: make([]*node, lvl)}
+	for i := 0; i < lvl; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	s.length++
+	s.nbytes += uint64(len(key) + len(value))
+}
+
+// delete removes the key and returns true if it was present.
+func (s *skiplist) delete(key []byte) bool {
+	update := make([]*node, maxLevel)
+	x := s.findPrev(key, update)
+	if x == nil || !bytes.Equal(x.key, key) {
+		return false
+	}
+	for i := 0; i < s.level; i++ {
+		if update[i].next[i] != x {
+			break
+		}
+		update[i].next[i] = x.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.length--
+	s.nbytes -= uint64(len(x.key) + len(x.value))
+	return true
+}
+
+// seek returns the first node with key >= the given key or nil if there is none.
+func (s *skiplist) seek(key []byte) *node {
+	return s.findPrev(key, nil)
+}
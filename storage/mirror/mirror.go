@@ -0,0 +1,664 @@
+/*
+	Package mirror implements a composite store that synchronously writes to two stores
+	declared in the [store] section of the configuration TOML and referenced by alias:
+
+		[store.mirrored]
+		engine = "mirror"
+		primary = "raid6"
+		secondary = "offsite"
+
+	Every write goes to both stores.  If a write fails on only one store, the write succeeds
+	but that store is marked stale and reads are served by the other store until the mirror
+	is resynced, e.g., using the "storage resync" RPC command.  Reads come from the primary
+	store and fail over to the secondary store on error.  Since stale state is not persisted,
+	a resync should be run after restarting a server whose mirrored store had a stale side.
+*/
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+)
+
+// ResyncBatchSize is the maximum number of key-value pairs read from each store while
+// writes are blocked during a resync.
+const ResyncBatchSize = 1000
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in mirror: %v\n", err)
+	}
+	e := Engine{"mirror", "Mirror of writes across two stores", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore is not supported since mirrored stores require other stores.  Mirrored stores are
+// opened by the storage manager through the CompositeEngine interface.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return nil, false, fmt.Errorf("mirror stores must be opened as composite stores")
+}
+
+// ComponentAliases returns the primary and secondary store aliases.
+func (e Engine) ComponentAliases(config dvid.StoreConfig) ([]storage.Alias, error) {
+	primary, secondary, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return []storage.Alias{primary, secondary}, nil
+}
+
+// NewCompositeStore returns a mirrored store.  Metadata initialization is required only if
+// neither store has metadata.
+func (e Engine) NewCompositeStore(config dvid.StoreConfig, components map[storage.Alias]dvid.Store) (dvid.Store, bool, error) {
+	primary, secondary, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	m := &Store{aliases: [2]storage.Alias{primary, secondary}}
+	for i, alias := range m.aliases {
+		db, ok := components[alias].(storage.OrderedKeyValueDB)
+		if !ok {
+			return nil, false, fmt.Errorf("mirrored store %q is not an ordered key-value store", alias)
+		}
+		m.dbs[i] = db
+	}
+	var exists [2]bool
+	for i, db := range m.dbs {
+		if exists[i], err = storage.MetadataExists(db); err != nil {
+			return nil, false, err
+		}
+	}
+	if exists[0] != exists[1] {
+		stale := 0
+		if exists[0] {
+			stale = 1
+		}
+		m.stale[stale] = true
+		dvid.Errorf("Mirrored store %q has no metadata unlike %q, so marking it stale until resync.\n",
+			m.aliases[stale], m.aliases[1-stale])
+	}
+	return m, !exists[0] && !exists[1], nil
+}
+
+func parseConfig(config dvid.StoreConfig) (primary, secondary storage.Alias, err error) {
+	var aliases [2]string
+	for i, key := range []string{"primary", "secondary"} {
+		var found bool
+		aliases[i], found, err = config.GetString(key)
+		if err != nil {
+			return
+		}
+		if !found || aliases[i] == "" {
+			err = fmt.Errorf("%q store alias must be specified for mirror configuration", key)
+			return
+		}
+	}
+	if aliases[0] == aliases[1] {
+		err = fmt.Errorf("mirror must use different primary and secondary stores, not %q for both", aliases[0])
+		return
+	}
+	return storage.Alias(aliases[0]), storage.Alias(aliases[1]), nil
+}
+
+// SideStats holds statistics for one of the mirrored stores.
+type SideStats struct {
+	Alias        storage.Alias
+	Stale        bool
+	FailedWrites uint64
+	FailedReads  uint64
+	LastError    string `json:",omitempty"`
+}
+
+// ResyncStats describes the last or current resync.
+type ResyncStats struct {
+	Source   storage.Alias
+	Target   storage.Alias
+	Running  bool
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	Compared uint64
+	Copied   uint64
+	Deleted  uint64
+	Error    string `json:",omitempty"`
+}
+
+// Stats holds statistics for a mirrored store.
+type Stats struct {
+	Primary   SideStats
+	Secondary SideStats
+	Resync    *ResyncStats `json:",omitempty"`
+}
+
+// Store is a mirrored store that fulfills the storage.OrderedKeyValueDB interface.
+type Store struct {
+	aliases [2]storage.Alias
+	dbs     [2]storage.OrderedKeyValueDB
+
+	// writeMu is held for reading during writes and for writing by resync batches.
+	writeMu sync.RWMutex
+
+	mu        sync.Mutex // protects the fields below
+	stale     [2]bool
+	failedW   [2]uint64
+	failedR   [2]uint64
+	lastError [2]string
+	resync    *ResyncStats
+}
+
+func (m *Store) String() string {
+	return fmt.Sprintf("mirror of %q and %q", m.aliases[0], m.aliases[1])
+}
+
+// Equal returns true if the configuration is for a mirror of the same stores.
+func (m *Store) Equal(config dvid.StoreConfig) bool {
+	if config.Engine != "mirror" {
+		return false
+	}
+	primary, secondary, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return primary == m.aliases[0] && secondary == m.aliases[1]
+}
+
+// Close does nothing since the mirrored stores are closed by the storage manager.
+func (m *Store) Close() {}
+
+// Stats returns the current mirror statistics.
+func (m *Store) Stats() interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sides [2]SideStats
+	for i := range sides {
+		sides[i] = SideStats{
+			Alias:        m.aliases[i],
+			Stale:        m.stale[i],
+			FailedWrites: m.failedW[i],
+			FailedReads:  m.failedR[i],
+			LastError:    m.lastError[i],
+		}
+	}
+	stats := Stats{Primary: sides[0], Secondary: sides[1]}
+	if m.resync != nil {
+		resync := *m.resync
+		stats.Resync = &resync
+	}
+	return stats
+}
+
+// readOrder returns the indices of stores in the order they should be read.
+func (m *Store) readOrder() [2]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stale[0] && !m.stale[1] {
+		return [2]int{1, 0}
+	}
+	return [2]int{0, 1}
+}
+
+func (m *Store) readFailed(i int, err error) {
+	m.mu.Lock()
+	m.failedR[i]++
+	m.lastError[i] = err.Error()
+	m.mu.Unlock()
+	dvid.Errorf("Read failed on mirrored store %q: %v\n", m.aliases[i], err)
+}
+
+// read calls f with stores in read order until one succeeds.  If f returns false for retry,
+// the error is returned without failing over, e.g., because results were already sent.
+func (m *Store) read(f func(db storage.OrderedKeyValueDB) (retry bool, err error)) error {
+	var errs []error
+	for _, i := range m.readOrder() {
+		retry, err := f(m.dbs[i])
+		if err == nil {
+			return nil
+		}
+		m.readFailed(i, err)
+		if !retry {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("read failed on both mirrored stores: %v", errs)
+}
+
+// write calls f concurrently on both stores.  The write fails only if it fails on both
+// stores; otherwise a store that failed is marked stale.
+func (m *Store) write(f func(db storage.OrderedKeyValueDB) error) error {
+	m.writeMu.RLock()
+	defer m.writeMu.RUnlock()
+
+	var errs [2]error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		errs[1] = f(m.dbs[1])
+		wg.Done()
+	}()
+	errs[0] = f(m.dbs[0])
+	wg.Wait()
+
+	if errs[0] != nil && errs[1] != nil {
+		return fmt.Errorf("write failed on both mirrored stores: %v; %v", errs[0], errs[1])
+	}
+	for i, err := range errs {
+		if err != nil {
+			m.mu.Lock()
+			m.failedW[i]++
+			m.lastError[i] = err.Error()
+			m.stale[i] = true
+			m.mu.Unlock()
+			dvid.Criticalf("Write failed on mirrored store %q, which is now stale until resync: %v\n", m.aliases[i], err)
+		}
+	}
+	return nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (m *Store) Get(ctx storage.Context, tk storage.TKey) (v []byte, err error) {
+	err = m.read(func(db storage.OrderedKeyValueDB) (bool, error) {
+		var dbErr error
+		v, dbErr = db.Get(ctx, tk)
+		return true, dbErr
+	})
+	return
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).
+func (m *Store) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) (keys []storage.TKey, err error) {
+	err = m.read(func(db storage.OrderedKeyValueDB) (bool, error) {
+		var dbErr error
+		keys, dbErr = db.KeysInRange(ctx, kStart, kEnd)
+		return true, dbErr
+	})
+	return
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.
+func (m *Store) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) (kvs []*storage.TKeyValue, err error) {
+	err = m.read(func(db storage.OrderedKeyValueDB) (bool, error) {
+		var dbErr error
+		kvs, dbErr = db.GetRange(ctx, kStart, kEnd)
+		return true, dbErr
+	})
+	return
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Failover only occurs if
+// no keys were sent.
+func (m *Store) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	return m.read(func(db storage.OrderedKeyValueDB) (bool, error) {
+		var sent bool
+		mid := make(storage.KeyChan)
+		done := make(chan struct{})
+		go func() {
+			for k := range mid {
+				if k == nil {
+					break
+				}
+				sent = true
+				ch <- k
+			}
+			close(done)
+		}()
+		err := db.SendKeysInRange(ctx, kStart, kEnd, mid)
+		close(mid)
+		<-done
+		if err == nil {
+			ch <- nil
+		} else if sent {
+			ch <- nil
+			return false, err
+		}
+		return true, err
+	})
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  Failover only occurs
+// if no chunks were processed.
+func (m *Store) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	var chunkErr error
+	err := m.read(func(db storage.OrderedKeyValueDB) (bool, error) {
+		var processed bool
+		err := db.ProcessRange(ctx, kStart, kEnd, op, func(c *storage.Chunk) error {
+			processed = true
+			chunkErr = f(c)
+			return chunkErr
+		})
+		if chunkErr != nil {
+			return false, nil // not a failure of the store
+		}
+		return !processed, err
+	})
+	if chunkErr != nil {
+		return chunkErr
+	}
+	return err
+}
+
+// RawRangeQuery sends a range of full keys from the store used for reads.  There is no
+// failover since the query may be cancelled after some key-value pairs are sent.
+func (m *Store) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	i := m.readOrder()[0]
+	err := m.dbs[i].RawRangeQuery(kStart, kEnd, keysOnly, out, cancel)
+	if err != nil {
+		m.readFailed(i, err)
+	}
+	return err
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key to both stores.
+func (m *Store) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	return m.write(func(db storage.OrderedKeyValueDB) error {
+		return db.Put(ctx, tk, v)
+	})
+}
+
+// Delete removes a value with given key from both stores.
+func (m *Store) Delete(ctx storage.Context, tk storage.TKey) error {
+	return m.write(func(db storage.OrderedKeyValueDB) error {
+		return db.Delete(ctx, tk)
+	})
+}
+
+// RawPut puts a key-value pair using full keys in both stores.
+func (m *Store) RawPut(k storage.Key, v []byte) error {
+	return m.write(func(db storage.OrderedKeyValueDB) error {
+		return db.RawPut(k, v)
+	})
+}
+
+// RawDelete deletes a key-value pair using full keys in both stores.
+func (m *Store) RawDelete(k storage.Key) error {
+	return m.write(func(db storage.OrderedKeyValueDB) error {
+		return db.RawDelete(k)
+	})
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs in both stores.
+func (m *Store) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	return m.write(func(db storage.OrderedKeyValueDB) error {
+		return db.PutRange(ctx, kvs)
+	})
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range from both stores.
+func (m *Store) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	return m.write(func(db storage.OrderedKeyValueDB) error {
+		return db.DeleteRange(ctx, kStart, kEnd)
+	})
+}
+
+// DeleteAll deletes all key-value associated with a context from both stores.
+func (m *Store) DeleteAll(ctx storage.Context, allVersions bool) error {
+	return m.write(func(db storage.OrderedKeyValueDB) error {
+		return db.DeleteAll(ctx, allVersions)
+	})
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the sizes of the given key ranges from the store used for reads.
+func (m *Store) GetApproximateSizes(ranges []storage.KeyRange) (sizes []uint64, err error) {
+	err = m.read(func(db storage.OrderedKeyValueDB) (bool, error) {
+		sv, ok := db.(storage.SizeViewer)
+		if !ok {
+			return true, fmt.Errorf("store %s cannot report sizes", db)
+		}
+		var dbErr error
+		sizes, dbErr = sv.GetApproximateSizes(ranges)
+		return true, dbErr
+	})
+	return
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	op storage.Op
+	tk storage.TKey
+	v  []byte
+}
+
+type batch struct {
+	m   *Store
+	ctx storage.Context
+	ops []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  The batch is committed
+// to each store as a batch if the store supports batching.
+func (m *Store) NewBatch(ctx storage.Context) storage.Batch {
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	return &batch{m: m, ctx: ctx}
+}
+
+func (b *batch) Delete(tk storage.TKey) {
+	b.ops = append(b.ops, batchOp{storage.DeleteOp, tk, nil})
+}
+
+func (b *batch) Put(tk storage.TKey, v []byte) {
+	b.ops = append(b.ops, batchOp{storage.PutOp, tk, v})
+}
+
+func (b *batch) Commit() error {
+	ops := b.ops
+	b.ops = nil
+	return b.m.write(func(db storage.OrderedKeyValueDB) error {
+		batcher, ok := db.(storage.KeyValueBatcher)
+		if !ok {
+			for _, op := range ops {
+				var err error
+				switch op.op {
+				case storage.PutOp:
+					err = db.Put(b.ctx, op.tk, op.v)
+				case storage.DeleteOp:
+					err = db.Delete(b.ctx, op.tk)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		dbBatch := batcher.NewBatch(b.ctx)
+		for _, op := range ops {
+			switch op.op {
+			case storage.PutOp:
+				dbBatch.Put(op.tk, op.v)
+			case storage.DeleteOp:
+				dbBatch.Delete(op.tk)
+			}
+		}
+		return dbBatch.Commit()
+	})
+}
+
+// --- Resync ---
+
+// Resync makes one store an exact copy of the other.  If source is empty, the store that
+// isn't stale is used as the source, or the primary store if neither is stale.  Writes are
+// blocked while each batch of keys is reconciled.
+func (m *Store) Resync(source storage.Alias) error {
+	src := 0
+	m.mu.Lock()
+	switch source {
+	case "":
+		if m.stale[0] && !m.stale[1] {
+			src = 1
+		}
+	case m.aliases[0]:
+	case m.aliases[1]:
+		src = 1
+	default:
+		m.mu.Unlock()
+		return fmt.Errorf("resync source %q is not one of the mirrored stores %q or %q", source, m.aliases[0], m.aliases[1])
+	}
+	if m.resync != nil && m.resync.Running {
+		m.mu.Unlock()
+		return fmt.Errorf("resync of %s already running", m)
+	}
+	dst := 1 - src
+	stats := &ResyncStats{
+		Source:  m.aliases[src],
+		Target:  m.aliases[dst],
+		Running: true,
+		Started: time.Now(),
+	}
+	m.resync = stats
+	m.mu.Unlock()
+
+	dvid.Infof("Starting resync of %s from %q to %q...\n", m, m.aliases[src], m.aliases[dst])
+	err := m.resyncStores(m.dbs[src], m.dbs[dst], stats)
+
+	m.mu.Lock()
+	stats.Running = false
+	stats.Finished = time.Now()
+	if err != nil {
+		stats.Error = err.Error()
+	} else {
+		m.stale = [2]bool{}
+	}
+	m.mu.Unlock()
+	if err != nil {
+		dvid.Errorf("Resync of %s failed: %v\n", m, err)
+		return err
+	}
+	dvid.Infof("Finished resync of %s: compared %d, copied %d, and deleted %d key-value pairs in %s.\n",
+		m, stats.Compared, stats.Copied, stats.Deleted, time.Since(stats.Started))
+	return nil
+}
+
+func (m *Store) resyncStores(src, dst storage.OrderedKeyValueDB, stats *ResyncStats) error {
+	maxKey := storage.Key(bytes.Repeat([]byte{0xFF}, 32))
+	begKey := storage.Key{}
+	for {
+		done, next, err := m.resyncBatch(src, dst, begKey, maxKey, stats)
+		if err != nil || done {
+			return err
+		}
+		begKey = next
+	}
+}
+
+// resyncBatch reconciles one batch of keys starting at begKey and returns the next key
+// to process or done if all keys were reconciled.
+func (m *Store) resyncBatch(src, dst storage.OrderedKeyValueDB, begKey, maxKey storage.Key, stats *ResyncStats) (done bool, next storage.Key, err error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	var srcKVs, dstKVs []*storage.KeyValue
+	if srcKVs, err = storage.ReadRawBatch(src, begKey, maxKey, ResyncBatchSize); err != nil {
+		return
+	}
+	if dstKVs, err = storage.ReadRawBatch(dst, begKey, maxKey, ResyncBatchSize); err != nil {
+		return
+	}
+
+	// Only keys up to the last key read from a full batch can be reconciled.
+	endKey := maxKey
+	done = true
+	if len(srcKVs) == ResyncBatchSize {
+		endKey = srcKVs[len(srcKVs)-1].K
+		done = false
+	}
+	if len(dstKVs) == ResyncBatchSize {
+		if last := dstKVs[len(dstKVs)-1].K; bytes.Compare(last, endKey) < 0 {
+			endKey = last
+		}
+		done = false
+	}
+
+	var copied, deleted, compared uint64
+	var i, j int
+	for i < len(srcKVs) || j < len(dstKVs) {
+		var cmp int
+		switch {
+		case i == len(srcKVs):
+			cmp = 1
+		case j == len(dstKVs):
+			cmp = -1
+		default:
+			cmp = bytes.Compare(srcKVs[i].K, dstKVs[j].K)
+		}
+		var key storage.Key
+		if cmp <= 0 {
+			key = srcKVs[i].K
+		} else {
+			key = dstKVs[j].K
+		}
+		if bytes.Compare(key, endKey) > 0 {
+			break
+		}
+		compared++
+		switch {
+		case cmp < 0:
+			err = dst.RawPut(key, srcKVs[i].V)
+			copied++
+			i++
+		case cmp > 0:
+			err = dst.RawDelete(key)
+			deleted++
+			j++
+		default:
+			if !bytes.Equal(srcKVs[i].V, dstKVs[j].V) {
+				err = dst.RawPut(key, srcKVs[i].V)
+				copied++
+			}
+			i++
+			j++
+		}
+		if err != nil {
+			return
+		}
+	}
+	next = append(append(storage.Key{}, endKey...), 0)
+
+	m.mu.Lock()
+	stats.Compared += compared
+	stats.Copied += copied
+	stats.Deleted += deleted
+	m.mu.Unlock()
+	return
+}
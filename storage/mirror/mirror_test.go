@@ -0,0 +1,139 @@
+package mirror
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/memstore"
+)
+
+// flakyDB is a store that can be made to fail all operations.
+type flakyDB struct {
+	storage.OrderedKeyValueDB
+	down bool
+}
+
+func (db *flakyDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db.down {
+		return nil, fmt.Errorf("store is down")
+	}
+	return db.OrderedKeyValueDB.Get(ctx, tk)
+}
+
+func (db *flakyDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db.down {
+		return fmt.Errorf("store is down")
+	}
+	return db.OrderedKeyValueDB.Put(ctx, tk, v)
+}
+
+func (db *flakyDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db.down {
+		return fmt.Errorf("store is down")
+	}
+	return db.OrderedKeyValueDB.Delete(ctx, tk)
+}
+
+func openMemstore(t *testing.T) (*flakyDB, func()) {
+	var e memstore.Engine
+	backend, err := e.GetTestConfig()
+	if err != nil {
+		t.Fatalf("unable to get test config: %v\n", err)
+	}
+	config := backend.Stores["default"]
+	store, _, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("unable to create memstore: %v\n", err)
+	}
+	return &flakyDB{OrderedKeyValueDB: store.(storage.OrderedKeyValueDB)}, func() { e.Delete(config) }
+}
+
+func openMirror(t *testing.T, primary, secondary storage.OrderedKeyValueDB) *Store {
+	var c dvid.Config
+	c.SetAll(map[string]interface{}{"primary": "a", "secondary": "b"})
+	config := dvid.StoreConfig{Config: c, Engine: "mirror"}
+
+	var e Engine
+	components := map[storage.Alias]dvid.Store{"a": primary, "b": secondary}
+	store, _, err := e.NewCompositeStore(config, components)
+	if err != nil {
+		t.Fatalf("unable to open mirror store: %v\n", err)
+	}
+	return store.(*Store)
+}
+
+func TestMirrorFailover(t *testing.T) {
+	primary, deletePrimary := openMemstore(t)
+	defer deletePrimary()
+	secondary, deleteSecondary := openMemstore(t)
+	defer deleteSecondary()
+	m := openMirror(t, primary, secondary)
+
+	ctx := storage.NewMetadataContext()
+	var kvs []storage.TKeyValue
+	for i := 0; i < 2500; i++ {
+		tk := storage.NewTKey(1, []byte(fmt.Sprintf("%06d", i)))
+		kvs = append(kvs, storage.TKeyValue{K: tk, V: []byte(fmt.Sprintf("value %d", i))})
+	}
+	if err := m.PutRange(ctx, kvs); err != nil {
+		t.Fatalf("error on PutRange: %v\n", err)
+	}
+	batch := m.NewBatch(ctx)
+	batch.Delete(kvs[0].K)
+	batch.Put(kvs[1].K, []byte("batched"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("error on batch commit: %v\n", err)
+	}
+	for _, db := range []storage.OrderedKeyValueDB{primary, secondary} {
+		if v, _ := db.Get(ctx, kvs[1].K); string(v) != "batched" {
+			t.Errorf("expected batched write in %s, got %q\n", db, string(v))
+		}
+		if v, _ := db.Get(ctx, kvs[0].K); v != nil {
+			t.Errorf("expected batched delete in %s, got %q\n", db, string(v))
+		}
+	}
+
+	// Reads fail over to the secondary.
+	primary.down = true
+	if v, err := m.Get(ctx, kvs[2].K); err != nil || string(v) != string(kvs[2].V) {
+		t.Errorf("expected failover read of %q, got %q, err %v\n", string(kvs[2].V), string(v), err)
+	}
+
+	// Writes succeed if one store is up, and the down store becomes stale.
+	if err := m.Put(ctx, kvs[3].K, []byte("missed")); err != nil {
+		t.Fatalf("expected write to succeed with one store up: %v\n", err)
+	}
+	if err := m.Delete(ctx, kvs[4].K); err != nil {
+		t.Fatalf("expected delete to succeed with one store up: %v\n", err)
+	}
+	primary.down = false
+	stats := m.Stats().(Stats)
+	if !stats.Primary.Stale || stats.Secondary.Stale || stats.Primary.FailedWrites != 2 {
+		t.Errorf("bad stats after failed writes: %+v\n", stats)
+	}
+	if v, _ := m.Get(ctx, kvs[3].K); string(v) != "missed" {
+		t.Errorf("expected read from up-to-date secondary, got %q\n", string(v))
+	}
+
+	// Resync brings the primary up to date.
+	if err := m.Resync(""); err != nil {
+		t.Fatalf("error on resync: %v\n", err)
+	}
+	stats = m.Stats().(Stats)
+	if stats.Primary.Stale || stats.Resync == nil || stats.Resync.Source != "b" ||
+		stats.Resync.Copied != 1 || stats.Resync.Deleted != 1 || stats.Resync.Compared != 2499 {
+		t.Errorf("bad stats after resync: %+v %+v\n", stats, stats.Resync)
+	}
+	if v, _ := primary.Get(ctx, kvs[3].K); string(v) != "missed" {
+		t.Errorf("expected resynced value in primary, got %q\n", string(v))
+	}
+	if v, _ := primary.Get(ctx, kvs[4].K); v != nil {
+		t.Errorf("expected resynced delete in primary, got %q\n", string(v))
+	}
+
+	if err := m.Resync("c"); err == nil {
+		t.Errorf("expected error on resync from unknown store\n")
+	}
+}
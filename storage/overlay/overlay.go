@@ -0,0 +1,844 @@
+/*
+	Package overlay implements a copy-on-write composite store that serves reads from a
+	read-only base store, e.g., a snapshot of a large reconstruction, and sends all writes
+	to a small upper store.  Both stores are declared in the [store] section of the
+	configuration TOML and referenced by alias:
+
+		[store.teamcopy]
+		engine = "overlay"
+		base = "snapshot"
+		upper = "teamssd"
+
+		[backend]
+		    [backend."segmentation:99ef22cd85f143f58a623bd22aad0ef7"]
+		    store = "teamcopy"
+
+	Key-value pairs in the upper store take precedence over the base store.  When a key
+	present in the base store is deleted, a whiteout is written to the upper store so the
+	base key-value pair is masked.  Deleting all versions of a data instance writes a single
+	range whiteout.  Since the merge is done on full keys, versioned reads see the same
+	ancestor resolution as if all key-value pairs were in one store.  The base store is
+	never modified.
+*/
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+)
+
+// Whiteouts are kept in the upper store under key prefixes beyond those used for
+// metadata and data keys.
+const (
+	rangeWhiteoutPrefix byte = 0xFD // followed by begin key, with end key as value
+	whiteoutPrefix      byte = 0xFE // followed by masked key
+)
+
+// DeleteBatchSize is the number of deletions per upper store batch for range deletions.
+const DeleteBatchSize = 10000
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in overlay: %v\n", err)
+	}
+	e := Engine{"overlay", "Copy-on-write overlay of a read-only base store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore is not supported since overlay stores require other stores.  Overlay stores are
+// opened by the storage manager through the CompositeEngine interface.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return nil, false, fmt.Errorf("overlay stores must be opened as composite stores")
+}
+
+// ComponentAliases returns the base and upper store aliases.
+func (e Engine) ComponentAliases(config dvid.StoreConfig) ([]storage.Alias, error) {
+	base, upper, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return []storage.Alias{base, upper}, nil
+}
+
+// NewCompositeStore returns an overlay store.  Metadata initialization is required if
+// neither store has metadata.
+func (e Engine) NewCompositeStore(config dvid.StoreConfig, components map[storage.Alias]dvid.Store) (dvid.Store, bool, error) {
+	baseAlias, upperAlias, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	base, ok := components[baseAlias].(storage.OrderedKeyValueDB)
+	if !ok {
+		return nil, false, fmt.Errorf("base store %q is not an ordered key-value store", baseAlias)
+	}
+	upper, ok := components[upperAlias].(storage.OrderedKeyValueDB)
+	if !ok {
+		return nil, false, fmt.Errorf("upper store %q is not an ordered key-value store", upperAlias)
+	}
+	if _, ok := upper.(storage.KeyValueBatcher); !ok {
+		return nil, false, fmt.Errorf("upper store %q must support batches", upperAlias)
+	}
+	o := &Store{
+		baseAlias:  baseAlias,
+		upperAlias: upperAlias,
+		base:       base,
+		upper:      upper,
+	}
+	if err := o.loadRangeWhiteouts(); err != nil {
+		return nil, false, err
+	}
+	exists, err := storage.MetadataExists(o)
+	if err != nil {
+		return nil, false, err
+	}
+	return o, !exists, nil
+}
+
+func parseConfig(config dvid.StoreConfig) (base, upper storage.Alias, err error) {
+	var aliases [2]string
+	for i, key := range []string{"base", "upper"} {
+		var found bool
+		aliases[i], found, err = config.GetString(key)
+		if err != nil {
+			return
+		}
+		if !found || aliases[i] == "" {
+			err = fmt.Errorf("%q store alias must be specified for overlay configuration", key)
+			return
+		}
+	}
+	if aliases[0] == aliases[1] {
+		err = fmt.Errorf("overlay must use different base and upper stores, not %q for both", aliases[0])
+		return
+	}
+	return storage.Alias(aliases[0]), storage.Alias(aliases[1]), nil
+}
+
+// rawContext passes full keys through unchanged so the upper store's batches can be
+// used for raw key-value pairs.
+type rawContext struct {
+	storage.MetadataContext
+}
+
+func (ctx rawContext) ConstructKey(tk storage.TKey) storage.Key {
+	return storage.Key(tk)
+}
+
+func (ctx rawContext) String() string {
+	return "Overlay raw context"
+}
+
+type keyRange struct {
+	beg, end storage.Key // inclusive
+}
+
+// Store is an overlay store that fulfills the storage.OrderedKeyValueDB interface.
+type Store struct {
+	baseAlias, upperAlias storage.Alias
+	base, upper           storage.OrderedKeyValueDB
+
+	rangeMu        sync.RWMutex
+	rangeWhiteouts []keyRange // sorted by beginning key
+}
+
+func (o *Store) String() string {
+	return fmt.Sprintf("overlay of %q on base %q", o.upperAlias, o.baseAlias)
+}
+
+// Equal returns true if the configuration is for an overlay of the same stores.
+func (o *Store) Equal(config dvid.StoreConfig) bool {
+	if config.Engine != "overlay" {
+		return false
+	}
+	base, upper, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return base == o.baseAlias && upper == o.upperAlias
+}
+
+// Close does nothing since the base and upper stores are closed by the storage manager.
+func (o *Store) Close() {}
+
+func (o *Store) loadRangeWhiteouts() error {
+	it := newRawIterator(o.upper, storage.Key{rangeWhiteoutPrefix}, storage.Key{rangeWhiteoutPrefix + 1}, false)
+	defer it.close()
+	for ; it.cur != nil; it.next() {
+		if len(it.cur.K) < 2 {
+			continue
+		}
+		o.rangeWhiteouts = append(o.rangeWhiteouts, keyRange{it.cur.K[1:], it.cur.V})
+	}
+	return it.err
+}
+
+// rangeWhiteout returns true if the key is masked by a range whiteout.
+func (o *Store) rangeWhiteout(k storage.Key) bool {
+	o.rangeMu.RLock()
+	defer o.rangeMu.RUnlock()
+	i := sort.Search(len(o.rangeWhiteouts), func(i int) bool {
+		return bytes.Compare(o.rangeWhiteouts[i].beg, k) > 0
+	})
+	for i--; i >= 0; i-- {
+		if bytes.Compare(k, o.rangeWhiteouts[i].end) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Store) addRangeWhiteout(beg, end storage.Key) error {
+	wkey := append(storage.Key{rangeWhiteoutPrefix}, beg...)
+	if err := o.upper.RawPut(wkey, end); err != nil {
+		return err
+	}
+	o.rangeMu.Lock()
+	defer o.rangeMu.Unlock()
+	i := sort.Search(len(o.rangeWhiteouts), func(i int) bool {
+		return bytes.Compare(o.rangeWhiteouts[i].beg, beg) >= 0
+	})
+	if i < len(o.rangeWhiteouts) && bytes.Equal(o.rangeWhiteouts[i].beg, beg) {
+		if bytes.Compare(end, o.rangeWhiteouts[i].end) > 0 {
+			o.rangeWhiteouts[i].end = end
+		}
+		return nil
+	}
+	o.rangeWhiteouts = append(o.rangeWhiteouts, keyRange{})
+	copy(o.rangeWhiteouts[i+1:], o.rangeWhiteouts[i:])
+	o.rangeWhiteouts[i] = keyRange{beg, end}
+	return nil
+}
+
+// --- merged iteration ---
+
+// rawIterator steps through a store's raw range query.  The channel is closed when the
+// query returns so queries that end on error without sending nil still terminate.
+type rawIterator struct {
+	ch     chan *storage.KeyValue
+	cancel chan struct{}
+	done   chan error
+	cur    *storage.KeyValue // nil when exhausted
+	err    error
+}
+
+func newRawIterator(db storage.OrderedKeyValueDB, begKey, endKey storage.Key, keysOnly bool) *rawIterator {
+	it := &rawIterator{
+		ch:     make(chan *storage.KeyValue, 100),
+		cancel: make(chan struct{}, 1),
+		done:   make(chan error, 1),
+	}
+	go func() {
+		it.done <- db.RawRangeQuery(begKey, endKey, keysOnly, it.ch, it.cancel)
+		close(it.ch)
+	}()
+	it.next()
+	return it
+}
+
+func (it *rawIterator) next() {
+	kv, ok := <-it.ch
+	if ok && kv != nil && kv.K != nil {
+		it.cur = kv
+		return
+	}
+	it.cur = nil
+	it.err = <-it.done
+	it.done = nil
+}
+
+// close stops a query that hasn't finished and waits for it to return.
+func (it *rawIterator) close() {
+	if it.done == nil {
+		return
+	}
+	it.cur = nil
+	it.cancel <- struct{}{}
+	for range it.ch {
+	}
+	<-it.done
+	it.done = nil
+}
+
+// isWhiteoutKey returns true if the upper store key holds whiteout bookkeeping.
+func isWhiteoutKey(k storage.Key) bool {
+	return len(k) != 0 && k[0] >= rangeWhiteoutPrefix
+}
+
+// iterate calls f for each key-value pair with begKey <= key <= endKey in ascending key
+// order, taking key-value pairs from the upper store over those in the base store and
+// skipping base key-value pairs that have been whited out.
+func (o *Store) iterate(begKey, endKey storage.Key, keysOnly bool, f func(*storage.KeyValue) error) error {
+	base := newRawIterator(o.base, begKey, endKey, keysOnly)
+	defer base.close()
+	upper := newRawIterator(o.upper, begKey, endKey, keysOnly)
+	defer upper.close()
+	wbeg := append(storage.Key{whiteoutPrefix}, begKey...)
+	wend := append(storage.Key{whiteoutPrefix}, endKey...)
+	whiteouts := newRawIterator(o.upper, wbeg, wend, true)
+	defer whiteouts.close()
+
+	for base.cur != nil || upper.cur != nil {
+		if upper.cur != nil && isWhiteoutKey(upper.cur.K) {
+			upper.next()
+			continue
+		}
+		if upper.cur != nil {
+			cmp := -1
+			if base.cur != nil {
+				cmp = bytes.Compare(upper.cur.K, base.cur.K)
+			}
+			if cmp <= 0 {
+				if err := f(upper.cur); err != nil {
+					return err
+				}
+				if cmp == 0 {
+					base.next()
+				}
+				upper.next()
+				continue
+			}
+		}
+		k := base.cur.K
+		for whiteouts.cur != nil && bytes.Compare(whiteouts.cur.K[1:], k) < 0 {
+			whiteouts.next()
+		}
+		masked := whiteouts.cur != nil && bytes.Equal(whiteouts.cur.K[1:], k)
+		if !masked && !o.rangeWhiteout(k) {
+			if err := f(base.cur); err != nil {
+				return err
+			}
+		}
+		base.next()
+	}
+	for _, it := range []*rawIterator{base, upper, whiteouts} {
+		if it.err != nil {
+			return it.err
+		}
+	}
+	return nil
+}
+
+// baseHas returns true if the base store has the key, whether masked or not.
+func (o *Store) baseHas(k storage.Key) (bool, error) {
+	it := newRawIterator(o.base, k, k, true)
+	defer it.close()
+	found := it.cur != nil
+	return found, it.err
+}
+
+// errStopIteration signals the early, successful end of an iteration.
+var errStopIteration = fmt.Errorf("iteration stopped")
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (o *Store) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := o.getSingleKeyVersions(vctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	var v []byte
+	err := o.iterate(key, key, false, func(kv *storage.KeyValue) error {
+		v = kv.V
+		return nil
+	})
+	return v, err
+}
+
+// getSingleKeyVersions returns all versions of a key.  These key-value pairs will be sorted
+// in ascending key order and could include a tombstone key.
+func (o *Store) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	values := []*storage.KeyValue{}
+	err = o.iterate(begKey, endKey, false, func(kv *storage.KeyValue) error {
+		values = append(values, kv)
+		return nil
+	})
+	return values, err
+}
+
+// versionedRange calls f with the key-value pair appropriate for the context's version
+// for each type-specific key in the range.
+func (o *Store) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		return err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		values = []*storage.KeyValue{}
+		if err != nil {
+			return err
+		}
+		if kv != nil {
+			return f(kv)
+		}
+		return nil
+	}
+
+	err = o.iterate(minKey, maxKey, keysOnly, func(kv *storage.KeyValue) error {
+		// Did we pass all versions for last key read?
+		if bytes.Compare(kv.K, maxVersionKey) > 0 {
+			tk, err := storage.TKeyFromKey(kv.K)
+			if err != nil {
+				return err
+			}
+			maxVersionKey, err = vctx.MaxVersionKey(tk)
+			if err != nil {
+				return err
+			}
+			if err := sendKV(); err != nil {
+				return err
+			}
+		}
+		values = append(values, kv)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return sendKV()
+}
+
+// processRange runs the versioned or unversioned range query appropriate for the context.
+func (o *Store) processRange(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	if !ctx.Versioned() {
+		return o.iterate(ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), keysOnly, f)
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	return o.versionedRange(vctx, kStart, kEnd, keysOnly, f)
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (o *Store) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := o.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (o *Store) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := o.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		kch <- kv.K
+		return nil
+	})
+	kch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (o *Store) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := o.processRange(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (o *Store) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	return o.processRange(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		return f(chunk)
+	})
+}
+
+// RawRangeQuery sends a range of full keys from the merged stores.  A nil is sent down
+// the channel when the range is complete.
+func (o *Store) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	err := o.iterate(kStart, kEnd, keysOnly, func(kv *storage.KeyValue) error {
+		select {
+		case out <- kv:
+			return nil
+		case <-cancel:
+			return errStopIteration
+		}
+	})
+	if err == errStopIteration {
+		return nil
+	}
+	out <- nil
+	return err
+}
+
+// --- writes ---
+
+// rawPut adds operations to an upper store batch that put a full key and remove any
+// whiteout of it.
+func (o *Store) rawPut(batch storage.Batch, k storage.Key, v []byte) {
+	batch.Put(storage.TKey(k), v)
+	batch.Delete(storage.TKey(append(storage.Key{whiteoutPrefix}, k...)))
+}
+
+// rawDelete adds operations to an upper store batch that delete a full key and, if
+// the key is in the base store, whiteout the base key-value pair.
+func (o *Store) rawDelete(batch storage.Batch, k storage.Key) error {
+	batch.Delete(storage.TKey(k))
+	inBase, err := o.baseHas(k)
+	if err != nil {
+		return err
+	}
+	if inBase {
+		batch.Put(storage.TKey(append(storage.Key{whiteoutPrefix}, k...)), dvid.EmptyValue())
+	}
+	return nil
+}
+
+// put adds operations equivalent to a Put in the given context.
+func (o *Store) put(batch storage.Batch, ctx storage.Context, tk storage.TKey, v []byte) error {
+	if vctx, ok := ctx.(storage.VersionedCtx); ok && ctx.Versioned() {
+		if err := o.rawDelete(batch, vctx.TombstoneKey(tk)); err != nil {
+			return err
+		}
+	}
+	o.rawPut(batch, ctx.ConstructKey(tk), v)
+	return nil
+}
+
+// delete adds operations equivalent to a Delete in the given context.
+func (o *Store) delete(batch storage.Batch, ctx storage.Context, tk storage.TKey) error {
+	if vctx, ok := ctx.(storage.VersionedCtx); ok && ctx.Versioned() {
+		o.rawPut(batch, vctx.TombstoneKey(tk), dvid.EmptyValue())
+	}
+	return o.rawDelete(batch, ctx.ConstructKey(tk))
+}
+
+func (o *Store) newUpperBatch() storage.Batch {
+	return o.upper.(storage.KeyValueBatcher).NewBatch(rawContext{})
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key to the upper store.
+func (o *Store) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := o.newUpperBatch()
+	if err := o.put(batch, ctx, tk, v); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// Delete removes a value with given key, masking any value in the base store.
+func (o *Store) Delete(ctx storage.Context, tk storage.TKey) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := o.newUpperBatch()
+	if err := o.delete(batch, ctx, tk); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+func (o *Store) RawPut(k storage.Key, v []byte) error {
+	batch := o.newUpperBatch()
+	o.rawPut(batch, k, v)
+	return batch.Commit()
+}
+
+// RawDelete is a low-level function that deletes a key-value pair using full keys.
+func (o *Store) RawDelete(k storage.Key) error {
+	batch := o.newUpperBatch()
+	if err := o.rawDelete(batch, k); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (o *Store) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := o.newUpperBatch()
+	for _, kv := range kvs {
+		if err := o.put(batch, ctx, kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (o *Store) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	var tkeys []storage.TKey
+	err := o.processRange(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := o.newUpperBatch()
+	for i, tk := range tkeys {
+		if err := o.delete(batch, ctx, tk); err != nil {
+			return err
+		}
+		if (i+1)%DeleteBatchSize == 0 {
+			if err := batch.Commit(); err != nil {
+				return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v", i, err)
+			}
+			batch = o.newUpperBatch()
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("Error on last batch commit of DeleteRange: %v", err)
+	}
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+// Deleting all versions masks the base store with a range whiteout, while deleting a
+// single version whites out each base key-value pair of that version.
+func (o *Store) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	var begKey, endKey storage.Key
+	if versioned {
+		var err error
+		if begKey, err = vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass)); err != nil {
+			return err
+		}
+		if endKey, err = vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass)); err != nil {
+			return err
+		}
+	} else {
+		begKey, endKey = ctx.KeyRange()
+	}
+	if allVersions {
+		if err := o.addRangeWhiteout(begKey, endKey); err != nil {
+			return err
+		}
+		return o.upper.DeleteAll(ctx, true)
+	}
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+
+	var keys []storage.Key
+	deleteVersion := vctx.VersionID()
+	err := o.iterate(begKey, endKey, true, func(kv *storage.KeyValue) error {
+		_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+		if err != nil {
+			return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+		}
+		if v == deleteVersion {
+			keys = append(keys, kv.K)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := o.newUpperBatch()
+	for i, k := range keys {
+		if err := o.rawDelete(batch, k); err != nil {
+			return err
+		}
+		if (i+1)%DeleteBatchSize == 0 {
+			if err := batch.Commit(); err != nil {
+				return fmt.Errorf("Error on batch commit of DeleteAll at key-value pair %d: %v", i, err)
+			}
+			batch = o.newUpperBatch()
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("Error on last batch commit of DeleteAll: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", len(keys), ctx)
+	return nil
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the sum of sizes in the base and upper stores, so data
+// masked by the upper store is still counted.
+func (o *Store) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	sizes := make([]uint64, len(ranges))
+	for _, db := range []storage.OrderedKeyValueDB{o.base, o.upper} {
+		sv, ok := db.(storage.SizeViewer)
+		if !ok {
+			return nil, fmt.Errorf("store %s in %s cannot report sizes", db, o)
+		}
+		s, err := sv.GetApproximateSizes(ranges)
+		if err != nil {
+			return nil, err
+		}
+		for i := range sizes {
+			sizes[i] += s[i]
+		}
+	}
+	return sizes, nil
+}
+
+// --- Batcher interface ----
+
+type batch struct {
+	o     *Store
+	ctx   storage.Context
+	upper storage.Batch
+	err   error
+}
+
+// NewBatch returns an implementation that allows batch writes, which are committed
+// atomically to the upper store.
+func (o *Store) NewBatch(ctx storage.Context) storage.Batch {
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	return &batch{o: o, ctx: ctx, upper: o.newUpperBatch()}
+}
+
+func (b *batch) Delete(tk storage.TKey) {
+	if err := b.o.delete(b.upper, b.ctx, tk); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *batch) Put(tk storage.TKey, v []byte) {
+	if err := b.o.put(b.upper, b.ctx, tk, v); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *batch) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.upper.Commit()
+}
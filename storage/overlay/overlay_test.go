@@ -0,0 +1,130 @@
+package overlay
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/memstore"
+)
+
+func openMemstore(t *testing.T) (storage.OrderedKeyValueDB, func()) {
+	var e memstore.Engine
+	backend, err := e.GetTestConfig()
+	if err != nil {
+		t.Fatalf("unable to get test config: %v\n", err)
+	}
+	config := backend.Stores["default"]
+	store, _, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("unable to create memstore: %v\n", err)
+	}
+	return store.(storage.OrderedKeyValueDB), func() { e.Delete(config) }
+}
+
+func openOverlay(t *testing.T, base, upper storage.OrderedKeyValueDB) *Store {
+	var c dvid.Config
+	c.SetAll(map[string]interface{}{"base": "snapshot", "upper": "scratch"})
+	config := dvid.StoreConfig{Config: c, Engine: "overlay"}
+
+	var e Engine
+	components := map[storage.Alias]dvid.Store{"snapshot": base, "scratch": upper}
+	store, _, err := e.NewCompositeStore(config, components)
+	if err != nil {
+		t.Fatalf("unable to open overlay store: %v\n", err)
+	}
+	return store.(*Store)
+}
+
+func checkRange(t *testing.T, db storage.OrderedKeyValueGetter, ctx storage.Context, expected []string) {
+	got, err := db.GetRange(ctx, storage.NewTKey(1, []byte("00")), storage.NewTKey(1, []byte("99")))
+	if err != nil {
+		t.Fatalf("error on GetRange: %v\n", err)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d key-value pairs in range, got %d\n", len(expected), len(got))
+	}
+	for i, kv := range got {
+		if string(kv.V) != expected[i] {
+			t.Errorf("expected value %d to be %q, got %q\n", i, expected[i], string(kv.V))
+		}
+	}
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	base, deleteBase := openMemstore(t)
+	defer deleteBase()
+	upper, deleteUpper := openMemstore(t)
+	defer deleteUpper()
+
+	ctx := storage.NewMetadataContext()
+	tkey := func(i int) storage.TKey {
+		return storage.NewTKey(1, []byte(fmt.Sprintf("%02d", i)))
+	}
+	for i := 0; i < 5; i++ {
+		if err := base.Put(ctx, tkey(i), []byte(fmt.Sprintf("base %d", i))); err != nil {
+			t.Fatalf("error on base Put: %v\n", err)
+		}
+	}
+
+	o := openOverlay(t, base, upper)
+	if err := o.Put(ctx, tkey(1), []byte("upper 1")); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+	if err := o.Delete(ctx, tkey(3)); err != nil {
+		t.Fatalf("error on Delete: %v\n", err)
+	}
+	batch := o.NewBatch(ctx)
+	batch.Put(tkey(7), []byte("upper 7"))
+	batch.Delete(tkey(0))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("error on batch commit: %v\n", err)
+	}
+	expected := []string{"upper 1", "base 2", "base 4", "upper 7"}
+	checkRange(t, o, ctx, expected)
+	if v, _ := o.Get(ctx, tkey(3)); v != nil {
+		t.Errorf("expected deleted key to be masked, got %q\n", string(v))
+	}
+	keys, err := o.KeysInRange(ctx, tkey(0), tkey(99))
+	if err != nil || len(keys) != 4 {
+		t.Errorf("expected 4 keys in range, got %d, err %v\n", len(keys), err)
+	}
+
+	// The base store is never modified.
+	checkRange(t, base, ctx, []string{"base 0", "base 1", "base 2", "base 3", "base 4"})
+
+	// Whiteouts persist when the overlay is reopened, and rewriting a key removes its whiteout.
+	o = openOverlay(t, base, upper)
+	checkRange(t, o, ctx, expected)
+	if err := o.Put(ctx, tkey(3), []byte("upper 3")); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+	if v, _ := o.Get(ctx, tkey(3)); string(v) != "upper 3" {
+		t.Errorf("expected rewritten key, got %q\n", string(v))
+	}
+
+	// Raw range queries can be cancelled midway.
+	ch := make(chan *storage.KeyValue)
+	cancel := make(chan struct{}, 1)
+	done := make(chan error)
+	beg, end := ctx.KeyRange()
+	go func() {
+		done <- o.RawRangeQuery(beg, end, false, ch, cancel)
+	}()
+	if kv := <-ch; kv == nil || string(kv.V) != "upper 1" {
+		t.Errorf("bad first key-value from raw range query: %v\n", kv)
+	}
+	cancel <- struct{}{}
+	if err := <-done; err != nil {
+		t.Errorf("error on cancelled raw range query: %v\n", err)
+	}
+
+	// Deleting all data masks the entire base range.
+	if err := o.DeleteAll(ctx, true); err != nil {
+		t.Fatalf("error on DeleteAll: %v\n", err)
+	}
+	checkRange(t, o, ctx, nil)
+	checkRange(t, openOverlay(t, base, upper), ctx, nil)
+	checkRange(t, base, ctx, []string{"base 0", "base 1", "base 2", "base 3", "base 4"})
+}
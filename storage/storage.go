@@ -48,6 +48,7 @@ package storage
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -230,6 +231,52 @@ type TestableEngine interface {
 	Delete(dvid.StoreConfig) error
 }
 
+// CompositeEngine is a storage engine whose stores are built on top of other stores
+// declared in the same configuration and referenced by their aliases, e.g., a cache
+// store that fronts a slower store.  The storage manager opens all referenced stores
+// before the composite store and closes the composite store first.
+type CompositeEngine interface {
+	Engine
+
+	// ComponentAliases returns the aliases of the stores used by a composite store
+	// with the given configuration.
+	ComponentAliases(dvid.StoreConfig) ([]Alias, error)
+
+	// NewCompositeStore returns a store built from the given component stores, which
+	// are keyed by the aliases returned by ComponentAliases().
+	NewCompositeStore(config dvid.StoreConfig, components map[Alias]dvid.Store) (db dvid.Store, initMetadata bool, err error)
+}
+
+// ExclusiveCompositeEngine is a CompositeEngine whose stores take over some of their
+// component stores, e.g., a cache store that clears its fast store when opened.  Those
+// components can't be used as the default or metadata store, mapped to data in the
+// [backend] configuration, or used by other composite stores.
+type ExclusiveCompositeEngine interface {
+	CompositeEngine
+
+	// ExclusiveAliases returns the aliases of the component stores used only by the
+	// composite store with the given configuration.
+	ExclusiveAliases(dvid.StoreConfig) ([]Alias, error)
+}
+
+// Resyncer stores hold replicas that can be reconciled, e.g., a mirrored store after one of
+// its stores was unavailable.  The source is the alias of the replica that should be copied
+// or empty if the store should choose.
+type Resyncer interface {
+	Resync(source Alias) error
+}
+
+// Rekeyer stores encrypt data and can re-encrypt it with their newest key.
+type Rekeyer interface {
+	Rekey() error
+}
+
+// StatsReporter stores can report runtime statistics, e.g., cache hits and misses,
+// as a JSON-encodable value.
+type StatsReporter interface {
+	Stats() interface{}
+}
+
 var (
 	// initialized by RegisterEngine() calls during init() within each storage engine
 	availEngines map[string]Engine
@@ -267,14 +314,27 @@ func GetEngine(name string) Engine {
 }
 
 // GetTestableEngine returns a Testable engine, i.e. has ability to create and delete database.
+// Since the in-memory engine is always compiled in, any other testable engine is preferred,
+// and among several engines the first by name is chosen so the choice is deterministic.
 func GetTestableEngine() TestableEngine {
-	for _, e := range availEngines {
-		testableEng, ok := e.(TestableEngine)
-		if ok {
-			return testableEng
+	names := make([]string, 0, len(availEngines))
+	for name := range availEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var memEng TestableEngine
+	for _, name := range names {
+		testableEng, ok := availEngines[name].(TestableEngine)
+		if !ok {
+			continue
+		}
+		if name == "memstore" {
+			memEng = testableEng
+			continue
 		}
+		return testableEng
 	}
-	return nil
+	return memEng
 }
 
 // NewStore checks if a given engine is available and if so, returns
@@ -290,6 +350,16 @@ func NewStore(c dvid.StoreConfig) (db dvid.Store, created bool, err error) {
 	return e.NewStore(c)
 }
 
+// IsComposite returns true if the store configuration uses a CompositeEngine.
+func IsComposite(c dvid.StoreConfig) bool {
+	e, found := availEngines[c.Engine]
+	if !found {
+		return false
+	}
+	_, composite := e.(CompositeEngine)
+	return composite
+}
+
 // Repair repairs a named engine's store at given path.
 func Repair(name, path string) error {
 	e := GetEngine(name)
@@ -609,6 +679,13 @@ func GetDataSizes(store dvid.Store, instances []dvid.InstanceID) (map[dvid.Insta
 	return getInstanceSizes(sv, ids)
 }
 
+// DataKeyRange returns the full keys bounding the key-value pairs of all data instances.
+func DataKeyRange() (min, max Key) {
+	min = constructDataKey(0, 0, 0, minTKey)
+	max = constructDataKey(dvid.MaxInstanceID, dvid.MaxVersionID, dvid.MaxClientID, maxTKey)
+	return
+}
+
 func getNextInstance(db OrderedKeyValueGetter, curID dvid.InstanceID) (nextID dvid.InstanceID, finished bool, err error) {
 	begKey := constructDataKey(curID+1, 0, 0, minTKey)
 	endKey := constructDataKey(dvid.MaxInstanceID, dvid.MaxVersionID, dvid.MaxClientID, maxTKey)
@@ -648,6 +725,33 @@ func getNextInstance(db OrderedKeyValueGetter, curID dvid.InstanceID) (nextID dv
 	return
 }
 
+// MetadataExists returns true if the store holds any metadata key-value pairs.
+// The metadata key space is small so it is read in full.
+func MetadataExists(db OrderedKeyValueGetter) (bool, error) {
+	var ctx MetadataContext
+	begKey, endKey := ctx.KeyRange()
+
+	ch := make(chan *KeyValue)
+	var exists bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			kv := <-ch
+			if kv == nil || kv.K == nil {
+				return
+			}
+			exists = true
+		}
+	}()
+	keysOnly := true
+	err := db.RawRangeQuery(begKey, endKey, keysOnly, ch, nil)
+	close(ch) // in case the query ended without sending a nil
+	wg.Wait()
+	return exists, err
+}
+
 func getInstanceSizes(sv SizeViewer, instances []dvid.InstanceID) (map[dvid.InstanceID]uint64, error) {
 	ranges := make([]KeyRange, len(instances))
 	for i, curID := range instances {
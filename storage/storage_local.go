@@ -5,12 +5,17 @@ package storage
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/janelia-flyem/dvid/dvid"
 )
 
 var manager managerT
 
+// storeAssignKeyClass is the metadata key class for data instance store assignments made
+// while running, e.g., by online migration, which override the [backend] configuration.
+const storeAssignKeyClass TKeyClass = 241
+
 // managerT should be implemented for each type of storage implementation (local, clustered, gcloud)
 // and it should fulfill a storage.Manager interface.
 type managerT struct {
@@ -21,6 +26,9 @@ type managerT struct {
 	metadataStore dvid.Store
 
 	stores        map[Alias]dvid.Store
+	composites    []Alias         // composite stores in order of opening
+	exclusive     map[Alias]Alias // components used only by the composite store
+	instanceMu    sync.RWMutex
 	instanceStore map[dvid.DataSpecifier]dvid.Store
 	datatypeStore map[dvid.TypeString]dvid.Store
 
@@ -111,7 +119,9 @@ func GetAssignedStore(dataname dvid.InstanceName, root dvid.UUID, typename dvid.
 		return nil, fmt.Errorf("Storage manager not initialized before requesting store for %s/%s", dataname, root)
 	}
 	dataid := dvid.GetDataSpecifier(dataname, root)
+	manager.instanceMu.RLock()
 	store, found := manager.instanceStore[dataid]
+	manager.instanceMu.RUnlock()
 	var err error
 	if !found {
 		store, err = assignedStoreByType(typename)
@@ -121,17 +131,80 @@ func GetAssignedStore(dataname dvid.InstanceName, root dvid.UUID, typename dvid.
 	}
 
 	// See if this is using caching and if so, establish a wrapper around it.
+	if manager.gcache.cache == nil {
+		return store, nil
+	}
 	if _, supported := manager.gcache.supported[dataid]; supported {
-		store, err = wrapGroupcache(store, manager.gcache.cache)
+		store, err = wrapGroupcache(store, manager.gcache.cache, false)
 		if err != nil {
 			dvid.Errorf("Unable to wrap groupcache around store %s for data instance %q (uuid %s): %v\n", store, dataname, root, err)
 		} else {
 			dvid.Infof("Returning groupcache-wrapped store %s for data instance %q @ %s\n", store, dataname, root)
 		}
+	} else if manager.gcache.auto {
+		cached, err := wrapGroupcache(store, manager.gcache.cache, true)
+		if err != nil {
+			dvid.Debugf("Not using automatic groupcache for data instance %q: %v\n", dataname, err)
+		} else {
+			store = cached
+		}
 	}
 	return store, nil
 }
 
+// AssignStore assigns a store given by alias to a data instance, overriding any assignment
+// in the [backend] configuration.  The assignment is saved in the metadata store so it
+// persists across restarts.
+func AssignStore(dataname dvid.InstanceName, root dvid.UUID, alias Alias) error {
+	store, err := GetStoreByAlias(alias)
+	if err != nil {
+		return err
+	}
+	if owner, found := manager.exclusive[alias]; found {
+		return fmt.Errorf("store %q is used only by composite store %q and can't be assigned to data", alias, owner)
+	}
+	metadb, err := MetaDataKVStore()
+	if err != nil {
+		return err
+	}
+	dataid := dvid.GetDataSpecifier(dataname, root)
+	tk := NewTKey(storeAssignKeyClass, []byte(dataid))
+	if err := metadb.Put(NewMetadataContext(), tk, []byte(alias)); err != nil {
+		return fmt.Errorf("unable to save store assignment for data %q: %v", dataname, err)
+	}
+	manager.instanceMu.Lock()
+	manager.instanceStore[dataid] = store
+	manager.instanceMu.Unlock()
+	return nil
+}
+
+// loadStoreAssignments applies data instance store assignments saved by AssignStore.
+func loadStoreAssignments() error {
+	metadb, err := MetaDataKVStore()
+	if err != nil {
+		return err
+	}
+	kvs, err := metadb.GetRange(NewMetadataContext(), MinTKey(storeAssignKeyClass), MaxTKey(storeAssignKeyClass))
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		b, err := kv.K.ClassBytes(storeAssignKeyClass)
+		if err != nil {
+			return err
+		}
+		dataid := dvid.DataSpecifier(b)
+		store, found := manager.stores[Alias(kv.V)]
+		if !found {
+			dvid.Errorf("Ignoring assignment of data %s to store %q, which is not in configuration\n", dataid, string(kv.V))
+			continue
+		}
+		manager.instanceStore[dataid] = store
+		dvid.Infof("Data %s assigned to store %q\n", dataid, string(kv.V))
+	}
+	return nil
+}
+
 // assignedStoreByType returns the store assigned to a particular datatype.
 func assignedStoreByType(typename dvid.TypeString) (dvid.Store, error) {
 	if !manager.setup {
@@ -147,13 +220,27 @@ func assignedStoreByType(typename dvid.TypeString) (dvid.Store, error) {
 // Close handles any storage-specific shutdown procedures.
 func Close() {
 	if manager.setup {
+		// Close composite stores before the stores they are built upon.
+		closed := make(map[Alias]bool, len(manager.composites))
+		for i := len(manager.composites) - 1; i >= 0; i-- {
+			alias := manager.composites[i]
+			store := manager.stores[alias]
+			dvid.Infof("Closing composite store %q: %s...\n", alias, store)
+			store.Close()
+			closed[alias] = true
+		}
 		for alias, store := range manager.stores {
+			if closed[alias] {
+				continue
+			}
 			dvid.Infof("Closing store %q: %s...\n", alias, store)
 			store.Close()
 		}
 		manager.setup = false
 	}
 	manager.stores = nil
+	manager.composites = nil
+	manager.exclusive = nil
 	manager.instanceStore = nil
 	manager.datatypeStore = nil
 	manager.defaultStore = nil
@@ -166,12 +253,16 @@ func Close() {
 // "default", or "metadata".
 func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, err error) {
 	dvid.Infof("backend:\n%v\n", *backend)
-	// Open all the backend stores
+	// Open all the backend stores, leaving composite stores until their components are open.
 	manager.stores = make(map[Alias]dvid.Store, len(backend.Stores))
-	var gotDefault, gotMetadata, createdDefault, lastCreated bool
-	var lastStore dvid.Store
+	manager.composites = nil
+	storeCreated := make(map[Alias]bool, len(backend.Stores))
+	var composites []Alias
 	for alias, dbconfig := range backend.Stores {
-		var store dvid.Store
+		if IsComposite(dbconfig) {
+			composites = append(composites, alias)
+			continue
+		}
 		for dbalias, db := range manager.stores {
 			if db.Equal(dbconfig) {
 				return false, fmt.Errorf("Store %q configuration is duplicate of store %q", alias, dbalias)
@@ -181,6 +272,20 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 		if err != nil {
 			return false, fmt.Errorf("bad store %q: %v", alias, err)
 		}
+		manager.stores[alias] = store
+		storeCreated[alias] = created
+	}
+	if err = checkExclusiveComponents(backend, composites); err != nil {
+		return
+	}
+	if err = openCompositeStores(backend, composites, storeCreated); err != nil {
+		return
+	}
+
+	var gotDefault, gotMetadata, createdDefault, lastCreated bool
+	var lastStore dvid.Store
+	for alias, store := range manager.stores {
+		created := storeCreated[alias]
 		if alias == backend.Metadata {
 			gotMetadata = true
 			createdMetadata = created
@@ -191,7 +296,6 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 			createdDefault = created
 			manager.defaultStore = store
 		}
-		manager.stores[alias] = store
 		lastStore = store
 		lastCreated = created
 	}
@@ -247,6 +351,17 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 	}
 	manager.setup = true
 
+	// Complete any transactions across stores that were interrupted and apply store
+	// assignments made while running.
+	if !createdMetadata {
+		if err = RecoverTransactions(); err != nil {
+			return
+		}
+		if err = loadStoreAssignments(); err != nil {
+			return
+		}
+	}
+
 	// Setup the graph store
 	var store dvid.Store
 	store, err = assignedStoreByType("labelgraph")
@@ -273,6 +388,91 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 	return
 }
 
+// checkExclusiveComponents makes sure component stores taken over by a composite store
+// aren't used for anything else.  This must be done before opening the composite stores,
+// which may modify those components.
+func checkExclusiveComponents(backend *Backend, composites []Alias) error {
+	manager.exclusive = make(map[Alias]Alias)
+	for _, alias := range composites {
+		e, ok := GetEngine(backend.Stores[alias].Engine).(ExclusiveCompositeEngine)
+		if !ok {
+			continue
+		}
+		aliases, err := e.ExclusiveAliases(backend.Stores[alias])
+		if err != nil {
+			return fmt.Errorf("bad store %q: %v", alias, err)
+		}
+		for _, component := range aliases {
+			manager.exclusive[component] = alias
+		}
+	}
+	for component, owner := range manager.exclusive {
+		if component == backend.Default || component == backend.Metadata {
+			return fmt.Errorf("store %q is used only by composite store %q and can't be the default or metadata store", component, owner)
+		}
+		for dataspec, alias := range backend.Mapping {
+			if alias == component {
+				return fmt.Errorf("store %q is used only by composite store %q and can't be mapped to %s", component, owner, dataspec)
+			}
+		}
+	}
+	for _, alias := range composites {
+		e := GetEngine(backend.Stores[alias].Engine).(CompositeEngine)
+		aliases, err := e.ComponentAliases(backend.Stores[alias])
+		if err != nil {
+			return fmt.Errorf("bad store %q: %v", alias, err)
+		}
+		for _, component := range aliases {
+			if owner, found := manager.exclusive[component]; found && owner != alias {
+				return fmt.Errorf("store %q is used only by composite store %q and can't be used by store %q", component, owner, alias)
+			}
+		}
+	}
+	return nil
+}
+
+// openCompositeStores opens composite stores after all the stores they reference,
+// which may themselves be composite stores.
+func openCompositeStores(backend *Backend, pending []Alias, storeCreated map[Alias]bool) error {
+	for len(pending) != 0 {
+		var waiting []Alias
+		for _, alias := range pending {
+			dbconfig := backend.Stores[alias]
+			e := GetEngine(dbconfig.Engine).(CompositeEngine)
+			aliases, err := e.ComponentAliases(dbconfig)
+			if err != nil {
+				return fmt.Errorf("bad store %q: %v", alias, err)
+			}
+			components := make(map[Alias]dvid.Store, len(aliases))
+			for _, component := range aliases {
+				if _, found := backend.Stores[component]; !found {
+					return fmt.Errorf("store %q uses unknown store %q", alias, component)
+				}
+				if store, found := manager.stores[component]; found {
+					components[component] = store
+				}
+			}
+			if len(components) != len(aliases) {
+				waiting = append(waiting, alias)
+				continue
+			}
+			store, created, err := e.NewCompositeStore(dbconfig, components)
+			if err != nil {
+				return fmt.Errorf("bad store %q: %v", alias, err)
+			}
+			dvid.Infof("Opened composite store %q: %s\n", alias, store)
+			manager.stores[alias] = store
+			manager.composites = append(manager.composites, alias)
+			storeCreated[alias] = created
+		}
+		if len(waiting) == len(pending) {
+			return fmt.Errorf("composite stores %v have circular store references", waiting)
+		}
+		pending = waiting
+	}
+	return nil
+}
+
 // DeleteDataInstance removes a data instance.
 func DeleteDataInstance(data dvid.Data) error {
 	if !manager.setup {
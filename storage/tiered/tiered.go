@@ -0,0 +1,1010 @@
+/*
+	Package tiered implements a composite store that places a fast store, e.g., an SSD-backed
+	leveldb, in front of a slow store, e.g., object storage or RAID.  Both stores must be declared
+	in the [store] section of the configuration TOML and are referenced by alias:
+
+		[store.ssdcache]
+		engine = "tiered"
+		fast = "ssd"
+		slow = "raid6"
+		cachemb = 200000     # LRU byte budget for values in the fast store
+		writeback = true     # default false, i.e., write-through
+		flushsecs = 10       # how often dirty entries are written back to the slow store
+
+	Values read via Get are read through the slow store and cached in the fast store, which
+	holds them under their original full keys.  Range queries are always served by the slow store
+	after any dirty cached values in the range have been written back.  When write-back is off,
+	writes go to the slow store and invalidate cached values.  When write-back is on, Put writes
+	only to the fast store and dirty values are written to the slow store periodically, when the
+	cache exceeds its byte budget, or before any read or deletion that needs them.  Deletions
+	always go to the slow store.
+
+	The fast store should be dedicated to the cache.  On startup, any values left dirty in the
+	fast store by an unclean shutdown are written back and the fast store is cleared.
+*/
+package tiered
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+)
+
+const (
+	// DefaultCacheMB is the default byte budget of the fast store in MB.
+	DefaultCacheMB = 1024
+
+	// DefaultFlushSecs is the default interval between write-backs of dirty values.
+	DefaultFlushSecs = 10
+)
+
+// Values in the fast store are prefixed with a byte marking whether they are dirty,
+// so dirty values can be recovered after an unclean shutdown.
+const (
+	markClean byte = 0
+	markDirty byte = 1
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in tiered: %v\n", err)
+	}
+	e := Engine{"tiered", "Tiered read-through cache of a slow store using a fast store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore is not supported since tiered stores require other stores.  Tiered stores are
+// opened by the storage manager through the CompositeEngine interface.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return nil, false, fmt.Errorf("tiered stores must be opened as composite stores")
+}
+
+// ComponentAliases returns the fast and slow store aliases.
+func (e Engine) ComponentAliases(config dvid.StoreConfig) ([]storage.Alias, error) {
+	c, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return []storage.Alias{c.fast, c.slow}, nil
+}
+
+// ExclusiveAliases returns the fast store alias, since the fast store is cleared when the
+// tiered store is opened.
+func (e Engine) ExclusiveAliases(config dvid.StoreConfig) ([]storage.Alias, error) {
+	c, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return []storage.Alias{c.fast}, nil
+}
+
+// NewCompositeStore returns a tiered store built from the fast and slow stores.  Metadata
+// initialization is required if the slow store has no metadata.
+func (e Engine) NewCompositeStore(config dvid.StoreConfig, components map[storage.Alias]dvid.Store) (dvid.Store, bool, error) {
+	c, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	fast, ok := components[c.fast].(storage.OrderedKeyValueDB)
+	if !ok {
+		return nil, false, fmt.Errorf("fast store %q is not an ordered key-value store", c.fast)
+	}
+	slow, ok := components[c.slow].(storage.OrderedKeyValueDB)
+	if !ok {
+		return nil, false, fmt.Errorf("slow store %q is not an ordered key-value store", c.slow)
+	}
+	s, err := newStore(c, fast, slow)
+	if err != nil {
+		return nil, false, err
+	}
+	exists, err := storage.MetadataExists(slow)
+	if err != nil {
+		s.Close()
+		return nil, false, err
+	}
+	return s, !exists, nil
+}
+
+type tieredConfig struct {
+	fast, slow storage.Alias
+	budget     uint64
+	writeBack  bool
+	flushEvery time.Duration
+}
+
+// getInt returns an integer setting, which may be decoded from TOML as an integer or a string.
+func getInt(config dvid.StoreConfig, key string, defaultVal int64) (int64, error) {
+	v, found := config.Get(key)
+	if !found {
+		return defaultVal, nil
+	}
+	switch i := v.(type) {
+	case int64:
+		return i, nil
+	case int:
+		return int64(i), nil
+	case float64:
+		return int64(i), nil
+	case string:
+		return strconv.ParseInt(i, 10, 64)
+	default:
+		return 0, fmt.Errorf("%q setting must be an integer (%v)", key, v)
+	}
+}
+
+func parseConfig(config dvid.StoreConfig) (c tieredConfig, err error) {
+	for _, key := range []string{"fast", "slow"} {
+		var alias string
+		var found bool
+		alias, found, err = config.GetString(key)
+		if err != nil {
+			return
+		}
+		if !found || alias == "" {
+			err = fmt.Errorf("%q store alias must be specified for tiered configuration", key)
+			return
+		}
+		if key == "fast" {
+			c.fast = storage.Alias(alias)
+		} else {
+			c.slow = storage.Alias(alias)
+		}
+	}
+	if c.fast == c.slow {
+		err = fmt.Errorf("tiered store must use different fast and slow stores, not %q for both", c.fast)
+		return
+	}
+	var mb, secs int64
+	if mb, err = getInt(config, "cachemb", DefaultCacheMB); err != nil {
+		return
+	}
+	if mb <= 0 {
+		err = fmt.Errorf("tiered store %q setting must be positive, not %d", "cachemb", mb)
+		return
+	}
+	c.budget = uint64(mb) << 20
+	if secs, err = getInt(config, "flushsecs", DefaultFlushSecs); err != nil {
+		return
+	}
+	if secs <= 0 {
+		err = fmt.Errorf("tiered store %q setting must be positive, not %d", "flushsecs", secs)
+		return
+	}
+	c.flushEvery = time.Duration(secs) * time.Second
+	if v, found := config.Get("writeback"); found {
+		switch b := v.(type) {
+		case bool:
+			c.writeBack = b
+		case string:
+			c.writeBack, _, err = config.GetBool("writeback")
+		default:
+			err = fmt.Errorf("%q setting must be a bool (%v)", "writeback", v)
+		}
+	}
+	return
+}
+
+// cacheContext stores cached values in the fast store under their original full keys.
+type cacheContext struct {
+	storage.MetadataContext
+}
+
+func (ctx cacheContext) ConstructKey(tk storage.TKey) storage.Key {
+	return storage.Key(tk)
+}
+
+func (ctx cacheContext) String() string {
+	return "Tiered cache context"
+}
+
+// entry tracks a value cached in the fast store.  Clean entries are kept in an LRU list
+// for eviction while dirty entries are not evictable until written back.
+type entry struct {
+	key   string
+	size  uint64
+	dirty bool
+	gen   uint64        // incremented on each write of a dirty value
+	elem  *list.Element // position in LRU list if clean
+}
+
+// Stats holds cache statistics for a tiered store.
+type Stats struct {
+	FastStore    storage.Alias
+	SlowStore    storage.Alias
+	WriteBack    bool
+	BudgetBytes  uint64
+	UsedBytes    uint64
+	Entries      int
+	DirtyEntries int
+	Hits         uint64
+	Misses       uint64
+	Evictions    uint64
+	WriteBacks   uint64
+	HitRate      float64
+}
+
+// Store is a tiered store that fulfills the storage.OrderedKeyValueDB interface.
+type Store struct {
+	config tieredConfig
+	fast   storage.OrderedKeyValueDB
+	slow   storage.OrderedKeyValueDB
+
+	mu      sync.Mutex // protects the fields below and writes to the fast store
+	entries map[string]*entry
+	lru     *list.List // clean entries with most recently used at front
+	used    uint64
+	ndirty  int
+	fills   map[string]uint64 // tokens for reads that may fill the cache
+	token   uint64
+	stats   Stats
+
+	// flushMu serializes write-backs with deletions so a deleted value can't be
+	// resurrected by a concurrent write-back.
+	flushMu sync.Mutex
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newStore(c tieredConfig, fast, slow storage.OrderedKeyValueDB) (*Store, error) {
+	s := &Store{
+		config:  c,
+		fast:    fast,
+		slow:    slow,
+		entries: make(map[string]*entry),
+		lru:     list.New(),
+		fills:   make(map[string]uint64),
+		done:    make(chan struct{}),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	if c.writeBack {
+		s.stopped.Add(1)
+		go s.flusher()
+	}
+	return s, nil
+}
+
+// recover writes back any dirty values left in the fast store and then clears it.
+func (s *Store) recover() error {
+	ch := make(chan *storage.KeyValue, 100)
+	var keys []storage.Key
+	var numDirty int
+	var writeErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for kv := range ch {
+			if kv == nil || kv.K == nil {
+				return
+			}
+			key := make(storage.Key, len(kv.K))
+			copy(key, kv.K)
+			keys = append(keys, key)
+			if len(kv.V) > 0 && kv.V[0] == markDirty && writeErr == nil {
+				writeErr = s.writeSlow(key, kv.V[1:])
+				numDirty++
+			}
+		}
+	}()
+	maxKey := storage.Key(bytes.Repeat([]byte{0xFF}, 32))
+	err := s.fast.RawRangeQuery(storage.Key{}, maxKey, false, ch, nil)
+	close(ch)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("unable to write back dirty cached values: %v", writeErr)
+	}
+	for _, key := range keys {
+		if err := s.fast.RawDelete(key); err != nil {
+			return err
+		}
+	}
+	if len(keys) != 0 {
+		dvid.Infof("Tiered store cleared %d cached values from fast store %q after writing back %d dirty values.\n",
+			len(keys), s.config.fast, numDirty)
+	}
+	return nil
+}
+
+// flusher periodically writes back dirty values until the store is closed.
+func (s *Store) flusher() {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(s.config.flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.flushAll(); err != nil {
+				dvid.Errorf("Error writing back dirty values for %s: %v\n", s, err)
+			}
+		}
+	}
+}
+
+func (s *Store) String() string {
+	mode := "write-through"
+	if s.config.writeBack {
+		mode = "write-back"
+	}
+	return fmt.Sprintf("tiered %s cache %q -> %q", mode, s.config.fast, s.config.slow)
+}
+
+// Equal returns true if the configuration is for a tiered store with the same fast and slow stores.
+func (s *Store) Equal(config dvid.StoreConfig) bool {
+	if config.Engine != "tiered" {
+		return false
+	}
+	c, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return c.fast == s.config.fast && c.slow == s.config.slow
+}
+
+// Close writes back all dirty values.  The fast and slow stores are closed by the
+// storage manager.
+func (s *Store) Close() {
+	if s.config.writeBack {
+		close(s.done)
+		s.stopped.Wait()
+		if err := s.flushAll(); err != nil {
+			dvid.Criticalf("Unable to write back dirty values on close of %s: %v\n", s, err)
+		}
+	}
+}
+
+// Stats returns the current cache statistics.
+func (s *Store) Stats() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.FastStore = s.config.fast
+	stats.SlowStore = s.config.slow
+	stats.WriteBack = s.config.writeBack
+	stats.BudgetBytes = s.config.budget
+	stats.UsedBytes = s.used
+	stats.Entries = len(s.entries)
+	stats.DirtyEntries = s.ndirty
+	if total := stats.Hits + stats.Misses; total != 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// --- cache management ---
+
+// writeSlow writes a dirty value to the slow store using its full key.  Data keys
+// have any tombstone for the same version removed as in a versioned Put.
+func (s *Store) writeSlow(key storage.Key, v []byte) error {
+	if _, _, _, err := storage.DataKeyToLocalIDs(key); err == nil {
+		tombstone := make(storage.Key, len(key))
+		copy(tombstone, key)
+		tombstone[len(tombstone)-1] = storage.MarkTombstone
+		if err := s.slow.RawDelete(tombstone); err != nil {
+			return err
+		}
+	}
+	return s.slow.RawPut(key, v)
+}
+
+// putLocked writes a value into the fast store and indexes it.  Must hold s.mu.
+func (s *Store) putLocked(key storage.Key, v []byte, dirty bool) error {
+	mark := markClean
+	if dirty {
+		mark = markDirty
+	}
+	buf := make([]byte, len(v)+1)
+	buf[0] = mark
+	copy(buf[1:], v)
+	if err := s.fast.RawPut(key, buf); err != nil {
+		return err
+	}
+	size := uint64(len(key) + len(buf))
+	e, found := s.entries[string(key)]
+	if found {
+		s.used -= e.size
+	} else {
+		e = &entry{key: string(key)}
+		s.entries[e.key] = e
+	}
+	e.size = size
+	s.used += size
+	switch {
+	case dirty && !e.dirty:
+		if e.elem != nil {
+			s.lru.Remove(e.elem)
+			e.elem = nil
+		}
+		e.dirty = true
+		s.ndirty++
+	case dirty || e.dirty:
+	case e.elem == nil:
+		e.elem = s.lru.PushFront(e)
+	default:
+		s.lru.MoveToFront(e.elem)
+	}
+	e.gen++
+	delete(s.fills, e.key)
+	return nil
+}
+
+// removeLocked drops an entry from the index and fast store.  Must hold s.mu.
+func (s *Store) removeLocked(e *entry) {
+	if err := s.fast.RawDelete(storage.Key(e.key)); err != nil {
+		dvid.Errorf("Unable to delete cached key from fast store %q: %v\n", s.config.fast, err)
+	}
+	if e.elem != nil {
+		s.lru.Remove(e.elem)
+	}
+	if e.dirty {
+		s.ndirty--
+	}
+	s.used -= e.size
+	delete(s.entries, e.key)
+}
+
+// evictLocked removes least recently used clean entries until the cache is within budget.
+// Returns true if the cache is still over budget due to dirty entries.  Must hold s.mu.
+func (s *Store) evictLocked() bool {
+	for s.used > s.config.budget {
+		elem := s.lru.Back()
+		if elem == nil {
+			return true
+		}
+		s.removeLocked(elem.Value.(*entry))
+		s.stats.Evictions++
+	}
+	return false
+}
+
+// invalidate drops clean cached values with keys in the given inclusive range as well as
+// any pending fills.  Dirty values are newer than the invalidating write and are retained.
+func (s *Store) invalidate(begKey, endKey storage.Key) error {
+	keys, err := s.cachedKeys(begKey, endKey)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		if e, found := s.entries[string(key)]; found && !e.dirty {
+			s.removeLocked(e)
+		}
+	}
+	for key := range s.fills {
+		if key >= string(begKey) && key <= string(endKey) {
+			delete(s.fills, key)
+		}
+	}
+	return nil
+}
+
+// cachedKeys returns the keys in the fast store within the given inclusive range.
+func (s *Store) cachedKeys(begKey, endKey storage.Key) ([]storage.Key, error) {
+	if bytes.Equal(begKey, endKey) {
+		return []storage.Key{begKey}, nil
+	}
+	ch := make(chan *storage.KeyValue, 100)
+	var keys []storage.Key
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for kv := range ch {
+			if kv == nil || kv.K == nil {
+				return
+			}
+			key := make(storage.Key, len(kv.K))
+			copy(key, kv.K)
+			keys = append(keys, key)
+		}
+	}()
+	err := s.fast.RawRangeQuery(begKey, endKey, true, ch, nil)
+	close(ch)
+	wg.Wait()
+	return keys, err
+}
+
+type dirtyValue struct {
+	key storage.Key
+	v   []byte
+	gen uint64
+}
+
+// flushKeysLocked writes back any dirty values for the given keys.  Must hold s.flushMu.
+func (s *Store) flushKeysLocked(keys []storage.Key) error {
+	var dirty []dirtyValue
+	s.mu.Lock()
+	for _, key := range keys {
+		e, found := s.entries[string(key)]
+		if !found || !e.dirty {
+			continue
+		}
+		v, err := s.fast.Get(cacheContext{}, storage.TKey(key))
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if len(v) == 0 {
+			s.mu.Unlock()
+			return fmt.Errorf("dirty value for key %v missing from fast store %q", key, s.config.fast)
+		}
+		dirty = append(dirty, dirtyValue{key, v[1:], e.gen})
+	}
+	s.mu.Unlock()
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	for _, d := range dirty {
+		if err := s.writeSlow(d.key, d.v); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range dirty {
+		s.stats.WriteBacks++
+		e, found := s.entries[string(d.key)]
+		if !found || !e.dirty || e.gen != d.gen {
+			continue // rewritten during write-back so still dirty
+		}
+		e.dirty = false
+		s.ndirty--
+		e.elem = s.lru.PushFront(e)
+	}
+	s.evictLocked()
+	return nil
+}
+
+// flushRange writes back dirty values with keys in the given inclusive range.
+func (s *Store) flushRange(begKey, endKey storage.Key) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	return s.flushRangeLocked(begKey, endKey)
+}
+
+func (s *Store) flushRangeLocked(begKey, endKey storage.Key) error {
+	s.mu.Lock()
+	ndirty := s.ndirty
+	s.mu.Unlock()
+	if ndirty == 0 {
+		return nil
+	}
+	keys, err := s.cachedKeys(begKey, endKey)
+	if err != nil {
+		return err
+	}
+	return s.flushKeysLocked(keys)
+}
+
+// flushAll writes back all dirty values.
+func (s *Store) flushAll() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	var keys []storage.Key
+	for _, e := range s.entries {
+		if e.dirty {
+			keys = append(keys, storage.Key(e.key))
+		}
+	}
+	s.mu.Unlock()
+	return s.flushKeysLocked(keys)
+}
+
+// versionRange returns the inclusive range of keys for all versions of the given key.
+// Non-data keys have no versions.
+func versionRange(key storage.Key) (begKey, endKey storage.Key) {
+	instance, _, _, err := storage.DataKeyToLocalIDs(key)
+	if err != nil {
+		return key, key
+	}
+	begKey = make(storage.Key, len(key))
+	copy(begKey, key)
+	storage.UpdateDataKey(begKey, instance, 0, 0)
+	begKey[len(begKey)-1] = 0
+	endKey = make(storage.Key, len(key))
+	copy(endKey, key)
+	storage.UpdateDataKey(endKey, instance, dvid.MaxVersionID, dvid.MaxClientID)
+	endKey[len(endKey)-1] = 0xFF
+	return
+}
+
+// keyRange returns the inclusive range of full keys for a range of type-specific keys.
+func keyRange(ctx storage.Context, kStart, kEnd storage.TKey) (begKey, endKey storage.Key, err error) {
+	if !ctx.Versioned() {
+		return ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), nil
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return nil, nil, fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	if begKey, err = vctx.MinVersionKey(kStart); err != nil {
+		return
+	}
+	endKey, err = vctx.MaxVersionKey(kEnd)
+	return
+}
+
+// allKeysRange returns the inclusive range of full keys for all data in the context.
+func allKeysRange(ctx storage.Context) (begKey, endKey storage.Key, err error) {
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		begKey, endKey = ctx.KeyRange()
+		return
+	}
+	minTKey := storage.MinTKey(storage.TKeyMinClass)
+	maxTKey := storage.MaxTKey(storage.TKeyMaxClass)
+	if begKey, err = vctx.MinVersionKey(minTKey); err != nil {
+		return
+	}
+	endKey, err = vctx.MaxVersionKey(maxTKey)
+	return
+}
+
+// putBack writes a dirty value into the cache, writing back dirty values if the cache
+// is over budget.
+func (s *Store) putBack(key storage.Key, v []byte) error {
+	s.mu.Lock()
+	err := s.putLocked(key, v, true)
+	over := s.evictLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if over {
+		return s.flushAll()
+	}
+	return nil
+}
+
+// deleteWith runs a deletion on the slow store after writing back any dirty values
+// in the given range and then invalidates the range.
+func (s *Store) deleteWith(begKey, endKey storage.Key, f func() error) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	if err := s.flushRangeLocked(begKey, endKey); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	return s.invalidate(begKey, endKey)
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value from the fast store if cached, otherwise it is read from the slow
+// store and cached.
+func (s *Store) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	key := ctx.ConstructKey(tk)
+	s.mu.Lock()
+	if e, found := s.entries[string(key)]; found {
+		v, err := s.fast.Get(cacheContext{}, storage.TKey(key))
+		if err == nil && len(v) != 0 {
+			if e.elem != nil {
+				s.lru.MoveToFront(e.elem)
+			}
+			s.stats.Hits++
+			s.mu.Unlock()
+			return v[1:], nil
+		}
+		if err != nil {
+			dvid.Errorf("Unable to read cached value from fast store %q: %v\n", s.config.fast, err)
+		}
+		s.removeLocked(e)
+	}
+	s.stats.Misses++
+	s.token++
+	token := s.token
+	s.fills[string(key)] = token
+	ndirty := s.ndirty
+	s.mu.Unlock()
+
+	// Other versions of the key may be dirty and needed to resolve this version.
+	if ndirty != 0 {
+		if err := s.flushRange(versionRange(key)); err != nil {
+			return nil, err
+		}
+	}
+	v, err := s.slow.Get(ctx, tk)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fills[string(key)] != token {
+		return v, err // a write intervened so don't cache what was read
+	}
+	delete(s.fills, string(key))
+	if err != nil || v == nil {
+		return v, err
+	}
+	if err := s.putLocked(key, v, false); err != nil {
+		dvid.Errorf("Unable to cache value in fast store %q: %v\n", s.config.fast, err)
+	}
+	s.evictLocked()
+	return v, nil
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd) from the slow store.
+func (s *Store) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	begKey, endKey, err := keyRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.flushRange(begKey, endKey); err != nil {
+		return nil, err
+	}
+	return s.slow.KeysInRange(ctx, kStart, kEnd)
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd) from the slow store.
+func (s *Store) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	begKey, endKey, err := keyRange(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	if err := s.flushRange(begKey, endKey); err != nil {
+		return err
+	}
+	return s.slow.SendKeysInRange(ctx, kStart, kEnd, ch)
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys from the slow store.
+func (s *Store) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	begKey, endKey, err := keyRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.flushRange(begKey, endKey); err != nil {
+		return nil, err
+	}
+	return s.slow.GetRange(ctx, kStart, kEnd)
+}
+
+// ProcessRange sends a range of key-value pairs from the slow store to chunk handlers.
+func (s *Store) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	begKey, endKey, err := keyRange(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	if err := s.flushRange(begKey, endKey); err != nil {
+		return err
+	}
+	return s.slow.ProcessRange(ctx, kStart, kEnd, op, f)
+}
+
+// RawRangeQuery sends a range of full keys from the slow store.
+func (s *Store) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	if err := s.flushRange(kStart, kEnd); err != nil {
+		return err
+	}
+	return s.slow.RawRangeQuery(kStart, kEnd, keysOnly, out, cancel)
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (s *Store) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	key := ctx.ConstructKey(tk)
+	if s.config.writeBack {
+		return s.putBack(key, v)
+	}
+	if err := s.slow.Put(ctx, tk, v); err != nil {
+		return err
+	}
+	return s.invalidate(key, key)
+}
+
+// Delete removes a value with given key.
+func (s *Store) Delete(ctx storage.Context, tk storage.TKey) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	key := ctx.ConstructKey(tk)
+	return s.deleteWith(key, key, func() error {
+		return s.slow.Delete(ctx, tk)
+	})
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.  Since
+// the value may be visible to other versions, cached values for all versions are dropped.
+func (s *Store) RawPut(k storage.Key, v []byte) error {
+	begKey, endKey := versionRange(k)
+	return s.deleteWith(begKey, endKey, func() error {
+		return s.slow.RawPut(k, v)
+	})
+}
+
+// RawDelete is a low-level function that deletes a key-value pair using full keys.
+func (s *Store) RawDelete(k storage.Key) error {
+	begKey, endKey := versionRange(k)
+	return s.deleteWith(begKey, endKey, func() error {
+		return s.slow.RawDelete(k)
+	})
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (s *Store) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	if s.config.writeBack {
+		for _, kv := range kvs {
+			if err := s.putBack(ctx.ConstructKey(kv.K), kv.V); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := s.slow.PutRange(ctx, kvs); err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		key := ctx.ConstructKey(kv.K)
+		if err := s.invalidate(key, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (s *Store) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	begKey, endKey, err := keyRange(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	return s.deleteWith(begKey, endKey, func() error {
+		return s.slow.DeleteRange(ctx, kStart, kEnd)
+	})
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (s *Store) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	begKey, endKey, err := allKeysRange(ctx)
+	if err != nil {
+		return err
+	}
+	return s.deleteWith(begKey, endKey, func() error {
+		return s.slow.DeleteAll(ctx, allVersions)
+	})
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the sizes of the given key ranges in the slow store.
+func (s *Store) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	sv, ok := s.slow.(storage.SizeViewer)
+	if !ok {
+		return nil, fmt.Errorf("slow store %q for %s cannot report sizes", s.config.slow, s)
+	}
+	return sv.GetApproximateSizes(ranges)
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	op storage.Op
+	tk storage.TKey
+	v  []byte
+}
+
+type batch struct {
+	s   *Store
+	ctx storage.Context
+	ops []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  When writing through,
+// the batch is committed to the slow store as a batch if it supports batching.
+func (s *Store) NewBatch(ctx storage.Context) storage.Batch {
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	return &batch{s: s, ctx: ctx}
+}
+
+func (b *batch) Delete(tk storage.TKey) {
+	b.ops = append(b.ops, batchOp{storage.DeleteOp, tk, nil})
+}
+
+func (b *batch) Put(tk storage.TKey, v []byte) {
+	b.ops = append(b.ops, batchOp{storage.PutOp, tk, v})
+}
+
+func (b *batch) Commit() error {
+	s := b.s
+	ops := b.ops
+	b.ops = nil
+	if slowBatcher, ok := s.slow.(storage.KeyValueBatcher); ok && !s.config.writeBack {
+		slowBatch := slowBatcher.NewBatch(b.ctx)
+		for _, op := range ops {
+			switch op.op {
+			case storage.PutOp:
+				slowBatch.Put(op.tk, op.v)
+			case storage.DeleteOp:
+				slowBatch.Delete(op.tk)
+			}
+		}
+		if err := slowBatch.Commit(); err != nil {
+			return err
+		}
+		for _, op := range ops {
+			key := b.ctx.ConstructKey(op.tk)
+			if err := s.invalidate(key, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, op := range ops {
+		var err error
+		switch op.op {
+		case storage.PutOp:
+			err = s.Put(b.ctx, op.tk, op.v)
+		case storage.DeleteOp:
+			err = s.Delete(b.ctx, op.tk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
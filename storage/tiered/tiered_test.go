@@ -0,0 +1,200 @@
+package tiered
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/memstore"
+)
+
+func openMemstore(t *testing.T) (storage.OrderedKeyValueDB, func()) {
+	var e memstore.Engine
+	backend, err := e.GetTestConfig()
+	if err != nil {
+		t.Fatalf("unable to get test config: %v\n", err)
+	}
+	config := backend.Stores["default"]
+	store, _, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("unable to create memstore: %v\n", err)
+	}
+	return store.(storage.OrderedKeyValueDB), func() { e.Delete(config) }
+}
+
+func testConfig(writeBack bool, cachemb int64) dvid.StoreConfig {
+	var c dvid.Config
+	c.SetAll(map[string]interface{}{
+		"fast":      "ssd",
+		"slow":      "raid",
+		"cachemb":   cachemb,
+		"writeback": writeBack,
+	})
+	return dvid.StoreConfig{Config: c, Engine: "tiered"}
+}
+
+func openTiered(t *testing.T, config dvid.StoreConfig, fast, slow storage.OrderedKeyValueDB) *Store {
+	var e Engine
+	components := map[storage.Alias]dvid.Store{"ssd": fast, "raid": slow}
+	store, _, err := e.NewCompositeStore(config, components)
+	if err != nil {
+		t.Fatalf("unable to open tiered store: %v\n", err)
+	}
+	return store.(*Store)
+}
+
+func TestReadThrough(t *testing.T) {
+	fast, deleteFast := openMemstore(t)
+	defer deleteFast()
+	slow, deleteSlow := openMemstore(t)
+	defer deleteSlow()
+	s := openTiered(t, testConfig(false, 1), fast, slow)
+	defer s.Close()
+
+	ctx := storage.NewMetadataContext()
+	tk := storage.NewTKey(1, []byte("foo"))
+	if err := s.Put(ctx, tk, []byte("bar")); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+	for i := 0; i < 3; i++ {
+		v, err := s.Get(ctx, tk)
+		if err != nil {
+			t.Fatalf("error on Get: %v\n", err)
+		}
+		if string(v) != "bar" {
+			t.Fatalf("expected %q, got %q\n", "bar", string(v))
+		}
+	}
+	stats := s.Stats().(Stats)
+	if stats.Misses != 1 || stats.Hits != 2 || stats.Entries != 1 {
+		t.Errorf("bad stats after read-through: %+v\n", stats)
+	}
+
+	// Write-through invalidates the cached value.
+	if err := s.Put(ctx, tk, []byte("baz")); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+	if v, _ := s.Get(ctx, tk); string(v) != "baz" {
+		t.Errorf("expected %q after overwrite, got %q\n", "baz", string(v))
+	}
+	if err := s.Delete(ctx, tk); err != nil {
+		t.Fatalf("error on Delete: %v\n", err)
+	}
+	if v, _ := s.Get(ctx, tk); v != nil {
+		t.Errorf("expected nil after delete, got %q\n", string(v))
+	}
+
+	// Exceeding the byte budget evicts least recently used values.
+	value := make([]byte, 300*1024)
+	for i := 0; i < 5; i++ {
+		tk := storage.NewTKey(2, []byte{byte(i)})
+		if err := s.Put(ctx, tk, value); err != nil {
+			t.Fatalf("error on Put: %v\n", err)
+		}
+		if _, err := s.Get(ctx, tk); err != nil {
+			t.Fatalf("error on Get: %v\n", err)
+		}
+	}
+	stats = s.Stats().(Stats)
+	if stats.UsedBytes > stats.BudgetBytes || stats.Evictions != 2 {
+		t.Errorf("bad stats after eviction: %+v\n", stats)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	fast, deleteFast := openMemstore(t)
+	defer deleteFast()
+	slow, deleteSlow := openMemstore(t)
+	defer deleteSlow()
+	config := testConfig(true, 1)
+	s := openTiered(t, config, fast, slow)
+
+	ctx := storage.NewMetadataContext()
+	var kvs []storage.TKeyValue
+	for i := 0; i < 10; i++ {
+		tk := storage.NewTKey(1, []byte(fmt.Sprintf("%02d", i)))
+		kvs = append(kvs, storage.TKeyValue{K: tk, V: []byte(fmt.Sprintf("value %d", i))})
+	}
+	if err := s.PutRange(ctx, kvs); err != nil {
+		t.Fatalf("error on PutRange: %v\n", err)
+	}
+	if v, _ := slow.Get(ctx, kvs[0].K); v != nil {
+		t.Fatalf("expected write-back to defer writes to slow store\n")
+	}
+	if v, _ := s.Get(ctx, kvs[3].K); !bytes.Equal(v, kvs[3].V) {
+		t.Errorf("expected %q from dirty cache, got %q\n", string(kvs[3].V), string(v))
+	}
+
+	// Range queries write back dirty values in range.
+	got, err := s.GetRange(ctx, kvs[0].K, kvs[4].K)
+	if err != nil {
+		t.Fatalf("error on GetRange: %v\n", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 values in range, got %d\n", len(got))
+	}
+	if stats := s.Stats().(Stats); stats.DirtyEntries != 5 || stats.WriteBacks != 5 {
+		t.Errorf("bad stats after range write-back: %+v\n", stats)
+	}
+
+	// Deleting a dirty value removes it from both tiers.
+	if err := s.Delete(ctx, kvs[7].K); err != nil {
+		t.Fatalf("error on Delete: %v\n", err)
+	}
+	if v, _ := s.Get(ctx, kvs[7].K); v != nil {
+		t.Errorf("expected nil after deleting dirty value, got %q\n", string(v))
+	}
+
+	// Dirty values left in the fast store are written back on reopen.
+	if err := s.Put(ctx, kvs[9].K, []byte("recovered")); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+	close(s.done)
+	s.stopped.Wait()
+	s2 := openTiered(t, config, fast, slow)
+	defer s2.Close()
+	if v, _ := slow.Get(ctx, kvs[9].K); string(v) != "recovered" {
+		t.Errorf("expected dirty value written back on reopen, got %q\n", string(v))
+	}
+	if v, _ := slow.Get(ctx, kvs[7].K); v != nil {
+		t.Errorf("deleted value was resurrected: %q\n", string(v))
+	}
+	if stats := s2.Stats().(Stats); stats.Entries != 0 {
+		t.Errorf("expected empty cache after reopen, got %+v\n", stats)
+	}
+}
+
+func TestExclusiveFastStore(t *testing.T) {
+	var e memstore.Engine
+	fastConfig, err := e.GetTestConfig()
+	if err != nil {
+		t.Fatalf("unable to get test config: %v\n", err)
+	}
+	slowConfig, err := e.GetTestConfig()
+	if err != nil {
+		t.Fatalf("unable to get test config: %v\n", err)
+	}
+	defer e.Delete(fastConfig.Stores["default"])
+	defer e.Delete(slowConfig.Stores["default"])
+
+	backend := &storage.Backend{
+		Metadata: "raid",
+		Stores: map[storage.Alias]dvid.StoreConfig{
+			"ssd":   fastConfig.Stores["default"],
+			"raid":  slowConfig.Stores["default"],
+			"cache": testConfig(false, 1),
+		},
+	}
+
+	backend.Default = "ssd"
+	if _, err := storage.Initialize(dvid.Config{}, backend); err == nil {
+		t.Errorf("expected error using fast store of tiered store as default store\n")
+	}
+	backend.Default = "cache"
+	backend.Mapping = map[dvid.DataSpecifier]storage.Alias{"grayscale": "ssd"}
+	if _, err := storage.Initialize(dvid.Config{}, backend); err == nil {
+		t.Errorf("expected error mapping data to fast store of tiered store\n")
+	}
+}
@@ -0,0 +1,238 @@
+/*
+	This file supports transactions that atomically write key-value pairs across
+	data contexts and stores.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// intentKeyClass is the metadata key class for the write-ahead intent log of transactions
+// spanning stores.  It is well above the metadata key classes used by the datastore package.
+const intentKeyClass TKeyClass = 240
+
+// txnContext passes full keys through unchanged so a store's batch can be used for
+// key-value pairs already constructed from different contexts.
+type txnContext struct {
+	MetadataContext
+}
+
+func (ctx txnContext) ConstructKey(tk TKey) Key {
+	return Key(tk)
+}
+
+func (ctx txnContext) String() string {
+	return "Transaction raw context"
+}
+
+// txnOp is a put or delete of a full key.
+type txnOp struct {
+	Op  Op
+	Key Key
+	V   []byte
+}
+
+// txnStoreOps are the operations on one store, identified by its alias in the intent log.
+type txnStoreOps struct {
+	Alias Alias
+	Ops   []txnOp
+}
+
+// Transaction collects puts and deletes across data contexts and stores and applies them
+// atomically on Commit.  If all operations are on a single store, they are committed
+// in one batch.  Otherwise, the operations are first written to an intent log in the
+// metadata store, then committed to each store, then removed from the log.  Intents
+// left by a crash are replayed by RecoverTransactions when storage is initialized.
+//
+// A Transaction is not safe for concurrent use and should not be reused after Commit.
+type Transaction struct {
+	stores []KeyValueBatcher // in order of first use
+	ops    map[KeyValueBatcher][]txnOp
+}
+
+// NewTransaction returns an empty transaction.
+func NewTransaction() *Transaction {
+	return &Transaction{ops: make(map[KeyValueBatcher][]txnOp)}
+}
+
+func (t *Transaction) add(db KeyValueBatcher, ops ...txnOp) {
+	if _, found := t.ops[db]; !found {
+		t.stores = append(t.stores, db)
+	}
+	t.ops[db] = append(t.ops[db], ops...)
+}
+
+// Put adds a put of the given key-value in the context to the transaction.  As with
+// batches, a put in a versioned context removes any tombstone at that version.
+func (t *Transaction) Put(db KeyValueBatcher, ctx Context, tk TKey, v []byte) {
+	if vctx, ok := ctx.(VersionedCtx); ok {
+		t.add(db, txnOp{DeleteOp, vctx.TombstoneKey(tk), nil})
+	}
+	t.add(db, txnOp{PutOp, ctx.ConstructKey(tk), v})
+}
+
+// Delete adds a delete of the given key in the context to the transaction.  As with
+// batches, a delete in a versioned context writes a tombstone at that version.
+func (t *Transaction) Delete(db KeyValueBatcher, ctx Context, tk TKey) {
+	if vctx, ok := ctx.(VersionedCtx); ok {
+		t.add(db, txnOp{PutOp, vctx.TombstoneKey(tk), dvid.EmptyValue()})
+	}
+	t.add(db, txnOp{DeleteOp, ctx.ConstructKey(tk), nil})
+}
+
+// RawPut adds a put of a full key, e.g., one read from a raw range query, to the transaction.
+func (t *Transaction) RawPut(db KeyValueBatcher, k Key, v []byte) {
+	t.add(db, txnOp{PutOp, k, v})
+}
+
+// RawDelete adds a delete of a full key to the transaction.
+func (t *Transaction) RawDelete(db KeyValueBatcher, k Key) {
+	t.add(db, txnOp{DeleteOp, k, nil})
+}
+
+// Len returns the number of key-value operations in the transaction.
+func (t *Transaction) Len() int {
+	var n int
+	for _, ops := range t.ops {
+		n += len(ops)
+	}
+	return n
+}
+
+// Commit atomically applies all operations in the transaction.
+func (t *Transaction) Commit() error {
+	switch len(t.stores) {
+	case 0:
+		return nil
+	case 1:
+		return commitOps(t.stores[0], t.ops[t.stores[0]])
+	}
+
+	// Write the intent log entry before touching any store.
+	intent := make([]txnStoreOps, len(t.stores))
+	for i, db := range t.stores {
+		alias, err := storeAlias(db)
+		if err != nil {
+			return err
+		}
+		intent[i] = txnStoreOps{Alias: alias, Ops: t.ops[db]}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(intent); err != nil {
+		return err
+	}
+	metadb, err := MetaDataKVStore()
+	if err != nil {
+		return err
+	}
+	ctx := NewMetadataContext()
+	tk := newIntentTKey()
+	if err := metadb.Put(ctx, tk, buf.Bytes()); err != nil {
+		return fmt.Errorf("unable to write transaction intent log: %v", err)
+	}
+
+	for _, db := range t.stores {
+		if err := commitOps(db, t.ops[db]); err != nil {
+			return fmt.Errorf("transaction interrupted, will be completed on restart: %v", err)
+		}
+	}
+	return metadb.Delete(ctx, tk)
+}
+
+// RecoverTransactions completes any transactions spanning stores that were interrupted
+// before all stores were written.  Operations are idempotent so they are simply reapplied.
+func RecoverTransactions() error {
+	metadb, err := MetaDataKVStore()
+	if err != nil {
+		return err
+	}
+	ctx := NewMetadataContext()
+	kvs, err := metadb.GetRange(ctx, MinTKey(intentKeyClass), MaxTKey(intentKeyClass))
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		var intent []txnStoreOps
+		if err := gob.NewDecoder(bytes.NewBuffer(kv.V)).Decode(&intent); err != nil {
+			return fmt.Errorf("bad transaction intent log entry: %v", err)
+		}
+		for _, storeOps := range intent {
+			store, err := GetStoreByAlias(storeOps.Alias)
+			if err != nil {
+				return fmt.Errorf("unable to recover transaction: %v", err)
+			}
+			db, ok := store.(KeyValueBatcher)
+			if !ok {
+				return fmt.Errorf("unable to recover transaction: store %q is not batch-enabled", storeOps.Alias)
+			}
+			if err := commitOps(db, storeOps.Ops); err != nil {
+				return fmt.Errorf("unable to recover transaction: %v", err)
+			}
+		}
+		if err := metadb.Delete(ctx, kv.K); err != nil {
+			return err
+		}
+		dvid.Infof("Recovered interrupted transaction across %d stores\n", len(intent))
+	}
+	return nil
+}
+
+func commitOps(db KeyValueBatcher, ops []txnOp) error {
+	batch := db.NewBatch(txnContext{})
+	for _, op := range ops {
+		switch op.Op {
+		case PutOp:
+			batch.Put(TKey(op.Key), op.V)
+		case DeleteOp:
+			batch.Delete(TKey(op.Key))
+		}
+	}
+	return batch.Commit()
+}
+
+// storeAlias returns the configured alias of a store.
+func storeAlias(db KeyValueBatcher) (Alias, error) {
+	stores, err := AllStores()
+	if err != nil {
+		return "", err
+	}
+	var underlying interface{} = db
+	if store, ok := db.(dvid.Store); ok {
+		underlying = unwrapGroupcache(store)
+	}
+	for alias, store := range stores {
+		if store == underlying {
+			return alias, nil
+		}
+	}
+	return "", fmt.Errorf("store %v in transaction is not a configured store", db)
+}
+
+var intentID struct {
+	sync.Mutex
+	last uint64
+}
+
+// newIntentTKey returns a unique key for an intent log entry that orders entries by time.
+func newIntentTKey() TKey {
+	intentID.Lock()
+	id := uint64(time.Now().UnixNano())
+	if id <= intentID.last {
+		id = intentID.last + 1
+	}
+	intentID.last = id
+	intentID.Unlock()
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return NewTKey(intentKeyClass, b)
+}
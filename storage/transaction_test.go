@@ -0,0 +1,120 @@
+package storage_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/memstore"
+)
+
+// intentClass must match the storage package's key class for transaction intents.
+const intentClass storage.TKeyClass = 240
+
+func openTwoStores(t *testing.T) (a, b storage.OrderedKeyValueDB, closeFn func()) {
+	var e memstore.Engine
+	backend := &storage.Backend{
+		Default:  "a",
+		Metadata: "a",
+		Stores:   make(map[storage.Alias]dvid.StoreConfig),
+	}
+	for _, alias := range []storage.Alias{"a", "b"} {
+		tc, err := e.GetTestConfig()
+		if err != nil {
+			t.Fatalf("unable to get test config: %v\n", err)
+		}
+		backend.Stores[alias] = tc.Stores["default"]
+	}
+	if _, err := storage.Initialize(dvid.Config{}, backend); err != nil {
+		t.Fatalf("unable to initialize storage: %v\n", err)
+	}
+	storeA, _ := storage.GetStoreByAlias("a")
+	storeB, _ := storage.GetStoreByAlias("b")
+	return storeA.(storage.OrderedKeyValueDB), storeB.(storage.OrderedKeyValueDB), func() {
+		storage.Close()
+		for _, config := range backend.Stores {
+			e.Delete(config)
+		}
+	}
+}
+
+func intents(t *testing.T) int {
+	metadb, err := storage.MetaDataKVStore()
+	if err != nil {
+		t.Fatalf("no metadata store: %v\n", err)
+	}
+	kvs, err := metadb.GetRange(storage.NewMetadataContext(), storage.MinTKey(intentClass), storage.MaxTKey(intentClass))
+	if err != nil {
+		t.Fatalf("error reading intent log: %v\n", err)
+	}
+	return len(kvs)
+}
+
+func TestTransactionAcrossStores(t *testing.T) {
+	a, b, closeFn := openTwoStores(t)
+	defer closeFn()
+
+	ctx := storage.NewMetadataContext()
+	tkey := func(s string) storage.TKey { return storage.NewTKey(1, []byte(s)) }
+	if err := b.Put(ctx, tkey("stale"), []byte("old")); err != nil {
+		t.Fatalf("error on Put: %v\n", err)
+	}
+
+	txn := storage.NewTransaction()
+	txn.Put(a.(storage.KeyValueBatcher), ctx, tkey("x"), []byte("in a"))
+	txn.Put(b.(storage.KeyValueBatcher), ctx, tkey("y"), []byte("in b"))
+	txn.Delete(b.(storage.KeyValueBatcher), ctx, tkey("stale"))
+	if txn.Len() != 3 {
+		t.Errorf("expected 3 operations in transaction, got %d\n", txn.Len())
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("error on transaction commit: %v\n", err)
+	}
+	if v, _ := a.Get(ctx, tkey("x")); string(v) != "in a" {
+		t.Errorf("expected transaction put in store a, got %q\n", string(v))
+	}
+	if v, _ := b.Get(ctx, tkey("y")); string(v) != "in b" {
+		t.Errorf("expected transaction put in store b, got %q\n", string(v))
+	}
+	if v, _ := b.Get(ctx, tkey("stale")); v != nil {
+		t.Errorf("expected transaction delete in store b, got %q\n", string(v))
+	}
+	if n := intents(t); n != 0 {
+		t.Errorf("expected empty intent log after commit, got %d entries\n", n)
+	}
+
+	// An intent left by a crash is replayed on recovery.
+	type op struct {
+		Op  storage.Op
+		Key storage.Key
+		V   []byte
+	}
+	intent := []struct {
+		Alias storage.Alias
+		Ops   []op
+	}{
+		{"a", []op{{storage.PutOp, ctx.ConstructKey(tkey("x")), []byte("replayed a")}}},
+		{"b", []op{{storage.DeleteOp, ctx.ConstructKey(tkey("y")), nil}}},
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(intent); err != nil {
+		t.Fatalf("unable to encode intent: %v\n", err)
+	}
+	if err := a.Put(ctx, storage.NewTKey(intentClass, []byte{1}), buf.Bytes()); err != nil {
+		t.Fatalf("unable to write intent: %v\n", err)
+	}
+	if err := storage.RecoverTransactions(); err != nil {
+		t.Fatalf("error recovering transactions: %v\n", err)
+	}
+	if v, _ := a.Get(ctx, tkey("x")); string(v) != "replayed a" {
+		t.Errorf("expected replayed put in store a, got %q\n", string(v))
+	}
+	if v, _ := b.Get(ctx, tkey("y")); v != nil {
+		t.Errorf("expected replayed delete in store b, got %q\n", string(v))
+	}
+	if n := intents(t); n != 0 {
+		t.Errorf("expected empty intent log after recovery, got %d entries\n", n)
+	}
+}
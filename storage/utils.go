@@ -19,3 +19,31 @@ func DataFromFile(filename string) ([]byte, error) {
 	FileBytesRead <- len(data)
 	return data, nil
 }
+
+// ReadRawBatch returns up to max key-value pairs of a store with full keys in [begKey, endKey],
+// stopping the range query once max pairs are read.
+func ReadRawBatch(db OrderedKeyValueDB, begKey, endKey Key, max int) ([]*KeyValue, error) {
+	ch := make(chan *KeyValue)
+	cancel := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- db.RawRangeQuery(begKey, endKey, false, ch, cancel)
+	}()
+	var kvs []*KeyValue
+	for {
+		select {
+		case kv := <-ch:
+			if kv == nil {
+				return kvs, <-done
+			}
+			if len(kvs) < max {
+				kvs = append(kvs, kv)
+				if len(kvs) == max {
+					cancel <- struct{}{}
+				}
+			}
+		case err := <-done:
+			return kvs, err
+		}
+	}
+}